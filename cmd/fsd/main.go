@@ -65,7 +65,8 @@ func main() {
 	// Create the daemon instance (implements service.Interface)
 	// Pass the pre-loaded config
 	dmn := &daemon.Daemon{
-		Cfg: cfg,
+		Cfg:     cfg,
+		CfgPath: cfgPath,
 	}
 
 	s, err := service.New(dmn, svcConfig)
@@ -92,7 +93,11 @@ func main() {
 	// Use LogPath from config
 	logPath := cfg.LogPath
 	if logPath == "" {
-		logPath = filepath.Join(filepath.Dir(ex), "fsd.log")
+		logDir := filepath.Dir(ex)
+		if cfg.DataDir != "" {
+			logDir = cfg.DataDir
+		}
+		logPath = filepath.Join(logDir, "fsd.log")
 	}
 
 	// Initialize LogRotator
@@ -102,6 +107,9 @@ func main() {
 		MaxBackups: cfg.LogMaxBackups,
 		MaxAgeDays: cfg.LogMaxAgeDays,
 		Compress:   cfg.LogCompress,
+		FileMode:   cfg.FilePerm(),
+		DirMode:    cfg.DirPerm(),
+		OnCreate:   cfg.ApplyFilePerms,
 	}
 
 	// Use rotator as the writer
@@ -111,9 +119,10 @@ func main() {
 
 	// Inject logger into daemon
 	dmn.Logger = logger
+	dmn.LogPath = logPath
 
 	// Initialize CLI and execute
-	rootCmd := cli.NewRootCmd(s, logger, logPath, cfgPath)
+	rootCmd := cli.NewRootCmd(s, dmn, logger, logPath, cfgPath)
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// Supported AuthScheme values. A custom header is selected with "header:<name>".
+const (
+	AuthSchemeBearer       = "bearer"
+	AuthSchemeBasic        = "basic"
+	AuthSchemeHeaderPrefix = "header:"
+)
+
+// AuthHeader returns the header carrying token under scheme:
+//   - "bearer" (or empty): Authorization: Bearer <token>
+//   - "header:<name>": <name>: <token>
+//   - "basic": Authorization: Basic base64(<token>), with token given as user:pass
+func AuthHeader(scheme, token string) (name, value string, err error) {
+	switch {
+	case scheme == "" || strings.EqualFold(scheme, AuthSchemeBearer):
+		return "Authorization", "Bearer " + token, nil
+	case strings.EqualFold(scheme, AuthSchemeBasic):
+		return "Authorization", "Basic " + base64.StdEncoding.EncodeToString([]byte(token)), nil
+	case strings.HasPrefix(strings.ToLower(scheme), AuthSchemeHeaderPrefix):
+		name := strings.TrimSpace(scheme[len(AuthSchemeHeaderPrefix):])
+		if name == "" {
+			return "", "", fmt.Errorf("auth scheme %q is missing a header name", scheme)
+		}
+		return http.CanonicalHeaderKey(name), token, nil
+	default:
+		return "", "", fmt.Errorf("unsupported auth scheme %q", scheme)
+	}
+}
+
+// credential is a token and the scheme it is sent with, see AuthHeader.
+type credential struct {
+	token, scheme string
+}
+
+// SetAuth configures the credentials attached to authenticated API calls.
+// An empty token disables authentication. It may be called while requests
+// are in flight, e.g. after the device was paired again.
+func (c *Client) SetAuth(token, scheme string) {
+	c.auth.Store(&credential{token: token, scheme: scheme})
+}
+
+// authorize decorates an authenticated request with the configured credentials.
+// Presigned upload URLs carry their own authorization and must not go through here.
+func (c *Client) authorize(req *http.Request) error {
+	cred := c.auth.Load()
+	if cred == nil || cred.token == "" {
+		return nil
+	}
+	name, value, err := AuthHeader(cred.scheme, cred.token)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(name, value)
+	return nil
+}
+
+// newAuthorizedRequest builds an authenticated JSON request to the API. The
+// trace context of ctx, if any, is sent along (W3C traceparent) so backend
+// traces link up with the upload's.
+func (c *Client) newAuthorizedRequest(ctx context.Context, method, url string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	propagation.TraceContext{}.Inject(ctx, propagation.HeaderCarrier(req.Header))
+	if err := c.authorize(req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
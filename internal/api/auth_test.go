@@ -0,0 +1,99 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientAuthSchemes(t *testing.T) {
+	tests := []struct {
+		name       string
+		scheme     string
+		token      string
+		wantHeader string
+		wantValue  string
+	}{
+		{"default is bearer", "", "tok-123", "Authorization", "Bearer tok-123"},
+		{"bearer", "bearer", "tok-123", "Authorization", "Bearer tok-123"},
+		{"custom header", "header:X-API-Key", "tok-123", "X-Api-Key", "tok-123"},
+		{"basic", "basic", "device:s3cret", "Authorization", "Basic " + base64.StdEncoding.EncodeToString([]byte("device:s3cret"))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got http.Header
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header.Clone()
+				w.WriteHeader(http.StatusCreated)
+				json.NewEncoder(w).Encode(IngestResponse{HandshakeID: "hs", UploadURL: "http://storage/upload"})
+			}))
+			defer srv.Close()
+
+			c := NewClient(srv.URL, "5s")
+			c.SetAuth(tt.token, tt.scheme)
+			if _, err := c.Ingest(context.Background(), IngestRequest{DeviceID: "dev"}); err != nil {
+				t.Fatalf("Ingest failed: %v", err)
+			}
+
+			if v := got.Get(tt.wantHeader); v != tt.wantValue {
+				t.Errorf("Expected %s %q, got %q", tt.wantHeader, tt.wantValue, v)
+			}
+			if tt.wantHeader != "Authorization" && got.Get("Authorization") != "" {
+				t.Errorf("Expected no Authorization header with a custom header scheme, got %q", got.Get("Authorization"))
+			}
+		})
+	}
+}
+
+func TestClientWithoutTokenSendsNoAuth(t *testing.T) {
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "5s")
+	if err := c.Confirm(context.Background(), ConfirmRequest{HandshakeID: "hs", Status: StatusSuccess}); err != nil {
+		t.Fatalf("Confirm failed: %v", err)
+	}
+	if v := got.Get("Authorization"); v != "" {
+		t.Errorf("Expected no Authorization header without a token, got %q", v)
+	}
+}
+
+func TestAuthHeaderRejectsUnknownScheme(t *testing.T) {
+	if _, _, err := AuthHeader("digest", "tok"); err == nil {
+		t.Error("Expected an error for an unsupported scheme")
+	}
+	if _, _, err := AuthHeader("header:", "tok"); err == nil {
+		t.Error("Expected an error for a header scheme without a name")
+	}
+}
+
+func TestIsAuthRevoked(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "5s")
+	c.SetAuth("revoked", "")
+	_, err := c.Ingest(context.Background(), IngestRequest{DeviceID: "dev"})
+	if !IsAuthRevoked(err) {
+		t.Errorf("Expected a 401 to be reported as revoked credentials, got %v", err)
+	}
+	if IsRetryable(err) {
+		t.Error("Expected a 401 not to be retryable")
+	}
+	if !IsAuthRevoked(&StatusError{Op: "ingest request", StatusCode: http.StatusForbidden}) {
+		t.Error("Expected a 403 to be reported as revoked credentials")
+	}
+	if IsAuthRevoked(&StatusError{Op: "ingest request", StatusCode: http.StatusBadRequest}) || IsAuthRevoked(nil) {
+		t.Error("Expected only a 401 or 403 to be reported as revoked credentials")
+	}
+}
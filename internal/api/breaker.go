@@ -0,0 +1,160 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without contacting the API while the circuit breaker is open.
+var ErrCircuitOpen = errors.New("api circuit breaker is open")
+
+// BreakerState is the state of a CircuitBreaker.
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // Requests flow normally
+	BreakerOpen                         // Requests fail fast until the cooldown expires
+	BreakerHalfOpen                     // A single probe request tests whether the API recovered
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// CircuitBreaker stops calls to the API after consecutive failures so that a
+// down or recovering backend is not hammered by every worker. Only
+// unavailability counts as a failure (network errors, 5xx, 429); other 4xx
+// responses prove the API is up.
+type CircuitBreaker struct {
+	threshold int           // Consecutive failures that open the circuit
+	cooldown  time.Duration // How long the circuit stays open before probing
+
+	// OnStateChange, if set, is called (outside the lock) on every transition.
+	OnStateChange func(from, to BreakerState)
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool // A half-open probe is in flight
+	now      func() time.Time
+}
+
+// NewCircuitBreaker creates a breaker that opens after threshold consecutive
+// failures and probes again after cooldown.
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+	}
+}
+
+// State returns the current state. An open circuit whose cooldown has expired
+// is reported as half-open.
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen && b.now().Sub(b.openedAt) >= b.cooldown {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// Allow reports whether a call may proceed, returning ErrCircuitOpen if not.
+// After the cooldown, exactly one caller is let through as a probe.
+func (b *CircuitBreaker) Allow() error {
+	b.mu.Lock()
+	var from, to BreakerState
+	changed := false
+
+	switch b.state {
+	case BreakerOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			b.mu.Unlock()
+			return ErrCircuitOpen
+		}
+		from, to, changed = b.state, BreakerHalfOpen, true
+		b.state = BreakerHalfOpen
+		b.probing = true
+	case BreakerHalfOpen:
+		if b.probing {
+			b.mu.Unlock()
+			return ErrCircuitOpen
+		}
+		b.probing = true
+	}
+	b.mu.Unlock()
+
+	if changed {
+		b.notify(from, to)
+	}
+	return nil
+}
+
+// Record reports the outcome of an allowed call.
+func (b *CircuitBreaker) Record(success bool) {
+	b.mu.Lock()
+	from := b.state
+	b.probing = false
+	if success {
+		b.failures = 0
+		b.state = BreakerClosed
+	} else {
+		b.failures++
+		if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+			b.state = BreakerOpen
+			b.openedAt = b.now()
+		}
+	}
+	to := b.state
+	b.mu.Unlock()
+
+	if from != to {
+		b.notify(from, to)
+	}
+}
+
+func (b *CircuitBreaker) notify(from, to BreakerState) {
+	if b.OnStateChange != nil {
+		b.OnStateChange(from, to)
+	}
+}
+
+// SetCircuitBreaker makes all API calls of c go through b. Pass nil to disable.
+// The same breaker may be shared by several clients talking to the same API.
+func (c *Client) SetCircuitBreaker(b *CircuitBreaker) {
+	c.breaker = b
+}
+
+// Breaker returns the client's circuit breaker, or nil.
+func (c *Client) Breaker() *CircuitBreaker {
+	return c.breaker
+}
+
+// CircuitOpen reports whether calls are currently failing fast.
+func (c *Client) CircuitOpen() bool {
+	return c.breaker != nil && c.breaker.State() == BreakerOpen
+}
+
+// do sends an API request through the circuit breaker.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	if c.breaker == nil {
+		return c.HTTPClient.Do(req)
+	}
+	if err := c.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	resp, err := c.HTTPClient.Do(req)
+	c.breaker.Record(err == nil && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests)
+	return resp, err
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerFailsFastWhileOpen(t *testing.T) {
+	var hits int32
+	var healthy atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	var transitions []string
+	b := NewCircuitBreaker(3, 100*time.Millisecond)
+	b.OnStateChange = func(from, to BreakerState) {
+		transitions = append(transitions, from.String()+"->"+to.String())
+	}
+	c := NewClient(srv.URL, "5s")
+	c.SetCircuitBreaker(b)
+	confirm := func() error {
+		return c.Confirm(context.Background(), ConfirmRequest{HandshakeID: "hs", Status: StatusSuccess})
+	}
+
+	// Trip the breaker with consecutive 503s.
+	for i := 0; i < 3; i++ {
+		if err := confirm(); err == nil {
+			t.Fatal("Expected 503 to fail")
+		}
+	}
+	if !c.CircuitOpen() {
+		t.Fatalf("Expected circuit to be open after 3 failures, state=%s", b.State())
+	}
+
+	// While open, calls fail fast without reaching the server.
+	before := atomic.LoadInt32(&hits)
+	for i := 0; i < 5; i++ {
+		if err := confirm(); !errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("Expected ErrCircuitOpen, got %v", err)
+		}
+	}
+	if after := atomic.LoadInt32(&hits); after != before {
+		t.Errorf("Expected no requests while open, server got %d more", after-before)
+	}
+	if !IsRetryable(ErrCircuitOpen) {
+		t.Error("Expected ErrCircuitOpen to be retryable")
+	}
+
+	// After the cooldown a probe goes through and closes the circuit.
+	healthy.Store(true)
+	time.Sleep(150 * time.Millisecond)
+	if err := confirm(); err != nil {
+		t.Fatalf("Expected probe to succeed, got %v", err)
+	}
+	if s := b.State(); s != BreakerClosed {
+		t.Errorf("Expected circuit to close after a successful probe, got %s", s)
+	}
+
+	want := []string{"closed->open", "open->half-open", "half-open->closed"}
+	if len(transitions) != len(want) {
+		t.Fatalf("Expected transitions %v, got %v", want, transitions)
+	}
+	for i := range want {
+		if transitions[i] != want[i] {
+			t.Errorf("Expected transitions %v, got %v", want, transitions)
+			break
+		}
+	}
+}
+
+func TestCircuitBreakerIgnoresClientErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}))
+	defer srv.Close()
+
+	b := NewCircuitBreaker(2, time.Minute)
+	c := NewClient(srv.URL, "5s")
+	c.SetCircuitBreaker(b)
+
+	for i := 0; i < 5; i++ {
+		err := c.Confirm(context.Background(), ConfirmRequest{HandshakeID: "hs", Status: StatusSuccess})
+		if errors.Is(err, ErrCircuitOpen) {
+			t.Fatal("Expected 4xx responses not to open the circuit")
+		}
+	}
+}
@@ -6,10 +6,16 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
+	"mime"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -17,6 +23,11 @@ import (
 type Client struct {
 	BaseURL    string       // The root URL of the API
 	HTTPClient *http.Client // underlying http.Client with timeouts configured
+
+	auth     atomic.Pointer[credential] // Attached to authenticated calls, see SetAuth
+	breaker  *CircuitBreaker            // Optional, see SetCircuitBreaker
+	compress atomic.Bool                // Gzip large request bodies, see SetCompressRequests
+	logger   *slog.Logger               // Optional, see SetLogger
 }
 
 // NewClient creates a new API client with configured timeouts and connection pooling.
@@ -40,51 +51,77 @@ func NewClient(baseURL string, timeoutStr string) *Client {
 	}
 }
 
+// SetLogger makes c log the calls it makes at debug level, e.g. every pairing
+// status poll. Pass nil to disable.
+func (c *Client) SetLogger(logger *slog.Logger) {
+	c.logger = logger
+}
+
 // Ingest sends a request to initiate a file transfer.
 // Returns the IngestResponse containing the upload URL, or an error.
-func (c *Client) Ingest(req IngestRequest) (*IngestResponse, error) {
+func (c *Client) Ingest(ctx context.Context, req IngestRequest) (*IngestResponse, error) {
 	body, err := json.Marshal(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal ingest request: %w", err)
 	}
 
 	url := fmt.Sprintf("%s/v1/ingest/request", c.BaseURL)
-	resp, err := c.HTTPClient.Post(url, "application/json", bytes.NewBuffer(body))
+	resp, err := c.send(ctx, http.MethodPost, url, body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send ingest request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusCreated {
-		respBody, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("ingest request failed with status %d: %s", resp.StatusCode, string(respBody))
+		return nil, responseError("ingest request", resp)
 	}
 
 	var ingestResp IngestResponse
-	if err := json.NewDecoder(resp.Body).Decode(&ingestResp); err != nil {
-		return nil, fmt.Errorf("failed to decode ingest response: %w", err)
+	if err := decodeJSON(resp, "ingest response", &ingestResp); err != nil {
+		return nil, err
 	}
 
 	return &ingestResp, nil
 }
 
 // Confirm notifies the API about the outcome of the file upload (Success/Failure).
-func (c *Client) Confirm(req ConfirmRequest) error {
+func (c *Client) Confirm(ctx context.Context, req ConfirmRequest) error {
 	body, err := json.Marshal(req)
 	if err != nil {
 		return fmt.Errorf("failed to marshal confirm request: %w", err)
 	}
 
 	url := fmt.Sprintf("%s/v1/ingest/confirm", c.BaseURL)
-	resp, err := c.HTTPClient.Post(url, "application/json", bytes.NewBuffer(body))
+	resp, err := c.send(ctx, http.MethodPost, url, body)
 	if err != nil {
 		return fmt.Errorf("failed to send confirm request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		respBody, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("confirm request failed with status %d: %s", resp.StatusCode, string(respBody))
+		return responseError("confirm request", resp)
+	}
+
+	return nil
+}
+
+// CompleteMultipart asks the API to assemble the parts of a multipart upload.
+// It is called before Confirm once every part was uploaded.
+func (c *Client) CompleteMultipart(ctx context.Context, req CompleteMultipartRequest) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal complete multipart request: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/v1/ingest/multipart/complete", c.BaseURL)
+	resp, err := c.send(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return fmt.Errorf("failed to send complete multipart request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError("complete multipart request", resp)
 	}
 
 	return nil
@@ -107,12 +144,12 @@ func (c *Client) RequestPairingCode(deviceID string) (*PairingResponse, error) {
 
 	if resp.StatusCode != http.StatusOK {
 		respBody, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("pairing request failed with status %d: %s", resp.StatusCode, string(respBody))
+		return nil, &StatusError{Op: "pairing request", StatusCode: resp.StatusCode, Body: string(respBody)}
 	}
 
 	var pairingResp PairingResponse
-	if err := json.NewDecoder(resp.Body).Decode(&pairingResp); err != nil {
-		return nil, fmt.Errorf("failed to decode pairing response: %w", err)
+	if err := decodeJSON(resp, "pairing response", &pairingResp); err != nil {
+		return nil, err
 	}
 
 	return &pairingResp, nil
@@ -120,9 +157,11 @@ func (c *Client) RequestPairingCode(deviceID string) (*PairingResponse, error) {
 
 // CheckPairingStatus checks if the device has been claimed.
 func (c *Client) CheckPairingStatus(deviceID string, code string) (*PairingStatusResponse, error) {
-	url := fmt.Sprintf("%s/v1/pairing/status?device_id=%s&code=%s", c.BaseURL, deviceID, code)
-	fmt.Printf("DEBUG: Checking status at %s\n", url)
-	resp, err := c.HTTPClient.Get(url)
+	query := url.Values{"device_id": {deviceID}, "code": {code}}
+	if c.logger != nil {
+		c.logger.Debug("API: Checking pairing status", "device_id", deviceID)
+	}
+	resp, err := c.HTTPClient.Get(fmt.Sprintf("%s/v1/pairing/status?%s", c.BaseURL, query.Encode()))
 	if err != nil {
 		return nil, fmt.Errorf("failed to check pairing status: %w", err)
 	}
@@ -141,12 +180,12 @@ func (c *Client) CheckPairingStatus(deviceID string, code string) (*PairingStatu
 
 		respBody, _ := io.ReadAll(resp.Body)
 		// Explicitly print the status code for debugging in the error
-		return nil, fmt.Errorf("check pairing status failed with status %d: %s", resp.StatusCode, string(respBody))
+		return nil, &StatusError{Op: "check pairing status", StatusCode: resp.StatusCode, Body: string(respBody)}
 	}
 
 	var statusResp PairingStatusResponse
-	if err := json.NewDecoder(resp.Body).Decode(&statusResp); err != nil {
-		return nil, fmt.Errorf("failed to decode pairing status response: %w", err)
+	if err := decodeJSON(resp, "pairing status response", &statusResp); err != nil {
+		return nil, err
 	}
 
 	return &statusResp, nil
@@ -159,28 +198,81 @@ func (c *Client) UpdateDeviceMetadata(deviceID string, metadata map[string]inter
 		return nil, fmt.Errorf("failed to marshal metadata: %w", err)
 	}
 
-	url := fmt.Sprintf("%s/v1/devices/%s/metadata", c.BaseURL, deviceID)
-	req, err := http.NewRequest(http.MethodPatch, url, bytes.NewBuffer(body))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create patch request: %w", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := c.HTTPClient.Do(req)
+	resp, err := c.send(context.Background(), http.MethodPatch, fmt.Sprintf("%s/v1/devices/%s/metadata", c.BaseURL, url.PathEscape(deviceID)), body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send metadata update request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		respBody, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("metadata update failed with status %d: %s", resp.StatusCode, string(respBody))
+		return nil, responseError("metadata update", resp)
 	}
 
 	var deviceRead DeviceRead
-	if err := json.NewDecoder(resp.Body).Decode(&deviceRead); err != nil {
-		return nil, fmt.Errorf("failed to decode device read response: %w", err)
+	if err := decodeJSON(resp, "device read response", &deviceRead); err != nil {
+		return nil, err
 	}
 
 	return &deviceRead, nil
 }
+
+// ReportDirectory tells the backend that a directory was created in the
+// device's watch directory, e.g. a new capture session, before files land in it.
+func (c *Client) ReportDirectory(deviceID string, event DirectoryEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal directory event: %w", err)
+	}
+
+	resp, err := c.send(context.Background(), http.MethodPost, fmt.Sprintf("%s/v1/devices/%s/directories", c.BaseURL, url.PathEscape(deviceID)), body)
+	if err != nil {
+		return fmt.Errorf("failed to send directory report: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+		return responseError("directory report", resp)
+	}
+	return nil
+}
+
+// Limits for reading API response bodies.
+const (
+	maxResponseBytes = 1 << 20 // Largest JSON response accepted
+	maxBodySnippet   = 200     // Bytes of an unexpected body quoted in errors
+)
+
+// decodeJSON reads a JSON response body into v. A body that is not JSON and not
+// declared as JSON (e.g. an HTML error page served with 200 by a proxy) yields a
+// *ContentTypeError quoting the start of the body instead of a bare decode error.
+func decodeJSON(resp *http.Response, what string, v any) error {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", what, err)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if !isJSONContentType(contentType) && !json.Valid(body) {
+		return &ContentTypeError{Op: what, StatusCode: resp.StatusCode, ContentType: contentType, Body: bodySnippet(body)}
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("failed to decode %s: %w (body: %s)", what, err, bodySnippet(body))
+	}
+	return nil
+}
+
+// isJSONContentType reports whether a Content-Type header declares JSON
+// ("application/json" or a "+json" type).
+func isJSONContentType(value string) bool {
+	mediaType, _, err := mime.ParseMediaType(value)
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
+
+// bodySnippet returns the start of body for error messages.
+func bodySnippet(body []byte) string {
+	s := strings.TrimSpace(string(body))
+	if len(s) > maxBodySnippet {
+		s = strings.ToValidUTF8(s[:maxBodySnippet], "") + "..."
+	}
+	return s
+}
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIngestRejectsHTMLResponse(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("<html><body><h1>Proxy login required</h1>" + strings.Repeat("x", 500) + "</body></html>"))
+	}))
+	defer srv.Close()
+
+	_, err := NewClient(srv.URL, "5s").Ingest(context.Background(), IngestRequest{DeviceID: "dev"})
+	var ctErr *ContentTypeError
+	if !errors.As(err, &ctErr) {
+		t.Fatalf("Expected a ContentTypeError, got %v", err)
+	}
+	if ctErr.ContentType != "text/html; charset=utf-8" || !strings.HasPrefix(ctErr.Body, "<html><body><h1>Proxy login required") {
+		t.Errorf("Expected the content type and the start of the body, got %+v", ctErr)
+	}
+	if len(ctErr.Body) > maxBodySnippet+3 {
+		t.Errorf("Expected the body to be truncated, got %d bytes", len(ctErr.Body))
+	}
+	if !strings.Contains(err.Error(), "non-JSON") {
+		t.Errorf("Expected a clear error message, got %q", err)
+	}
+}
+
+func TestIngestAcceptsUndeclaredJSON(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// No Content-Type: net/http sniffs it as text/plain.
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"handshake_id":"hs-1","upload_url":"http://storage/upload"}`))
+	}))
+	defer srv.Close()
+
+	resp, err := NewClient(srv.URL, "5s").Ingest(context.Background(), IngestRequest{DeviceID: "dev"})
+	if err != nil {
+		t.Fatalf("Expected a JSON body without a JSON content type to decode, got %v", err)
+	}
+	if resp.HandshakeID != "hs-1" {
+		t.Errorf("Unexpected response %+v", resp)
+	}
+}
+
+func TestIngestCompressesLargeRequests(t *testing.T) {
+	var encoding string
+	var got IngestRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding = r.Header.Get("Content-Encoding")
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Errorf("Expected a gzip body: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err := json.NewDecoder(zr).Decode(&got); err != nil {
+			t.Errorf("Failed to decode the request: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"handshake_id":"hs-1","upload_url":"http://storage/upload"}`))
+	}))
+	defer srv.Close()
+
+	metadata := make(map[string]string)
+	for n := 0; n < 100; n++ {
+		metadata[fmt.Sprintf("key%d", n)] = strings.Repeat("v", 50)
+	}
+	client := NewClient(srv.URL, "5s")
+	client.SetCompressRequests(true)
+	if _, err := client.Ingest(context.Background(), IngestRequest{DeviceID: "dev", Metadata: metadata}); err != nil {
+		t.Fatal(err)
+	}
+	if encoding != "gzip" {
+		t.Errorf("Expected Content-Encoding gzip, got %q", encoding)
+	}
+	if len(got.Metadata) != 100 {
+		t.Errorf("Expected the metadata to survive compression, got %d keys", len(got.Metadata))
+	}
+}
+
+func TestIngestFallsBackToUncompressedOn415(t *testing.T) {
+	var encodings []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encodings = append(encodings, r.Header.Get("Content-Encoding"))
+		if r.Header.Get("Content-Encoding") != "" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"handshake_id":"hs-1","upload_url":"http://storage/upload"}`))
+	}))
+	defer srv.Close()
+
+	client := NewClient(srv.URL, "5s")
+	client.SetCompressRequests(true)
+	req := IngestRequest{DeviceID: "dev", Metadata: map[string]string{"notes": strings.Repeat("x", 2000)}}
+	for n := 0; n < 2; n++ {
+		if _, err := client.Ingest(context.Background(), req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []string{"gzip", "", ""}; strings.Join(encodings, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected one gzip attempt and plain requests afterwards, got %q", encodings)
+	}
+}
+
+func TestMaintenanceResponses(t *testing.T) {
+	tests := []struct {
+		name        string
+		header      http.Header
+		body        string
+		maintenance bool
+		retryAfter  time.Duration
+	}{
+		{"header", http.Header{"X-Maintenance": {"true"}, "Retry-After": {"120"}}, "", true, 2 * time.Minute},
+		{"body flag", http.Header{"Content-Type": {"application/json"}}, `{"maintenance": true}`, true, 0},
+		{"plain 503", nil, "overloaded", false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for k, v := range tt.header {
+					w.Header()[k] = v
+				}
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			_, err := NewClient(srv.URL, "5s").Ingest(context.Background(), IngestRequest{DeviceID: "dev"})
+			var m *MaintenanceError
+			if errors.As(err, &m) != tt.maintenance {
+				t.Fatalf("Expected maintenance=%v, got %v", tt.maintenance, err)
+			}
+			if tt.maintenance && m.RetryAfter != tt.retryAfter {
+				t.Errorf("Expected retry after %v, got %v", tt.retryAfter, m.RetryAfter)
+			}
+			if !IsRetryable(err) {
+				t.Errorf("Expected a 503 to be retryable, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCheckPairingStatusEscapesQuery(t *testing.T) {
+	var deviceID, code string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deviceID, code = r.URL.Query().Get("device_id"), r.URL.Query().Get("code")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"WAITING"}`))
+	}))
+	defer srv.Close()
+
+	client := NewClient(srv.URL, "5s")
+	for _, id := range []string{"b8:27:eb:12:34:56", "cam+1:b8:27", "cam 1&code=x"} {
+		if _, err := client.CheckPairingStatus(id, "AB+C#1"); err != nil {
+			t.Fatal(err)
+		}
+		if deviceID != id || code != "AB+C#1" {
+			t.Errorf("Expected device ID %q and code %q to arrive unchanged, got %q and %q", id, "AB+C#1", deviceID, code)
+		}
+	}
+}
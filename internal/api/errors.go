@@ -0,0 +1,155 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// StatusError is returned when the API answers with an unexpected HTTP status.
+type StatusError struct {
+	Op         string // The failed operation, e.g. "ingest request"
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s failed with status %d: %s", e.Op, e.StatusCode, e.Body)
+}
+
+// ContentTypeError is returned when the API answers with a body that is not
+// JSON, typically an HTML error page from a proxy or captive portal.
+type ContentTypeError struct {
+	Op          string // The failed operation, e.g. "ingest response"
+	StatusCode  int
+	ContentType string
+	Body        string // The start of the body
+}
+
+func (e *ContentTypeError) Error() string {
+	return fmt.Sprintf("%s: backend returned non-JSON response (status %d, Content-Type %q): %s", e.Op, e.StatusCode, e.ContentType, e.Body)
+}
+
+// MaintenanceError is returned when the API answers 503 and signals planned
+// maintenance, with an "X-Maintenance: true" header or a {"maintenance": true}
+// body. Uploads should pause for RetryAfter rather than count as failures.
+type MaintenanceError struct {
+	Op         string        // The failed operation, e.g. "ingest request"
+	RetryAfter time.Duration // Pause suggested by the Retry-After header, 0 if none
+	Body       string
+}
+
+func (e *MaintenanceError) Error() string {
+	return fmt.Sprintf("%s: backend is in maintenance (retry after %s): %s", e.Op, e.RetryAfter, e.Body)
+}
+
+// IsMaintenance reports whether err signals backend maintenance, see MaintenanceError.
+func IsMaintenance(err error) bool {
+	var m *MaintenanceError
+	return errors.As(err, &m)
+}
+
+// responseError reads an unexpected response into a *MaintenanceError or a *StatusError.
+func responseError(op string, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if resp.StatusCode == http.StatusServiceUnavailable && isMaintenance(resp.Header, body) {
+		return &MaintenanceError{Op: op, RetryAfter: retryAfter(resp.Header.Get("Retry-After"), time.Now()), Body: bodySnippet(body)}
+	}
+	return &StatusError{Op: op, StatusCode: resp.StatusCode, Body: string(body)}
+}
+
+// isMaintenance reports whether a 503 response flags maintenance in its header or JSON body.
+func isMaintenance(header http.Header, body []byte) bool {
+	if v, err := strconv.ParseBool(header.Get("X-Maintenance")); err == nil && v {
+		return true
+	}
+	var flag struct {
+		Maintenance bool `json:"maintenance"`
+	}
+	return json.Unmarshal(body, &flag) == nil && flag.Maintenance
+}
+
+// retryAfter parses a Retry-After value given in seconds or as an HTTP date.
+// It returns 0 if the value is missing, invalid or in the past.
+func retryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(secs)*time.Second, 0)
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(t.Sub(now), 0)
+	}
+	return 0
+}
+
+// IsRetryable reports whether err is a transient condition worth retrying later:
+// DNS resolution failures (common on boot before the resolver is ready), timeouts,
+// connection-level network errors, 5xx/408/429 responses and unavailable gRPC backends.
+// Other API errors (4xx) are considered permanent.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrCircuitOpen) || IsMaintenance(err) {
+		return true
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500 ||
+			statusErr.StatusCode == http.StatusRequestTimeout ||
+			statusErr.StatusCode == http.StatusTooManyRequests
+	}
+
+	if retryable, ok := isRetryableGRPC(err); ok {
+		return retryable
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	var opErr *net.OpError
+	return errors.As(err, &opErr)
+}
+
+// IsAuthRevoked reports whether the API rejected the device's credentials
+// (HTTP 401/403 or gRPC Unauthenticated/PermissionDenied), e.g. because its
+// token was revoked. The device has to be paired again; retrying does not help.
+// Presigned uploads to object storage do not return a StatusError, so their
+// 403s are not mistaken for this.
+func IsAuthRevoked(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusUnauthorized || statusErr.StatusCode == http.StatusForbidden
+	}
+	return isAuthRevokedGRPC(err)
+}
+
+// Retry calls fn until it succeeds, returns a non-retryable error, or attempts are exhausted.
+// The wait between attempts starts at backoff and doubles each time.
+func Retry(attempts int, backoff time.Duration, fn func() error) error {
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if err = fn(); err == nil || !IsRetryable(err) {
+			return err
+		}
+		if attempt < attempts-1 {
+			time.Sleep(backoff << attempt)
+		}
+	}
+	return err
+}
@@ -0,0 +1,232 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/propagation"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// IngestServiceName is the gRPC service for streaming uploads. Proto equivalent:
+//
+//	service IngestService {
+//	  rpc Upload(stream UploadChunk) returns (UploadAck);
+//	}
+//
+//	message UploadChunk {
+//	  IngestRequest request = 1; // Only set on the first message
+//	  bytes data = 2;            // File content, in order
+//	}
+//
+//	message UploadAck {
+//	  bool accepted = 1;
+//	  string uploaded_path = 2;
+//	  string error = 3;
+//	}
+//
+// Messages are encoded as JSON (content-subtype "json") so that the structs in
+// models.go are shared with the HTTP API and no generated code is needed.
+const IngestServiceName = "fsd.ingest.v1.IngestService"
+
+// GRPCChunkSize is the number of file bytes sent per UploadChunk.
+const GRPCChunkSize = 256 * 1024
+
+// jsonCodec encodes gRPC messages as JSON.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                       { return "json" }
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// IngestServiceServer is implemented by backends accepting gRPC uploads.
+type IngestServiceServer interface {
+	Upload(stream IngestUploadServer) error
+}
+
+// IngestUploadServer is the server side of an Upload stream.
+type IngestUploadServer interface {
+	Recv() (*UploadChunk, error)
+	SendAndClose(*UploadAck) error
+	Context() context.Context
+}
+
+type ingestUploadServer struct {
+	grpc.ServerStream
+}
+
+func (s *ingestUploadServer) Recv() (*UploadChunk, error) {
+	chunk := new(UploadChunk)
+	if err := s.RecvMsg(chunk); err != nil {
+		return nil, err
+	}
+	return chunk, nil
+}
+
+func (s *ingestUploadServer) SendAndClose(ack *UploadAck) error {
+	return s.SendMsg(ack)
+}
+
+// IngestServiceDesc describes IngestService for grpc.Server.RegisterService.
+var IngestServiceDesc = grpc.ServiceDesc{
+	ServiceName: IngestServiceName,
+	HandlerType: (*IngestServiceServer)(nil),
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName: "Upload",
+			Handler: func(srv any, stream grpc.ServerStream) error {
+				return srv.(IngestServiceServer).Upload(&ingestUploadServer{stream})
+			},
+			ClientStreams: true,
+		},
+	},
+}
+
+// RegisterIngestServiceServer registers an IngestService implementation.
+func RegisterIngestServiceServer(s *grpc.Server, srv IngestServiceServer) {
+	s.RegisterService(&IngestServiceDesc, srv)
+}
+
+// GRPCClient streams files to an IngestService backend.
+type GRPCClient struct {
+	conn *grpc.ClientConn
+
+	auth atomic.Pointer[credential] // Sent as stream metadata, see SetAuth
+}
+
+// SetAuth configures the credentials sent with every upload stream, using the
+// same schemes as the HTTP client (see AuthHeader). An empty token disables it.
+func (c *GRPCClient) SetAuth(token, scheme string) {
+	c.auth.Store(&credential{token: token, scheme: scheme})
+}
+
+// NewGRPCClient creates a client for target (host:port). TLS is used unless
+// insecureTransport is set. The connection is established lazily.
+func NewGRPCClient(target string, insecureTransport bool) (*GRPCClient, error) {
+	if target == "" {
+		return nil, errors.New("grpc endpoint is not configured")
+	}
+
+	creds := credentials.NewTLS(&tls.Config{})
+	if insecureTransport {
+		creds = insecure.NewCredentials()
+	}
+
+	conn, err := grpc.NewClient(target,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithDefaultCallOptions(grpc.CallContentSubtype(jsonCodec{}.Name())),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create grpc client for %s: %w", target, err)
+	}
+	return &GRPCClient{conn: conn}, nil
+}
+
+// Upload streams req followed by the content of r and waits for the backend's ack.
+// A rejected upload (Accepted == false) is returned as an error.
+func (c *GRPCClient) Upload(ctx context.Context, req IngestRequest, r io.Reader) (*UploadAck, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // Aborts the stream if we bail out before the ack
+
+	if cred := c.auth.Load(); cred != nil && cred.token != "" {
+		name, value, err := AuthHeader(cred.scheme, cred.token)
+		if err != nil {
+			return nil, err
+		}
+		ctx = metadata.AppendToOutgoingContext(ctx, strings.ToLower(name), value)
+	}
+	trace := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, trace)
+	for k, v := range trace {
+		ctx = metadata.AppendToOutgoingContext(ctx, k, v)
+	}
+
+	stream, err := c.conn.NewStream(ctx, &IngestServiceDesc.Streams[0], "/"+IngestServiceName+"/Upload")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open upload stream: %w", err)
+	}
+
+	if err := stream.SendMsg(&UploadChunk{Request: &req}); err != nil {
+		return nil, fmt.Errorf("failed to send ingest request: %w", closeAndRecvErr(stream, err))
+	}
+
+	buf := make([]byte, GRPCChunkSize)
+	for {
+		n, readErr := r.Read(buf)
+		if n > 0 {
+			if err := stream.SendMsg(&UploadChunk{Data: buf[:n]}); err != nil {
+				return nil, fmt.Errorf("failed to send chunk: %w", closeAndRecvErr(stream, err))
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return nil, fmt.Errorf("failed to read file: %w", readErr)
+		}
+	}
+
+	if err := stream.CloseSend(); err != nil {
+		return nil, fmt.Errorf("failed to close upload stream: %w", err)
+	}
+	ack := new(UploadAck)
+	if err := stream.RecvMsg(ack); err != nil {
+		return nil, fmt.Errorf("failed to receive upload ack: %w", err)
+	}
+	if !ack.Accepted {
+		return nil, fmt.Errorf("upload rejected by backend: %s", ack.Error)
+	}
+	return ack, nil
+}
+
+// closeAndRecvErr returns the stream's real status when a send fails with io.EOF
+// (gRPC reports server-side errors on the receive path).
+func closeAndRecvErr(stream grpc.ClientStream, err error) error {
+	if err != io.EOF {
+		return err
+	}
+	if recvErr := stream.RecvMsg(new(UploadAck)); recvErr != nil {
+		return recvErr
+	}
+	return err
+}
+
+// Close releases the underlying connection.
+func (c *GRPCClient) Close() error {
+	return c.conn.Close()
+}
+
+// isAuthRevokedGRPC reports whether err is a gRPC Unauthenticated or PermissionDenied status.
+func isAuthRevokedGRPC(err error) bool {
+	st, ok := status.FromError(err)
+	return ok && err != nil && (st.Code() == codes.Unauthenticated || st.Code() == codes.PermissionDenied)
+}
+
+// isRetryableGRPC reports whether a gRPC status indicates a transient condition.
+func isRetryableGRPC(err error) (retryable, ok bool) {
+	st, ok := status.FromError(err)
+	if !ok {
+		return false, false
+	}
+	switch st.Code() {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted:
+		return true, true
+	}
+	return false, true
+}
@@ -7,39 +7,80 @@ import (
 // IngestRequest represents the payload for initiating a file ingestion.
 // It contains metadata about the file and the device context.
 type IngestRequest struct {
-	DeviceID        string                 `json:"device_id"`         // Unique identifier for the edge device
-	Filename        string                 `json:"filename"`          // Name of the file being uploaded
-	FileSizeBytes   int64                  `json:"file_size_bytes"`   // Size of the file in bytes
-	SHA256Checksum  string                 `json:"sha256_checksum"`   // SHA256 hash for integrity verification
-	FilePathContext []string               `json:"file_path_context"` // Contextual tags (e.g., directory structure: ["cam1", "2023"])
-	DeviceContext   map[string]interface{} `json:"device_context"`    // Device specific context
-	Metadata        map[string]string      `json:"metadata"`          // Key-value pairs of extracted metadata
-	Timestamp       time.Time              `json:"timestamp"`         // Time of capture/ingest
+	DeviceID        string                 `json:"device_id"`                       // Unique identifier for the edge device
+	Filename        string                 `json:"filename"`                        // Name of the file being uploaded
+	FileSizeBytes   int64                  `json:"file_size_bytes"`                 // Size of the file in bytes
+	SHA256Checksum  string                 `json:"sha256_checksum"`                 // SHA256 hash for integrity verification
+	ChecksumMode    ChecksumMode           `json:"checksum_mode"`                   // What SHA256Checksum covers, so the backend verifies it the same way
+	ChecksumPrefix  int64                  `json:"checksum_prefix_bytes,omitempty"` // Bytes hashed in ChecksumModePrefix
+	ContentType     string                 `json:"content_type"`                    // MIME type of the file, also sent with the upload PUT
+	FilePathContext []string               `json:"file_path_context"`               // Contextual tags (e.g., directory structure: ["cam1", "2023"])
+	DeviceContext   map[string]interface{} `json:"device_context"`                  // Device specific context
+	Metadata        map[string]string      `json:"metadata"`                        // Key-value pairs of extracted metadata
+	Timestamp       time.Time              `json:"timestamp"`                       // Time of capture: the sidecar's "timestamp", else the file's mod time
+	UploadedAt      time.Time              `json:"uploaded_at"`                     // Time the upload was requested
+	Multipart       bool                   `json:"multipart,omitempty"`             // Ask for part URLs instead of a single UploadURL (large files)
 }
 
+// ChecksumMode defines what an IngestRequest's SHA256Checksum was computed over.
+type ChecksumMode string
+
+const (
+	ChecksumModeFull ChecksumMode = "full" // The whole file content
+	// The first ChecksumPrefix bytes of the content (all of it for smaller
+	// files) followed by the file size as a big-endian uint64. A fingerprint
+	// for very large files rather than an integrity check.
+	ChecksumModePrefix ChecksumMode = "prefix"
+)
+
 // IngestResponse represents the API response after a successful IngestRequest.
 // It provides the URL to upload the actual file content.
 type IngestResponse struct {
 	HandshakeID string    `json:"handshake_id"` // Unique session ID for this upload transaction
 	UploadURL   string    `json:"upload_url"`   // Presigned URL (e.g., S3) for putting the file
 	ExpiresAt   time.Time `json:"expires_at"`   // Expiration time for the UploadURL
+
+	// Set instead of UploadURL when a multipart upload was requested and granted:
+	// part N (1-based) covers bytes [(N-1)*PartSizeBytes, N*PartSizeBytes) and is
+	// PUT to PartURLs[N-1]. The upload is finalized with CompleteMultipart.
+	PartURLs      []string `json:"part_urls,omitempty"`
+	PartSizeBytes int64    `json:"part_size_bytes,omitempty"`
+}
+
+// MultipartPart identifies one uploaded part of a multipart upload.
+type MultipartPart struct {
+	PartNumber int    `json:"part_number"` // 1-based part index
+	ETag       string `json:"etag"`        // ETag returned by the storage endpoint for the part PUT
+}
+
+// CompleteMultipartRequest asks the API to assemble the uploaded parts.
+type CompleteMultipartRequest struct {
+	HandshakeID string          `json:"handshake_id"` // The session ID received in IngestResponse
+	Parts       []MultipartPart `json:"parts"`        // All parts, in order
 }
 
 // IngestStatus defines the final status of the ingestion process.
 type IngestStatus string
 
 const (
-	StatusSuccess IngestStatus = "INGESTED"
-	StatusFailed  IngestStatus = "FAILED"
+	StatusSuccess   IngestStatus = "INGESTED"
+	StatusFailed    IngestStatus = "FAILED"
+	StatusDuplicate IngestStatus = "DUPLICATE" // Not uploaded: the content is already ingested as DuplicateOf
 )
 
 // ConfirmRequest represents the payload to finalize the ingestion transaction.
 // It tells the API whether the file upload to the UploadURL was successful.
 type ConfirmRequest struct {
 	HandshakeID  string       `json:"handshake_id"`            // The session ID received in IngestResponse
-	Status       IngestStatus `json:"status"`                  // SUCCESS or FAILED
+	Status       IngestStatus `json:"status"`                  // INGESTED, FAILED or DUPLICATE
 	ErrorMessage *string      `json:"error_message"`           // Error details if Status is FAILED, nullable
 	UploadedPath *string      `json:"uploaded_path,omitempty"` // The resulting path/key in cloud storage, optional
+
+	// Parts already stored when a multipart upload FAILED, so the server can abort it cleanly
+	UploadedParts []MultipartPart `json:"uploaded_parts,omitempty"`
+
+	// Path (relative to the watch directory) of the file already ingested with the same content, if Status is DUPLICATE
+	DuplicateOf string `json:"duplicate_of,omitempty"`
 }
 
 // PairingRequest represents the payload to request a pairing code.
@@ -68,6 +109,12 @@ type PairingStatusResponse struct {
 	APIKey *string       `json:"apikey"` // The API Key if claimed
 }
 
+// DirectoryEvent reports a directory created in the watch directory.
+type DirectoryEvent struct {
+	Path      string    `json:"path"`       // Slash-separated path relative to the watch directory
+	CreatedAt time.Time `json:"created_at"` // When the daemon noticed the directory
+}
+
 // DeviceRead represents the device information returned by the API.
 type DeviceRead struct {
 	DeviceID  string                 `json:"device_id"`
@@ -75,3 +122,17 @@ type DeviceRead struct {
 	IsActive  bool                   `json:"is_active"`
 	Metadata  map[string]interface{} `json:"metadata"`
 }
+
+// UploadChunk is one message of a gRPC Upload stream (see IngestServiceName).
+// The first message carries the ingest request, the following ones the file content.
+type UploadChunk struct {
+	Request *IngestRequest `json:"request,omitempty"` // Ingest metadata, first message only
+	Data    []byte         `json:"data,omitempty"`    // File content chunk
+}
+
+// UploadAck is the backend's response to a gRPC Upload stream.
+type UploadAck struct {
+	Accepted     bool   `json:"accepted"`                // Whether the file was stored
+	UploadedPath string `json:"uploaded_path,omitempty"` // The resulting path/key in cloud storage, optional
+	Error        string `json:"error,omitempty"`         // Reason for rejection
+}
@@ -0,0 +1,204 @@
+package cli
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+
+	"fs-ingest-daemon/internal/config"
+	"fs-ingest-daemon/internal/store"
+	"fs-ingest-daemon/internal/sysinfo"
+
+	"github.com/spf13/cobra"
+)
+
+// maxLogTailBytes is how much of the end of each log file is included in a bundle.
+const maxLogTailBytes = 256 * 1024
+
+// redacted replaces secret config values in a bundle.
+const redacted = "[REDACTED]"
+
+// BundleCmd collects config, logs, DB stats and system info into a zip for support.
+func BundleCmd(cfgPath, logPath string) *cobra.Command {
+	var out string
+
+	cmd := &cobra.Command{
+		Use:   "bundle",
+		Short: "Create a diagnostic bundle (zip) for support",
+		Run: func(cmd *cobra.Command, args []string) {
+			cfg, err := config.Load(cfgPath)
+			if err != nil {
+				fmt.Fprintf(cmd.OutOrStdout(), "Failed to load config: %v\n", err)
+				return
+			}
+
+			f, err := os.Create(out)
+			if err != nil {
+				fmt.Fprintf(cmd.OutOrStdout(), "Failed to create %s: %v\n", out, err)
+				return
+			}
+			defer f.Close()
+
+			if err := writeBundle(f, cfg, logPath); err != nil {
+				fmt.Fprintf(cmd.OutOrStdout(), "Failed to write bundle: %v\n", err)
+				return
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Diagnostic bundle written to %s\n", out)
+		},
+	}
+
+	cmd.Flags().StringVar(&out, "out", "fsd-bundle.zip", "Path of the zip file to create")
+	return cmd
+}
+
+// writeBundle writes the diagnostic zip to w. Sections that cannot be collected
+// are replaced by a note so a partial bundle is still useful.
+func writeBundle(w io.Writer, cfg *config.Config, logPath string) error {
+	zw := zip.NewWriter(w)
+
+	add := func(name string, data []byte) error {
+		fw, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		_, err = fw.Write(data)
+		return err
+	}
+
+	cfgJSON, err := redactedConfig(cfg)
+	if err != nil {
+		cfgJSON = []byte(fmt.Sprintf("failed to encode config: %v\n", err))
+	}
+	if err := add("config.json", cfgJSON); err != nil {
+		return err
+	}
+
+	if err := add("version.txt", []byte(versionInfo())); err != nil {
+		return err
+	}
+
+	var stats bytes.Buffer
+	if s, err := store.NewStore(cfg.DBPath); err != nil {
+		fmt.Fprintf(&stats, "Failed to open store at %s: %v\n", cfg.DBPath, err)
+	} else {
+		writeStats(&stats, s)
+		s.Close()
+	}
+	if err := add("stats.txt", stats.Bytes()); err != nil {
+		return err
+	}
+
+	var sysJSON []byte
+	if info, err := sysinfo.Collect(); err != nil {
+		sysJSON = []byte(fmt.Sprintf("failed to collect sysinfo: %v\n", err))
+	} else if sysJSON, err = json.MarshalIndent(info, "", "  "); err != nil {
+		sysJSON = []byte(fmt.Sprintf("failed to encode sysinfo: %v\n", err))
+	}
+	if err := add("sysinfo.json", sysJSON); err != nil {
+		return err
+	}
+
+	for _, name := range logFiles(logPath) {
+		tail, err := readTail(name, maxLogTailBytes)
+		if err != nil {
+			tail = []byte(fmt.Sprintf("failed to read log: %v\n", err))
+		}
+		if err := add("logs/"+filepath.Base(name), tail); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// redactedConfig encodes cfg with secrets (tokens, keys, passwords) replaced.
+// Keys are matched by name so secrets added to Config later are covered too.
+func redactedConfig(cfg *config.Config) ([]byte, error) {
+	raw, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	for k, v := range fields {
+		if s, ok := v.(string); ok && s != "" && isSecretKey(k) {
+			fields[k] = redacted
+		}
+	}
+	return json.MarshalIndent(fields, "", "  ")
+}
+
+// isSecretKey reports whether a config key holds a credential.
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, marker := range []string{"token", "key", "secret", "password"} {
+		if strings.Contains(key, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// versionInfo describes the running binary.
+func versionInfo() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "go: %s\nos/arch: %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	if info, ok := debug.ReadBuildInfo(); ok {
+		fmt.Fprintf(&b, "module: %s %s\n", info.Main.Path, info.Main.Version)
+		for _, setting := range info.Settings {
+			if strings.HasPrefix(setting.Key, "vcs.") {
+				fmt.Fprintf(&b, "%s: %s\n", setting.Key, setting.Value)
+			}
+		}
+	}
+	return b.String()
+}
+
+// logFiles returns the current log and its uncompressed rotated backups, oldest first.
+func logFiles(logPath string) []string {
+	if logPath == "" {
+		return nil
+	}
+	ext := filepath.Ext(logPath)
+	prefix := strings.TrimSuffix(logPath, ext)
+	backups, _ := filepath.Glob(prefix + "-*" + ext)
+	sort.Strings(backups)
+
+	var files []string
+	for _, name := range append(backups, logPath) {
+		if _, err := os.Stat(name); err == nil {
+			files = append(files, name)
+		}
+	}
+	return files
+}
+
+// readTail returns up to max bytes from the end of a file.
+func readTail(path string, max int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > max {
+		if _, err := f.Seek(-max, io.SeekEnd); err != nil {
+			return nil, err
+		}
+	}
+	return io.ReadAll(f)
+}
@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"fs-ingest-daemon/internal/config"
+)
+
+func TestWriteBundleRedactsToken(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "cli_bundle_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	logPath := filepath.Join(tmpDir, "fsd.log")
+	if err := os.WriteFile(logPath, []byte("level=INFO msg=\"Upload success\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{
+		DeviceID:  "dev-001",
+		DBPath:    filepath.Join(tmpDir, "fsd.db"),
+		WatchPath: filepath.Join(tmpDir, "data"),
+		AuthToken: "super-secret-token",
+	}
+
+	var buf bytes.Buffer
+	if err := writeBundle(&buf, cfg, logPath); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		entries[f.Name] = string(data)
+	}
+
+	for _, name := range []string{"config.json", "version.txt", "stats.txt", "sysinfo.json", "logs/fsd.log"} {
+		if _, ok := entries[name]; !ok {
+			t.Errorf("Expected bundle to contain %s", name)
+		}
+	}
+
+	for name, content := range entries {
+		if strings.Contains(content, "super-secret-token") {
+			t.Errorf("Expected auth token to be redacted, found it in %s", name)
+		}
+	}
+	if !strings.Contains(entries["config.json"], redacted) {
+		t.Errorf("Expected config.json to mark the token as redacted, got:\n%s", entries["config.json"])
+	}
+	if !strings.Contains(entries["config.json"], "dev-001") {
+		t.Errorf("Expected config.json to keep non-secret fields, got:\n%s", entries["config.json"])
+	}
+	if !strings.Contains(entries["logs/fsd.log"], "Upload success") {
+		t.Errorf("Expected log tail in bundle, got:\n%s", entries["logs/fsd.log"])
+	}
+}
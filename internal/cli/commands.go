@@ -5,15 +5,17 @@ import (
 	"io"
 	"log/slog"
 	"os"
+	"time"
 
 	"fs-ingest-daemon/internal/config"
+	"fs-ingest-daemon/internal/daemon"
 
 	"github.com/kardianos/service"
 	"github.com/spf13/cobra"
 )
 
 // NewRootCmd creates the root command and all subcommands for the CLI.
-func NewRootCmd(s service.Service, logger *slog.Logger, logPath string, cfgPath string) *cobra.Command {
+func NewRootCmd(s service.Service, dmn *daemon.Daemon, logger *slog.Logger, logPath string, cfgPath string) *cobra.Command {
 	var rootCmd = &cobra.Command{
 		Use:   "fsd",
 		Short: "FS Ingest Daemon CLI",
@@ -84,10 +86,25 @@ func NewRootCmd(s service.Service, logger *slog.Logger, logPath string, cfgPath
 		},
 	}
 
+	var runOnce bool
+	var maxDuration time.Duration
 	var runCmd = &cobra.Command{
 		Use:   "run",
 		Short: "Run the service in foreground",
 		Run: func(cmd *cobra.Command, args []string) {
+			if runOnce {
+				// Scan, drain the backlog and exit (cron/serverless style).
+				if err := dmn.RunOnce(maxDuration); err != nil {
+					if logger != nil {
+						logger.Error("Run once error", "error", err)
+					} else {
+						fmt.Printf("Run once error: %v\n", err)
+					}
+					os.Exit(1)
+				}
+				return
+			}
+
 			err := s.Run()
 			if err != nil {
 				if logger != nil {
@@ -99,6 +116,9 @@ func NewRootCmd(s service.Service, logger *slog.Logger, logPath string, cfgPath
 		},
 	}
 
+	runCmd.Flags().BoolVar(&runOnce, "once", false, "Scan, upload pending files and exit instead of running as a service")
+	runCmd.Flags().DurationVar(&maxDuration, "max-duration", 0, "With --once, exit after this long even if files are still pending (0 = no limit)")
+
 	var statusCmd = &cobra.Command{
 		Use:   "status",
 		Short: "Show service status",
@@ -116,6 +136,7 @@ func NewRootCmd(s service.Service, logger *slog.Logger, logPath string, cfgPath
 			default:
 				fmt.Println("Unknown/Other")
 			}
+			writeStoreStatus(cmd.OutOrStdout(), cfgPath)
 		},
 	}
 
@@ -143,6 +164,7 @@ func NewRootCmd(s service.Service, logger *slog.Logger, logPath string, cfgPath
 	rootCmd.AddCommand(
 		InstallCmd(s),
 		ServiceInstallCmd(s), // Hidden command for self-registration
+		PairCmd(cfgPath),
 		uninstallCmd,
 		startCmd,
 		stopCmd,
@@ -151,6 +173,20 @@ func NewRootCmd(s service.Service, logger *slog.Logger, logPath string, cfgPath
 		statusCmd,
 		logsCmd,
 		SimulateCmd(logger),
+		ForgetCmd(logger, cfgPath),
+		ListCmd(cfgPath),
+		StatsCmd(cfgPath),
+		HistoryCmd(cfgPath),
+		DeadLetterCmd(cfgPath),
+		DBCmd(cfgPath),
+		ScanCmd(cfgPath),
+		KeygenCmd(),
+		SessionCmd(cfgPath),
+		BundleCmd(cfgPath, logPath),
+		PruneCmd(cfgPath),
+		PriorityCmd(cfgPath),
+		ConfigCmd(cfgPath),
+		ValidateCmd(cfgPath),
 	)
 	return rootCmd
 }
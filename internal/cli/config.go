@@ -0,0 +1,118 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"fs-ingest-daemon/internal/config"
+
+	"github.com/spf13/cobra"
+)
+
+// ConfigCmd groups commands that inspect the configuration file.
+func ConfigCmd(cfgPath string) *cobra.Command {
+	configCmd := &cobra.Command{
+		Use:   "config",
+		Short: "Inspect the configuration",
+	}
+
+	validateCmd := &cobra.Command{
+		Use:           "validate",
+		Short:         "Check the configuration for mistakes before restarting the service",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := cmd.OutOrStdout()
+
+			if _, err := os.Stat(cfgPath); os.IsNotExist(err) {
+				fmt.Fprintf(out, "%s does not exist, checking the defaults.\n", cfgPath)
+			}
+			return writeValidation(out, checkConfig(cfgPath, false, true), "text")
+		},
+	}
+
+	configCmd.AddCommand(validateCmd)
+	return configCmd
+}
+
+// ValidateCmd checks a configuration file without touching the service or the
+// database, so CI and provisioning pipelines can gate on it. It exits non-zero
+// if the file cannot be loaded or has problems. Only static checks run, as the
+// file may be meant for another host; see `fsd config validate`.
+func ValidateCmd(cfgPath string) *cobra.Command {
+	var path, output string
+
+	cmd := &cobra.Command{
+		Use:           "validate",
+		Short:         "Validate a configuration file without applying it",
+		Args:          cobra.NoArgs,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if output != "text" && output != "json" {
+				return fmt.Errorf("invalid --output %q: must be text or json", output)
+			}
+			return writeValidation(cmd.OutOrStdout(), checkConfig(path, true, false), output)
+		},
+	}
+
+	cmd.Flags().StringVar(&path, "config", cfgPath, "Configuration file to validate")
+	cmd.Flags().StringVarP(&output, "output", "o", "text", "Output format: text or json")
+	return cmd
+}
+
+// validation is the result of checking a configuration file, as printed by
+// `fsd validate --output json`.
+type validation struct {
+	Path     string   `json:"path"`
+	Valid    bool     `json:"valid"`
+	Problems []string `json:"problems"`
+}
+
+// checkConfig loads the configuration at path and collects its problems. A
+// missing file is a problem if requireFile is set; otherwise the defaults are
+// checked. With checkHost, problems on this host (see Config.HostProblems) are
+// reported too.
+func checkConfig(path string, requireFile, checkHost bool) validation {
+	v := validation{Path: path, Problems: []string{}}
+	if _, err := os.Stat(path); requireFile && err != nil {
+		v.Problems = append(v.Problems, err.Error())
+		return v
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		v.Problems = append(v.Problems, err.Error())
+		return v
+	}
+	v.Problems = append(v.Problems, cfg.Problems()...)
+	if checkHost {
+		v.Problems = append(v.Problems, cfg.HostProblems()...)
+	}
+	v.Valid = len(v.Problems) == 0
+	return v
+}
+
+// writeValidation prints v as text or json and returns an error if it is invalid.
+func writeValidation(out io.Writer, v validation, output string) error {
+	if output == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+	} else if v.Valid {
+		fmt.Fprintf(out, "%s is valid.\n", v.Path)
+	} else {
+		fmt.Fprintf(out, "%s has %d problem(s):\n", v.Path, len(v.Problems))
+		for _, p := range v.Problems {
+			fmt.Fprintf(out, "  - %s\n", p)
+		}
+	}
+
+	if !v.Valid {
+		return fmt.Errorf("configuration is invalid")
+	}
+	return nil
+}
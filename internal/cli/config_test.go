@@ -0,0 +1,125 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigValidateReportsProblems(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "cli_config_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cfgPath := filepath.Join(tmpDir, "config.json")
+	raw := `{
+		"watch_path": "` + filepath.ToSlash(filepath.Join(tmpDir, "data")) + `",
+		"db_path": "` + filepath.ToSlash(filepath.Join(tmpDir, "fsd.db")) + `",
+		"ingest_check_interval": "20",
+		"max_data_size_gb": 0,
+		"prune_high_watermark_percent": 70,
+		"prune_low_watermark_percent": 80
+	}`
+	if err := os.WriteFile(cfgPath, []byte(raw), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	cmd := ConfigCmd(cfgPath)
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"validate"})
+	if err := cmd.Execute(); err == nil {
+		t.Fatal("Expected validate to fail for an invalid config")
+	}
+
+	output := out.String()
+	for _, want := range []string{"ingest_check_interval", "max_data_size_gb", "must be below prune_high_watermark_percent"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected output to mention %q, got:\n%s", want, output)
+		}
+	}
+
+	// The defaults with a writable watch path are valid.
+	raw = `{"watch_path": "` + filepath.ToSlash(filepath.Join(tmpDir, "data")) + `"}`
+	if err := os.WriteFile(cfgPath, []byte(raw), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err := cmd.Execute(); err != nil {
+		t.Errorf("Expected the default config to be valid, got %v:\n%s", err, out.String())
+	}
+}
+
+func TestValidateCmdExitsNonZeroOnProblems(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "cli_validate_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	validPath := filepath.Join(tmpDir, "valid.json")
+	raw := `{"watch_path": "` + filepath.ToSlash(filepath.Join(tmpDir, "data")) + `"}`
+	if err := os.WriteFile(validPath, []byte(raw), 0644); err != nil {
+		t.Fatal(err)
+	}
+	invalidPath := filepath.Join(tmpDir, "invalid.json")
+	raw = `{"watch_path": "` + filepath.ToSlash(filepath.Join(tmpDir, "data")) + `", "api_timeout": "30"}`
+	if err := os.WriteFile(invalidPath, []byte(raw), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Paths of the target host need not exist where the check runs.
+	otherHostPath := filepath.Join(tmpDir, "other-host.json")
+	raw = `{"watch_path": "` + filepath.ToSlash(filepath.Join(tmpDir, "missing", "data")) + `"}`
+	if err := os.WriteFile(otherHostPath, []byte(raw), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(args ...string) (string, error) {
+		var out bytes.Buffer
+		// The default path must not be used when --config is given.
+		cmd := ValidateCmd(filepath.Join(tmpDir, "unused.json"))
+		cmd.SetOut(&out)
+		cmd.SetArgs(args)
+		err := cmd.Execute()
+		return out.String(), err
+	}
+
+	if out, err := run("--config", validPath); err != nil || !strings.Contains(out, "is valid") {
+		t.Errorf("Expected the valid config to pass, got %v:\n%s", err, out)
+	}
+	if out, err := run("--config", otherHostPath); err != nil {
+		t.Errorf("Expected a config for another host to pass, got %v:\n%s", err, out)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "missing")); !os.IsNotExist(err) {
+		t.Error("Expected validate not to create anything")
+	}
+	if out, err := run("--config", invalidPath); err == nil || !strings.Contains(out, "api_timeout") {
+		t.Errorf("Expected the invalid config to fail mentioning api_timeout, got %v:\n%s", err, out)
+	}
+	if _, err := run("--config", filepath.Join(tmpDir, "missing.json")); err == nil {
+		t.Error("Expected a missing config file to fail")
+	}
+
+	out, err := run("--config", invalidPath, "--output", "json")
+	if err == nil {
+		t.Error("Expected the invalid config to fail with JSON output")
+	}
+	var v validation
+	if err := json.Unmarshal([]byte(out), &v); err != nil {
+		t.Fatalf("Expected JSON output, got %v:\n%s", err, out)
+	}
+	if v.Valid || v.Path != invalidPath || len(v.Problems) != 1 {
+		t.Errorf("Unexpected JSON result %+v", v)
+	}
+
+	out, err = run("--config", validPath, "-o", "json")
+	if err != nil || json.Unmarshal([]byte(out), &v) != nil || !v.Valid || len(v.Problems) != 0 {
+		t.Errorf("Expected a valid JSON result, got %v:\n%s", err, out)
+	}
+}
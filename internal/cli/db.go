@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"fmt"
+
+	"fs-ingest-daemon/internal/daemon"
+
+	"github.com/spf13/cobra"
+)
+
+// DBCmd groups commands that work on the daemon's database.
+func DBCmd(cfgPath string) *cobra.Command {
+	dbCmd := &cobra.Command{
+		Use:   "db",
+		Short: "Maintain the daemon's database",
+	}
+
+	var noVacuum bool
+	maintenanceCmd := &cobra.Command{
+		Use:   "maintenance",
+		Short: "Compact the database and checkpoint its WAL now",
+		Long: "Runs the maintenance the daemon does every db_maintenance_interval: ANALYZE, VACUUM\n" +
+			"(rebuilds the database without the space freed by deleted records) and a WAL checkpoint.",
+		Run: func(cmd *cobra.Command, args []string) {
+			out := cmd.OutOrStdout()
+			cfg, s, err := openStore(cfgPath)
+			if err != nil {
+				fmt.Fprintln(out, err)
+				return
+			}
+			defer s.Close()
+
+			if pid, running := daemon.InstanceRunning(cfg.DBPath); running && !noVacuum {
+				fmt.Fprintf(out, "Warning: the daemon (pid %s) is running on %s; its database writes wait until VACUUM is done.\n", pid, cfg.DBPath)
+			}
+
+			report, err := s.Maintenance(!noVacuum)
+			if err != nil {
+				fmt.Fprintf(out, "Database maintenance failed: %v\n", err)
+				return
+			}
+			fmt.Fprintf(out, "Maintenance complete. Database size: %d -> %d bytes.\n", report.SizeBefore, report.SizeAfter)
+			if report.WALBusy {
+				fmt.Fprintln(out, "The WAL could not be checkpointed completely because the database is in use; it is truncated by a later checkpoint.")
+			}
+		},
+	}
+	maintenanceCmd.Flags().BoolVar(&noVacuum, "no-vacuum", false, "Only run ANALYZE and the WAL checkpoint, without rebuilding the database")
+
+	dbCmd.AddCommand(maintenanceCmd)
+	return dbCmd
+}
@@ -0,0 +1,94 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"fs-ingest-daemon/internal/store"
+
+	"github.com/spf13/cobra"
+)
+
+// DeadLetterCmd groups commands for files whose upload retries are exhausted
+// (see max_upload_retries). They are no longer retried until they change on
+// disk or are moved back with `fsd deadletter retry`.
+func DeadLetterCmd(cfgPath string) *cobra.Command {
+	deadLetterCmd := &cobra.Command{
+		Use:   "deadletter",
+		Short: "Inspect and requeue files that failed too often",
+	}
+
+	var limit int
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List dead-lettered files and their last error",
+		Run: func(cmd *cobra.Command, args []string) {
+			out := cmd.OutOrStdout()
+			_, s, err := openStore(cfgPath)
+			if err != nil {
+				fmt.Fprintln(out, err)
+				return
+			}
+			defer s.Close()
+
+			letters, err := s.ListDeadLetters(limit)
+			if err != nil {
+				fmt.Fprintf(out, "Failed to list dead letters: %v\n", err)
+				return
+			}
+			if len(letters) == 0 {
+				fmt.Fprintln(out, "No dead-lettered files.")
+				return
+			}
+			writeDeadLetterTable(out, letters)
+		},
+	}
+	listCmd.Flags().IntVar(&limit, "limit", 100, "Maximum number of files to list")
+
+	retryCmd := &cobra.Command{
+		Use:   "retry <path>",
+		Short: "Move a dead-lettered file back to PENDING",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			out := cmd.OutOrStdout()
+			path, err := filepath.Abs(args[0])
+			if err != nil {
+				fmt.Fprintf(out, "Invalid path %s: %v\n", args[0], err)
+				return
+			}
+
+			_, s, err := openStore(cfgPath)
+			if err != nil {
+				fmt.Fprintln(out, err)
+				return
+			}
+			defer s.Close()
+
+			if err := s.RetryDeadLetter(path); err != nil {
+				fmt.Fprintf(out, "Failed to requeue file: %v\n", err)
+				return
+			}
+			fmt.Fprintf(out, "%s is PENDING again.\n", path)
+		},
+	}
+
+	deadLetterCmd.AddCommand(listCmd, retryCmd)
+	return deadLetterCmd
+}
+
+// writeDeadLetterTable prints dead-lettered files as an aligned table, most recently failed first.
+func writeDeadLetterTable(w io.Writer, letters []store.DeadLetter) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FAILED AT\tRETRIES\tSIZE\tPATH\tLAST ERROR")
+	for _, d := range letters {
+		lastErr := "-"
+		if d.LastError.Valid {
+			lastErr = truncateError(d.LastError.String, maxErrorDisplayLen)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\n", d.FailedAt.Local().Format(time.RFC3339), d.RetryCount, d.Size, d.Path, lastErr)
+	}
+	tw.Flush()
+}
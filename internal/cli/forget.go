@@ -0,0 +1,43 @@
+package cli
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+// ForgetCmd removes a path from the local database without touching the file on disk.
+// Useful when a file is managed by another process and its stale record causes churn.
+func ForgetCmd(logger *slog.Logger, cfgPath string) *cobra.Command {
+	return &cobra.Command{
+		Use:   "forget <path>",
+		Short: "Remove a file from the database without deleting it from disk",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			path, err := filepath.Abs(args[0])
+			if err != nil {
+				fmt.Printf("Invalid path %s: %v\n", args[0], err)
+				return
+			}
+
+			_, s, err := openStore(cfgPath)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			defer s.Close()
+
+			if err := s.ForgetPath(path); err != nil {
+				fmt.Printf("Failed to forget %s: %v\n", path, err)
+				return
+			}
+
+			if logger != nil {
+				logger.Info("Forgot file record (disk untouched, not pruned)", "path", path)
+			}
+			fmt.Printf("Forgot %s (file on disk left untouched).\n", path)
+		},
+	}
+}
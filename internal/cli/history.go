@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"fs-ingest-daemon/internal/store"
+
+	"github.com/spf13/cobra"
+)
+
+// HistoryCmd prints the recorded upload attempts of a file, to diagnose
+// intermittent failures that a single last error does not explain.
+func HistoryCmd(cfgPath string) *cobra.Command {
+	return &cobra.Command{
+		Use:   "history <path>",
+		Short: "Show the recent upload attempts of a file",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			out := cmd.OutOrStdout()
+			path, err := filepath.Abs(args[0])
+			if err != nil {
+				fmt.Fprintf(out, "Invalid path %s: %v\n", args[0], err)
+				return
+			}
+
+			_, s, err := openStore(cfgPath)
+			if err != nil {
+				fmt.Fprintln(out, err)
+				return
+			}
+			defer s.Close()
+
+			attempts, err := s.GetUploadAttempts(path)
+			if err != nil {
+				fmt.Fprintf(out, "Failed to read upload history: %v\n", err)
+				return
+			}
+			if len(attempts) == 0 {
+				fmt.Fprintf(out, "No upload attempts recorded for %s.\n", path)
+				return
+			}
+			writeAttemptTable(out, attempts)
+		},
+	}
+}
+
+// writeAttemptTable prints upload attempts as an aligned table, oldest first.
+func writeAttemptTable(w io.Writer, attempts []store.UploadAttempt) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tOUTCOME\tHTTP\tDURATION\tERROR")
+	for _, a := range attempts {
+		status := "-"
+		if a.HTTPStatus != 0 {
+			status = fmt.Sprint(a.HTTPStatus)
+		}
+		errMsg := "-"
+		if a.Error != "" {
+			errMsg = truncateError(a.Error, maxErrorDisplayLen)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", a.AttemptedAt.Local().Format(time.RFC3339), a.Outcome, status, a.Duration, errMsg)
+	}
+	tw.Flush()
+}
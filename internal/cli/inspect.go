@@ -0,0 +1,260 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"fs-ingest-daemon/internal/config"
+	"fs-ingest-daemon/internal/store"
+
+	"github.com/spf13/cobra"
+)
+
+// maxErrorDisplayLen is the number of characters of a file's last error shown in tables.
+const maxErrorDisplayLen = 80
+
+// openStore loads the config and opens the database it points to.
+func openStore(cfgPath string) (*config.Config, *store.Store, error) {
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	s, err := store.NewStore(cfg.DBPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open store at %s: %w", cfg.DBPath, err)
+	}
+	return cfg, s, nil
+}
+
+// truncateError shortens an error message for single-line display.
+func truncateError(msg string, max int) string {
+	msg = strings.Join(strings.Fields(msg), " ")
+	if len(msg) <= max {
+		return msg
+	}
+	return msg[:max-3] + "..."
+}
+
+// writeFileTable prints file records as an aligned table including the last error.
+func writeFileTable(w io.Writer, files []store.FileRecord) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATUS\tSIZE\tPATH\tLAST ERROR")
+	writeFileRows(tw, files)
+	tw.Flush()
+}
+
+// writeFileRows writes one table row per file record.
+func writeFileRows(w io.Writer, files []store.FileRecord) {
+	for _, f := range files {
+		lastErr := "-"
+		if f.LastError.Valid {
+			lastErr = truncateError(f.LastError.String, maxErrorDisplayLen)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", f.Status, f.Size, f.Path, lastErr)
+	}
+}
+
+// listPageSize is the number of records `fsd list` reads and prints at a time.
+const listPageSize = 500
+
+// ListCmd lists tracked files, optionally filtered by status or to failed files only.
+// Files are read and printed page by page, so listing millions of records does
+// not load them all into memory.
+func ListCmd(cfgPath string) *cobra.Command {
+	var status string
+	var failed bool
+	var limit int
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List tracked files and their last error",
+		Run: func(cmd *cobra.Command, args []string) {
+			_, s, err := openStore(cfgPath)
+			if err != nil {
+				fmt.Fprintln(cmd.OutOrStdout(), err)
+				return
+			}
+			defer s.Close()
+
+			if failed {
+				files, err := s.GetFailedFiles(limit)
+				if err != nil {
+					fmt.Fprintf(cmd.OutOrStdout(), "Failed to list files: %v\n", err)
+					return
+				}
+				writeFileTable(cmd.OutOrStdout(), files)
+				return
+			}
+
+			if _, err := streamFiles(cmd.OutOrStdout(), s, store.FileStatus(strings.ToUpper(status)), limit, listPageSize); err != nil {
+				fmt.Fprintf(cmd.OutOrStdout(), "Failed to list files: %v\n", err)
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&status, "status", "", "Only list files with this status (e.g. PENDING, UPLOADED)")
+	cmd.Flags().BoolVar(&failed, "failed", false, "Only list files whose last upload attempt failed")
+	cmd.Flags().IntVar(&limit, "limit", 100, "Maximum number of files to list (0 = all)")
+	return cmd
+}
+
+// streamFiles prints up to limit files (0 = all) in the order they were first
+// tracked, fetching and printing pageSize records at a time. It returns the
+// number of files printed.
+func streamFiles(w io.Writer, s *store.Store, status store.FileStatus, limit, pageSize int) (int, error) {
+	var format *fileRowFormat
+	printed := 0
+	var afterID int64
+	for limit <= 0 || printed < limit {
+		n := pageSize
+		if limit > 0 && limit-printed < n {
+			n = limit - printed
+		}
+		files, err := s.ListFilesAfter(status, afterID, n)
+		if err != nil {
+			return printed, err
+		}
+		if format == nil {
+			format = newFileRowFormat(files)
+			format.write(w, "STATUS", "SIZE", "PATH", "LAST ERROR")
+		}
+		for _, f := range files {
+			lastErr := "-"
+			if f.LastError.Valid {
+				lastErr = truncateError(f.LastError.String, maxErrorDisplayLen)
+			}
+			format.write(w, string(f.Status), strconv.FormatInt(f.Size, 10), f.Path, lastErr)
+		}
+		printed += len(files)
+		if len(files) < n {
+			break
+		}
+		afterID = files[len(files)-1].ID
+	}
+	return printed, nil
+}
+
+// fileRowFormat lays out the rows printed by streamFiles. Unlike a tabwriter
+// flushed per page, its widths are set once, so every page lines up with the
+// header: status and size have fixed widths, the path column is as wide as
+// the longest path of the first page. A longer path on a later page only
+// shifts the error of its own row.
+type fileRowFormat struct {
+	pathWidth int
+}
+
+// Widths of the status and size columns: the longest status, and sizes below 1 TB.
+const (
+	statusColumnWidth = len(store.StatusAwaitingPartner)
+	sizeColumnWidth   = 12
+)
+
+// newFileRowFormat returns the format for a table whose first page is files.
+func newFileRowFormat(files []store.FileRecord) *fileRowFormat {
+	f := &fileRowFormat{pathWidth: len("PATH")}
+	for _, r := range files {
+		f.pathWidth = max(f.pathWidth, len(r.Path))
+	}
+	return f
+}
+
+// write prints one row.
+func (f *fileRowFormat) write(w io.Writer, status, size, path, lastErr string) {
+	fmt.Fprintf(w, "%-*s  %-*s  %-*s  %s\n", statusColumnWidth, status, sizeColumnWidth, size, f.pathWidth, path, lastErr)
+}
+
+// StatsCmd prints a summary of the local database including recent failures.
+func StatsCmd(cfgPath string) *cobra.Command {
+	return &cobra.Command{
+		Use:   "stats",
+		Short: "Show database statistics and recent failures",
+		Run: func(cmd *cobra.Command, args []string) {
+			_, s, err := openStore(cfgPath)
+			if err != nil {
+				fmt.Fprintln(cmd.OutOrStdout(), err)
+				return
+			}
+			defer s.Close()
+
+			writeStats(cmd.OutOrStdout(), s)
+		},
+	}
+}
+
+// writeStats prints the tracked size and the most recent failures.
+func writeStats(out io.Writer, s *store.Store) {
+	total, err := s.GetTotalSize()
+	if err != nil {
+		fmt.Fprintf(out, "Failed to get total size: %v\n", err)
+		return
+	}
+	fmt.Fprintf(out, "Tracked bytes: %d\n", total)
+
+	failedFiles, err := s.GetFailedFiles(10)
+	if err != nil {
+		fmt.Fprintf(out, "Failed to get failed files: %v\n", err)
+		return
+	}
+	if len(failedFiles) == 0 {
+		fmt.Fprintln(out, "Recent failures: none")
+		return
+	}
+	fmt.Fprintln(out, "Recent failures:")
+	writeFileTable(out, failedFiles)
+}
+
+// statusOrder is the order file counts are printed by `fsd status`.
+var statusOrder = []store.FileStatus{
+	store.StatusPending,
+	store.StatusUploaded,
+	store.StatusAwaitingPartner,
+	store.StatusOrphan,
+}
+
+// writeStoreStatus prints the number of files per status and the tracked
+// bytes. The database is opened read-only, so this works while the daemon runs.
+func writeStoreStatus(out io.Writer, cfgPath string) {
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		fmt.Fprintf(out, "Failed to load config: %v\n", err)
+		return
+	}
+	s, err := store.OpenReadOnly(cfg.DBPath)
+	if os.IsNotExist(err) {
+		fmt.Fprintf(out, "No database at %s yet.\n", cfg.DBPath)
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(out, "Failed to open store at %s: %v\n", cfg.DBPath, err)
+		return
+	}
+	defer s.Close()
+
+	counts, err := s.CountByStatus()
+	if err != nil {
+		fmt.Fprintf(out, "Failed to count files: %v\n", err)
+		return
+	}
+	deadLetters, err := s.CountDeadLetters()
+	if err != nil {
+		fmt.Fprintf(out, "Failed to count dead letters: %v\n", err)
+		return
+	}
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, status := range statusOrder {
+		fmt.Fprintf(tw, "%s\t%d\n", status, counts[status])
+	}
+	fmt.Fprintf(tw, "DEAD_LETTER\t%d\n", deadLetters)
+	tw.Flush()
+
+	total, err := s.GetTotalSize()
+	if err != nil {
+		fmt.Fprintf(out, "Failed to get total size: %v\n", err)
+		return
+	}
+	fmt.Fprintf(out, "Tracked bytes: %d\n", total)
+}
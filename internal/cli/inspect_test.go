@@ -0,0 +1,166 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"fs-ingest-daemon/internal/config"
+	"fs-ingest-daemon/internal/store"
+)
+
+func TestListCmdShowsLastError(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "cli_list_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cfgPath := filepath.Join(tmpDir, "config.json")
+	cfg := &config.Config{
+		DBPath:    filepath.Join(tmpDir, "fsd.db"),
+		WatchPath: filepath.Join(tmpDir, "data"),
+	}
+	if err := config.Save(cfgPath, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := store.NewStore(cfg.DBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failedPath := filepath.Join(cfg.WatchPath, "bad.png")
+	if err := s.RegisterFile(failedPath, 10, time.Now(), false, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RecordError(failedPath, "ingest: ingest request failed with status 422: invalid filename"); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	var out bytes.Buffer
+	cmd := ListCmd(cfgPath)
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"--failed"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("list command failed: %v", err)
+	}
+
+	output := out.String()
+	if !strings.Contains(output, failedPath) {
+		t.Errorf("Expected output to contain %s, got:\n%s", failedPath, output)
+	}
+	if !strings.Contains(output, "invalid filename") {
+		t.Errorf("Expected output to contain the stored error, got:\n%s", output)
+	}
+}
+
+func TestTruncateError(t *testing.T) {
+	long := strings.Repeat("a", 200)
+	got := truncateError(long, 20)
+	if len(got) != 20 || !strings.HasSuffix(got, "...") {
+		t.Errorf("Expected 20-char truncated error ending in ..., got %q", got)
+	}
+
+	if got := truncateError("line1\nline2", 80); got != "line1 line2" {
+		t.Errorf("Expected newlines collapsed, got %q", got)
+	}
+}
+
+func TestStreamFilesPagesThroughAllRecords(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "cli_stream_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s, err := store.NewStore(filepath.Join(tmpDir, "fsd.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	const total = 1050
+	for i := 0; i < total; i++ {
+		// Sizes grow from page to page.
+		if err := s.RegisterFile(fmt.Sprintf("/data/img_%04d.png", i), int64(i)*1000, time.Now(), false, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Every row exactly once, in order, across pages that do not divide the total.
+	var out bytes.Buffer
+	printed, err := streamFiles(&out, s, "", 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if printed != total || len(lines) != total+1 {
+		t.Fatalf("Expected %d rows plus a header, printed %d with %d lines", total, printed, len(lines))
+	}
+	// The columns line up with the header on every page.
+	pathCol := strings.Index(lines[0], "PATH")
+	for i, line := range lines[1:] {
+		if want := fmt.Sprintf("/data/img_%04d.png", i); strings.Index(line, want) != pathCol {
+			t.Fatalf("Line %d: expected %s at column %d, got %q", i+1, want, pathCol, line)
+		}
+	}
+
+	// A limit stops mid-page.
+	out.Reset()
+	if printed, err := streamFiles(&out, s, store.StatusPending, 250, 100); err != nil || printed != 250 {
+		t.Errorf("Expected 250 rows with --limit 250, got %d (err=%v)", printed, err)
+	}
+}
+
+func TestWriteStoreStatusCountsFiles(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "cli_status_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cfgPath := filepath.Join(tmpDir, "config.json")
+	cfg := &config.Config{
+		DBPath:    filepath.Join(tmpDir, "fsd.db"),
+		WatchPath: filepath.Join(tmpDir, "data"),
+	}
+	if err := config.Save(cfgPath, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	writeStoreStatus(&out, cfgPath)
+	if !strings.Contains(out.String(), "No database") {
+		t.Errorf("Expected a missing database to be reported, got:\n%s", out.String())
+	}
+
+	// The daemon keeps its store open while `fsd status` runs.
+	s, err := store.NewStore(cfg.DBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for n := 0; n < 3; n++ {
+		path := filepath.Join(cfg.WatchPath, fmt.Sprintf("img%d.png", n))
+		if err := s.RegisterFile(path, 100, time.Now(), false, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.MarkUploaded(filepath.Join(cfg.WatchPath, "img0.png")); err != nil {
+		t.Fatal(err)
+	}
+
+	out.Reset()
+	writeStoreStatus(&out, cfgPath)
+	output := out.String()
+	for _, want := range []string{"PENDING           2", "UPLOADED          1", "AWAITING_PARTNER  0", "ORPHAN            0", "Tracked bytes: 300"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, output)
+		}
+	}
+}
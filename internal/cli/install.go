@@ -10,14 +10,11 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
-	"time"
 
-	"fs-ingest-daemon/internal/api"
 	"fs-ingest-daemon/internal/config"
 	"fs-ingest-daemon/internal/device"
 
 	"github.com/kardianos/service"
-	"github.com/mdp/qrterminal/v3"
 	"github.com/spf13/cobra"
 )
 
@@ -102,7 +99,9 @@ func copyFile(src, dst string) error {
 }
 
 func InstallCmd(s service.Service) *cobra.Command {
-	return &cobra.Command{
+	var dataDirFlag string
+
+	cmd := &cobra.Command{
 		Use:   "install",
 		Short: "Interactive installer for the service",
 		Run: func(cmd *cobra.Command, args []string) {
@@ -135,11 +134,22 @@ func InstallCmd(s service.Service) *cobra.Command {
 			targetDir := prompt("Install Directory", defaultDir)
 
 			// Create Directory
-			if err := os.MkdirAll(targetDir, 0755); err != nil {
+			err := os.MkdirAll(targetDir, 0755)
+			if err != nil {
 				fmt.Printf("❌ Error creating directory %s: %v\n", targetDir, err)
 				return
 			}
 
+			// Writable location for the DB, logs and watch data (defaults to the install dir)
+			dataDir := targetDir
+			if dataDirFlag != "" {
+				dataDir, err = filepath.Abs(dataDirFlag)
+				if err != nil {
+					fmt.Printf("❌ Invalid data directory %s: %v\n", dataDirFlag, err)
+					return
+				}
+			}
+
 			// 3. Self-Copy Binary
 			currentExe, err := os.Executable()
 			if err != nil {
@@ -206,9 +216,9 @@ func InstallCmd(s service.Service) *cobra.Command {
 					DeviceID:               userInputID,
 					Endpoint:               userInputEndpoint,
 					MaxDataSizeGB:          config.DefaultMaxDataSizeGB,
-					WatchPath:              filepath.Join(targetDir, "data"),
-					LogPath:                filepath.Join(targetDir, "fsd.log"),
-					DBPath:                 filepath.Join(targetDir, "fsd.db"),
+					WatchPath:              filepath.Join(dataDir, "data"),
+					LogPath:                filepath.Join(dataDir, "fsd.log"),
+					DBPath:                 filepath.Join(dataDir, "fsd.db"),
 					IngestCheckInterval:    config.DefaultIngestCheckInterval,
 					IngestBatchSize:        config.DefaultIngestBatchSize,
 					IngestWorkerCount:      config.DefaultIngestWorkerCount,
@@ -220,10 +230,18 @@ func InstallCmd(s service.Service) *cobra.Command {
 					MetadataUpdateInterval: config.DefaultMetadataUpdateInterval,
 					WebClientURL:           config.DefaultWebClientURL,
 					SidecarStrategy:        userInputStrategy,
+					PutMaxRetries:          config.DefaultPutMaxRetries,
+					PutRetryBackoff:        config.DefaultPutRetryBackoff,
+					OrphanSidecarPolicy:    config.DefaultOrphanSidecarPolicy,
+					DirMode:                config.DefaultDirMode,
+					FileMode:               config.DefaultFileMode,
+				}
+				if dataDir != targetDir {
+					cfg.DataDir = dataDir
 				}
 
 				// Create the Watch Directory now
-				os.MkdirAll(cfg.WatchPath, 0755)
+				cfg.MkdirAll(cfg.WatchPath)
 
 				// Save Config
 				if err := config.Save(targetConfigPath, cfg); err != nil {
@@ -237,63 +255,12 @@ func InstallCmd(s service.Service) *cobra.Command {
 			if cfg != nil && cfg.AuthToken == "" {
 				fmt.Println("\n-> Device not paired. Initiating pairing sequence...")
 
-				apiClient := api.NewClient(cfg.Endpoint, cfg.APITimeout)
-				pairingResp, err := apiClient.RequestPairingCode(cfg.DeviceID)
-
+				claimed, err := RunPairing(cfg, targetConfigPath, 0)
 				if err != nil {
-					fmt.Printf("⚠️  Pairing request failed: %v\n", err)
-					fmt.Println("   Continuing installation without pairing. You can pair later or edit config.json manually.")
-				} else {
-					claimURL := fmt.Sprintf("%s/claim/%s", strings.TrimSuffix(cfg.WebClientURL, "/"), pairingResp.Code)
-
-					fmt.Println("\n==========================================")
-					fmt.Printf(" 📱 SCAN TO CLAIM DEVICE\n")
-					fmt.Printf(" Code: %s\n", pairingResp.Code)
-					fmt.Printf(" URL:  %s\n", claimURL)
-					fmt.Println("==========================================")
-
-					qrterminal.GenerateHalfBlock(claimURL, qrterminal.L, os.Stdout)
-
-					fmt.Println("\nWaiting for device to be claimed (Ctrl+C to skip)...")
-
-					// Poll loop
-				ticker := time.NewTicker(5 * time.Second)
-					defer ticker.Stop()
-
-					paired := false
-				pollLoop:
-					for {
-						select {
-						case <-ticker.C:
-							statusResp, err := apiClient.CheckPairingStatus(cfg.DeviceID, pairingResp.Code)
-							if err != nil {
-								continue
-							}
-
-							if statusResp.Status == api.PairingStatusClaimed {
-								fmt.Println("\n✅ Device successfully claimed!")
-							if statusResp.APIKey != nil {
-								cfg.AuthToken = *statusResp.APIKey
-							} else {
-								cfg.AuthToken = "provisioned"
-							}
-
-							// Save updated config
-								if err := config.Save(targetConfigPath, cfg); err != nil {
-									fmt.Printf("❌ Error saving paired config: %v\n", err)
-								}
-								paired = true
-								break pollLoop
-							} else if statusResp.Status == api.PairingStatusExpired {
-								fmt.Println("\n❌ Pairing code expired.")
-							break pollLoop
-						}
-						}
-					}
-
-					if !paired {
-						fmt.Println("   Proceeding with installation (unpaired).")
-					}
+					fmt.Printf("⚠️  Pairing failed: %v\n", err)
+				}
+				if !claimed || err != nil {
+					fmt.Println("   Proceeding with installation (unpaired). Pair later with 'fsd pair'.")
 				}
 			}
 
@@ -362,11 +329,14 @@ func InstallCmd(s service.Service) *cobra.Command {
 			}
 
 			fmt.Println("\nInstallation Complete!")
-			fmt.Printf("Logs:   %s\n", filepath.Join(targetDir, "fsd.log"))
+			fmt.Printf("Logs:   %s\n", cfg.LogPath)
 			fmt.Printf("Config: %s\n", targetConfigPath)
 			fmt.Printf("Data:   %s  <-- PUT FILES HERE\n", cfg.WatchPath)
 		},
 	}
+
+	cmd.Flags().StringVar(&dataDirFlag, "data-dir", "", "Writable directory for the database, logs and watch data (default: the install directory)")
+	return cmd
 }
 
 // Hidden command to actually perform the registration logic from the correct path
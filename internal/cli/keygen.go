@@ -0,0 +1,29 @@
+package cli
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// KeygenCmd prints a new random key for encrypt_uploads. The key is the only
+// line on stdout, so it can be piped into the config; the warning goes to stderr.
+func KeygenCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "keygen",
+		Short: "Generate a key for encrypting uploads",
+		Long: "Prints a new random AES-256 key, base64-encoded, for the encryption_key setting.\n" +
+			"Keep a copy somewhere safe: uploads encrypted with a lost key cannot be decrypted.",
+		Run: func(cmd *cobra.Command, args []string) {
+			key := make([]byte, 32)
+			if _, err := rand.Read(key); err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "Failed to generate key: %v\n", err)
+				return
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), base64.StdEncoding.EncodeToString(key))
+			fmt.Fprintln(cmd.ErrOrStderr(), "Set this as encryption_key with encrypt_uploads: true. Keep a copy somewhere safe: without the key the uploaded data cannot be decrypted.")
+		},
+	}
+}
@@ -0,0 +1,137 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"fs-ingest-daemon/internal/api"
+	"fs-ingest-daemon/internal/config"
+	"fs-ingest-daemon/internal/daemon"
+	"fs-ingest-daemon/internal/pairing"
+
+	"github.com/mdp/qrterminal/v3"
+	"github.com/spf13/cobra"
+)
+
+// Pairing request retry policy for transient network failures (e.g. DNS not ready on boot).
+const (
+	pairingRetryAttempts = 5
+	pairingRetryBackoff  = 2 * time.Second
+)
+
+// pairingPollInterval is how often RunPairing checks whether the code was claimed.
+var pairingPollInterval = pairing.DefaultPollInterval
+
+// DefaultPairTimeout is how long `fsd pair` waits for the device to be claimed.
+const DefaultPairTimeout = 10 * time.Minute
+
+// PairCmd pairs an installed device again, e.g. after pairing was skipped
+// during install or the auth token was lost.
+func PairCmd(cfgPath string) *cobra.Command {
+	var timeout time.Duration
+	var force bool
+
+	cmd := &cobra.Command{
+		Use:           "pair",
+		Short:         "Pair the device with the web app and save its auth token",
+		Args:          cobra.NoArgs,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.Load(cfgPath)
+			if err != nil {
+				return fmt.Errorf("failed to load config: %w", err)
+			}
+			if cfg.AuthToken != "" && !force {
+				fmt.Println("Device is already paired. Use --force to pair it again.")
+				return nil
+			}
+
+			claimed, err := RunPairing(cfg, cfgPath, timeout)
+			if err != nil {
+				return err
+			}
+			if !claimed {
+				return errors.New("pairing aborted")
+			}
+			if pid, running := daemon.InstanceRunning(cfg.DBPath); running {
+				fmt.Printf("The daemon (pid %s) is running; restart it to use the new token: sudo fsd restart\n", pid)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().DurationVar(&timeout, "timeout", DefaultPairTimeout, "Give up if the device is not claimed within this long (0 = until the code expires)")
+	cmd.Flags().BoolVar(&force, "force", false, "Pair again even if the config already has an auth token")
+	return cmd
+}
+
+// RunPairing requests a pairing code, shows it with a QR code of the claim URL
+// and waits until the device is claimed, the code expires (see
+// pairing.ExpiryGrace) or timeout elapses (0 waits until the code expires).
+// Once claimed, the auth token is stored in cfg and saved to cfgPath. Ctrl+C
+// aborts the wait cleanly and returns (false, nil); a code that was not
+// claimed otherwise returns an error.
+func RunPairing(cfg *config.Config, cfgPath string, timeout time.Duration) (claimed bool, err error) {
+	client := api.NewClient(cfg.Endpoint, cfg.APITimeout)
+	client.SetLogger(slog.Default())
+	flow := &pairing.Flow{
+		Client:       client,
+		DeviceID:     cfg.DeviceID,
+		PollInterval: pairingPollInterval,
+		Timeout:      timeout,
+		Logger:       slog.Default(),
+	}
+	// DNS may not be ready yet on a freshly booted device, so retry
+	// transient network failures before giving up on pairing.
+	var pairingResp *api.PairingResponse
+	err = api.Retry(pairingRetryAttempts, pairingRetryBackoff, func() error {
+		var reqErr error
+		pairingResp, reqErr = flow.RequestCode()
+		if reqErr != nil && api.IsRetryable(reqErr) {
+			fmt.Printf("   Pairing request failed (%v), retrying...\n", reqErr)
+		}
+		return reqErr
+	})
+	if err != nil {
+		return false, fmt.Errorf("pairing request failed: %w", err)
+	}
+
+	claimURL := fmt.Sprintf("%s/claim/%s", strings.TrimSuffix(cfg.WebClientURL, "/"), pairingResp.Code)
+
+	fmt.Println("\n==========================================")
+	fmt.Printf(" 📱 SCAN TO CLAIM DEVICE\n")
+	fmt.Printf(" Code: %s\n", pairingResp.Code)
+	fmt.Printf(" URL:  %s\n", claimURL)
+	fmt.Println("==========================================")
+
+	qrterminal.GenerateHalfBlock(claimURL, qrterminal.L, os.Stdout)
+
+	fmt.Println("\nWaiting for device to be claimed (Ctrl+C to skip)...")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	switch result, apiKey := flow.Wait(pairingResp, ctx.Done()); result {
+	case pairing.Claimed:
+		fmt.Println("\n✅ Device successfully claimed!")
+		cfg.AuthToken = apiKey
+		if err := config.Save(cfgPath, cfg); err != nil {
+			return true, fmt.Errorf("failed to save paired config: %w", err)
+		}
+		return true, nil
+	case pairing.Expired:
+		return false, errors.New("pairing code expired")
+	case pairing.TimedOut:
+		return false, fmt.Errorf("device was not claimed within %s", timeout)
+	default:
+		fmt.Println("\nPairing skipped.")
+		return false, nil
+	}
+}
@@ -0,0 +1,71 @@
+package cli
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"fs-ingest-daemon/internal/api"
+	"fs-ingest-daemon/internal/config"
+)
+
+func TestPairCmd(t *testing.T) {
+	old := pairingPollInterval
+	pairingPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { pairingPollInterval = old })
+
+	status := api.PairingStatusWaiting
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/pairing/request":
+			json.NewEncoder(w).Encode(api.PairingResponse{Code: "ABC123", ExpiresAt: time.Now().Add(time.Hour)})
+		case "/v1/pairing/status":
+			key := "new-key"
+			json.NewEncoder(w).Encode(api.PairingStatusResponse{Status: status, APIKey: &key})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	tmpDir := t.TempDir()
+	cfgPath := filepath.Join(tmpDir, "config.json")
+	cfg := &config.Config{DeviceID: "dev", Endpoint: srv.URL, APITimeout: "5s", DBPath: filepath.Join(tmpDir, "fsd.db")}
+	if err := config.Save(cfgPath, cfg); err != nil {
+		t.Fatal(err)
+	}
+	pair := func(args ...string) error {
+		cmd := PairCmd(cfgPath)
+		cmd.SetArgs(args)
+		return cmd.Execute()
+	}
+	token := func() string {
+		cfg, err := config.Load(cfgPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cfg.AuthToken
+	}
+
+	// Nobody claims the code: the timeout ends the wait and nothing is saved.
+	err := pair("--timeout", "50ms")
+	if err == nil || !strings.Contains(err.Error(), "not claimed within 50ms") {
+		t.Fatalf("Expected a timeout error, got %v", err)
+	}
+	if got := token(); got != "" {
+		t.Fatalf("Expected no auth token after a timeout, got %q", got)
+	}
+
+	status = api.PairingStatusClaimed
+	if err := pair(); err != nil {
+		t.Fatalf("Expected pairing to succeed, got %v", err)
+	}
+	if got := token(); got != "new-key" {
+		t.Fatalf("Expected the new auth token to be saved, got %q", got)
+	}
+}
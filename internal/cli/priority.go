@@ -0,0 +1,46 @@
+package cli
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+// PriorityCmd changes the upload priority of a tracked file.
+// Pending files with a higher priority are uploaded before older ones.
+func PriorityCmd(cfgPath string) *cobra.Command {
+	return &cobra.Command{
+		Use:   "priority <path> <n>",
+		Short: "Set the upload priority of a file (higher uploads first, default 0)",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			out := cmd.OutOrStdout()
+
+			path, err := filepath.Abs(args[0])
+			if err != nil {
+				fmt.Fprintf(out, "Invalid path %s: %v\n", args[0], err)
+				return
+			}
+			priority, err := strconv.Atoi(args[1])
+			if err != nil {
+				fmt.Fprintf(out, "Invalid priority %q: must be an integer\n", args[1])
+				return
+			}
+
+			_, s, err := openStore(cfgPath)
+			if err != nil {
+				fmt.Fprintln(out, err)
+				return
+			}
+			defer s.Close()
+
+			if err := s.SetPriority(path, priority); err != nil {
+				fmt.Fprintf(out, "Failed to set priority: %v\n", err)
+				return
+			}
+			fmt.Fprintf(out, "Priority of %s set to %d.\n", path, priority)
+		},
+	}
+}
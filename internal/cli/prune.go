@@ -0,0 +1,104 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"fs-ingest-daemon/internal/config"
+	"fs-ingest-daemon/internal/daemon"
+	"fs-ingest-daemon/internal/pruner"
+	"fs-ingest-daemon/internal/store"
+
+	"github.com/spf13/cobra"
+)
+
+// PruneCmd runs the pruner without starting the full daemon, e.g. from cron or
+// right after lowering max_data_size_gb. It is safe next to a running daemon:
+// the DB is in WAL mode and the store waits on busy_timeout instead of failing
+// when the daemon holds the write lock, so it only warns about the contention.
+func PruneCmd(cfgPath string) *cobra.Command {
+	var once bool
+	var dryRun bool
+	var retryFailed bool
+
+	cmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Run the pruner outside the daemon",
+		Run: func(cmd *cobra.Command, args []string) {
+			out := cmd.OutOrStdout()
+
+			cfg, s, err := openStore(cfgPath)
+			if err != nil {
+				fmt.Fprintln(out, err)
+				return
+			}
+			defer s.Close()
+
+			if pid, running := daemon.InstanceRunning(cfg.DBPath); running {
+				fmt.Fprintf(out, "Warning: the daemon (pid %s) is running on %s; pruning alongside it may briefly contend for the database.\n", pid, cfg.DBPath)
+			}
+
+			if retryFailed {
+				n, err := s.ClearPruneFailures()
+				if err != nil {
+					fmt.Fprintf(out, "Failed to reset files the pruner gave up on: %v\n", err)
+					return
+				}
+				fmt.Fprintf(out, "%d file(s) the pruner failed to delete are candidates again.\n", n)
+			}
+
+			logger := slog.New(slog.NewTextHandler(out, nil))
+			if once || dryRun {
+				if err := pruneOnce(out, cfg, s, logger, dryRun); err != nil {
+					fmt.Fprintln(out, err)
+				}
+				return
+			}
+
+			// Foreground mode: prune on the configured interval until interrupted.
+			p := pruner.NewPruner(cfg, s, logger)
+			p.DryRun = dryRun
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			p.Start()
+			<-ctx.Done()
+			p.Stop()
+		},
+	}
+
+	cmd.Flags().BoolVar(&once, "once", false, "Run a single prune cycle and exit")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Run a single prune cycle that only logs the files it would delete")
+	cmd.Flags().BoolVar(&retryFailed, "retry-failed", false, "Retry files skipped after prune_max_delete_attempts failed deletes (e.g. after fixing their permissions)")
+	return cmd
+}
+
+// pruneOnce runs one prune cycle and prints the tracked size before and after
+// and the number of files evicted.
+func pruneOnce(out io.Writer, cfg *config.Config, s *store.Store, logger *slog.Logger, dryRun bool) error {
+	dryRun = dryRun || cfg.PruneDryRun
+	before, err := s.GetTotalSize()
+	if err != nil {
+		return fmt.Errorf("failed to get tracked size: %w", err)
+	}
+
+	p := pruner.NewPruner(cfg, s, logger)
+	p.DryRun = dryRun
+	evicted := p.Prune()
+
+	after, err := s.GetTotalSize()
+	if err != nil {
+		return fmt.Errorf("failed to get tracked size: %w", err)
+	}
+
+	if dryRun {
+		fmt.Fprintf(out, "Dry run complete. Tracked size: %d bytes, %d file(s) would be evicted (nothing deleted).\n", before, evicted)
+		return nil
+	}
+	fmt.Fprintf(out, "Prune complete. Tracked size: %d -> %d bytes, %d file(s) evicted.\n", before, after, evicted)
+	return nil
+}
@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"fs-ingest-daemon/internal/config"
+	"fs-ingest-daemon/internal/store"
+)
+
+func TestPruneCmdOnce(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "cli_prune_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cfgPath := filepath.Join(tmpDir, "config.json")
+	cfg := &config.Config{
+		DBPath:         filepath.Join(tmpDir, "fsd.db"),
+		WatchPath:      tmpDir,
+		MaxDataSizeGB:  0.000001, // ~1KB, so one 1KB upload exceeds the high watermark
+		PruneBatchSize: 10,
+	}
+	if err := config.Save(cfgPath, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := store.NewStore(cfg.DBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploaded := filepath.Join(tmpDir, "old.png")
+	if err := os.WriteFile(uploaded, make([]byte, 1024), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterFile(uploaded, 1024, time.Now().Add(-time.Hour), false, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MarkUploaded(uploaded); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	run := func(args ...string) string {
+		var out bytes.Buffer
+		cmd := PruneCmd(cfgPath)
+		cmd.SetOut(&out)
+		cmd.SetArgs(args)
+		if err := cmd.Execute(); err != nil {
+			t.Fatalf("prune command failed: %v", err)
+		}
+		return out.String()
+	}
+
+	output := run("--once", "--dry-run")
+	if !strings.Contains(output, "would prune file") || !strings.Contains(output, "1 file(s) would be evicted") {
+		t.Errorf("Expected dry run to list the candidate, got:\n%s", output)
+	}
+	if _, err := os.Stat(uploaded); err != nil {
+		t.Fatalf("Dry run must not delete files: %v", err)
+	}
+
+	output = run("--once")
+	if !strings.Contains(output, "1024 -> 0 bytes, 1 file(s) evicted") {
+		t.Errorf("Expected before/after size and evicted count in output, got:\n%s", output)
+	}
+	if _, err := os.Stat(uploaded); !os.IsNotExist(err) {
+		t.Error("Expected the uploaded file to be pruned")
+	}
+}
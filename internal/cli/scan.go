@@ -0,0 +1,43 @@
+package cli
+
+import (
+	"fmt"
+	"log/slog"
+
+	"fs-ingest-daemon/internal/daemon"
+
+	"github.com/spf13/cobra"
+)
+
+// ScanCmd registers files in the watch directory that the database does not
+// track, e.g. files added while the daemon was stopped or after the database
+// was reset. A running daemon uploads them like any other pending file.
+func ScanCmd(cfgPath string) *cobra.Command {
+	return &cobra.Command{
+		Use:   "scan",
+		Short: "Register untracked files in the watch directory",
+		Run: func(cmd *cobra.Command, args []string) {
+			out := cmd.OutOrStdout()
+			cfg, s, err := openStore(cfgPath)
+			if err != nil {
+				fmt.Fprintln(out, err)
+				return
+			}
+			defer s.Close()
+
+			if pid, running := daemon.InstanceRunning(cfg.DBPath); running {
+				fmt.Fprintf(out, "Warning: the daemon (pid %s) is running on %s and registers new files itself; this scan may duplicate its work.\n", pid, cfg.DBPath)
+			}
+
+			// Only problems are logged; the registered files are counted below.
+			logger := slog.New(slog.NewTextHandler(out, &slog.HandlerOptions{Level: slog.LevelWarn}))
+			d := &daemon.Daemon{Cfg: cfg, DbStore: s, Logger: logger}
+			n, err := d.Scan()
+			if err != nil {
+				fmt.Fprintf(out, "Scan of %s failed after registering %d file(s): %v\n", cfg.WatchPath, n, err)
+				return
+			}
+			fmt.Fprintf(out, "Scan of %s complete: %d new file(s) registered.\n", cfg.WatchPath, n)
+		},
+	}
+}
@@ -0,0 +1,88 @@
+package cli
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"fs-ingest-daemon/internal/config"
+	"fs-ingest-daemon/internal/store"
+)
+
+func TestScanCmdRegistersUntrackedFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	watchDir := filepath.Join(tmpDir, "data")
+	if err := os.MkdirAll(filepath.Join(watchDir, "cache"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	cfgPath := filepath.Join(tmpDir, "config.json")
+	cfg := &config.Config{
+		DBPath:            filepath.Join(tmpDir, "fsd.db"),
+		WatchPath:         watchDir,
+		AllowedExtensions: []string{".png"},
+		ExcludePatterns:   []string{"cache/"},
+	}
+	if err := config.Save(cfgPath, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]bool{ // Path -> expected to be tracked after the scan
+		"tracked.png":   true,
+		"new.png":       true,
+		"notes.txt":     false, // Extension not allowed
+		"cache/tmp.png": false, // Excluded
+	}
+	for name := range files {
+		if err := os.WriteFile(filepath.Join(watchDir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := store.NewStore(cfg.DBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracked := filepath.Join(watchDir, "tracked.png")
+	info, err := os.Stat(tracked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterFile(tracked, info.Size(), info.ModTime(), false, false); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	scan := func() string {
+		var out bytes.Buffer
+		cmd := ScanCmd(cfgPath)
+		cmd.SetOut(&out)
+		cmd.SetArgs(nil)
+		if err := cmd.Execute(); err != nil {
+			t.Fatalf("scan command failed: %v", err)
+		}
+		return out.String()
+	}
+
+	if out := scan(); !strings.Contains(out, "1 new file(s) registered") {
+		t.Errorf("Expected one new file to be registered, got:\n%s", out)
+	}
+	if out := scan(); !strings.Contains(out, "0 new file(s) registered") {
+		t.Errorf("Expected a second scan to find nothing new, got:\n%s", out)
+	}
+
+	s, err = store.OpenReadOnly(cfg.DBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for name, want := range files {
+		got, err := s.HasFile(filepath.Join(watchDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%s: expected tracked=%v, got %v", name, want, got)
+		}
+	}
+}
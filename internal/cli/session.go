@@ -0,0 +1,86 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// SessionCmd manages the capture session ID attached to every upload.
+// The ID lives in the database, so a running daemon picks up changes immediately.
+func SessionCmd(cfgPath string) *cobra.Command {
+	sessionCmd := &cobra.Command{
+		Use:   "session",
+		Short: "Show or change the capture session ID attached to uploads",
+	}
+
+	showCmd := &cobra.Command{
+		Use:   "show",
+		Short: "Show the current session ID",
+		Run: func(cmd *cobra.Command, args []string) {
+			cfg, s, err := openStore(cfgPath)
+			if err != nil {
+				fmt.Fprintln(cmd.OutOrStdout(), err)
+				return
+			}
+			defer s.Close()
+
+			id, err := s.GetSessionID()
+			if err != nil {
+				fmt.Fprintf(cmd.OutOrStdout(), "Failed to read session ID: %v\n", err)
+				return
+			}
+			if id == "" {
+				id = cfg.SessionID
+			}
+			if id == "" {
+				fmt.Fprintln(cmd.OutOrStdout(), "No session set.")
+				return
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), id)
+		},
+	}
+
+	setCmd := &cobra.Command{
+		Use:   "set <id>",
+		Short: "Set the session ID for subsequent uploads",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			_, s, err := openStore(cfgPath)
+			if err != nil {
+				fmt.Fprintln(cmd.OutOrStdout(), err)
+				return
+			}
+			defer s.Close()
+
+			if err := s.SetSessionID(args[0]); err != nil {
+				fmt.Fprintf(cmd.OutOrStdout(), "Failed to set session ID: %v\n", err)
+				return
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Session set to %s\n", args[0])
+		},
+	}
+
+	rotateCmd := &cobra.Command{
+		Use:   "rotate",
+		Short: "Start a new session with a generated ID",
+		Run: func(cmd *cobra.Command, args []string) {
+			_, s, err := openStore(cfgPath)
+			if err != nil {
+				fmt.Fprintln(cmd.OutOrStdout(), err)
+				return
+			}
+			defer s.Close()
+
+			id, err := s.RotateSessionID()
+			if err != nil {
+				fmt.Fprintf(cmd.OutOrStdout(), "Failed to rotate session ID: %v\n", err)
+				return
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Session rotated to %s\n", id)
+		},
+	}
+
+	sessionCmd.AddCommand(showCmd, setCmd, rotateCmd)
+	return sessionCmd
+}
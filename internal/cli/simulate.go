@@ -0,0 +1,69 @@
+package cli
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// SimulateCmd copies files from a source tree into the watch directory at a fixed rate,
+// mimicking a camera or sensor producing data. Used by start-simulation.sh.
+func SimulateCmd(logger *slog.Logger) *cobra.Command {
+	var source, target, rate string
+
+	cmd := &cobra.Command{
+		Use:   "simulate",
+		Short: "Simulate file production by copying a source tree into the watch directory",
+		Run: func(cmd *cobra.Command, args []string) {
+			interval, err := time.ParseDuration(rate)
+			if err != nil {
+				fmt.Printf("Invalid rate %q: %v\n", rate, err)
+				return
+			}
+
+			count := 0
+			err = filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if info.IsDir() {
+					return nil
+				}
+
+				rel, err := filepath.Rel(source, path)
+				if err != nil {
+					return err
+				}
+				dst := filepath.Join(target, rel)
+				if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+					return err
+				}
+				if err := copyFile(path, dst); err != nil {
+					return err
+				}
+
+				count++
+				if logger != nil {
+					logger.Info("Simulated file", "source", path, "target", dst)
+				}
+				time.Sleep(interval)
+				return nil
+			})
+			if err != nil {
+				fmt.Printf("Simulation failed: %v\n", err)
+				return
+			}
+			fmt.Printf("Simulation complete: %d files copied.\n", count)
+		},
+	}
+
+	cmd.Flags().StringVar(&source, "source", "", "Source directory to copy files from")
+	cmd.Flags().StringVar(&target, "target", "./data", "Target (watch) directory")
+	cmd.Flags().StringVar(&rate, "rate", "1s", "Delay between files")
+	_ = cmd.MarkFlagRequired("source")
+	return cmd
+}
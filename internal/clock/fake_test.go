@@ -0,0 +1,58 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeTickerFiresOnAdvance(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := NewFake(start)
+	ticker := f.NewTicker(time.Minute)
+
+	f.Advance(59 * time.Second)
+	select {
+	case <-ticker.C():
+		t.Fatal("Ticker fired before its interval elapsed")
+	default:
+	}
+
+	f.Advance(time.Second)
+	select {
+	case got := <-ticker.C():
+		if !got.Equal(start.Add(time.Minute)) {
+			t.Errorf("Expected tick at %v, got %v", start.Add(time.Minute), got)
+		}
+	default:
+		t.Fatal("Expected ticker to fire after one interval")
+	}
+
+	ticker.Stop()
+	f.Advance(time.Hour)
+	select {
+	case <-ticker.C():
+		t.Fatal("Stopped ticker fired")
+	default:
+	}
+}
+
+func TestFakeAfterFiresOnce(t *testing.T) {
+	f := NewFake(time.Now())
+	ch := f.After(10 * time.Second)
+
+	f.BlockUntil(1)
+	f.Advance(10 * time.Second)
+	select {
+	case <-ch:
+	default:
+		t.Fatal("Expected After to fire once its duration elapsed")
+	}
+
+	f.BlockUntil(0)
+	f.Advance(time.Hour)
+	select {
+	case <-ch:
+		t.Fatal("After fired twice")
+	default:
+	}
+}
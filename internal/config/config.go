@@ -4,20 +4,30 @@ package config
 // It supports reading from a JSON file and provides default values for valid initialization.
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
+
+	"fs-ingest-daemon/internal/util"
+	"fs-ingest-daemon/internal/watcher"
 )
 
 // Config represents the application configuration structure.
 type Config struct {
 	DeviceID                  string   `json:"device_id"`                    // Unique identifier for the device (e.g., "dev-001")
 	Endpoint                  string   `json:"endpoint"`                     // The API base URL
+	BackupEndpoint            string   `json:"backup_endpoint"`              // Optional second Ingestion API every file is also uploaded to (best effort, retried separately). Empty = no backup
 	MaxDataSizeGB             float64  `json:"max_data_size_gb"`             // Maximum allowed size for the local storage in GB before pruning kicks in
 	WatchPath                 string   `json:"watch_path"`                   // The local directory path to watch for new files
 	LogPath                   string   `json:"log_path"`                     // Path to the log file
 	DBPath                    string   `json:"db_path"`                      // Path to the SQLite database
+	DataDir                   string   `json:"data_dir"`                     // Writable directory for the DB, logs and watch data. Relative paths resolve against it instead of the binary's directory
 	IngestCheckInterval       string   `json:"ingest_check_interval"`        // Duration string (e.g. "2s") for ingest polling
 	IngestBatchSize           int      `json:"ingest_batch_size"`            // Number of files to process per ingest tick
 	IngestWorkerCount         int      `json:"ingest_worker_count"`          // Number of concurrent upload workers
@@ -25,18 +35,94 @@ type Config struct {
 	PruneBatchSize            int      `json:"prune_batch_size"`             // Number of files to prune per tick
 	PruneHighWatermarkPercent int      `json:"prune_high_watermark_percent"` // Start pruning when usage > MaxDataSizeGB * (High/100)
 	PruneLowWatermarkPercent  int      `json:"prune_low_watermark_percent"`  // Stop pruning when usage < MaxDataSizeGB * (Low/100)
+	PruneMinAge               string   `json:"prune_min_age"`                // Duration string (e.g. "10m"). Never prune files uploaded more recently than this
 	APITimeout                string   `json:"api_timeout"`                  // HTTP Client timeout duration string
+	CircuitBreakerThreshold   int      `json:"circuit_breaker_threshold"`    // Consecutive API failures before calls fail fast. 0 = disabled
+	CircuitBreakerCooldown    string   `json:"circuit_breaker_cooldown"`     // Duration string (e.g. "30s") the circuit stays open before a probe request
 	DebounceDuration          string   `json:"debounce_duration"`            // Duration string (e.g. "500ms") for watcher debounce
 	OrphanCheckInterval       string   `json:"orphan_check_interval"`        // Duration string (e.g. "5m") for orphan checks
 	MetadataUpdateInterval    string   `json:"metadata_update_interval"`     // Duration string (e.g. "24h") for device metadata updates
 	AuthToken                 string   `json:"auth_token"`                   // Token indicating the device is registered (or empty if not)
+	AuthScheme                string   `json:"auth_scheme"`                  // How AuthToken is sent: "bearer" (default), "header:<name>" (e.g. "header:X-API-Key") or "basic" (token is user:pass)
 	WebClientURL              string   `json:"web_client_url"`               // URL where the user claims the device
 	SidecarStrategy           string   `json:"sidecar_strategy"`             // "strict" (default) or "none" (image only)
 	LogMaxSizeMB              int      `json:"log_max_size_mb"`              // Max size in MB before rotation. Default 10.
 	LogMaxBackups             int      `json:"log_max_backups"`              // Max number of old files to keep. Default 3.
 	LogMaxAgeDays             int      `json:"log_max_age_days"`             // Max number of days to keep old files. Default 28.
 	LogCompress               bool     `json:"log_compress"`                 // Whether to compress old files. Default true.
-	AllowedExtensions         []string `json:"allowed_extensions"`           // List of allowed file extensions (e.g. [".jpg", ".json"])
+	AllowedExtensions         []string `json:"allowed_extensions"`           // List of allowed file extensions (e.g. [".jpg", ".json"]). Empty = allow all
+	AllowedContentTypes       []string `json:"allowed_content_types"`        // Optional sniffed MIME types (e.g. ["image/jpeg", "image/*"]) files must match in addition to the extension. Empty = no content check
+	PutMaxRetries             int      `json:"put_max_retries"`              // Retries for a transiently failing presigned PUT within one upload attempt
+	PutRetryBackoff           string   `json:"put_retry_backoff"`            // Duration string (e.g. "1s") for the initial PUT retry backoff (doubles per retry)
+	OrphanSidecarPolicy       string   `json:"orphan_sidecar_policy"`        // "upload" (default), "drop", "hold" or "flag" (hold and report as failed) for .json sidecars whose data never arrives
+	MaxPendingFiles           int      `json:"max_pending_files"`            // Defer registering new files while this many are not yet uploaded. 0 = unlimited
+	SessionID                 string   `json:"session_id"`                   // Initial capture session ID attached to uploads (overridden by `fsd session`)
+	SessionRotateInterval     string   `json:"session_rotate_interval"`      // Duration string (e.g. "1h") to auto-rotate the session ID. Empty = never
+	ReportIngestHealth        bool     `json:"report_ingest_health"`         // Include error rate, last error and backlog size in device metadata updates
+	DirMode                   string   `json:"dir_mode"`                     // Octal permissions (e.g. "0750") for directories the daemon creates. Default "0755"
+	FileMode                  string   `json:"file_mode"`                    // Octal permissions (e.g. "0640") for the DB, log and config files. Default "0644"
+	Owner                     string   `json:"owner"`                        // Unix user name or uid to own created files and dirs (only applied when running as root)
+	Group                     string   `json:"group"`                        // Unix group name or gid to own created files and dirs (only applied when running as root)
+	RunAsUser                 string   `json:"run_as_user"`                  // Unix user name or uid to switch to after startup when started as root. Empty = keep running as root
+	RunAsGroup                string   `json:"run_as_group"`                 // Unix group name or gid for RunAsUser. Empty = the user's primary group
+	Transport                 string   `json:"transport"`                    // "http" (default, presigned URL handshake) or "grpc" (streaming upload)
+	GRPCEndpoint              string   `json:"grpc_endpoint"`                // host:port of the gRPC IngestService when Transport is "grpc"
+	GRPCInsecure              bool     `json:"grpc_insecure"`                // Disable TLS for the gRPC connection (testing/local backends only)
+	MissingFileCheckInterval  string   `json:"missing_file_check_interval"`  // Duration string (e.g. "1h") for removing DB records of files deleted from disk. Empty = never
+	MissingFileGracePeriod    string   `json:"missing_file_grace_period"`    // Duration string (e.g. "24h"). Records of files missing for less long are kept
+	UploadSchedule            string   `json:"upload_schedule"`              // Comma-separated daily windows (e.g. "22:00-06:00") during which uploads run. Empty = always
+	UploadScheduleTimezone    string   `json:"upload_schedule_timezone"`     // IANA time zone for UploadSchedule (e.g. "Europe/Berlin"). Empty = system local time
+	MetadataRules             []string `json:"metadata_rules"`               // Derived metadata assignments over path parts, e.g. ["date = parts[1]; camera = upper(parts[0])"]
+	ScanFingerprintMode       string   `json:"scan_fingerprint_mode"`        // How the startup scan finds unchanged tracked files: "memory" (default, loads all records) or "chunked" (queries per directory, bounded memory)
+	TriggerEvents             []string `json:"trigger_events"`               // File events that start the debounce: "create", "write". Empty = both
+	PrioritizeCompletePairs   bool     `json:"prioritize_complete_pairs"`    // Upload pending complete pairs before orphans, regardless of age
+	DeadLetterDir             string   `json:"dead_letter_dir"`              // Directory for a JSON manifest entry per file the API rejected permanently. Empty = disabled
+	SingleInstance            bool     `json:"single_instance"`              // Refuse to start while another daemon holds the lock on the same DB. Default true
+	MaxUploadRetries          int      `json:"max_upload_retries"`           // Failed upload attempts after which a file moves to the dead_letters table. 0 = retry forever
+	RetryMaxBackoff           string   `json:"retry_max_backoff"`            // Duration string (e.g. "10m") capping the per-file retry delay, which starts at 2s and doubles per failure
+	ExtractImageDimensions    bool     `json:"extract_image_dimensions"`     // Attach image_width, image_height and image_mime (read from the header) to JPEG/PNG/GIF uploads
+	MultipartThresholdMB      int      `json:"multipart_threshold_mb"`       // Files of at least this many MB are uploaded in parts over part URLs (HTTP transport). 0 = always a single PUT
+	MaxUploadBytesPerSec      int64    `json:"max_upload_bytes_per_sec"`     // Cap on the combined upload rate of all workers in bytes per second. 0 = unlimited
+	ResumableUploads          bool     `json:"resumable_uploads"`            // Resume interrupted PUTs with a Content-Range request from the recorded offset (the endpoint must support range PUTs)
+	UploadDelay               string   `json:"upload_delay"`                 // Duration string (e.g. "5s") a file must be unmodified before it is uploaded, so bursts upload together. Empty = no delay
+	ExcludePatterns           []string `json:"exclude_patterns"`             // Globs for files to ignore: "*.tmp" matches base names, "cache/**" paths relative to WatchPath
+	IgnoreFile                string   `json:"ignore_file"`                  // File with one ExcludePatterns-style pattern per line (gitignore style, "!" re-includes), re-read when it changes. Empty = none
+	ReuploadOnModify          bool     `json:"reupload_on_modify"`           // Upload an UPLOADED file again when its size or mod time changes; false keeps it UPLOADED
+	CompressRequests          bool     `json:"compress_requests"`            // Gzip API request bodies of 1 KiB or more (e.g. large metadata); turned off again if the API answers 415
+	UploadHistoryPerFile      int      `json:"upload_history_per_file"`      // Upload attempts kept per file for `fsd history`. 0 = don't record attempts
+	MetricsAddr               string   `json:"metrics_addr"`                 // Address (e.g. ":9090") serving Prometheus metrics at /metrics. Empty = no metrics server
+	RampUpDuration            string   `json:"ramp_up_duration"`             // Duration string (e.g. "2m") over which concurrent uploads grow from 1 to IngestWorkerCount after start. Empty = no ramp-up
+	ReconcileInterval         string   `json:"reconcile_interval"`           // Duration string (e.g. "6h") between passes that register untracked files and drop records of missing ones. Empty = never
+	ShutdownGraceSeconds      int      `json:"shutdown_grace_seconds"`       // Seconds in-flight uploads may finish on stop before they are cancelled and left PENDING. 0 = cancel at once
+	PruneDryRun               bool     `json:"prune_dry_run"`                // Log the files eviction would delete and the projected size instead of deleting them
+	ReportNewDirectories      bool     `json:"report_new_directories"`       // Tell the API about directories created in WatchPath (e.g. a new session folder) before files land in them
+	PruneUseRealDiskUsage     bool     `json:"prune_use_real_disk_usage"`    // Apply the prune watermarks to the size of all files under WatchPath (walked at most every 5m) instead of the tracked sizes in the DB
+	SidecarMaxSizeKB          int      `json:"sidecar_max_size_kb"`          // Larger .json files are content uploaded on their own, never sidecars folded into a data file's context. 0 = every .json is a sidecar
+	PruneMaxDeleteAttempts    int      `json:"prune_max_delete_attempts"`    // Failed deletes (e.g. permission denied) after which the pruner skips a file until it changes. 0 = retry every cycle
+	ChecksumMode              string   `json:"checksum_mode"`                // "full" (default, SHA256 of the whole file) or "prefix" (SHA256 of the first ChecksumPrefixBytes plus the size, for very large files)
+	ChecksumPrefixBytes       int64    `json:"checksum_prefix_bytes"`        // Bytes hashed in "prefix" checksum mode. Default 1048576 (1 MiB)
+	PruneDeferActiveUploads   int      `json:"prune_defer_active_uploads"`   // Defer prune cycles while more than this many uploads are in flight, to avoid disk contention. 0 = never defer
+	PruneMaxDefer             string   `json:"prune_max_defer"`              // Duration string (e.g. "10m") after which a deferred prune cycle runs despite active uploads. Empty = 10m
+	IngestBackend             string   `json:"ingest_backend"`               // Where files go: "api" (default, the Ingestion API over Transport), "local" (copied to LocalBackendDir) or "s3" (PUT into S3Bucket)
+	LocalBackendDir           string   `json:"local_backend_dir"`            // Destination directory of the "local" ingest backend
+	S3Bucket                  string   `json:"s3_bucket"`                    // Bucket of the "s3" ingest backend
+	S3Region                  string   `json:"s3_region"`                    // Region of S3Bucket (e.g. "eu-central-1")
+	S3Endpoint                string   `json:"s3_endpoint"`                  // Base URL of an S3-compatible store (e.g. "http://minio:9000"), addressed path-style. Empty = AWS S3
+	S3Prefix                  string   `json:"s3_prefix"`                    // Key prefix of uploaded objects, e.g. "ingest"
+	S3AccessKeyID             string   `json:"s3_access_key_id"`             // Access key for S3Bucket. Empty = AWS_ACCESS_KEY_ID (and AWS_SESSION_TOKEN) from the environment
+	S3SecretAccessKey         string   `json:"s3_secret_access_key"`         // Secret key for S3AccessKeyID. Empty = AWS_SECRET_ACCESS_KEY from the environment
+	OTLPEndpoint              string   `json:"otlp_endpoint"`                // URL of an OTLP/HTTP collector (e.g. "http://localhost:4318") receiving traces of the upload pipeline. Empty = no tracing
+	DeduplicateByHash         bool     `json:"deduplicate_by_hash"`          // Skip uploading files whose content (SHA256 and size) is already uploaded under another name; they are confirmed as duplicates. Needs the "full" checksum mode
+	DBMaintenanceInterval     string   `json:"db_maintenance_interval"`      // Duration string (e.g. "24h") between database maintenance runs (ANALYZE, VACUUM, WAL checkpoint). Empty = never
+	RequireStableSize         bool     `json:"require_stable_size"`          // Before a debounced file is registered, check that its size and mod time stay unchanged for StabilityInterval; if not, wait for another debounce period
+	StabilityInterval         string   `json:"stability_interval"`           // Duration string (e.g. "1s") between the two checks of RequireStableSize. Empty = 1s
+	WatcherMode               string   `json:"watcher_mode"`                 // "fsnotify" (default, kernel events), "poll" (walk the tree every PollInterval) or "auto" (fsnotify, polling if the inotify watch limit is reached)
+	PollInterval              string   `json:"poll_interval"`                // Duration string (e.g. "5s") between the walks of the "poll" watcher mode. Empty = 5s
+	FollowSymlinks            bool     `json:"follow_symlinks"`              // Watch and scan symlinked directories below WatchPath like real ones, and register symlinked files by their resolved path
+	CompressUploads           bool     `json:"compress_uploads"`             // Gzip the upload PUT (Content-Encoding: gzip) of files with one of CompressibleExtensions
+	CompressibleExtensions    []string `json:"compressible_extensions"`      // Extensions compressed with CompressUploads, e.g. [".json", ".log"]
+	EncryptUploads            bool     `json:"encrypt_uploads"`              // Encrypt uploads with AES-256-GCM and EncryptionKey before the PUT. Needs the "api" backend with the "http" transport
+	EncryptionKey             string   `json:"encryption_key"`               // Base64-encoded 32-byte key for EncryptUploads, see "fsd keygen". Losing it means losing the uploaded data
 }
 
 var (
@@ -52,6 +138,8 @@ var (
 	DefaultPruneHighWatermarkPercent = 90
 	DefaultPruneLowWatermarkPercent  = 75
 	DefaultAPITimeout                = "30s"
+	DefaultCircuitBreakerThreshold   = 5
+	DefaultCircuitBreakerCooldown    = "30s"
 	DefaultDebounceDuration          = "500ms"
 	DefaultOrphanCheckInterval       = "5m"
 	DefaultMetadataUpdateInterval    = "24h"
@@ -61,6 +149,32 @@ var (
 	DefaultLogMaxAgeDays             = 28
 	DefaultLogCompress               = true
 	DefaultAllowedExtensions         = []string{".jpg", ".jpeg", ".png", ".json"}
+	DefaultPutMaxRetries             = 3
+	DefaultPutRetryBackoff           = "1s"
+	DefaultOrphanSidecarPolicy       = "upload"
+	DefaultDirMode                   = "0755"
+	DefaultFileMode                  = "0644"
+	DefaultTransport                 = "http"
+	DefaultScanFingerprintMode       = "memory"
+	DefaultMissingFileCheckInterval  = "1h"
+	DefaultMissingFileGracePeriod    = "24h"
+	DefaultReconcileInterval         = "6h"
+	DefaultShutdownGraceSeconds      = 30
+	DefaultSingleInstance            = true
+	DefaultMaxUploadRetries          = 10
+	DefaultRetryMaxBackoff           = "10m"
+	DefaultReuploadOnModify          = true
+	DefaultUploadHistoryPerFile      = 20
+	DefaultSidecarMaxSizeKB          = 1024
+	DefaultPruneMaxDeleteAttempts    = 3
+	DefaultChecksumMode              = "full"
+	DefaultChecksumPrefixBytes       = int64(1 << 20)
+	DefaultPruneMaxDefer             = "10m"
+	DefaultDBMaintenanceInterval     = "24h"
+	DefaultStabilityInterval         = "1s"
+	DefaultWatcherMode               = "fsnotify"
+	DefaultPollInterval              = "5s"
+	DefaultCompressibleExtensions    = []string{".json", ".txt", ".log", ".csv", ".xml"}
 )
 
 // Load reads the configuration from the specified path.
@@ -82,6 +196,8 @@ func Load(path string) (*Config, error) {
 		PruneHighWatermarkPercent: DefaultPruneHighWatermarkPercent,
 		PruneLowWatermarkPercent:  DefaultPruneLowWatermarkPercent,
 		APITimeout:                DefaultAPITimeout,
+		CircuitBreakerThreshold:   DefaultCircuitBreakerThreshold,
+		CircuitBreakerCooldown:    DefaultCircuitBreakerCooldown,
 		DebounceDuration:          DefaultDebounceDuration,
 		OrphanCheckInterval:       DefaultOrphanCheckInterval,
 		MetadataUpdateInterval:    DefaultMetadataUpdateInterval,
@@ -92,6 +208,32 @@ func Load(path string) (*Config, error) {
 		LogMaxAgeDays:             DefaultLogMaxAgeDays,
 		LogCompress:               DefaultLogCompress,
 		AllowedExtensions:         DefaultAllowedExtensions,
+		PutMaxRetries:             DefaultPutMaxRetries,
+		PutRetryBackoff:           DefaultPutRetryBackoff,
+		OrphanSidecarPolicy:       DefaultOrphanSidecarPolicy,
+		DirMode:                   DefaultDirMode,
+		FileMode:                  DefaultFileMode,
+		Transport:                 DefaultTransport,
+		ScanFingerprintMode:       DefaultScanFingerprintMode,
+		MissingFileCheckInterval:  DefaultMissingFileCheckInterval,
+		MissingFileGracePeriod:    DefaultMissingFileGracePeriod,
+		ReconcileInterval:         DefaultReconcileInterval,
+		ShutdownGraceSeconds:      DefaultShutdownGraceSeconds,
+		SingleInstance:            DefaultSingleInstance,
+		MaxUploadRetries:          DefaultMaxUploadRetries,
+		RetryMaxBackoff:           DefaultRetryMaxBackoff,
+		ReuploadOnModify:          DefaultReuploadOnModify,
+		UploadHistoryPerFile:      DefaultUploadHistoryPerFile,
+		SidecarMaxSizeKB:          DefaultSidecarMaxSizeKB,
+		PruneMaxDeleteAttempts:    DefaultPruneMaxDeleteAttempts,
+		ChecksumMode:              DefaultChecksumMode,
+		ChecksumPrefixBytes:       DefaultChecksumPrefixBytes,
+		PruneMaxDefer:             DefaultPruneMaxDefer,
+		DBMaintenanceInterval:     DefaultDBMaintenanceInterval,
+		StabilityInterval:         DefaultStabilityInterval,
+		WatcherMode:               DefaultWatcherMode,
+		PollInterval:              DefaultPollInterval,
+		CompressibleExtensions:    DefaultCompressibleExtensions,
 	}
 
 	f, err := os.Open(path)
@@ -110,20 +252,148 @@ func Load(path string) (*Config, error) {
 		return nil, err
 	}
 
-	// Helper to resolve relative paths against executable directory
+	if _, err := ParseMode(cfg.DirMode); err != nil {
+		return nil, fmt.Errorf("invalid dir_mode: %w", err)
+	}
+	if _, err := ParseMode(cfg.FileMode); err != nil {
+		return nil, fmt.Errorf("invalid file_mode: %w", err)
+	}
+	if _, err := util.ParseSchedule(cfg.UploadSchedule, cfg.UploadScheduleTimezone); err != nil {
+		return nil, fmt.Errorf("invalid upload_schedule: %w", err)
+	}
+	if _, err := util.ParseMetadataRules(cfg.MetadataRules); err != nil {
+		return nil, fmt.Errorf("invalid metadata_rules: %w", err)
+	}
+	if _, err := util.ParseExcludePatterns(cfg.ExcludePatterns); err != nil {
+		return nil, fmt.Errorf("invalid exclude_patterns: %w", err)
+	}
+	if _, err := watcher.ParseTriggerEvents(cfg.TriggerEvents); err != nil {
+		return nil, fmt.Errorf("invalid trigger_events: %w", err)
+	}
+	if cfg.MetricsAddr != "" {
+		if _, _, err := net.SplitHostPort(cfg.MetricsAddr); err != nil {
+			return nil, fmt.Errorf("invalid metrics_addr %q: %w", cfg.MetricsAddr, err)
+		}
+	}
+	if cfg.OTLPEndpoint != "" {
+		if u, err := url.Parse(cfg.OTLPEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid otlp_endpoint %q: must be an http(s) URL", cfg.OTLPEndpoint)
+		}
+	}
+	if cfg.UploadHistoryPerFile < 0 {
+		return nil, fmt.Errorf("invalid upload_history_per_file %d: must not be negative", cfg.UploadHistoryPerFile)
+	}
+	if cfg.ShutdownGraceSeconds < 0 {
+		return nil, fmt.Errorf("invalid shutdown_grace_seconds %d: must not be negative", cfg.ShutdownGraceSeconds)
+	}
+	if cfg.PruneMaxDeleteAttempts < 0 {
+		return nil, fmt.Errorf("invalid prune_max_delete_attempts %d: must not be negative", cfg.PruneMaxDeleteAttempts)
+	}
+	if cfg.PruneDeferActiveUploads < 0 {
+		return nil, fmt.Errorf("invalid prune_defer_active_uploads %d: must not be negative", cfg.PruneDeferActiveUploads)
+	}
+	if cfg.PruneMaxDefer != "" {
+		if d, err := time.ParseDuration(cfg.PruneMaxDefer); err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid prune_max_defer %q: must be a positive duration", cfg.PruneMaxDefer)
+		}
+	}
+	if cfg.StabilityInterval != "" {
+		if d, err := time.ParseDuration(cfg.StabilityInterval); err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid stability_interval %q: must be a positive duration", cfg.StabilityInterval)
+		}
+	}
+	if cfg.PollInterval != "" {
+		if d, err := time.ParseDuration(cfg.PollInterval); err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid poll_interval %q: must be a positive duration", cfg.PollInterval)
+		}
+	}
+	if cfg.SidecarMaxSizeKB < 0 {
+		return nil, fmt.Errorf("invalid sidecar_max_size_kb %d: must not be negative", cfg.SidecarMaxSizeKB)
+	}
+	if cfg.MultipartThresholdMB < 0 {
+		return nil, fmt.Errorf("invalid multipart_threshold_mb %d: must not be negative", cfg.MultipartThresholdMB)
+	}
+	if cfg.MaxUploadBytesPerSec < 0 {
+		return nil, fmt.Errorf("invalid max_upload_bytes_per_sec %d: must not be negative", cfg.MaxUploadBytesPerSec)
+	}
+	if cfg.RampUpDuration != "" {
+		if d, err := time.ParseDuration(cfg.RampUpDuration); err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid ramp_up_duration %q: must be a non-negative duration", cfg.RampUpDuration)
+		}
+	}
+	if cfg.UploadDelay != "" {
+		if d, err := time.ParseDuration(cfg.UploadDelay); err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid upload_delay %q: must be a non-negative duration", cfg.UploadDelay)
+		}
+	}
+	switch cfg.ScanFingerprintMode {
+	case "", "memory", "chunked":
+	default:
+		return nil, fmt.Errorf("invalid scan_fingerprint_mode %q: must be \"memory\" or \"chunked\"", cfg.ScanFingerprintMode)
+	}
+	switch cfg.IngestBackend {
+	case "", "api":
+	case "local":
+		if cfg.LocalBackendDir == "" {
+			return nil, fmt.Errorf("invalid ingest_backend \"local\": local_backend_dir is empty")
+		}
+	case "s3":
+		if cfg.S3Bucket == "" || cfg.S3Region == "" {
+			return nil, fmt.Errorf("invalid ingest_backend \"s3\": s3_bucket and s3_region are required")
+		}
+	default:
+		return nil, fmt.Errorf("invalid ingest_backend %q: must be \"api\", \"local\" or \"s3\"", cfg.IngestBackend)
+	}
+	switch cfg.OrphanSidecarPolicy {
+	case "", "upload", "drop", "hold", "flag":
+	default:
+		return nil, fmt.Errorf("invalid orphan_sidecar_policy %q: must be \"upload\", \"drop\", \"hold\" or \"flag\"", cfg.OrphanSidecarPolicy)
+	}
+	if cfg.EncryptUploads {
+		if key, err := base64.StdEncoding.DecodeString(cfg.EncryptionKey); err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("invalid encryption_key: must be 32 bytes, base64-encoded (generate one with \"fsd keygen\")")
+		}
+		if (cfg.IngestBackend != "" && cfg.IngestBackend != "api") || (cfg.Transport != "" && cfg.Transport != "http") {
+			return nil, fmt.Errorf("invalid encrypt_uploads: only supported with the \"api\" ingest_backend and the \"http\" transport")
+		}
+	}
+	switch cfg.WatcherMode {
+	case "", "fsnotify", "poll", "auto":
+	default:
+		return nil, fmt.Errorf("invalid watcher_mode %q: must be \"fsnotify\", \"poll\" or \"auto\"", cfg.WatcherMode)
+	}
+	switch cfg.ChecksumMode {
+	case "", "full":
+	case "prefix":
+		if cfg.ChecksumPrefixBytes <= 0 {
+			return nil, fmt.Errorf("invalid checksum_prefix_bytes %d: must be positive in prefix checksum mode", cfg.ChecksumPrefixBytes)
+		}
+	default:
+		return nil, fmt.Errorf("invalid checksum_mode %q: must be \"full\" or \"prefix\"", cfg.ChecksumMode)
+	}
+
+	// Helper to resolve relative paths against the data dir (or executable directory)
+	baseDir := ""
+	if ex, err := os.Executable(); err == nil {
+		baseDir = filepath.Dir(ex)
+	}
 	resolvePath := func(p string) string {
 		if p == "" {
 			return p
 		}
 		if !filepath.IsAbs(p) && (strings.HasPrefix(p, "./") || !strings.HasPrefix(p, "/")) { // simplistic check
-			ex, err := os.Executable()
-			if err == nil {
-				return filepath.Join(filepath.Dir(ex), p)
+			if baseDir != "" {
+				return filepath.Join(baseDir, p)
 			}
 		}
 		return p
 	}
 
+	if cfg.DataDir != "" {
+		cfg.DataDir = resolvePath(cfg.DataDir)
+		baseDir = cfg.DataDir
+	}
+
 	// Normalize Paths if they are defaults or relative
 	if cfg.WatchPath == "./data" {
 		cfg.WatchPath = resolvePath("data")
@@ -133,13 +403,36 @@ func Load(path string) (*Config, error) {
 
 	cfg.LogPath = resolvePath(cfg.LogPath)
 	cfg.DBPath = resolvePath(cfg.DBPath)
+	cfg.DeadLetterDir = resolvePath(cfg.DeadLetterDir)
+	cfg.IgnoreFile = resolvePath(cfg.IgnoreFile)
+	cfg.LocalBackendDir = resolvePath(cfg.LocalBackendDir)
+
+	// Copies inside the watch directory would be detected and ingested again.
+	if cfg.IngestBackend == "local" && within(cfg.WatchPath, cfg.LocalBackendDir) {
+		return nil, fmt.Errorf("invalid local_backend_dir %q: must not be inside watch_path %q", cfg.LocalBackendDir, cfg.WatchPath)
+	}
 
 	return cfg, nil
 }
 
+// within reports whether path is dir or below it.
+func within(dir, path string) bool {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(absDir, absPath)
+	return err == nil && filepath.IsLocal(rel)
+}
+
 // Save writes the provided Config struct to the specified path as a JSON file.
+// The file is created with cfg's FileMode and ownership.
 func Save(path string, cfg *Config) error {
-	f, err := os.Create(path)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, cfg.FilePerm())
 	if err != nil {
 		return err
 	}
@@ -147,5 +440,8 @@ func Save(path string, cfg *Config) error {
 
 	encoder := json.NewEncoder(f)
 	encoder.SetIndent("", "  ") // Pretty print for human readability
-	return encoder.Encode(cfg)
+	if err := encoder.Encode(cfg); err != nil {
+		return err
+	}
+	return cfg.ApplyFilePerms(path)
 }
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestMkdirAllUsesDirMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Unix permissions are not supported on Windows")
+	}
+
+	tmpDir, err := os.MkdirTemp("", "config_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cfg := &Config{DirMode: "0750"}
+	dir := filepath.Join(tmpDir, "watch", "data")
+	if err := cfg.MkdirAll(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := info.Mode().Perm(); got != 0750 {
+		t.Errorf("Expected dir mode 0750, got %#o", got)
+	}
+}
+
+func TestLoadRejectsInvalidModes(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "config_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "config.json")
+	if err := os.WriteFile(path, []byte(`{"dir_mode": "0759"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Error("Expected Load to reject a non-octal dir_mode")
+	}
+}
+
+func TestLoadRejectsLocalBackendDirInsideWatchPath(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "config.json")
+	for dir, wantErr := range map[string]bool{
+		filepath.Join(tmpDir, "watch"):           true,
+		filepath.Join(tmpDir, "watch", "copies"): true,
+		filepath.Join(tmpDir, "watch-copies"):    false,
+	} {
+		data := fmt.Sprintf(`{"watch_path": %q, "ingest_backend": "local", "local_backend_dir": %q}`, filepath.Join(tmpDir, "watch"), dir)
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(path); (err != nil) != wantErr {
+			t.Errorf("Load with local_backend_dir %s: got err=%v, want error: %v", dir, err, wantErr)
+		}
+	}
+}
+
+func TestLoadResolvesPathsAgainstDataDir(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "config_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	installDir := filepath.Join(tmpDir, "opt", "fsd")
+	dataDir := filepath.Join(tmpDir, "var", "lib", "fsd")
+	if err := os.MkdirAll(installDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cfgPath := filepath.Join(installDir, "config.json")
+	if err := Save(cfgPath, &Config{
+		DataDir: dataDir,
+		DBPath:  "fsd.db",
+		LogPath: "./fsd.log",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dataDir, "fsd.db"); cfg.DBPath != want {
+		t.Errorf("Expected DB in data dir %s, got %s", want, cfg.DBPath)
+	}
+	if want := filepath.Join(dataDir, "fsd.log"); cfg.LogPath != want {
+		t.Errorf("Expected log in data dir %s, got %s", want, cfg.LogPath)
+	}
+	if _, err := os.Stat(cfgPath); err != nil {
+		t.Errorf("Expected config to stay in the install dir: %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+//go:build !windows
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+)
+
+// Chown assigns the configured Owner/Group to path.
+// It is a no-op unless running as root and at least one of them is set.
+func (c *Config) Chown(path string) error {
+	if (c.Owner == "" && c.Group == "") || os.Geteuid() != 0 {
+		return nil
+	}
+
+	uid, gid := -1, -1
+	if c.Owner != "" {
+		id, err := LookupUID(c.Owner)
+		if err != nil {
+			return err
+		}
+		uid = id
+	}
+	if c.Group != "" {
+		id, err := LookupGID(c.Group)
+		if err != nil {
+			return err
+		}
+		gid = id
+	}
+
+	return os.Chown(path, uid, gid)
+}
+
+// LookupUID resolves a Unix user name or numeric uid.
+func LookupUID(nameOrID string) (int, error) {
+	id, err := lookupID(nameOrID, func(name string) (string, error) {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", err
+		}
+		return u.Uid, nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("unknown user %q: %w", nameOrID, err)
+	}
+	return id, nil
+}
+
+// LookupGID resolves a Unix group name or numeric gid.
+func LookupGID(nameOrID string) (int, error) {
+	id, err := lookupID(nameOrID, func(name string) (string, error) {
+		g, err := user.LookupGroup(name)
+		if err != nil {
+			return "", err
+		}
+		return g.Gid, nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("unknown group %q: %w", nameOrID, err)
+	}
+	return id, nil
+}
+
+// lookupID resolves a numeric id or a name via lookup.
+func lookupID(nameOrID string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(nameOrID); err == nil {
+		return id, nil
+	}
+	s, err := lookup(nameOrID)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(s)
+}
@@ -0,0 +1,8 @@
+//go:build windows
+
+package config
+
+// Chown is a no-op on Windows, where Owner/Group are not supported.
+func (c *Config) Chown(path string) error {
+	return nil
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// ParseMode parses an octal permission string such as "0750".
+// An empty string is valid and means "use the default".
+func ParseMode(s string) (os.FileMode, error) {
+	if s == "" {
+		return 0, nil
+	}
+	v, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not an octal mode", s)
+	}
+	if v > 0777 {
+		return 0, fmt.Errorf("%q has bits outside 0777", s)
+	}
+	return os.FileMode(v), nil
+}
+
+// DirPerm returns the permissions for directories the daemon creates.
+func (c *Config) DirPerm() os.FileMode {
+	if mode, err := ParseMode(c.DirMode); err == nil && mode != 0 {
+		return mode
+	}
+	return 0755
+}
+
+// FilePerm returns the permissions for files the daemon creates (DB, logs, config).
+func (c *Config) FilePerm() os.FileMode {
+	if mode, err := ParseMode(c.FileMode); err == nil && mode != 0 {
+		return mode
+	}
+	return 0644
+}
+
+// MkdirAll creates path (and any parents) with DirPerm, then applies the exact
+// mode (bypassing the umask) and configured ownership to path itself.
+func (c *Config) MkdirAll(path string) error {
+	if err := os.MkdirAll(path, c.DirPerm()); err != nil {
+		return err
+	}
+	if err := os.Chmod(path, c.DirPerm()); err != nil {
+		return err
+	}
+	return c.Chown(path)
+}
+
+// ApplyFilePerms sets FilePerm and the configured ownership on an existing file.
+func (c *Config) ApplyFilePerms(path string) error {
+	if err := os.Chmod(path, c.FilePerm()); err != nil {
+		return err
+	}
+	return c.Chown(path)
+}
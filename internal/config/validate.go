@@ -0,0 +1,86 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Problems checks settings that Load accepts but the daemon would silently
+// replace with a default at runtime (e.g. a duration without a unit), and
+// returns one human-readable message per problem found.
+func (c *Config) Problems() []string {
+	var problems []string
+
+	// Durations that must be set, and optional ones where "" disables the feature.
+	durations := []struct {
+		name, value string
+		optional    bool
+	}{
+		{"ingest_check_interval", c.IngestCheckInterval, false},
+		{"prune_check_interval", c.PruneCheckInterval, false},
+		{"prune_min_age", c.PruneMinAge, true},
+		{"api_timeout", c.APITimeout, false},
+		{"circuit_breaker_cooldown", c.CircuitBreakerCooldown, false},
+		{"debounce_duration", c.DebounceDuration, false},
+		{"orphan_check_interval", c.OrphanCheckInterval, false},
+		{"metadata_update_interval", c.MetadataUpdateInterval, false},
+		{"put_retry_backoff", c.PutRetryBackoff, false},
+		{"session_rotate_interval", c.SessionRotateInterval, true},
+		{"missing_file_check_interval", c.MissingFileCheckInterval, true},
+		{"missing_file_grace_period", c.MissingFileGracePeriod, true},
+		{"retry_max_backoff", c.RetryMaxBackoff, false},
+		{"upload_delay", c.UploadDelay, true},
+		{"ramp_up_duration", c.RampUpDuration, true},
+		{"reconcile_interval", c.ReconcileInterval, true},
+		{"db_maintenance_interval", c.DBMaintenanceInterval, true},
+	}
+	for _, d := range durations {
+		if d.value == "" {
+			if !d.optional {
+				problems = append(problems, fmt.Sprintf("%s is empty", d.name))
+			}
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %q is not a duration (use a unit, e.g. \"20ms\", \"5m\")", d.name, d.value))
+		} else if v < 0 {
+			problems = append(problems, fmt.Sprintf("%s: %q must not be negative", d.name, d.value))
+		}
+	}
+
+	low, high := c.PruneLowWatermarkPercent, c.PruneHighWatermarkPercent
+	if low < 0 || low > 100 || high < 0 || high > 100 {
+		problems = append(problems, fmt.Sprintf("prune watermarks must be between 0 and 100 (low %d, high %d)", low, high))
+	} else if low >= high {
+		problems = append(problems, fmt.Sprintf("prune_low_watermark_percent (%d) must be below prune_high_watermark_percent (%d)", low, high))
+	}
+
+	if c.MaxDataSizeGB <= 0 {
+		problems = append(problems, fmt.Sprintf("max_data_size_gb must be greater than 0 (got %g)", c.MaxDataSizeGB))
+	}
+	return problems
+}
+
+// HostProblems checks the configuration against the host it runs on, such as
+// whether the watch path can be created. Unlike Problems it touches the
+// filesystem, so it only makes sense on the host the daemon runs on.
+func (c *Config) HostProblems() []string {
+	var problems []string
+	if err := checkWritableDir(filepath.Dir(c.WatchPath)); err != nil {
+		problems = append(problems, fmt.Sprintf("watch_path %s: parent directory is not writable: %v", c.WatchPath, err))
+	}
+	return problems
+}
+
+// checkWritableDir verifies that a file can be created in dir.
+func checkWritableDir(dir string) error {
+	f, err := os.CreateTemp(dir, ".fsd-write-check-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
@@ -3,20 +3,28 @@ package daemon
 import (
 	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"fs-ingest-daemon/internal/api"
+	"fs-ingest-daemon/internal/clock"
 	"fs-ingest-daemon/internal/config"
 	"fs-ingest-daemon/internal/ingest"
+	"fs-ingest-daemon/internal/logger"
+	"fs-ingest-daemon/internal/metrics"
 	"fs-ingest-daemon/internal/pruner"
 	"fs-ingest-daemon/internal/store"
 	"fs-ingest-daemon/internal/sysinfo"
+	"fs-ingest-daemon/internal/util"
 	"fs-ingest-daemon/internal/watcher"
 
 	"github.com/kardianos/service"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
 // Daemon implements the service.Interface required by kardianos/service.
@@ -24,11 +32,44 @@ import (
 type Daemon struct {
 	Logger      *slog.Logger
 	Cfg         *config.Config
+	CfgPath     string // config.json re-read by Reload. Empty means next to the executable
+	LogPath     string // Log file written by the logger, never ingested. Empty means Cfg.LogPath
 	DbStore     *store.Store
 	ApiClient   *api.Client
 	PrunerSvc   *pruner.Pruner
 	IngesterSvc *ingest.Ingester
 	WatcherSvc  *watcher.Watcher
+	Clock       clock.Clock // Drives periodic tasks and age checks. Nil means the real clock
+
+	// OnUploadResult is passed to the Ingester, see ingest.Ingester.OnUploadResult.
+	OnUploadResult func(store.FileRecord, error)
+
+	deferred atomic.Bool               // Set when a detected file was skipped due to backpressure
+	scanIdx  atomic.Pointer[scanIndex] // Set during the startup scan to skip unchanged tracked files
+	lockFile *os.File                  // Held single-instance lock, see acquireInstanceLock
+	scans    scanGuard                 // Serializes walks of the watch directory
+
+	excludeOnce sync.Once
+	exclude     atomic.Pointer[util.ExcludePatterns] // Parsed ExcludePatterns, see excluded
+	ignore      atomic.Pointer[util.IgnoreRules]     // Patterns of IgnoreFile, see reloadIgnoreFile
+	ignoreStamp *ignoreFileStamp                     // Version of IgnoreFile last loaded, nil before the first load
+
+	ownOnce  sync.Once
+	ownPaths map[string]bool // Absolute paths of the database files, see ownFile
+	ownLog   string          // Absolute log path, see ownFile
+
+	reloadMu   sync.Mutex     // Serializes Reload
+	reloaded   *config.Config // Settings applied by the last Reload, nil = Cfg
+	stopReload func()         // Stops the reload signal handler, see watchReloadSignal
+	done       chan struct{}  // Closed by Stop to end the periodic tasks started by Start
+
+	metricsSrv     *http.Server             // Serves MetricsAddr, nil if disabled
+	tracerProvider *sdktrace.TracerProvider // Exports upload traces to OTLPEndpoint, nil if disabled
+	dirReports     chan string              // Directories to report, see queueDirectoryReport
+
+	// Discrepancies fixed by reconcile, nil if metrics are disabled
+	reconciledUntracked *metrics.Counter
+	reconciledMissing   *metrics.Counter
 }
 
 // Start is called when the service is started.
@@ -44,6 +85,10 @@ func (d *Daemon) Start(s service.Service) error {
 	}
 	exPath := filepath.Dir(ex)
 	cfgPath := filepath.Join(exPath, "config.json")
+	if d.CfgPath != "" {
+		cfgPath = d.CfgPath
+	}
+	d.CfgPath = cfgPath
 
 	if d.Cfg == nil {
 		d.Cfg, err = config.Load(cfgPath)
@@ -58,27 +103,99 @@ func (d *Daemon) Start(s service.Service) error {
 	}
 
 	// 2. Initialize Store using configured DB Path
+	if d.Cfg.SingleInstance {
+		d.lockFile, err = acquireInstanceLock(d.Cfg.DBPath, d.Cfg.FilePerm())
+		if err != nil {
+			return err
+		}
+	}
 	d.DbStore, err = store.NewStore(d.Cfg.DBPath)
 	if err != nil {
 		return fmt.Errorf("failed to init store at %s: %v", d.Cfg.DBPath, err)
 	}
+	if err := d.Cfg.ApplyFilePerms(d.Cfg.DBPath); err != nil && d.Logger != nil {
+		d.Logger.Warn("Failed to apply permissions to database", "path", d.Cfg.DBPath, "error", err)
+	}
+
+	d.DbStore.SetClock(d.Clock)
+	d.DbStore.SetPrioritizePairs(d.Cfg.PrioritizeCompletePairs)
+	if d.Cfg.UploadDelay != "" {
+		delay, err := time.ParseDuration(d.Cfg.UploadDelay)
+		if err != nil && d.Logger != nil {
+			d.Logger.Error("Invalid upload delay, uploading without delay", "error", err)
+		}
+		d.DbStore.SetUploadDelay(delay)
+	}
+	d.DbStore.SetReuploadOnModify(d.Cfg.ReuploadOnModify)
+	d.DbStore.SetSidecarMaxSize(int64(d.Cfg.SidecarMaxSizeKB) * 1024)
 
 	// 3. Initialize API Client
 	d.ApiClient = api.NewClient(d.Cfg.Endpoint, d.Cfg.APITimeout)
+	d.ApiClient.SetAuth(d.Cfg.AuthToken, d.Cfg.AuthScheme)
+	d.ApiClient.SetCompressRequests(d.Cfg.CompressRequests)
+
+	var m daemonMetrics
+	if d.Cfg.MetricsAddr != "" {
+		m = newDaemonMetrics(d.DbStore)
+	}
+	d.reconciledUntracked = m.reconciledUntracked
+	d.reconciledMissing = m.reconciledMissing
 
-	// 4. Start Pruner
+	// 4. Create Pruner (started after the Ingester)
 	d.PrunerSvc = pruner.NewPruner(d.Cfg, d.DbStore, d.Logger)
-	d.PrunerSvc.Start()
+	d.PrunerSvc.OnSpaceRecovered = d.resumeDeferred
+	d.PrunerSvc.Clock = d.Clock
+	d.PrunerSvc.PrunedFiles = m.prunedFiles
+	d.PrunerSvc.PrunedBytes = m.prunedBytes
+	d.PrunerSvc.PruneFailed = m.pruneFailed
 
-	// 5. Start Ingester
+	// 5. Create Ingester (started once privileges are dropped)
 	d.IngesterSvc = ingest.NewIngester(d.Cfg, d.DbStore, d.Logger)
-	d.IngesterSvc.Start()
+	d.ApiClient.SetCircuitBreaker(d.IngesterSvc.CircuitBreaker())
+	d.IngesterSvc.SetClock(d.Clock)
+	d.IngesterSvc.OnUploadResult = d.OnUploadResult
+	d.IngesterSvc.SaveAuthToken = d.saveAuthToken
+	d.IngesterSvc.SetMetrics(m.uploads)
+	d.IngesterSvc.SetPairingMetrics(m.pairing)
+	// Tracing is optional too; without a collector the tracer is a no-op.
+	if d.Cfg.OTLPEndpoint != "" {
+		tp, err := newTracerProvider(d.Cfg.OTLPEndpoint, d.Cfg.DeviceID)
+		if err != nil {
+			if d.Logger != nil {
+				d.Logger.Error("Failed to set up tracing", "endpoint", d.Cfg.OTLPEndpoint, "error", err)
+			}
+		} else {
+			d.tracerProvider = tp
+			d.IngesterSvc.SetTracerProvider(tp)
+		}
+	}
 
-	// 6. Start Watcher
-	if err := os.MkdirAll(d.Cfg.WatchPath, 0755); err != nil {
+	// Metrics are optional; a busy port must not stop uploads.
+	if m.registry != nil {
+		if err := d.serveMetrics(d.Cfg.MetricsAddr, m.registry); err != nil && d.Logger != nil {
+			d.Logger.Error("Failed to start metrics server", "addr", d.Cfg.MetricsAddr, "error", err)
+		}
+	}
+
+	if err := d.Cfg.MkdirAll(d.Cfg.WatchPath); err != nil {
+		d.abortStart()
 		return fmt.Errorf("failed to create watch dir: %v", err)
 	}
 
+	// Everything that needs root (DB, listeners, watch dir) is open now; no
+	// worker may run before privileges are dropped.
+	if err := d.dropPrivileges(); err != nil {
+		d.abortStart()
+		return fmt.Errorf("failed to drop privileges: %v", err)
+	}
+
+	d.IngesterSvc.Start()
+
+	// The pruner starts once it can see the uploads it yields to.
+	d.PrunerSvc.ActiveUploads = d.IngesterSvc.InFlight
+	d.PrunerSvc.Start()
+
+	// 6. Start Watcher
 	debounceDur, err := time.ParseDuration(d.Cfg.DebounceDuration)
 	if err != nil {
 		if d.Logger != nil {
@@ -87,10 +204,46 @@ func (d *Daemon) Start(s service.Service) error {
 		debounceDur = 500 * time.Millisecond
 	}
 
-	d.WatcherSvc, err = watcher.NewWatcher(d.Cfg.WatchPath, debounceDur, d.processFile, d.Logger)
+	// Exclusions from IgnoreFile apply to the startup scan too.
+	if d.Cfg.IgnoreFile != "" {
+		d.reloadIgnoreFile()
+		go d.ignoreFileWatcher()
+	}
+
+	// The watcher registers existing files while it walks the tree; skip the
+	// ones already tracked unchanged so a restart does not re-upload them.
+	if idx, err := newScanIndex(d.DbStore, d.Cfg.ScanFingerprintMode); err != nil {
+		if d.Logger != nil {
+			d.Logger.Error("Failed to load file fingerprints, startup scan will re-register all files", "error", err)
+		}
+	} else {
+		d.scanIdx.Store(idx)
+	}
+	d.exclusiveWalk("startup", func() {
+		d.WatcherSvc, err = d.newWatcher(debounceDur)
+	})
+	d.scanIdx.Store(nil)
 	if err != nil {
+		d.abortStart()
 		return fmt.Errorf("failed to start watcher: %v", err)
 	}
+	d.WatcherSvc.SetOnEventsLost(d.incrementalRescan)
+	d.WatcherSvc.SetOnRootRestored(d.rootRestored)
+	d.WatcherSvc.SetExclude(d.excluded)
+	if d.Cfg.RequireStableSize {
+		d.WatcherSvc.SetStabilityCheck(d.stabilityInterval())
+	}
+	if err := d.WatcherSvc.SetTriggerEvents(d.Cfg.TriggerEvents); err != nil {
+		d.abortStart()
+		return fmt.Errorf("invalid trigger_events: %v", err)
+	}
+	if d.Cfg.ReportNewDirectories {
+		d.dirReports = make(chan string, directoryReportQueue)
+		go d.directoryReporter()
+		d.WatcherSvc.SetOnNewDirectory(d.queueDirectoryReport)
+	}
+
+	d.done = make(chan struct{})
 
 	// 7. Start Orphan Checker
 	go d.orphanChecker()
@@ -98,6 +251,29 @@ func (d *Daemon) Start(s service.Service) error {
 	// 9. Start Metadata Updater
 	go d.metadataUpdater()
 
+	// 10. Start Session Rotator (optional)
+	if d.Cfg.SessionRotateInterval != "" {
+		go d.sessionRotator(d.done)
+	}
+
+	// 11. Start Missing File Cleaner (optional)
+	if d.Cfg.MissingFileCheckInterval != "" {
+		go d.missingFileCleaner(d.done)
+	}
+
+	// 12. Start Reconciler (optional)
+	if d.Cfg.ReconcileInterval != "" {
+		go d.reconciler(d.done)
+	}
+
+	// 13. Start Database Maintenance (optional)
+	if d.Cfg.DBMaintenanceInterval != "" {
+		go d.dbMaintainer(d.done)
+	}
+
+	// 14. Reload live-changeable settings on SIGHUP (Unix only)
+	d.stopReload = d.watchReloadSignal()
+
 	if d.Logger != nil {
 		d.Logger.Info("FS Ingest Daemon Started")
 		d.Logger.Info("Configuration", "watch_path", d.Cfg.WatchPath, "endpoint", d.Cfg.Endpoint)
@@ -106,6 +282,58 @@ func (d *Daemon) Start(s service.Service) error {
 	return nil
 }
 
+// RunOnce starts the daemon, lets the initial scan register existing files, and
+// returns once every pending file has been processed or maxDuration elapses
+// (0 means no limit). The daemon is stopped before returning.
+// This supports cron-style deployments that don't want a persistent service.
+func (d *Daemon) RunOnce(maxDuration time.Duration) error {
+	if err := d.Start(nil); err != nil {
+		return err
+	}
+	defer d.Stop(nil)
+
+	clk := clock.OrReal(d.Clock)
+	var deadline <-chan time.Time
+	if maxDuration > 0 {
+		deadline = clk.After(maxDuration)
+	}
+
+	ticker := clk.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			drained, err := d.drained()
+			if err != nil {
+				return err
+			}
+			if drained {
+				if d.Logger != nil {
+					d.Logger.Info("Run once: queue drained, exiting")
+				}
+				return nil
+			}
+		case <-deadline:
+			return fmt.Errorf("max duration %s reached with files still pending", maxDuration)
+		}
+	}
+}
+
+// drained reports whether no files are waiting for upload or currently in flight.
+// Files held back by upload_delay or a retry backoff are still waiting, so
+// PENDING and ORPHAN files are counted rather than fetched for upload.
+func (d *Daemon) drained() (bool, error) {
+	if d.IngesterSvc.InFlight() > 0 {
+		return false, nil
+	}
+	counts, err := d.DbStore.CountByStatus()
+	if err != nil {
+		return false, err
+	}
+	return counts[store.StatusPending]+counts[store.StatusOrphan] == 0, nil
+}
+
 // metadataUpdater runs periodically to collect and send system metadata.
 func (d *Daemon) metadataUpdater() {
 	interval, err := time.ParseDuration(d.Cfg.MetadataUpdateInterval)
@@ -117,42 +345,242 @@ func (d *Daemon) metadataUpdater() {
 	}
 
 	// Wait a bit before the first run to allow the system to stabilize
-	time.Sleep(10 * time.Second)
+	clk := clock.OrReal(d.Clock)
+	<-clk.After(10 * time.Second)
 
-	ticker := time.NewTicker(interval)
+	ticker := clk.NewTicker(interval)
 	defer ticker.Stop()
 
-	update := func() {
-		info, err := sysinfo.Collect()
-		if err != nil {
-			if d.Logger != nil {
-				d.Logger.Error("Failed to collect system info", "error", err)
-			}
-			return
+	// Run immediately once
+	d.updateMetadata()
+
+	for {
+		select {
+		case <-ticker.C():
+			d.updateMetadata()
 		}
+	}
+}
 
-		if _, err := d.ApiClient.UpdateDeviceMetadata(d.Cfg.DeviceID, info); err != nil {
-			if d.Logger != nil {
-				d.Logger.Error("Failed to update device metadata", "error", err)
+// updateMetadata collects system info (and ingest health, if enabled) and sends it to the API.
+func (d *Daemon) updateMetadata() {
+	info, err := sysinfo.Collect()
+	if err != nil {
+		if d.Logger != nil {
+			d.Logger.Error("Failed to collect system info", "error", err)
+		}
+		return
+	}
+
+	if d.Cfg.ReportIngestHealth {
+		d.addIngestHealth(info)
+	}
+
+	if _, err := d.ApiClient.UpdateDeviceMetadata(d.Cfg.DeviceID, info); err != nil {
+		if d.Logger != nil {
+			d.Logger.Error("Failed to update device metadata", "error", err)
+		}
+	} else {
+		if d.Logger != nil {
+			d.Logger.Info("Device metadata updated successfully")
+		}
+	}
+}
+
+// addIngestHealth merges upload error statistics since the previous report and the
+// current backlog size into the metadata map, so the backend can alert on misbehaving devices.
+func (d *Daemon) addIngestHealth(info map[string]interface{}) {
+	snap := d.IngesterSvc.Stats().Snapshot(true)
+	info["Ingest Successes"] = snap.Successes
+	info["Ingest Failures"] = snap.Failures
+	info["Ingest Error Rate"] = snap.ErrorRate
+	info["Last Ingest Error"] = snap.LastError
+	if !snap.LastErrorAt.IsZero() {
+		info["Last Ingest Error At"] = snap.LastErrorAt.UTC().Format(time.RFC3339)
+	}
+
+	backlog, err := d.DbStore.CountNotUploaded()
+	if err != nil {
+		if d.Logger != nil {
+			d.Logger.Error("Failed to count backlog", "error", err)
+		}
+		return
+	}
+	info["Pending Backlog"] = backlog
+
+	if b := d.IngesterSvc.CircuitBreaker(); b != nil {
+		info["API Circuit"] = b.State().String()
+	}
+	info["Scan In Progress"] = d.ScanInProgress()
+}
+
+// sessionRotator starts a new capture session every SessionRotateInterval
+// until done is closed.
+func (d *Daemon) sessionRotator(done <-chan struct{}) {
+	interval, err := time.ParseDuration(d.Cfg.SessionRotateInterval)
+	if err != nil || interval <= 0 {
+		if d.Logger != nil {
+			d.Logger.Error("Invalid session rotate interval, session rotation disabled", "value", d.Cfg.SessionRotateInterval, "error", err)
+		}
+		return
+	}
+
+	ticker := clock.OrReal(d.Clock).NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			id, err := d.DbStore.RotateSessionID()
+			if err != nil {
+				if d.Logger != nil {
+					d.Logger.Error("Failed to rotate session ID", "error", err)
+				}
+				continue
 			}
-		} else {
 			if d.Logger != nil {
-				d.Logger.Info("Device metadata updated successfully")
+				d.Logger.Info("Session rotated", "session_id", id)
 			}
+		case <-done:
+			return
 		}
 	}
+}
 
-	// Run immediately once
-	update()
+// missingFileBatchSize is the number of records checked per missing-file cleanup pass.
+const missingFileBatchSize = 500
+
+// missingFileCleaner periodically removes records of files that disappeared from
+// disk, so retries, orphans and errors of deleted files don't grow the DB forever.
+// It returns once done is closed.
+func (d *Daemon) missingFileCleaner(done <-chan struct{}) {
+	interval, err := time.ParseDuration(d.Cfg.MissingFileCheckInterval)
+	if err != nil || interval <= 0 {
+		if d.Logger != nil {
+			d.Logger.Error("Invalid missing file check interval, cleanup disabled", "value", d.Cfg.MissingFileCheckInterval, "error", err)
+		}
+		return
+	}
+
+	ticker := clock.OrReal(d.Clock).NewTicker(interval)
+	defer ticker.Stop()
 
 	for {
 		select {
-		case <-ticker.C:
-			update()
+		case <-ticker.C():
+			d.removeMissingFiles()
+		case <-done:
+			return
 		}
 	}
 }
 
+// removeMissingFiles runs one missing-file cleanup pass. The pass is skipped
+// while the watch root is unavailable, e.g. an unmounted disk, since every
+// file below it would look missing.
+func (d *Daemon) removeMissingFiles() {
+	if !d.watchRootAvailable() {
+		if d.Logger != nil {
+			d.Logger.Warn("Watch directory is unavailable, skipping missing file cleanup", "path", d.Cfg.WatchPath)
+		}
+		return
+	}
+	removed, err := d.DbStore.RemoveMissingFiles(missingFileBatchSize, d.missingFileGrace())
+	if err != nil {
+		if d.Logger != nil {
+			d.Logger.Error("Failed to remove records of missing files", "error", err)
+		}
+		return
+	}
+	if removed > 0 && d.Logger != nil {
+		d.Logger.Info("Removed records of files missing from disk", "count", removed)
+	}
+}
+
+// missingFileGrace returns MissingFileGracePeriod, during which records of
+// missing files are kept.
+func (d *Daemon) missingFileGrace() time.Duration {
+	grace, err := time.ParseDuration(d.Cfg.MissingFileGracePeriod)
+	if err != nil {
+		if d.Logger != nil {
+			d.Logger.Error("Invalid missing file grace period, defaulting to 24h", "value", d.Cfg.MissingFileGracePeriod, "error", err)
+		}
+		grace = 24 * time.Hour
+	}
+	return grace
+}
+
+// watchRootAvailable reports whether the watch root exists and is a directory,
+// and the watcher is not waiting for it to reappear.
+func (d *Daemon) watchRootAvailable() bool {
+	if d.WatcherSvc != nil && d.WatcherSvc.Recovering() {
+		return false
+	}
+	info, err := os.Stat(d.Cfg.WatchPath)
+	return err == nil && info.IsDir()
+}
+
+// newWatcher creates the watcher selected by WatcherMode. In "auto" mode a
+// tree with more directories than the inotify watch limit is polled instead.
+func (d *Daemon) newWatcher(debounce time.Duration) (*watcher.Watcher, error) {
+	var opts []watcher.Option
+	if d.Cfg.FollowSymlinks {
+		opts = append(opts, watcher.FollowSymlinks())
+	}
+	switch d.Cfg.WatcherMode {
+	case "poll":
+		return watcher.NewPollingWatcher(d.Cfg.WatchPath, debounce, d.pollInterval(), d.processFile, d.Logger, opts...)
+	case "auto":
+		w, err := watcher.NewWatcher(d.Cfg.WatchPath, debounce, d.processFile, d.Logger, opts...)
+		if !watcher.IsWatchLimit(err) {
+			return w, err
+		}
+		if d.Logger != nil {
+			d.Logger.Warn("Inotify watch limit reached, polling the watch directory instead", "path", d.Cfg.WatchPath,
+				"sysctl", "fs.inotify.max_user_watches", "poll_interval", d.pollInterval(), "error", err)
+		}
+		return watcher.NewPollingWatcher(d.Cfg.WatchPath, debounce, d.pollInterval(), d.processFile, d.Logger, opts...)
+	default:
+		w, err := watcher.NewWatcher(d.Cfg.WatchPath, debounce, d.processFile, d.Logger, opts...)
+		if watcher.IsWatchLimit(err) {
+			err = fmt.Errorf("%w (or set watcher_mode to \"auto\" or \"poll\")", err)
+		}
+		return w, err
+	}
+}
+
+// pollInterval returns PollInterval, the time between two walks of the
+// polling watcher.
+func (d *Daemon) pollInterval() time.Duration {
+	if d.Cfg.PollInterval == "" {
+		return 5 * time.Second
+	}
+	interval, err := time.ParseDuration(d.Cfg.PollInterval)
+	if err != nil || interval <= 0 {
+		if d.Logger != nil {
+			d.Logger.Error("Invalid poll interval, defaulting to 5s", "value", d.Cfg.PollInterval, "error", err)
+		}
+		interval = 5 * time.Second
+	}
+	return interval
+}
+
+// stabilityInterval returns StabilityInterval, the wait between the two size
+// checks of RequireStableSize.
+func (d *Daemon) stabilityInterval() time.Duration {
+	if d.Cfg.StabilityInterval == "" {
+		return time.Second
+	}
+	interval, err := time.ParseDuration(d.Cfg.StabilityInterval)
+	if err != nil || interval <= 0 {
+		if d.Logger != nil {
+			d.Logger.Error("Invalid stability interval, defaulting to 1s", "value", d.Cfg.StabilityInterval, "error", err)
+		}
+		interval = time.Second
+	}
+	return interval
+}
+
 // orphanChecker runs periodically to mark timed-out files as ORPHAN.
 func (d *Daemon) orphanChecker() {
 	orphanInterval, err := time.ParseDuration(d.Cfg.OrphanCheckInterval)
@@ -161,7 +589,7 @@ func (d *Daemon) orphanChecker() {
 		orphanInterval = 5 * time.Minute
 	}
 
-	ticker := time.NewTicker(orphanInterval)
+	ticker := clock.OrReal(d.Clock).NewTicker(orphanInterval)
 	defer ticker.Stop()
 
 	// Use a timeout slightly less than the check interval to avoid race conditions.
@@ -173,12 +601,8 @@ func (d *Daemon) orphanChecker() {
 
 	for {
 		select {
-		case <-ticker.C:
-			if err := d.DbStore.MarkOrphans(timeout); err != nil {
-				if d.Logger != nil {
-					d.Logger.Error("Failed to mark orphans", "error", err)
-				}
-			}
+		case <-ticker.C():
+			d.handleOrphans(timeout)
 			// We rely on service stop to kill this goroutine implicitly when the process exits,
 			// or we could add a stop channel if strictly needed.
 			// For simplicity in this daemon structure, we assume process termination.
@@ -186,8 +610,87 @@ func (d *Daemon) orphanChecker() {
 	}
 }
 
+// orphanSidecarError is recorded as the last error of sidecars flagged by the
+// "flag" OrphanSidecarPolicy.
+const orphanSidecarError = "orphan sidecar: its data file never arrived"
+
+// handleOrphans applies the orphan timeout to files still waiting for a partner.
+// Data files always become ORPHAN (uploaded alone). Sidecars follow OrphanSidecarPolicy:
+// "upload" treats them like data, "drop" deletes them (useless without data),
+// "hold" keeps them AWAITING_PARTNER indefinitely and "flag" holds them too but
+// records an error, so they show up with the failed files (`fsd list --failed`).
+func (d *Daemon) handleOrphans(timeout time.Duration) {
+	policy := d.Cfg.OrphanSidecarPolicy
+
+	if policy == "flag" {
+		sidecars, err := d.DbStore.GetStaleSidecars(timeout)
+		if err != nil {
+			if d.Logger != nil {
+				d.Logger.Error("Failed to get stale sidecars", "error", err)
+			}
+		}
+		for _, f := range sidecars {
+			if f.LastError.Valid {
+				continue // Flagged by an earlier check
+			}
+			if err := d.DbStore.RecordError(f.Path, orphanSidecarError); err != nil {
+				if d.Logger != nil {
+					d.Logger.Error("Failed to flag orphan sidecar", "path", f.Path, "error", err)
+				}
+				continue
+			}
+			if d.Logger != nil {
+				d.Logger.Warn("Sidecar has no data file, holding it", "event", "OrphanSidecar", "path", f.Path)
+			}
+		}
+	}
+
+	if policy == "drop" {
+		sidecars, err := d.DbStore.GetStaleSidecars(timeout)
+		if err != nil {
+			if d.Logger != nil {
+				d.Logger.Error("Failed to get stale sidecars", "error", err)
+			}
+		}
+		for _, f := range sidecars {
+			if err := os.Remove(f.Path); err != nil && !os.IsNotExist(err) {
+				if d.Logger != nil {
+					d.Logger.Error("Failed to remove orphan sidecar", "path", f.Path, "error", err)
+				}
+				continue
+			}
+			if err := d.DbStore.RemoveFile(f.Path); err != nil {
+				if d.Logger != nil {
+					d.Logger.Error("Failed to remove orphan sidecar record", "path", f.Path, "error", err)
+				}
+				continue
+			}
+			if d.Logger != nil {
+				d.Logger.Info("Dropped orphan sidecar", "path", f.Path)
+			}
+		}
+	}
+
+	excludeSidecars := policy == "drop" || policy == "hold" || policy == "flag"
+	if err := d.DbStore.MarkOrphans(timeout, excludeSidecars); err != nil {
+		if d.Logger != nil {
+			d.Logger.Error("Failed to mark orphans", "error", err)
+		}
+	}
+}
+
 // processFile handles a detected file by adding it to the store.
 func (d *Daemon) processFile(path string) {
+	if d.Cfg.FollowSymlinks {
+		path = resolveFileLink(path)
+	}
+	if d.excluded(path) {
+		if d.Logger != nil {
+			d.Logger.Debug("Skipping excluded file", "path", path)
+		}
+		return
+	}
+
 	info, err := os.Stat(path)
 	if err != nil {
 		if d.Logger != nil {
@@ -195,13 +698,13 @@ func (d *Daemon) processFile(path string) {
 		}
 		return
 	}
-	if info.IsDir() {
+	if info.IsDir() || d.skipUnchanged(path, info) {
 		return
 	}
 
-	// Check allowed extensions
+	// Check allowed extensions (an empty list allows every extension)
 	ext := strings.ToLower(filepath.Ext(path))
-	allowed := false
+	allowed := len(d.Cfg.AllowedExtensions) == 0
 	for _, e := range d.Cfg.AllowedExtensions {
 		if strings.EqualFold(ext, e) {
 			allowed = true
@@ -220,6 +723,32 @@ func (d *Daemon) processFile(path string) {
 	// Check extension to determine if it is metadata
 	isMeta := ext == ".json"
 
+	// Optionally verify the actual content, since extensions can lie.
+	// JSON sidecars are not sniffed (they detect as text/plain).
+	if len(d.Cfg.AllowedContentTypes) > 0 && !isMeta {
+		contentType, err := util.DetectContentType(path)
+		if err != nil {
+			if d.Logger != nil {
+				d.Logger.Error("Failed to detect content type", "path", path, "error", err)
+			}
+			return
+		}
+		if !util.ContentTypeAllowed(contentType, d.Cfg.AllowedContentTypes) {
+			if d.Logger != nil {
+				d.Logger.Warn("Skipping file with disallowed content type", "path", path, "content_type", contentType)
+			}
+			return
+		}
+	}
+
+	if d.shouldDefer() {
+		if d.Logger != nil {
+			d.Logger.Debug("Deferring file registration due to backpressure", "path", path)
+		}
+		d.deferred.Store(true)
+		return
+	}
+
 	expectSidecar := true
 	if d.Cfg.SidecarStrategy == "none" {
 		expectSidecar = false
@@ -236,22 +765,251 @@ func (d *Daemon) processFile(path string) {
 	}
 }
 
+// resolveFileLink returns the resolved path of a symlink to a file, so a file
+// is tracked once however many links point to it. Other paths, including
+// files reached through symlinked directories, are returned unchanged.
+func resolveFileLink(path string) string {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return path
+	}
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return path
+	}
+	return target
+}
+
+// excluded reports whether path is one of the daemon's own files or matches one
+// of the configured ExcludePatterns or the patterns in IgnoreFile.
+func (d *Daemon) excluded(path string) bool {
+	if d.ownFile(path) {
+		return true
+	}
+	d.excludeOnce.Do(func() { d.setExcludePatterns(d.Cfg.ExcludePatterns) })
+	return d.exclude.Load().Match(d.Cfg.WatchPath, path) || d.ignore.Load().Match(d.Cfg.WatchPath, path)
+}
+
+// ownFile reports whether path is part of the daemon's own state: the database
+// with its WAL, shared-memory, journal and lock files, the IgnoreFile, or the
+// log file and its rotated backups. They end up inside WatchPath when everything defaults to the
+// install directory, and must never be uploaded.
+func (d *Daemon) ownFile(path string) bool {
+	d.ownOnce.Do(func() {
+		d.ownPaths = make(map[string]bool)
+		if d.Cfg.DBPath != "" {
+			if db, err := filepath.Abs(d.Cfg.DBPath); err == nil {
+				for _, suffix := range []string{"", "-wal", "-shm", "-journal", ".lock"} {
+					d.ownPaths[db+suffix] = true
+				}
+			}
+		}
+		if d.Cfg.IgnoreFile != "" {
+			if ignoreFile, err := filepath.Abs(d.Cfg.IgnoreFile); err == nil {
+				d.ownPaths[ignoreFile] = true
+			}
+		}
+		logPath := d.LogPath
+		if logPath == "" {
+			logPath = d.Cfg.LogPath
+		}
+		if logPath != "" {
+			d.ownLog, _ = filepath.Abs(logPath)
+		}
+	})
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	return d.ownPaths[abs] || (d.ownLog != "" && logger.IsLogFile(d.ownLog, abs))
+}
+
+// setExcludePatterns replaces the patterns checked by excluded.
+func (d *Daemon) setExcludePatterns(patterns []string) {
+	parsed, err := util.ParseExcludePatterns(patterns)
+	if err != nil {
+		// Patterns are validated by config.Load; a failure here means Cfg was built in code.
+		if d.Logger != nil {
+			d.Logger.Error("Invalid exclude patterns, ignoring them", "error", err)
+		}
+		return
+	}
+	d.exclude.Store(parsed)
+}
+
+// shouldDefer reports whether new files should not be registered right now,
+// either because the pruner cannot free space or MaxPendingFiles is reached.
+func (d *Daemon) shouldDefer() bool {
+	if d.PrunerSvc != nil && d.PrunerSvc.Backpressured() {
+		return true
+	}
+	if d.Cfg.MaxPendingFiles > 0 {
+		count, err := d.DbStore.CountNotUploaded()
+		if err != nil {
+			if d.Logger != nil {
+				d.Logger.Error("db error", "error", err)
+			}
+			return false
+		}
+		return count >= d.Cfg.MaxPendingFiles
+	}
+	return false
+}
+
+// resumeDeferred is called by the pruner once disk usage has recovered.
+// If any files were deferred in the meantime, it rescans the watch directory
+// to register them.
+func (d *Daemon) resumeDeferred() {
+	if !d.deferred.Swap(false) {
+		return
+	}
+	d.exclusiveWalk("full", func() {
+		if d.Logger != nil {
+			d.Logger.Info("Space recovered, rescanning for deferred files", "path", d.Cfg.WatchPath)
+		}
+		if err := d.rescan(time.Time{}); err != nil && d.Logger != nil {
+			d.Logger.Error("Rescan failed", "error", err)
+		}
+	})
+}
+
+// incrementalRescan re-examines files modified after the stored scan watermark.
+// It is used after suspected event loss (e.g. an fsnotify queue overflow) so the
+// whole tree does not need to be re-registered.
+func (d *Daemon) incrementalRescan() {
+	d.exclusiveWalk("incremental", d.walkSinceWatermark)
+}
+
+// walkSinceWatermark rescans from the stored scan watermark. The watermark is
+// read inside the walk so a coalesced request still sees the latest value.
+func (d *Daemon) walkSinceWatermark() {
+	since, err := d.DbStore.GetScanWatermark()
+	if err != nil {
+		if d.Logger != nil {
+			d.Logger.Error("Failed to read scan watermark, rescanning everything", "error", err)
+		}
+		since = time.Time{}
+	}
+	if d.Logger != nil {
+		d.Logger.Info("Incremental rescan", "path", d.Cfg.WatchPath, "since", since)
+	}
+	if err := d.rescan(since); err != nil && d.Logger != nil {
+		d.Logger.Error("Rescan failed", "error", err)
+	}
+}
+
+// rootRestored rescans the watch directory after it was deleted and recreated
+// (e.g. remounted). Files still tracked unchanged are skipped as on startup.
+func (d *Daemon) rootRestored() {
+	d.exclusiveWalk("restore", d.walkRestoredRoot)
+}
+
+// walkRestoredRoot registers every changed file under the watch directory.
+func (d *Daemon) walkRestoredRoot() {
+	if idx, err := newScanIndex(d.DbStore, d.Cfg.ScanFingerprintMode); err != nil {
+		if d.Logger != nil {
+			d.Logger.Error("Failed to load file fingerprints, rescan will re-register all files", "error", err)
+		}
+	} else {
+		d.scanIdx.Store(idx)
+		defer d.scanIdx.Store(nil)
+	}
+
+	err := util.Walk(d.Cfg.WatchPath, d.Cfg.FollowSymlinks, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			d.processFile(path)
+		}
+		return nil
+	})
+	if err != nil && d.Logger != nil {
+		d.Logger.Error("Rescan of restored watch directory failed", "error", err)
+	}
+}
+
+// rescan walks the watch directory and registers the new and changed files modified
+// after since (the zero time means all files); files tracked unchanged are skipped as
+// on startup. On a complete scan with nothing deferred, the scan watermark is advanced
+// to the newest mod_time seen.
+func (d *Daemon) rescan(since time.Time) error {
+	if idx, err := newScanIndex(d.DbStore, d.Cfg.ScanFingerprintMode); err != nil {
+		if d.Logger != nil {
+			d.Logger.Error("Failed to load file fingerprints, rescan will re-register all files", "error", err)
+		}
+	} else {
+		d.scanIdx.Store(idx)
+		defer d.scanIdx.Store(nil)
+	}
+
+	watermark := since
+	err := util.Walk(d.Cfg.WatchPath, d.Cfg.FollowSymlinks, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !info.ModTime().After(since) {
+			return nil
+		}
+		if info.ModTime().After(watermark) {
+			watermark = info.ModTime()
+		}
+		d.processFile(path)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Files deferred by backpressure were not registered; keep the old watermark
+	// so the next rescan still covers them.
+	if d.deferred.Load() || !watermark.After(since) {
+		return nil
+	}
+	return d.DbStore.SetScanWatermark(watermark)
+}
+
+// abortStart stops whatever a failed Start already set up (workers, listeners,
+// the store and the instance lock), so the caller need not call Stop.
+func (d *Daemon) abortStart() {
+	d.Stop(nil)
+	d.WatcherSvc, d.IngesterSvc, d.PrunerSvc, d.DbStore = nil, nil, nil, nil
+}
+
 // Stop is called when the service is being stopped.
 func (d *Daemon) Stop(s service.Service) error {
 	if d.Logger != nil {
 		d.Logger.Info("Stopping FS Ingest Daemon...")
 	}
+	if d.stopReload != nil {
+		d.stopReload()
+		d.stopReload = nil
+	}
+	if d.done != nil {
+		close(d.done)
+		d.done = nil
+	}
+	if d.metricsSrv != nil {
+		d.metricsSrv.Close()
+		d.metricsSrv = nil
+	}
 	if d.WatcherSvc != nil {
 		d.WatcherSvc.Close()
 	}
 	if d.IngesterSvc != nil {
 		d.IngesterSvc.Stop()
 	}
+	d.shutdownTracing()
 	if d.PrunerSvc != nil {
 		d.PrunerSvc.Stop()
 	}
 	if d.DbStore != nil {
 		d.DbStore.Close()
 	}
+	if d.lockFile != nil {
+		releaseInstanceLock(d.lockFile)
+		d.lockFile = nil
+	}
 	return nil
 }
@@ -1,13 +1,24 @@
 package daemon
 
 import (
+	"encoding/json"
+	"errors"
+	"io"
 	"log/slog"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"fs-ingest-daemon/internal/api"
+	"fs-ingest-daemon/internal/clock"
 	"fs-ingest-daemon/internal/config"
+	"fs-ingest-daemon/internal/ingest"
+	"fs-ingest-daemon/internal/pruner"
 	"fs-ingest-daemon/internal/store"
 )
 
@@ -167,3 +178,1152 @@ func TestDaemonNoSidecarStrategy(t *testing.T) {
 		}
 	}
 }
+
+func TestHandleOrphans_SidecarPolicies(t *testing.T) {
+	tests := []struct {
+		policy       string
+		expectRecord bool
+		expectStatus store.FileStatus
+		expectOnDisk bool
+		expectError  bool // The sidecar is reported as failed
+	}{
+		{policy: "upload", expectRecord: true, expectStatus: store.StatusOrphan, expectOnDisk: true},
+		{policy: "drop", expectRecord: false, expectOnDisk: false},
+		{policy: "hold", expectRecord: true, expectStatus: store.StatusAwaitingPartner, expectOnDisk: true},
+		{policy: "flag", expectRecord: true, expectStatus: store.StatusAwaitingPartner, expectOnDisk: true, expectError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.policy, func(t *testing.T) {
+			tmpDir, err := os.MkdirTemp("", "daemon_orphan_test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tmpDir)
+
+			s, err := store.NewStore(filepath.Join(tmpDir, "fsd.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			// A sidecar whose data file never arrives.
+			sidecar := filepath.Join(tmpDir, "lonely.png.json")
+			if err := os.WriteFile(sidecar, []byte("{}"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			clk := clock.NewFake(time.Now())
+			s.SetClock(clk)
+			if err := s.RegisterFile(sidecar, 2, time.Now().Add(-2*time.Hour), true, true); err != nil {
+				t.Fatal(err)
+			}
+
+			d := &Daemon{
+				Logger:  slog.New(slog.NewTextHandler(os.Stdout, nil)),
+				Cfg:     &config.Config{OrphanSidecarPolicy: tc.policy},
+				DbStore: s,
+			}
+
+			// Its old mod time does not count: it only just arrived.
+			d.handleOrphans(time.Minute)
+			files, err := s.ListFiles("", 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != 1 || files[0].Status != store.StatusAwaitingPartner || files[0].LastError.Valid {
+				t.Fatalf("Expected a just detected sidecar to keep waiting, got %+v", files)
+			}
+
+			clk.Advance(2 * time.Minute)
+			d.handleOrphans(time.Minute)
+
+			files, err = s.ListFiles("", 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.expectRecord {
+				if len(files) != 1 {
+					t.Fatalf("Expected sidecar record to remain, got %d records", len(files))
+				}
+				if files[0].Status != tc.expectStatus {
+					t.Errorf("Expected status %s, got %s", tc.expectStatus, files[0].Status)
+				}
+				if files[0].LastError.Valid != tc.expectError {
+					t.Errorf("Expected an error recorded=%v, got %q", tc.expectError, files[0].LastError.String)
+				}
+			} else if len(files) != 0 {
+				t.Errorf("Expected sidecar record to be dropped, got %+v", files)
+			}
+
+			_, statErr := os.Stat(sidecar)
+			if onDisk := statErr == nil; onDisk != tc.expectOnDisk {
+				t.Errorf("Expected on-disk=%v, got %v", tc.expectOnDisk, onDisk)
+			}
+		})
+	}
+}
+
+func TestDeferredFilesResumeAfterSpaceRecovery(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "daemon_backpressure_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	watchDir := filepath.Join(tmpDir, "data")
+	if err := os.MkdirAll(watchDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := store.NewStore(filepath.Join(tmpDir, "fsd.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	cfg := &config.Config{
+		WatchPath:         watchDir,
+		MaxDataSizeGB:     float64(100) / (1024 * 1024 * 1024), // 100 bytes
+		PruneBatchSize:    10,
+		SidecarStrategy:   "none",
+		AllowedExtensions: []string{".png"},
+	}
+	d := &Daemon{
+		Logger:    logger,
+		Cfg:       cfg,
+		DbStore:   s,
+		PrunerSvc: pruner.NewPruner(cfg, s, logger),
+	}
+	d.PrunerSvc.OnSpaceRecovered = d.resumeDeferred
+
+	// 1. Fill up with a file that is not uploaded yet -> backpressure.
+	big := filepath.Join(watchDir, "big.png")
+	if err := os.WriteFile(big, make([]byte, 200), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d.processFile(big)
+	d.PrunerSvc.Prune()
+	if !d.PrunerSvc.Backpressured() {
+		t.Fatal("Expected pruner to be in backpressure")
+	}
+
+	// 2. A new file arrives while backpressured -> deferred.
+	late := filepath.Join(watchDir, "late.png")
+	if err := os.WriteFile(late, []byte("late"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d.processFile(late)
+	if tracked, _ := s.HasFile(late); tracked {
+		t.Fatal("Expected late file to be deferred while backpressured")
+	}
+
+	// 3. The big file gets uploaded and pruned -> space recovers -> rescan.
+	if err := s.MarkUploaded(big); err != nil {
+		t.Fatal(err)
+	}
+	d.PrunerSvc.Prune()
+
+	if d.PrunerSvc.Backpressured() {
+		t.Error("Expected backpressure to be cleared")
+	}
+	if tracked, _ := s.HasFile(late); !tracked {
+		t.Error("Expected deferred file to be registered after space recovered")
+	}
+}
+
+// newMockIngestAPI returns a server implementing the ingest/upload/confirm handshake.
+func newMockIngestAPI(t *testing.T) *httptest.Server {
+	t.Helper()
+	var srv *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/ingest/request", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(api.IngestResponse{
+			HandshakeID: "hs-1",
+			UploadURL:   srv.URL + "/upload",
+			ExpiresAt:   time.Now().Add(time.Hour),
+		})
+	})
+	mux.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/v1/ingest/confirm", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	srv = httptest.NewServer(mux)
+	return srv
+}
+
+func TestRunOnceDrainsBacklog(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "daemon_once_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	watchDir := filepath.Join(tmpDir, "data")
+	if err := os.MkdirAll(watchDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Seed a backlog created while no daemon was running.
+	for _, name := range []string{"a.png", "b.png", "c.png"} {
+		if err := os.WriteFile(filepath.Join(watchDir, name), []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	srv := newMockIngestAPI(t)
+	defer srv.Close()
+
+	dbPath := filepath.Join(tmpDir, "fsd.db")
+	cfg := &config.Config{
+		DeviceID:            "test-dev",
+		Endpoint:            srv.URL,
+		WatchPath:           watchDir,
+		DBPath:              dbPath,
+		MaxDataSizeGB:       1.0,
+		IngestCheckInterval: "20ms",
+		IngestBatchSize:     10,
+		IngestWorkerCount:   2,
+		SidecarStrategy:     "none",
+		AllowedExtensions:   []string{".png"},
+	}
+	d := &Daemon{
+		Logger: slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		Cfg:    cfg,
+	}
+
+	start := time.Now()
+	if err := d.RunOnce(10 * time.Second); err != nil {
+		t.Fatalf("RunOnce failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected RunOnce to exit once drained, took %s", elapsed)
+	}
+
+	s, err := store.NewStore(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	uploaded, err := s.ListFiles(store.StatusUploaded, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uploaded) != 3 {
+		t.Errorf("Expected 3 uploaded files, got %d", len(uploaded))
+	}
+}
+
+func TestRunOnceWaitsForUploadDelay(t *testing.T) {
+	tmpDir := t.TempDir()
+	watchDir := filepath.Join(tmpDir, "data")
+	if err := os.MkdirAll(watchDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(watchDir, "a.png"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := newMockIngestAPI(t)
+	defer srv.Close()
+
+	dbPath := filepath.Join(tmpDir, "fsd.db")
+	d := &Daemon{
+		Logger: slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		Cfg: &config.Config{
+			DeviceID:            "test-dev",
+			Endpoint:            srv.URL,
+			WatchPath:           watchDir,
+			DBPath:              dbPath,
+			MaxDataSizeGB:       1.0,
+			IngestCheckInterval: "20ms",
+			IngestBatchSize:     10,
+			IngestWorkerCount:   1,
+			SidecarStrategy:     "none",
+			AllowedExtensions:   []string{".png"},
+			UploadDelay:         "500ms",
+		},
+	}
+
+	// The new file is held back by upload_delay, but it is not done yet.
+	if err := d.RunOnce(10 * time.Second); err != nil {
+		t.Fatalf("RunOnce failed: %v", err)
+	}
+
+	s, err := store.NewStore(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if uploaded, _ := s.ListFiles(store.StatusUploaded, 10); len(uploaded) != 1 {
+		t.Errorf("Expected RunOnce to wait for the delayed file to upload, got %d uploaded", len(uploaded))
+	}
+}
+
+func TestIncrementalRescanUsesWatermark(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "daemon_rescan_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	watchDir := filepath.Join(tmpDir, "data")
+	if err := os.MkdirAll(watchDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := store.NewStore(filepath.Join(tmpDir, "fsd.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	watermark := time.Now().Add(-1 * time.Hour)
+	if err := s.SetScanWatermark(watermark); err != nil {
+		t.Fatal(err)
+	}
+
+	// An untracked file older than the watermark is assumed covered by an earlier scan.
+	oldFile := filepath.Join(watchDir, "old.png")
+	if err := os.WriteFile(oldFile, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	oldTime := watermark.Add(-1 * time.Hour)
+	if err := os.Chtimes(oldFile, oldTime, oldTime); err != nil {
+		t.Fatal(err)
+	}
+
+	// A file newer than the watermark was missed (e.g. dropped events).
+	newFile := filepath.Join(watchDir, "new.png")
+	if err := os.WriteFile(newFile, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Daemon{
+		Logger: slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		Cfg: &config.Config{
+			WatchPath:         watchDir,
+			SidecarStrategy:   "none",
+			AllowedExtensions: []string{".png"},
+		},
+		DbStore: s,
+	}
+	d.incrementalRescan()
+
+	if tracked, _ := s.HasFile(newFile); !tracked {
+		t.Error("Expected file newer than the watermark to be registered")
+	}
+	if tracked, _ := s.HasFile(oldFile); tracked {
+		t.Error("Expected file older than the watermark to be skipped")
+	}
+
+	info, err := os.Stat(newFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.GetScanWatermark()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(info.ModTime()) {
+		t.Errorf("Expected watermark to advance to %v, got %v", info.ModTime(), got)
+	}
+}
+
+func TestIncrementalRescanReregistersModifiedFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	watchDir := filepath.Join(tmpDir, "data")
+	if err := os.MkdirAll(watchDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := store.NewStore(filepath.Join(tmpDir, "fsd.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	watermark := time.Now().Add(-1 * time.Hour)
+	if err := s.SetScanWatermark(watermark); err != nil {
+		t.Fatal(err)
+	}
+
+	// Tracked before the watermark, then rewritten while events were lost.
+	img := filepath.Join(watchDir, "img.png")
+	if err := s.RegisterFile(img, 3, watermark.Add(-1*time.Hour), false, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(img, []byte("rewritten"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Daemon{
+		Logger: slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		Cfg: &config.Config{
+			WatchPath:         watchDir,
+			SidecarStrategy:   "none",
+			AllowedExtensions: []string{".png"},
+		},
+		DbStore: s,
+	}
+	d.incrementalRescan()
+
+	info, err := os.Stat(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fingerprints, err := s.Fingerprints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fp := fingerprints[img]; fp.Size != info.Size() || !fp.ModTime.Equal(info.ModTime()) {
+		t.Errorf("Expected the rewritten file to be re-registered with size %d, got %+v", info.Size(), fp)
+	}
+}
+
+func TestUpdateMetadataIncludesIngestHealth(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "daemon_metadata_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s, err := store.NewStore(filepath.Join(tmpDir, "fsd.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// Two files still waiting for upload.
+	for _, name := range []string{"a.png", "b.png"} {
+		if err := s.RegisterFile(filepath.Join(tmpDir, name), 1, time.Now(), false, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	payloads := make(chan map[string]interface{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		payloads <- payload
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(api.DeviceRead{DeviceID: "test-dev"})
+	}))
+	defer srv.Close()
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	cfg := &config.Config{
+		DeviceID:           "test-dev",
+		Endpoint:           srv.URL,
+		ReportIngestHealth: true,
+	}
+	d := &Daemon{
+		Logger:      logger,
+		Cfg:         cfg,
+		DbStore:     s,
+		ApiClient:   api.NewClient(srv.URL, "5s"),
+		IngesterSvc: ingest.NewIngester(cfg, s, logger),
+	}
+
+	stats := d.IngesterSvc.Stats()
+	stats.RecordSuccess()
+	stats.RecordFailure(errors.New("ingest request failed with status 500"))
+
+	d.updateMetadata()
+
+	var payload map[string]interface{}
+	select {
+	case payload = <-payloads:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for metadata update")
+	}
+
+	if rate, _ := payload["Ingest Error Rate"].(float64); rate != 0.5 {
+		t.Errorf("Expected error rate 0.5, got %v", payload["Ingest Error Rate"])
+	}
+	if msg, _ := payload["Last Ingest Error"].(string); msg != "ingest request failed with status 500" {
+		t.Errorf("Unexpected last error: %v", payload["Last Ingest Error"])
+	}
+	if backlog, _ := payload["Pending Backlog"].(float64); backlog != 2 {
+		t.Errorf("Expected backlog 2, got %v", payload["Pending Backlog"])
+	}
+	if _, ok := payload["Go Version"]; !ok {
+		t.Error("Expected system info to still be present")
+	}
+}
+
+func TestProcessFileRejectsMismatchedContentType(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "daemon_content_type_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s, err := store.NewStore(filepath.Join(tmpDir, "fsd.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// A text file named like an image, and a realPNG PNG.
+	fakePNG := filepath.Join(tmpDir, "fakePNG.png")
+	if err := os.WriteFile(fakePNG, []byte("just some text, not an image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	realPNG := filepath.Join(tmpDir, "realPNG.png")
+	if err := os.WriteFile(realPNG, []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Daemon{
+		Logger: slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		Cfg: &config.Config{
+			WatchPath:           tmpDir,
+			SidecarStrategy:     "none",
+			AllowedExtensions:   []string{".png"},
+			AllowedContentTypes: []string{"image/png"},
+		},
+		DbStore: s,
+	}
+	d.processFile(fakePNG)
+	d.processFile(realPNG)
+
+	if tracked, err := s.HasFile(fakePNG); err != nil || tracked {
+		t.Errorf("Expected text file with .png extension to be rejected (tracked=%v, err=%v)", tracked, err)
+	}
+	if tracked, err := s.HasFile(realPNG); err != nil || !tracked {
+		t.Errorf("Expected realPNG PNG to be registered (tracked=%v, err=%v)", tracked, err)
+	}
+}
+
+func TestOrphanCheckerUsesClock(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "daemon_orphan_clock_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s, err := store.NewStore(filepath.Join(tmpDir, "fsd.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	s.SetClock(clk)
+
+	// A data file waiting for a sidecar, detected "now".
+	path := filepath.Join(tmpDir, "img.png")
+	if err := s.RegisterFile(path, 4, clk.Now(), false, true); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Daemon{
+		Logger:  slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		Cfg:     &config.Config{OrphanCheckInterval: "5m"},
+		DbStore: s,
+		Clock:   clk,
+	}
+	go d.orphanChecker()
+	clk.BlockUntil(1)
+
+	status := func() store.FileStatus {
+		files, err := s.ListFiles("", 10)
+		if err != nil || len(files) != 1 {
+			t.Fatalf("Expected 1 record, got %d (err=%v)", len(files), err)
+		}
+		return files[0].Status
+	}
+
+	// Nothing happens until the check interval elapses on the clock.
+	time.Sleep(20 * time.Millisecond)
+	if got := status(); got != store.StatusAwaitingPartner {
+		t.Fatalf("Expected AWAITING_PARTNER before the first check, got %s", got)
+	}
+
+	// After 5m the file is older than the 4m orphan timeout.
+	clk.Advance(5 * time.Minute)
+	deadline := time.Now().Add(5 * time.Second)
+	for status() != store.StatusOrphan {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the file to be marked ORPHAN after the check interval")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSecondInstanceFailsFast(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "daemon_lock_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	newDaemon := func() *Daemon {
+		return &Daemon{
+			Logger: slog.New(slog.NewTextHandler(os.Stdout, nil)),
+			Cfg: &config.Config{
+				DeviceID:            "test-dev",
+				Endpoint:            "http://localhost:8080",
+				WatchPath:           filepath.Join(tmpDir, "data"),
+				DBPath:              filepath.Join(tmpDir, "fsd.db"),
+				MaxDataSizeGB:       1.0,
+				IngestCheckInterval: "100ms",
+				SingleInstance:      true,
+			},
+		}
+	}
+
+	dbPath := filepath.Join(tmpDir, "fsd.db")
+	if _, running := InstanceRunning(dbPath); running {
+		t.Error("Expected no running instance before the first start")
+	}
+
+	first := newDaemon()
+	if err := first.Start(nil); err != nil {
+		t.Fatalf("Failed to start first daemon: %v", err)
+	}
+	if pid, running := InstanceRunning(dbPath); !running || pid != strconv.Itoa(os.Getpid()) {
+		t.Errorf("Expected the first daemon to be reported running with pid %d, got %q (running=%v)", os.Getpid(), pid, running)
+	}
+
+	second := newDaemon()
+	start := time.Now()
+	err = second.Start(nil)
+	if err == nil {
+		second.Stop(nil)
+		first.Stop(nil)
+		t.Fatal("Expected the second daemon on the same DB to fail")
+	}
+	if !strings.Contains(err.Error(), "another instance") {
+		t.Errorf("Expected an 'another instance' error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the second daemon to fail fast, took %v", elapsed)
+	}
+	if second.DbStore != nil {
+		t.Error("Expected the second daemon not to open the DB")
+	}
+
+	// Once the first instance stops, the lock is free again.
+	first.Stop(nil)
+	if _, running := InstanceRunning(dbPath); running {
+		t.Error("Expected no running instance after the first stopped")
+	}
+	third := newDaemon()
+	if err := third.Start(nil); err != nil {
+		t.Fatalf("Expected a new daemon to start after the first stopped: %v", err)
+	}
+	third.Stop(nil)
+}
+
+func TestProcessFileSkipsExcludedFiles(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "daemon_exclude_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s, err := store.NewStore(filepath.Join(tmpDir, "fsd.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	watchDir := filepath.Join(tmpDir, "watch")
+	paths := map[string]bool{ // relative path -> expected to be registered
+		"img.png":               true,
+		"img.png.tmp":           false,
+		".hidden.png":           false,
+		"cam1/.DS_Store.png":    false,
+		"cam1/img.png":          true,
+		"cam1/cache/img.png":    false,
+		"cam2/a/b/cache/x.json": false,
+	}
+	for rel := range paths {
+		path := filepath.Join(watchDir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d := &Daemon{
+		Logger: slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		Cfg: &config.Config{
+			WatchPath:         watchDir,
+			SidecarStrategy:   "none",
+			AllowedExtensions: []string{".png", ".tmp", ".json"},
+			ExcludePatterns:   []string{"*.tmp", ".*", "**/cache/**"},
+		},
+		DbStore: s,
+	}
+	for rel := range paths {
+		d.processFile(filepath.Join(watchDir, filepath.FromSlash(rel)))
+	}
+
+	for rel, want := range paths {
+		tracked, err := s.HasFile(filepath.Join(watchDir, filepath.FromSlash(rel)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tracked != want {
+			t.Errorf("%s: tracked=%v, want %v", rel, tracked, want)
+		}
+	}
+}
+
+func TestProcessFileSkipsOwnDatabaseAndLogs(t *testing.T) {
+	watchDir, err := os.MkdirTemp("", "daemon_self_exclude_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(watchDir)
+
+	// Everything lives in the watch directory, as with the install dir defaults.
+	dbPath := filepath.Join(watchDir, "fsd.db")
+	s, err := store.NewStore(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	own := []string{
+		dbPath,
+		dbPath + "-wal",
+		dbPath + "-shm",
+		dbPath + ".lock",
+		filepath.Join(watchDir, "fsd.log"),
+		filepath.Join(watchDir, "fsd-2024-05-01T10-00-00.000.log"),
+		filepath.Join(watchDir, "fsd-2024-05-01T10-00-00.000.log.gz"),
+	}
+	data := filepath.Join(watchDir, "fsd-notes.log")
+	for _, path := range append(own, data) {
+		if _, err := os.Stat(path); err == nil {
+			continue // created by the store
+		}
+		if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Relative config paths resolve to the same files.
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(watchDir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	d := &Daemon{
+		Logger: slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		Cfg: &config.Config{
+			WatchPath:       watchDir,
+			DBPath:          "fsd.db",
+			LogPath:         "./fsd.log",
+			SidecarStrategy: "none",
+		},
+		DbStore: s,
+	}
+	for _, path := range append(own, data) {
+		d.processFile(path)
+	}
+
+	for _, path := range own {
+		if tracked, err := s.HasFile(path); err != nil || tracked {
+			t.Errorf("Expected %s never to be registered (tracked=%v, err=%v)", path, tracked, err)
+		}
+	}
+	if tracked, _ := s.HasFile(data); !tracked {
+		t.Errorf("Expected the unrelated file %s to be registered", data)
+	}
+}
+
+func TestProcessFileEnforcesAllowedExtensions(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "daemon_extensions_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s, err := store.NewStore(filepath.Join(tmpDir, "fsd.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	jpg := filepath.Join(tmpDir, "img.JPG")
+	txt := filepath.Join(tmpDir, "notes.txt")
+	for _, p := range []string{jpg, txt} {
+		if err := os.WriteFile(p, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d := &Daemon{
+		Logger: slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		Cfg: &config.Config{
+			WatchPath:         tmpDir,
+			SidecarStrategy:   "none",
+			AllowedExtensions: []string{".jpg"},
+		},
+		DbStore: s,
+	}
+	d.processFile(jpg)
+	d.processFile(txt)
+
+	if tracked, _ := s.HasFile(jpg); !tracked {
+		t.Error("Expected the .JPG file to be registered (extensions are case-insensitive)")
+	}
+	if tracked, _ := s.HasFile(txt); tracked {
+		t.Error("Expected the .txt file never to reach the store")
+	}
+
+	// An empty list allows every extension.
+	d.Cfg.AllowedExtensions = []string{}
+	d.processFile(txt)
+	if tracked, _ := s.HasFile(txt); !tracked {
+		t.Error("Expected an empty allowed_extensions to allow the .txt file")
+	}
+}
+
+func TestReconcileFixesDrift(t *testing.T) {
+	tmpDir := t.TempDir()
+	watchDir := filepath.Join(tmpDir, "data")
+	if err := os.MkdirAll(filepath.Join(watchDir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := store.NewStore(filepath.Join(tmpDir, "fsd.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	old := time.Now().Add(-48 * time.Hour)
+	writeFile := func(path string) os.FileInfo {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(filepath.Base(path)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info
+	}
+
+	// In sync: tracked and on disk.
+	kept := filepath.Join(watchDir, "kept.png")
+	info := writeFile(kept)
+	if err := s.RegisterFile(kept, info.Size(), info.ModTime(), false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Drift: deleted externally while tracked.
+	deleted := filepath.Join(watchDir, "deleted.png")
+	info = writeFile(deleted)
+	if err := s.RegisterFile(deleted, info.Size(), info.ModTime(), false, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(deleted); err != nil {
+		t.Fatal(err)
+	}
+
+	// Drift: present on disk but never tracked (e.g. events lost).
+	untracked := filepath.Join(watchDir, "sub", "untracked.png")
+	writeFile(untracked)
+
+	d := &Daemon{
+		Logger: slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		Cfg: &config.Config{
+			WatchPath:              watchDir,
+			SidecarStrategy:        "none",
+			AllowedExtensions:      []string{".png"},
+			MissingFileGracePeriod: "24h",
+		},
+		DbStore: s,
+	}
+	clk := clock.NewFake(time.Now())
+	s.SetClock(clk)
+
+	// The deleted file's grace period starts with the first pass.
+	if registered, removed := d.reconcile(); registered != 1 || removed != 0 {
+		t.Errorf("Expected 1 untracked file registered and no missing record removed yet, got %d and %d", registered, removed)
+	}
+	clk.Advance(25 * time.Hour)
+	if registered, removed := d.reconcile(); registered != 0 || removed != 1 {
+		t.Errorf("Expected 1 missing record removed after the grace period, got %d untracked and %d missing", registered, removed)
+	}
+
+	for path, want := range map[string]bool{kept: true, deleted: false, untracked: true} {
+		if tracked, _ := s.HasFile(path); tracked != want {
+			t.Errorf("Expected %s tracked=%v after reconciliation, got %v", filepath.Base(path), want, tracked)
+		}
+	}
+
+	// A second pass finds nothing left to fix.
+	if registered, removed := d.reconcile(); registered != 0 || removed != 0 {
+		t.Errorf("Expected no drift on the second pass, got %d untracked and %d missing", registered, removed)
+	}
+}
+
+func TestMissingFileCleanupSkipsUnmountedRoot(t *testing.T) {
+	tmpDir := t.TempDir()
+	watchDir := filepath.Join(tmpDir, "data")
+	if err := os.MkdirAll(watchDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := store.NewStore(filepath.Join(tmpDir, "fsd.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	img := filepath.Join(watchDir, "img.png")
+	if err := os.WriteFile(img, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterFile(img, 4, time.Now().Add(-48*time.Hour), false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Daemon{
+		Logger: slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		Cfg: &config.Config{
+			WatchPath:              watchDir,
+			MissingFileGracePeriod: "0s",
+		},
+		DbStore: s,
+	}
+
+	// Unmounting the disk takes the whole watch directory away.
+	unmounted := filepath.Join(tmpDir, "unmounted")
+	if err := os.Rename(watchDir, unmounted); err != nil {
+		t.Fatal(err)
+	}
+	d.removeMissingFiles()
+	if _, removed := d.reconcile(); removed != 0 {
+		t.Errorf("Expected reconciliation to remove nothing while the watch directory is missing, got %d", removed)
+	}
+	if tracked, _ := s.HasFile(img); !tracked {
+		t.Fatal("Expected the record to be kept while the watch directory is missing")
+	}
+
+	// Once the directory is back, a file that is really gone is removed.
+	if err := os.Rename(unmounted, watchDir); err != nil {
+		t.Fatal(err)
+	}
+	d.removeMissingFiles()
+	if tracked, _ := s.HasFile(img); !tracked {
+		t.Fatal("Expected the record of a present file to be kept")
+	}
+	if err := os.Remove(img); err != nil {
+		t.Fatal(err)
+	}
+	d.removeMissingFiles()
+	if tracked, _ := s.HasFile(img); tracked {
+		t.Error("Expected the record of a deleted file to be removed")
+	}
+}
+
+func TestPeriodicTasksEndOnStop(t *testing.T) {
+	d := &Daemon{
+		Logger: slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		Cfg: &config.Config{
+			MissingFileCheckInterval: "1h",
+			ReconcileInterval:        "1h",
+			SessionRotateInterval:    "1h",
+			DBMaintenanceInterval:    "1h",
+		},
+	}
+	tasks := map[string]func(<-chan struct{}){
+		"missingFileCleaner": d.missingFileCleaner,
+		"reconciler":         d.reconciler,
+		"sessionRotator":     d.sessionRotator,
+		"dbMaintainer":       d.dbMaintainer,
+	}
+
+	for name, task := range tasks {
+		done := make(chan struct{})
+		ended := make(chan struct{})
+		go func() {
+			task(done)
+			close(ended)
+		}()
+		close(done)
+		select {
+		case <-ended:
+		case <-time.After(5 * time.Second):
+			t.Errorf("Expected %s to end once done is closed", name)
+		}
+	}
+}
+
+func TestIgnoreFileChangesApplyAtRuntime(t *testing.T) {
+	tmpDir := t.TempDir()
+	watchDir := filepath.Join(tmpDir, "watch")
+	if err := os.MkdirAll(watchDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := store.NewStore(filepath.Join(tmpDir, "fsd.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// The ignore file lives in the watch directory and must not be uploaded itself.
+	ignoreFile := filepath.Join(watchDir, ".fsdignore")
+	if err := os.WriteFile(ignoreFile, []byte("*.bak\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Daemon{
+		Logger: slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		Cfg: &config.Config{
+			WatchPath:       watchDir,
+			SidecarStrategy: "none",
+			IgnoreFile:      ignoreFile,
+		},
+		DbStore: s,
+	}
+	d.reloadIgnoreFile()
+
+	register := func(name string) bool {
+		t.Helper()
+		path := filepath.Join(watchDir, name)
+		if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		d.processFile(path)
+		tracked, err := s.HasFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tracked
+	}
+
+	if register("a.bak") {
+		t.Error("Expected a.bak to be ignored by the initial pattern")
+	}
+	if !register("a.tmp") {
+		t.Error("Expected a.tmp to be registered before it is ignored")
+	}
+
+	// Add a pattern, plus an invalid one that must not break the others.
+	if err := os.WriteFile(ignoreFile, []byte("*.bak\n[broken\n*.tmp\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d.reloadIgnoreFile()
+
+	if register("b.tmp") {
+		t.Error("Expected b.tmp to be ignored after the pattern was added")
+	}
+	if register("b.bak") {
+		t.Error("Expected the existing pattern to keep applying")
+	}
+	if !register("b.png") {
+		t.Error("Expected b.png to be registered")
+	}
+	d.processFile(ignoreFile)
+	if tracked, _ := s.HasFile(ignoreFile); tracked {
+		t.Error("Expected the ignore file itself to be excluded")
+	}
+}
+
+func TestNewDirectoriesAreReported(t *testing.T) {
+	tmpDir := t.TempDir()
+	watchDir := filepath.Join(tmpDir, "data")
+
+	reports := make(chan api.DirectoryEvent, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.URL.Path == "/v1/devices/test-dev/directories" {
+			var event api.DirectoryEvent
+			if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+				t.Errorf("Invalid directory report: %v", err)
+			}
+			reports <- event
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	newDaemon := func(report bool) *Daemon {
+		return &Daemon{
+			Logger: slog.New(slog.NewTextHandler(os.Stdout, nil)),
+			Cfg: &config.Config{
+				DeviceID:             "test-dev",
+				Endpoint:             srv.URL,
+				WatchPath:            watchDir,
+				DBPath:               filepath.Join(tmpDir, "fsd.db"),
+				MaxDataSizeGB:        1.0,
+				IngestCheckInterval:  "1h",
+				DebounceDuration:     "10ms",
+				ReportNewDirectories: report,
+			},
+		}
+	}
+
+	// Disabled by default: nothing is reported.
+	d := newDaemon(false)
+	if err := d.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(watchDir, "ignored"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	d.Stop(nil)
+	select {
+	case event := <-reports:
+		t.Fatalf("Expected no report while disabled, got %+v", event)
+	default:
+	}
+
+	d = newDaemon(true)
+	if err := d.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Stop(nil)
+	if err := os.MkdirAll(filepath.Join(watchDir, "session-42", "cam1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]bool{}
+	for len(got) < 2 {
+		select {
+		case event := <-reports:
+			got[event.Path] = true
+			if event.CreatedAt.IsZero() {
+				t.Errorf("Expected a creation time in %+v", event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timeout waiting for directory reports, got %v", got)
+		}
+	}
+	if !got["session-42"] || !got["session-42/cam1"] {
+		t.Errorf("Expected session-42 and session-42/cam1 to be reported, got %v", got)
+	}
+	select {
+	case event := <-reports:
+		t.Errorf("Expected each directory to be reported once, got another report %+v", event)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
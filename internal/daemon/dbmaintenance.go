@@ -0,0 +1,71 @@
+package daemon
+
+import (
+	"time"
+
+	"fs-ingest-daemon/internal/clock"
+)
+
+// vacuumRetry is how soon a maintenance run whose VACUUM was skipped because
+// of busy uploads is repeated.
+const vacuumRetry = 15 * time.Minute
+
+// dbMaintainer runs database maintenance (see store.Maintenance) every
+// DBMaintenanceInterval, so deleted-file churn and the WAL don't grow the
+// database file without bound. It returns once done is closed.
+func (d *Daemon) dbMaintainer(done <-chan struct{}) {
+	interval, err := time.ParseDuration(d.Cfg.DBMaintenanceInterval)
+	if err != nil || interval <= 0 {
+		if d.Logger != nil {
+			d.Logger.Error("Invalid database maintenance interval, maintenance disabled", "value", d.Cfg.DBMaintenanceInterval, "error", err)
+		}
+		return
+	}
+
+	clk := clock.OrReal(d.Clock)
+	ticker := clk.NewTicker(interval)
+	defer ticker.Stop()
+
+	var retry <-chan time.Time
+	for {
+		select {
+		case <-ticker.C():
+		case <-retry:
+		case <-done:
+			return
+		}
+		retry = nil
+		if !d.maintainDB() {
+			retry = clk.After(vacuumRetry)
+		}
+	}
+}
+
+// maintainDB runs one database maintenance pass and reports whether the
+// database was vacuumed. VACUUM blocks every database write until it is done,
+// so it is left out while all upload workers are busy.
+func (d *Daemon) maintainDB() bool {
+	vacuum := true
+	if d.IngesterSvc != nil {
+		if active := d.IngesterSvc.InFlight(); active >= max(d.Cfg.IngestWorkerCount, 1) {
+			vacuum = false
+			if d.Logger != nil {
+				d.Logger.Info("Uploads are busy, skipping VACUUM of the database", "active_uploads", active, "retry_in", vacuumRetry)
+			}
+		}
+	}
+
+	start := clock.OrReal(d.Clock).Now()
+	report, err := d.DbStore.Maintenance(vacuum)
+	if err != nil {
+		if d.Logger != nil {
+			d.Logger.Error("Database maintenance failed", "error", err)
+		}
+		return false
+	}
+	if d.Logger != nil {
+		d.Logger.Info("Database maintenance finished", "size_before", report.SizeBefore, "size_after", report.SizeAfter,
+			"vacuumed", report.Vacuumed, "wal_busy", report.WALBusy, "duration", clock.OrReal(d.Clock).Now().Sub(start))
+	}
+	return report.Vacuumed
+}
@@ -0,0 +1,60 @@
+package daemon
+
+import (
+	"path/filepath"
+	"time"
+
+	"fs-ingest-daemon/internal/api"
+	"fs-ingest-daemon/internal/clock"
+)
+
+// Directory reports are queued so the watcher's event loop never waits for the API.
+const (
+	directoryReportQueue    = 100 // Reports waiting to be sent; more are dropped
+	directoryReportAttempts = 3   // Attempts per report for transient API errors
+	directoryReportBackoff  = time.Second
+)
+
+// queueDirectoryReport queues a report of a directory the watcher saw created.
+func (d *Daemon) queueDirectoryReport(path string) {
+	select {
+	case d.dirReports <- path:
+	default:
+		if d.Logger != nil {
+			d.Logger.Warn("Directory report queue is full, dropping report", "path", path)
+		}
+	}
+}
+
+// directoryReporter sends the queued directory reports to the API, for
+// backends that model capture directories (e.g. sessions) explicitly.
+func (d *Daemon) directoryReporter() {
+	for path := range d.dirReports {
+		d.reportDirectory(path)
+	}
+}
+
+// reportDirectory sends one directory report. Failures are logged and dropped;
+// the files in the directory are uploaded either way.
+func (d *Daemon) reportDirectory(path string) {
+	rel, err := filepath.Rel(d.Cfg.WatchPath, path)
+	if err != nil {
+		if d.Logger != nil {
+			d.Logger.Error("Failed to report new directory", "path", path, "error", err)
+		}
+		return
+	}
+	event := api.DirectoryEvent{Path: filepath.ToSlash(rel), CreatedAt: clock.OrReal(d.Clock).Now().UTC()}
+	err = api.Retry(directoryReportAttempts, directoryReportBackoff, func() error {
+		return d.ApiClient.ReportDirectory(d.Cfg.DeviceID, event)
+	})
+	if err != nil {
+		if d.Logger != nil {
+			d.Logger.Warn("Failed to report new directory", "path", event.Path, "error", err)
+		}
+		return
+	}
+	if d.Logger != nil {
+		d.Logger.Info("Reported new directory", "path", event.Path)
+	}
+}
@@ -0,0 +1,82 @@
+package daemon
+
+import (
+	"os"
+	"time"
+
+	"fs-ingest-daemon/internal/clock"
+	"fs-ingest-daemon/internal/util"
+)
+
+// ignoreFilePollInterval is how often IgnoreFile is checked for changes.
+const ignoreFilePollInterval = 5 * time.Second
+
+// ignoreFileStamp identifies a version of IgnoreFile, so it is only re-read
+// when it changed.
+type ignoreFileStamp struct {
+	exists  bool
+	size    int64
+	modTime time.Time
+}
+
+// ignoreFileWatcher re-reads IgnoreFile whenever it changes, so operators can
+// update exclusions without editing the config or restarting.
+func (d *Daemon) ignoreFileWatcher() {
+	ticker := clock.OrReal(d.Clock).NewTicker(ignoreFilePollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			d.reloadIgnoreFile()
+		}
+	}
+}
+
+// reloadIgnoreFile loads the patterns of IgnoreFile if the file changed since
+// the last call. Invalid lines are logged and skipped; a missing file ignores
+// nothing. If the file cannot be read, the previous patterns stay in effect.
+func (d *Daemon) reloadIgnoreFile() {
+	path := d.Cfg.IgnoreFile
+	var stamp ignoreFileStamp
+	info, err := os.Stat(path)
+	if err == nil {
+		stamp = ignoreFileStamp{exists: true, size: info.Size(), modTime: info.ModTime()}
+	} else if !os.IsNotExist(err) {
+		if d.Logger != nil {
+			d.Logger.Error("Failed to check ignore file, keeping its previous patterns", "file", path, "error", err)
+		}
+		return
+	}
+	if d.ignoreStamp != nil && stamp == *d.ignoreStamp {
+		return
+	}
+
+	if !stamp.exists {
+		d.ignoreStamp = &stamp
+		d.ignore.Store(nil)
+		if d.Logger != nil {
+			d.Logger.Warn("Ignore file not found, no patterns from it apply", "file", path)
+		}
+		return
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if d.Logger != nil {
+			d.Logger.Error("Failed to read ignore file, keeping its previous patterns", "file", path, "error", err)
+		}
+		return
+	}
+	rules, errs := util.ParseIgnoreRules(string(data))
+	for _, err := range errs {
+		if d.Logger != nil {
+			d.Logger.Warn("Skipping invalid pattern in ignore file", "file", path, "error", err)
+		}
+	}
+	d.ignoreStamp = &stamp
+	d.ignore.Store(rules)
+	if d.Logger != nil {
+		d.Logger.Info("Loaded ignore file", "file", path, "patterns", rules.Len())
+	}
+}
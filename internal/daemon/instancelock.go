@@ -0,0 +1,67 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// errLocked is returned by lockFile when another process holds the lock.
+var errLocked = errors.New("lock is held by another process")
+
+// acquireInstanceLock takes an exclusive lock on a lock file next to the DB,
+// so a second daemon using the same DB refuses to start instead of competing
+// for SQLite and uploading files twice. The lock is released by the OS if the
+// process dies, so a stale lock file never blocks a restart.
+func acquireInstanceLock(dbPath string, perm os.FileMode) (*os.File, error) {
+	lockPath := dbPath + ".lock"
+	f, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, perm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file %s: %v", lockPath, err)
+	}
+
+	if err := lockFile(f); err != nil {
+		pid := make([]byte, 32)
+		n, _ := f.Read(pid)
+		f.Close()
+		if errors.Is(err, errLocked) {
+			return nil, fmt.Errorf("another instance is already running with database %s (pid %s holds %s)",
+				dbPath, strings.TrimSpace(string(pid[:n])), lockPath)
+		}
+		return nil, fmt.Errorf("failed to lock %s: %v", lockPath, err)
+	}
+
+	// Record our PID for the error message of the next instance.
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return f, nil
+}
+
+// releaseInstanceLock unlocks and closes f. The file is left in place: removing
+// it could let a starting instance lock an unlinked file.
+func releaseInstanceLock(f *os.File) {
+	unlockFile(f)
+	f.Close()
+}
+
+// InstanceRunning reports whether a daemon holds the instance lock of the DB
+// at dbPath, and the PID it recorded. Daemons running with single_instance
+// disabled take no lock and are not detected.
+func InstanceRunning(dbPath string) (pid string, running bool) {
+	f, err := os.OpenFile(dbPath+".lock", os.O_RDWR, 0)
+	if err != nil {
+		return "", false // No lock file, no daemon has used this DB with the lock
+	}
+	defer f.Close()
+
+	if err := lockFile(f); err != nil {
+		buf := make([]byte, 32)
+		n, _ := f.Read(buf)
+		return strings.TrimSpace(string(buf[:n])), errors.Is(err, errLocked)
+	}
+	unlockFile(f)
+	return "", false
+}
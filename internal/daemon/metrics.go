@@ -0,0 +1,90 @@
+package daemon
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"fs-ingest-daemon/internal/ingest"
+	"fs-ingest-daemon/internal/metrics"
+	"fs-ingest-daemon/internal/pairing"
+	"fs-ingest-daemon/internal/store"
+)
+
+// uploadDurationBuckets are the upper bounds (seconds) of the upload duration histogram.
+var uploadDurationBuckets = []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// daemonMetrics are the metrics served on MetricsAddr. The zero value records nothing.
+type daemonMetrics struct {
+	registry    *metrics.Registry
+	uploads     ingest.UploadMetrics
+	prunedFiles *metrics.Counter
+	prunedBytes *metrics.Counter
+	pruneFailed *metrics.Counter
+
+	reconciledUntracked *metrics.Counter
+	reconciledMissing   *metrics.Counter
+
+	pairing pairing.Metrics
+}
+
+// newDaemonMetrics registers the daemon's metrics. Gauges query s on every scrape.
+func newDaemonMetrics(s *store.Store) daemonMetrics {
+	r := metrics.NewRegistry()
+	m := daemonMetrics{
+		registry: r,
+		uploads: ingest.UploadMetrics{
+			Uploaded: r.NewCounter("fsd_files_uploaded_total", "Files uploaded and confirmed."),
+			Bytes:    r.NewCounter("fsd_upload_bytes_total", "Bytes of the files uploaded."),
+			Failures: r.NewCounter("fsd_upload_failures_total", "Failed upload attempts."),
+			Duration: r.NewHistogram("fsd_upload_duration_seconds", "Duration of successful uploads from handshake to confirm.", uploadDurationBuckets),
+		},
+		prunedFiles: r.NewCounter("fsd_files_pruned_total", "Uploaded files deleted to free space."),
+		prunedBytes: r.NewCounter("fsd_pruned_bytes_total", "Bytes freed by pruning."),
+		pruneFailed: r.NewCounter("fsd_prune_failed_files_total", "Files excluded from pruning after repeated failed deletes."),
+
+		reconciledUntracked: r.NewCounter("fsd_reconcile_untracked_total", "Untracked files on disk registered by reconciliation."),
+		reconciledMissing:   r.NewCounter("fsd_reconcile_missing_total", "Records of files missing from disk removed by reconciliation."),
+
+		pairing: pairing.Metrics{
+			Requested: r.NewCounter("fsd_pairing_codes_requested_total", "Pairing codes issued to re-pair the device."),
+			Claimed:   r.NewCounter("fsd_pairing_claimed_total", "Pairing codes claimed in the web client."),
+			Expired:   r.NewCounter("fsd_pairing_expired_total", "Pairing codes that expired before they were claimed."),
+			TimedOut:  r.NewCounter("fsd_pairing_timed_out_total", "Pairing attempts given up before the code was claimed or expired."),
+		},
+	}
+	r.NewGaugeFunc("fsd_files_pending", "Files waiting for upload (PENDING or ORPHAN).", func() (float64, error) {
+		counts, err := s.CountByStatus()
+		if err != nil {
+			return 0, err
+		}
+		return float64(counts[store.StatusPending] + counts[store.StatusOrphan]), nil
+	})
+	r.NewGaugeFunc("fsd_tracked_bytes", "Size of the files tracked in the watch directory.", func() (float64, error) {
+		total, err := s.GetTotalSize()
+		return float64(total), err
+	})
+	return m
+}
+
+// serveMetrics serves r on addr at /metrics until Stop.
+func (d *Daemon) serveMetrics(addr string, r *metrics.Registry) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r)
+	d.metricsSrv = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func(srv *http.Server) {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) && d.Logger != nil {
+			d.Logger.Error("Metrics server stopped", "error", err)
+		}
+	}(d.metricsSrv)
+	if d.Logger != nil {
+		d.Logger.Info("Serving metrics", "addr", ln.Addr().String())
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+//go:build !windows
+
+package daemon
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"fs-ingest-daemon/internal/config"
+)
+
+// Privilege syscalls, replaced in tests.
+var (
+	setgroups = syscall.Setgroups
+	setgid    = syscall.Setgid
+	setuid    = syscall.Setuid
+	geteuid   = os.Geteuid
+	chown     = os.Chown
+)
+
+// dropPrivileges switches the process to RunAsUser/RunAsGroup once the watch dir
+// and DB are open. Without owner/group, what the daemon created as root is
+// handed over to the target identity first. It refuses to drop if the target
+// identity could not write the paths the daemon needs afterwards.
+func (d *Daemon) dropPrivileges() error {
+	if d.Cfg.RunAsUser == "" && d.Cfg.RunAsGroup == "" {
+		return nil
+	}
+
+	uid, gid, err := resolveRunAs(d.Cfg.RunAsUser, d.Cfg.RunAsGroup)
+	if err != nil {
+		return err
+	}
+
+	if geteuid() != 0 {
+		if uid != geteuid() && d.Logger != nil {
+			d.Logger.Warn("Not running as root, cannot drop privileges", "run_as_user", d.Cfg.RunAsUser, "run_as_group", d.Cfg.RunAsGroup)
+		}
+		return nil
+	}
+
+	// With owner/group set, created paths already got their ownership.
+	if d.Cfg.Owner == "" && d.Cfg.Group == "" {
+		d.handOver(uid, gid)
+	}
+
+	for _, path := range []string{d.Cfg.WatchPath, filepath.Dir(d.Cfg.DBPath), d.Cfg.DBPath, filepath.Dir(d.Cfg.LogPath)} {
+		if path == "" {
+			continue
+		}
+		if err := checkWritable(path, uid, gid); err != nil {
+			return err
+		}
+	}
+
+	// Order matters: groups must be changed while still root.
+	if err := setgroups([]int{gid}); err != nil {
+		return fmt.Errorf("setgroups: %w", err)
+	}
+	if err := setgid(gid); err != nil {
+		return fmt.Errorf("setgid(%d): %w", gid, err)
+	}
+	if err := setuid(uid); err != nil {
+		return fmt.Errorf("setuid(%d): %w", uid, err)
+	}
+
+	if d.Logger != nil {
+		d.Logger.Info("Dropped privileges", "uid", uid, "gid", gid)
+	}
+	return nil
+}
+
+// handOver chowns the files and directories the daemon created or opened as
+// root (watch dir, DB and its WAL, SQLite and lock files, log file and their
+// directories) to uid/gid. Paths the target can already write, and paths not
+// owned by root, are left alone.
+func (d *Daemon) handOver(uid, gid int) {
+	db := d.Cfg.DBPath
+	paths := []string{d.Cfg.WatchPath, filepath.Dir(db), db, db + "-wal", db + "-shm", db + ".lock"}
+	logPath := d.LogPath
+	if logPath == "" {
+		logPath = d.Cfg.LogPath
+	}
+	if logPath != "" {
+		paths = append(paths, filepath.Dir(logPath), logPath)
+	}
+
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		st, ok := info.Sys().(*syscall.Stat_t)
+		if !ok || int(st.Uid) != geteuid() || checkWritable(path, uid, gid) == nil {
+			continue
+		}
+		if err := chown(path, uid, gid); err != nil && d.Logger != nil {
+			d.Logger.Warn("Failed to hand over path to run_as_user", "path", path, "error", err)
+		}
+	}
+}
+
+// resolveRunAs returns the uid/gid to run as. An empty group means the user's primary group.
+func resolveRunAs(runAsUser, runAsGroup string) (int, int, error) {
+	uid := os.Getuid()
+	if runAsUser != "" {
+		id, err := config.LookupUID(runAsUser)
+		if err != nil {
+			return 0, 0, err
+		}
+		uid = id
+	}
+
+	if runAsGroup != "" {
+		gid, err := config.LookupGID(runAsGroup)
+		return uid, gid, err
+	}
+
+	u, err := user.LookupId(strconv.Itoa(uid))
+	if err != nil {
+		return 0, 0, fmt.Errorf("cannot determine primary group of uid %d, set run_as_group: %w", uid, err)
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid primary gid %q for uid %d", u.Gid, uid)
+	}
+	return uid, gid, nil
+}
+
+// checkWritable reports an error if uid/gid would not be able to write path
+// (and traverse it, for directories). Supplementary groups are not considered.
+func checkWritable(path string, uid, gid int) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil // Created later by the daemon; its parent is checked separately
+		}
+		return err
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+
+	need := os.FileMode(0200) // write
+	if info.IsDir() {
+		need |= 0100 // traverse
+	}
+	mode := info.Mode().Perm()
+	switch {
+	case uid == 0:
+		return nil
+	case int(st.Uid) == uid:
+	case int(st.Gid) == gid:
+		need >>= 3
+	default:
+		need >>= 6
+	}
+	if mode&need != need {
+		return fmt.Errorf("uid %d/gid %d cannot write %s (mode %#o, owner %d:%d)", uid, gid, path, mode, st.Uid, st.Gid)
+	}
+	return nil
+}
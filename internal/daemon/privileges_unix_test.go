@@ -0,0 +1,184 @@
+//go:build !windows
+
+package daemon
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"fs-ingest-daemon/internal/config"
+)
+
+func TestDropPrivilegesSwitchesUID(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "privileges_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Pretend to be root and record the syscalls instead of performing them.
+	var gotUID, gotGID = -1, -1
+	var gotGroups []int
+	origSetgroups, origSetgid, origSetuid, origGeteuid := setgroups, setgid, setuid, geteuid
+	defer func() { setgroups, setgid, setuid, geteuid = origSetgroups, origSetgid, origSetuid, origGeteuid }()
+	setgroups = func(gids []int) error { gotGroups = gids; return nil }
+	setgid = func(gid int) error { gotGID = gid; return nil }
+	setuid = func(uid int) error { gotUID = uid; return nil }
+	geteuid = func() int { return 0 }
+
+	// Target the current user so the writability checks pass on the temp dir.
+	uid, gid := os.Getuid(), os.Getgid()
+	d := &Daemon{
+		Cfg: &config.Config{
+			WatchPath:  tmpDir,
+			DBPath:     filepath.Join(tmpDir, "fsd.db"),
+			LogPath:    filepath.Join(tmpDir, "fsd.log"),
+			RunAsUser:  strconv.Itoa(uid),
+			RunAsGroup: strconv.Itoa(gid),
+		},
+		Logger: slog.New(slog.NewTextHandler(os.Stdout, nil)),
+	}
+
+	if err := d.dropPrivileges(); err != nil {
+		t.Fatalf("dropPrivileges failed: %v", err)
+	}
+	if gotUID != uid || gotGID != gid {
+		t.Errorf("Expected setuid(%d)/setgid(%d), got setuid(%d)/setgid(%d)", uid, gid, gotUID, gotGID)
+	}
+	if len(gotGroups) != 1 || gotGroups[0] != gid {
+		t.Errorf("Expected supplementary groups to be reset to [%d], got %v", gid, gotGroups)
+	}
+}
+
+func TestDropPrivilegesRefusesUnwritablePaths(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "privileges_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	watchPath := filepath.Join(tmpDir, "data")
+	if err := os.Mkdir(watchPath, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	called := false
+	origSetuid, origGeteuid := setuid, geteuid
+	defer func() { setuid, geteuid = origSetuid, origGeteuid }()
+	setuid = func(int) error { called = true; return nil }
+	geteuid = func() int { return 0 }
+
+	// A uid/gid that owns nothing here cannot write the 0700 watch dir, which
+	// is not handed over as an owner is configured.
+	d := &Daemon{
+		Cfg: &config.Config{
+			WatchPath:  watchPath,
+			DBPath:     filepath.Join(tmpDir, "fsd.db"),
+			Owner:      strconv.Itoa(os.Getuid()),
+			RunAsUser:  "54321",
+			RunAsGroup: "54321",
+		},
+	}
+
+	if err := d.dropPrivileges(); err == nil {
+		t.Fatal("Expected dropPrivileges to refuse an unwritable watch dir")
+	}
+	if called {
+		t.Error("Expected setuid not to be called when validation fails")
+	}
+}
+
+func TestStartCleansUpWhenDropFails(t *testing.T) {
+	tmpDir := t.TempDir()
+	watchPath := filepath.Join(tmpDir, "data")
+	dbPath := filepath.Join(tmpDir, "fsd.db")
+
+	var watchDirReady bool
+	origSetgroups, origSetgid, origSetuid, origGeteuid := setgroups, setgid, setuid, geteuid
+	defer func() { setgroups, setgid, setuid, geteuid = origSetgroups, origSetgid, origSetuid, origGeteuid }()
+	setgroups = func([]int) error { return nil }
+	setgid = func(int) error { return nil }
+	setuid = func(int) error {
+		_, err := os.Stat(watchPath)
+		watchDirReady = err == nil
+		return errors.New("operation not permitted")
+	}
+	geteuid = func() int { return 0 }
+
+	d := &Daemon{
+		Logger:  slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		CfgPath: filepath.Join(tmpDir, "config.json"),
+		Cfg: &config.Config{
+			WatchPath:      watchPath,
+			DBPath:         dbPath,
+			SingleInstance: true,
+			RunAsUser:      strconv.Itoa(os.Getuid()),
+			RunAsGroup:     strconv.Itoa(os.Getgid()),
+		},
+	}
+	if err := d.Start(nil); err == nil {
+		d.Stop(nil)
+		t.Fatal("Expected Start to fail when privileges cannot be dropped")
+	}
+	if !watchDirReady {
+		t.Error("Expected the watch dir to be created before dropping privileges")
+	}
+	if d.IngesterSvc != nil || d.DbStore != nil {
+		t.Error("Expected the ingester and store to be shut down")
+	}
+
+	// The instance lock was released, so another daemon can start.
+	lock, err := acquireInstanceLock(dbPath, 0600)
+	if err != nil {
+		t.Fatalf("Expected the instance lock to be released, got %v", err)
+	}
+	releaseInstanceLock(lock)
+}
+
+func TestStartHandsOverCreatedPathsToRunAsUser(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Handing over paths needs root")
+	}
+	tmpDir := t.TempDir()
+	watchPath := filepath.Join(tmpDir, "data")
+	dbPath := filepath.Join(tmpDir, "fsd.db")
+
+	var gotUID = -1
+	origSetgroups, origSetgid, origSetuid, origGeteuid := setgroups, setgid, setuid, geteuid
+	defer func() { setgroups, setgid, setuid, geteuid = origSetgroups, origSetgid, origSetuid, origGeteuid }()
+	setgroups = func([]int) error { return nil }
+	setgid = func(int) error { return nil }
+	setuid = func(uid int) error { gotUID = uid; return nil }
+	geteuid = func() int { return 0 }
+
+	// Only run_as_user: nothing else chowns what Start creates as root.
+	d := &Daemon{
+		Logger:  slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		CfgPath: filepath.Join(tmpDir, "config.json"),
+		Cfg: &config.Config{
+			WatchPath:      watchPath,
+			DBPath:         dbPath,
+			LogPath:        filepath.Join(tmpDir, "fsd.log"),
+			SingleInstance: true,
+			RunAsUser:      "54321",
+			RunAsGroup:     "54321",
+		},
+	}
+	if err := d.Start(nil); err != nil {
+		t.Fatalf("Expected Start to succeed with only run_as_user set, got %v", err)
+	}
+	defer d.Stop(nil)
+
+	if gotUID != 54321 {
+		t.Errorf("Expected setuid(54321), got %d", gotUID)
+	}
+	for _, path := range []string{watchPath, tmpDir, dbPath, dbPath + ".lock"} {
+		if err := checkWritable(path, 54321, 54321); err != nil {
+			t.Errorf("Expected %s to be handed over: %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+//go:build windows
+
+package daemon
+
+// dropPrivileges is a no-op on Windows; run the service under the desired account instead.
+func (d *Daemon) dropPrivileges() error {
+	return nil
+}
@@ -0,0 +1,161 @@
+package daemon
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"fs-ingest-daemon/internal/clock"
+	"fs-ingest-daemon/internal/util"
+)
+
+// reconcileBatchSize is the number of files (on disk or in the DB) a
+// reconciliation pass handles before pausing for reconcileBatchPause, so a
+// large tree is not read in one burst.
+const reconcileBatchSize = 500
+
+// reconcileBatchPause is the pause between two batches of a reconciliation pass.
+const reconcileBatchPause = time.Second
+
+// reconciler periodically brings the DB back in line with the watch directory.
+// Unlike the startup scan and the missing-file cleaner it covers both directions:
+// files on disk that are not tracked, and records of files that are gone.
+// It returns once done is closed.
+func (d *Daemon) reconciler(done <-chan struct{}) {
+	interval, err := time.ParseDuration(d.Cfg.ReconcileInterval)
+	if err != nil || interval <= 0 {
+		if d.Logger != nil {
+			d.Logger.Error("Invalid reconcile interval, reconciliation disabled", "value", d.Cfg.ReconcileInterval, "error", err)
+		}
+		return
+	}
+
+	ticker := clock.OrReal(d.Clock).NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			d.reconcile()
+		case <-done:
+			return
+		}
+	}
+}
+
+// reconcile runs one reconciliation pass. It returns the number of untracked
+// files registered and of records of missing files removed.
+// Records of missing files are only removed after a complete walk of an
+// available watch root, so an unmounted disk does not look like every file
+// was deleted.
+func (d *Daemon) reconcile() (untracked, missing int) {
+	var walkErr error
+	d.exclusiveWalk("reconcile", func() {
+		if untracked, walkErr = d.registerUntracked(reconcileBatchPause); walkErr != nil && d.Logger != nil {
+			d.Logger.Error("Reconciliation: Walk of the watch directory failed", "error", walkErr)
+		}
+	})
+	switch {
+	case walkErr != nil:
+		if d.Logger != nil {
+			d.Logger.Warn("Reconciliation: Skipping removal of missing records after the failed walk")
+		}
+	case !d.watchRootAvailable():
+		if d.Logger != nil {
+			d.Logger.Warn("Reconciliation: Watch directory is unavailable, skipping removal of missing records", "path", d.Cfg.WatchPath)
+		}
+	default:
+		missing = d.removeAllMissingFiles()
+	}
+
+	d.reconciledUntracked.Add(int64(untracked))
+	d.reconciledMissing.Add(int64(missing))
+	if d.Logger != nil {
+		if untracked > 0 || missing > 0 {
+			d.Logger.Warn("Reconciliation fixed drift between the database and disk", "untracked", untracked, "missing", missing)
+		} else {
+			d.Logger.Debug("Reconciliation found no drift")
+		}
+	}
+	return untracked, missing
+}
+
+// registerUntracked walks the watch directory and registers files that are not
+// tracked with their current size and mod time, pausing for pause (if not 0)
+// after every reconcileBatchSize files. Fingerprints are loaded per directory
+// so memory stays bounded. It returns the number of files that were not
+// tracked at all and are now, also when the walk fails part way.
+func (d *Daemon) registerUntracked(pause time.Duration) (int, error) {
+	idx, err := newScanIndex(d.DbStore, "chunked")
+	if err != nil {
+		return 0, fmt.Errorf("failed to load file fingerprints: %w", err)
+	}
+
+	registered, seen := 0, 0
+	err = util.Walk(d.Cfg.WatchPath, d.Cfg.FollowSymlinks, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || d.excluded(path) {
+			return nil
+		}
+
+		seen++
+		if pause > 0 && seen%reconcileBatchSize == 0 {
+			<-clock.OrReal(d.Clock).After(pause)
+		}
+
+		unchanged, err := idx.unchanged(path, info)
+		if err != nil {
+			return err
+		}
+		if unchanged {
+			return nil
+		}
+		tracked, err := d.DbStore.HasFile(path)
+		if err != nil {
+			return err
+		}
+		d.processFile(path)
+		if tracked {
+			return nil
+		}
+		if tracked, err = d.DbStore.HasFile(path); err != nil {
+			return err
+		}
+		if tracked {
+			registered++
+			if d.Logger != nil {
+				d.Logger.Info("Reconciliation: Registered untracked file", "path", path)
+			}
+		}
+		return nil
+	})
+	return registered, err
+}
+
+// removeAllMissingFiles checks every record, in batches, and removes those of
+// files that have been missing from disk for MissingFileGracePeriod, counted
+// from the first check that found them missing (see Store.RemoveMissingFiles).
+// It does not check the watch root; callers skip it while the root is
+// unavailable. It returns the number of records removed.
+func (d *Daemon) removeAllMissingFiles() int {
+	grace := d.missingFileGrace()
+	total := 0
+	var afterID int64
+	for {
+		removed, next, err := d.DbStore.RemoveMissingFilesAfter(afterID, reconcileBatchSize, grace)
+		total += removed
+		if err != nil {
+			if d.Logger != nil {
+				d.Logger.Error("Reconciliation: Failed to remove records of missing files", "error", err)
+			}
+			return total
+		}
+		if next == 0 {
+			return total
+		}
+		afterID = next
+		<-clock.OrReal(d.Clock).After(reconcileBatchPause)
+	}
+}
@@ -0,0 +1,131 @@
+package daemon
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"fs-ingest-daemon/internal/config"
+)
+
+// Reload re-reads the configuration file and applies the settings that are safe
+// to change while running: ingest_batch_size, ingest_check_interval, the prune
+// watermarks, max_data_size_gb, exclude_patterns and prune_dry_run. Other changed settings are
+// logged as requiring a restart and ignored. Cfg itself is never modified; the
+// new settings are handed to the components as a copy.
+func (d *Daemon) Reload() error {
+	d.reloadMu.Lock()
+	defer d.reloadMu.Unlock()
+
+	next, err := config.Load(d.CfgPath)
+	if err != nil {
+		return err
+	}
+
+	current := d.reloaded
+	if current == nil {
+		current = d.Cfg
+	}
+	applied := *current
+	applied.IngestBatchSize = next.IngestBatchSize
+	applied.IngestCheckInterval = next.IngestCheckInterval
+	applied.PruneHighWatermarkPercent = next.PruneHighWatermarkPercent
+	applied.PruneLowWatermarkPercent = next.PruneLowWatermarkPercent
+	applied.MaxDataSizeGB = next.MaxDataSizeGB
+	applied.ExcludePatterns = next.ExcludePatterns
+	applied.PruneDryRun = next.PruneDryRun
+	if err := checkLiveSettings(&applied); err != nil {
+		return err
+	}
+
+	if restart := changedSettings(&applied, next); len(restart) > 0 && d.Logger != nil {
+		d.Logger.Warn("Configuration changes require a restart, ignoring them", "settings", restart)
+	}
+	changed := changedSettings(current, &applied)
+	if len(changed) == 0 {
+		if d.Logger != nil {
+			d.Logger.Info("Configuration reloaded, nothing to apply")
+		}
+		return nil
+	}
+
+	if d.PrunerSvc != nil {
+		d.PrunerSvc.SetConfig(&applied)
+	}
+	if d.IngesterSvc != nil {
+		d.IngesterSvc.SetConfig(&applied)
+	}
+	d.excludeOnce.Do(func() {}) // the patterns below replace the ones from Cfg
+	d.setExcludePatterns(applied.ExcludePatterns)
+	d.reloaded = &applied
+
+	if d.Logger != nil {
+		d.Logger.Info("Configuration reloaded", "applied", changed)
+	}
+	return nil
+}
+
+// saveAuthToken persists the device's auth token in the configuration file and
+// applies it to the metadata client. The Ingester calls it when the API revoked
+// the token ("") and once the device was paired again. Like Reload, it leaves
+// Cfg untouched; the token is recorded as applied so a later Reload does not
+// report it as requiring a restart.
+func (d *Daemon) saveAuthToken(token string) error {
+	d.reloadMu.Lock()
+	defer d.reloadMu.Unlock()
+
+	if d.ApiClient != nil {
+		d.ApiClient.SetAuth(token, d.Cfg.AuthScheme)
+	}
+	current := d.reloaded
+	if current == nil {
+		current = d.Cfg
+	}
+	applied := *current
+	applied.AuthToken = token
+	d.reloaded = &applied
+
+	cfg, err := config.Load(d.CfgPath)
+	if err != nil {
+		return err
+	}
+	cfg.AuthToken = token
+	return config.Save(d.CfgPath, cfg)
+}
+
+// checkLiveSettings rejects values Load accepts but the components cannot run with.
+func checkLiveSettings(c *config.Config) error {
+	if c.IngestBatchSize <= 0 {
+		return fmt.Errorf("invalid ingest_batch_size %d: must be greater than 0", c.IngestBatchSize)
+	}
+	if d, err := time.ParseDuration(c.IngestCheckInterval); err != nil || d <= 0 {
+		return fmt.Errorf("invalid ingest_check_interval %q: must be a positive duration", c.IngestCheckInterval)
+	}
+	low, high := c.PruneLowWatermarkPercent, c.PruneHighWatermarkPercent
+	if low < 0 || high > 100 || low >= high {
+		return fmt.Errorf("invalid prune watermarks (low %d, high %d): need 0 <= low < high <= 100", low, high)
+	}
+	if c.MaxDataSizeGB <= 0 {
+		return fmt.Errorf("invalid max_data_size_gb %g: must be greater than 0", c.MaxDataSizeGB)
+	}
+	return nil
+}
+
+// changedSettings returns the JSON names of the settings that differ between a and b.
+func changedSettings(a, b *config.Config) []string {
+	va, vb := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+	var names []string
+	for i := 0; i < va.NumField(); i++ {
+		if reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			continue
+		}
+		field := va.Type().Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" {
+			name = field.Name
+		}
+		names = append(names, name)
+	}
+	return names
+}
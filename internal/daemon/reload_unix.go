@@ -0,0 +1,38 @@
+//go:build !windows
+
+package daemon
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchReloadSignal calls Reload whenever the process receives SIGHUP. It
+// returns a function that stops watching.
+func (d *Daemon) watchReloadSignal() func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-signals:
+				if d.Logger != nil {
+					d.Logger.Info("Received SIGHUP, reloading configuration", "path", d.CfgPath)
+				}
+				if err := d.Reload(); err != nil && d.Logger != nil {
+					d.Logger.Error("Failed to reload configuration, keeping the current one", "error", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
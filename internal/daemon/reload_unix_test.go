@@ -0,0 +1,103 @@
+//go:build !windows
+
+package daemon
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"fs-ingest-daemon/internal/config"
+	"fs-ingest-daemon/internal/pruner"
+	"fs-ingest-daemon/internal/store"
+)
+
+func TestSIGHUPReloadsPruneWatermarks(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "daemon_reload_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s, err := store.NewStore(filepath.Join(tmpDir, "fsd.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// 10 uploaded files of 100 bytes use 50% of a 2000 byte limit.
+	for n := 0; n < 10; n++ {
+		trackUploaded(t, s, filepath.Join(tmpDir, "data", fmt.Sprintf("img%d.png", n)), strings.Repeat("x", 100))
+	}
+
+	cfgPath := filepath.Join(tmpDir, "config.json")
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.WatchPath = filepath.Join(tmpDir, "data")
+	cfg.MaxDataSizeGB = 2000.0 / (1 << 30)
+	cfg.PruneHighWatermarkPercent = 90
+	cfg.PruneLowWatermarkPercent = 75
+	if err := config.Save(cfgPath, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	d := &Daemon{
+		Logger:    logger,
+		Cfg:       cfg,
+		CfgPath:   cfgPath,
+		DbStore:   s,
+		PrunerSvc: pruner.NewPruner(cfg, s, logger),
+	}
+	stop := d.watchReloadSignal()
+	defer stop()
+
+	d.PrunerSvc.Prune()
+	if total, _ := s.GetTotalSize(); total != 1000 {
+		t.Fatalf("Expected nothing to be pruned below the high watermark, total size %d", total)
+	}
+
+	// Lower the watermarks; WatchPath changes too but needs a restart.
+	edited := *cfg
+	edited.PruneHighWatermarkPercent = 40
+	edited.PruneLowWatermarkPercent = 20
+	edited.WatchPath = filepath.Join(tmpDir, "other")
+	if err := config.Save(cfgPath, &edited); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		d.PrunerSvc.Prune()
+		total, err := s.GetTotalSize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total <= 400 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the pruner to use the reloaded low watermark (400 bytes), total size %d", total)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if cfg.PruneHighWatermarkPercent != 90 || cfg.WatchPath != filepath.Join(tmpDir, "data") {
+		t.Error("Expected Cfg to be left unchanged by the reload")
+	}
+	d.reloadMu.Lock()
+	defer d.reloadMu.Unlock()
+	if got := d.reloaded.WatchPath; got != cfg.WatchPath {
+		t.Errorf("Expected the WatchPath change to be ignored until restart, got %s", got)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build windows
+
+package daemon
+
+// watchReloadSignal is a no-op on Windows, which has no SIGHUP; the service
+// must be restarted to pick up configuration changes.
+func (d *Daemon) watchReloadSignal() func() {
+	return func() {}
+}
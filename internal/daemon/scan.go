@@ -0,0 +1,139 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"fs-ingest-daemon/internal/store"
+)
+
+// scanIndex tells the startup scan which files are already tracked with the
+// same size and mod time, so they are not re-registered (and re-uploaded).
+//
+// In "memory" mode all fingerprints are loaded once. In "chunked" mode only the
+// fingerprints of the directory being walked are held, trading one query per
+// directory for memory that does not grow with the size of the tree.
+type scanIndex struct {
+	store   *store.Store
+	chunked bool
+
+	mu           sync.Mutex
+	fingerprints map[string]store.Fingerprint
+	dir          string // Directory whose fingerprints are loaded (chunked mode)
+}
+
+// newScanIndex creates an index for the given ScanFingerprintMode.
+func newScanIndex(s *store.Store, mode string) (*scanIndex, error) {
+	x := &scanIndex{store: s, chunked: mode == "chunked"}
+	if x.chunked {
+		return x, nil
+	}
+	fingerprints, err := s.Fingerprints()
+	if err != nil {
+		return nil, err
+	}
+	x.fingerprints = fingerprints
+	return x, nil
+}
+
+// unchanged reports whether path is tracked with the size and mod time in info.
+func (x *scanIndex) unchanged(path string, info os.FileInfo) (bool, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if x.chunked {
+		if dir := filepath.Dir(path); x.fingerprints == nil || dir != x.dir {
+			fingerprints, err := x.store.FingerprintsInDir(dir)
+			if err != nil {
+				return false, err
+			}
+			x.fingerprints, x.dir = fingerprints, dir
+		}
+	}
+
+	fp, ok := x.fingerprints[path]
+	return ok && fp.Size == info.Size() && fp.ModTime.Equal(info.ModTime()), nil
+}
+
+// skipUnchanged reports whether the startup scan may skip path because it is
+// already tracked and has not changed. It is always false outside the scan.
+func (d *Daemon) skipUnchanged(path string, info os.FileInfo) bool {
+	x := d.scanIdx.Load()
+	if x == nil {
+		return false
+	}
+	unchanged, err := x.unchanged(path, info)
+	if err != nil {
+		if d.Logger != nil {
+			d.Logger.Error("Failed to look up file fingerprint, registering it", "path", path, "error", err)
+		}
+		return false
+	}
+	return unchanged
+}
+
+// scanGuard ensures only one walk of the watch directory runs at a time, so
+// rescans triggered in bursts (mount flaps, event loss) do not thrash the disk.
+// A request of a kind that is already queued behind the running walk is
+// coalesced into it: the queued walk has not started yet and covers it.
+type scanGuard struct {
+	walkMu  sync.Mutex // Held by the running walk
+	running atomic.Bool
+
+	mu     sync.Mutex
+	queued map[string]bool // Kinds waiting for walkMu
+}
+
+// run calls walk once no other walk is running. It reports false if an equal
+// request was already waiting and this one was dropped.
+func (g *scanGuard) run(kind string, walk func()) bool {
+	g.mu.Lock()
+	if g.queued[kind] {
+		g.mu.Unlock()
+		return false
+	}
+	if g.queued == nil {
+		g.queued = make(map[string]bool)
+	}
+	g.queued[kind] = true
+	g.mu.Unlock()
+
+	g.walkMu.Lock()
+	defer g.walkMu.Unlock()
+
+	g.mu.Lock()
+	delete(g.queued, kind)
+	g.mu.Unlock()
+
+	g.running.Store(true)
+	defer g.running.Store(false)
+	walk()
+	return true
+}
+
+// exclusiveWalk runs walk under the daemon's scan guard, see scanGuard.
+func (d *Daemon) exclusiveWalk(kind string, walk func()) {
+	if !d.scans.run(kind, walk) && d.Logger != nil {
+		d.Logger.Debug("Scan already queued, coalescing request", "kind", kind)
+	}
+}
+
+// ScanInProgress reports whether a walk of the watch directory is running.
+func (d *Daemon) ScanInProgress() bool {
+	return d.scans.running.Load()
+}
+
+// Scan walks WatchPath once and registers the files that are not tracked yet,
+// applying the same exclusions and filters as the watcher (see processFile).
+// It is meant for `fsd scan` and only needs Cfg, DbStore and Logger, not a
+// started daemon. It returns the number of files registered.
+func (d *Daemon) Scan() (int, error) {
+	d.DbStore.SetReuploadOnModify(d.Cfg.ReuploadOnModify)
+	d.DbStore.SetSidecarMaxSize(int64(d.Cfg.SidecarMaxSizeKB) * 1024)
+	if d.Cfg.IgnoreFile != "" {
+		d.reloadIgnoreFile()
+	}
+	return d.registerUntracked(0)
+}
@@ -0,0 +1,210 @@
+package daemon
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"fs-ingest-daemon/internal/config"
+	"fs-ingest-daemon/internal/store"
+)
+
+// trackUploaded writes a file, registers it with its real size and mod time and marks it UPLOADED.
+func trackUploaded(t *testing.T, s *store.Store, path string, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterFile(path, info.Size(), info.ModTime(), false, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MarkUploaded(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScanIndexChunkedSkipsUnchangedPerDirectory(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "daemon_scan_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s, err := store.NewStore(filepath.Join(tmpDir, "fsd.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	watchDir := filepath.Join(tmpDir, "data")
+	a1 := filepath.Join(watchDir, "cam1", "a1.png")
+	a2 := filepath.Join(watchDir, "cam1", "a2.png")
+	nested := filepath.Join(watchDir, "cam1", "night", "n1.png")
+	b1 := filepath.Join(watchDir, "cam2", "b1.png")
+	for _, p := range []string{a1, a2, nested, b1} {
+		trackUploaded(t, s, p, "data")
+	}
+	// a2 is overwritten while the daemon is down.
+	if err := os.WriteFile(a2, []byte("new data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err := newScanIndex(s, "chunked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(path string, want bool) {
+		t.Helper()
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := idx.unchanged(path, info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("unchanged(%s) = %v, want %v", path, got, want)
+		}
+	}
+
+	check(a1, true)
+	if n := len(idx.fingerprints); n != 2 {
+		t.Errorf("Expected only the 2 records of cam1 to be loaded, got %d", n)
+	}
+	check(a2, false)
+	check(nested, true)
+	check(b1, true)
+	if n := len(idx.fingerprints); n != 1 {
+		t.Errorf("Expected only the record of cam2 to be loaded, got %d", n)
+	}
+}
+
+func TestStartupScanDoesNotReregisterUnchangedFiles(t *testing.T) {
+	for _, mode := range []string{"memory", "chunked"} {
+		t.Run(mode, func(t *testing.T) {
+			tmpDir, err := os.MkdirTemp("", "daemon_scan_test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tmpDir)
+
+			s, err := store.NewStore(filepath.Join(tmpDir, "fsd.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			path := filepath.Join(tmpDir, "data", "img.png")
+			trackUploaded(t, s, path, "data")
+
+			d := &Daemon{
+				Logger:  slog.New(slog.NewTextHandler(os.Stdout, nil)),
+				Cfg:     &config.Config{AllowedExtensions: []string{".png"}, SidecarStrategy: "none"},
+				DbStore: s,
+			}
+			idx, err := newScanIndex(s, mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d.scanIdx.Store(idx)
+			d.processFile(path)
+
+			uploaded, err := s.ListFiles(store.StatusUploaded, 10)
+			if err != nil || len(uploaded) != 1 {
+				t.Fatalf("Expected the unchanged file to stay UPLOADED, got %d (err=%v)", len(uploaded), err)
+			}
+
+			// Outside the startup scan, events are registered; the store
+			// re-queues the file once it was modified.
+			d.scanIdx.Store(nil)
+			if err := os.WriteFile(path, []byte("modified"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			d.processFile(path)
+			if pending, _ := s.GetPendingFiles(10); len(pending) != 1 {
+				t.Error("Expected a watcher event to re-register the modified file")
+			}
+		})
+	}
+}
+
+func TestScanGuardRunsOneWalkAtATime(t *testing.T) {
+	d := &Daemon{}
+
+	var active, maxActive, walks int32
+	walk := func() {
+		n := atomic.AddInt32(&active, 1)
+		for {
+			m := atomic.LoadInt32(&maxActive)
+			if n <= m || atomic.CompareAndSwapInt32(&maxActive, m, n) {
+				break
+			}
+		}
+		atomic.AddInt32(&walks, 1)
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&active, -1)
+	}
+
+	// Hold a first walk open while a burst of rescans of two kinds comes in.
+	release := make(chan struct{})
+	started := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		d.scans.run("startup", func() {
+			close(started)
+			<-release
+			walk()
+		})
+	}()
+	<-started
+	if !d.ScanInProgress() {
+		t.Error("Expected a scan to be reported in progress")
+	}
+
+	var dropped int32
+	for i := 0; i < 10; i++ {
+		kind := "incremental"
+		if i%2 == 0 {
+			kind = "full"
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !d.scans.run(kind, walk) {
+				atomic.AddInt32(&dropped, 1)
+			}
+		}()
+	}
+
+	// One request per kind queues up behind the running walk, the rest coalesce.
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt32(&dropped) < 8 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if m := atomic.LoadInt32(&maxActive); m != 1 {
+		t.Errorf("Expected walks to run one at a time, saw %d concurrently", m)
+	}
+	if n, dr := atomic.LoadInt32(&walks), atomic.LoadInt32(&dropped); n != 3 || dr != 8 {
+		t.Errorf("Expected 3 walks with 8 coalesced requests, got %d walks and %d coalesced", n, dr)
+	}
+	if d.ScanInProgress() {
+		t.Error("Expected no scan in progress after all walks finished")
+	}
+}
@@ -0,0 +1,41 @@
+package daemon
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// tracingShutdownTimeout bounds how long Stop waits to export the remaining spans.
+const tracingShutdownTimeout = 5 * time.Second
+
+// newTracerProvider returns a provider exporting spans in batches to the
+// OTLP/HTTP collector at endpoint. Spans are tagged with the device ID.
+func newTracerProvider(endpoint, deviceID string) (*sdktrace.TracerProvider, error) {
+	exp, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, err
+	}
+	res := resource.NewSchemaless(
+		attribute.String("service.name", "fsd"),
+		attribute.String("device.id", deviceID),
+	)
+	return sdktrace.NewTracerProvider(sdktrace.WithBatcher(exp), sdktrace.WithResource(res)), nil
+}
+
+// shutdownTracing flushes the spans not exported yet and stops the exporter.
+func (d *Daemon) shutdownTracing() {
+	if d.tracerProvider == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+	defer cancel()
+	if err := d.tracerProvider.Shutdown(ctx); err != nil && d.Logger != nil {
+		d.Logger.Warn("Failed to flush traces", "endpoint", d.Cfg.OTLPEndpoint, "error", err)
+	}
+	d.tracerProvider = nil
+}
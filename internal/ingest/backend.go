@@ -0,0 +1,191 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"fs-ingest-daemon/internal/api"
+	"fs-ingest-daemon/internal/config"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Backend is a destination files are ingested into, in three steps: reserve an
+// upload for the ingest request, write the file content, confirm the outcome.
+// The Ingestion API (handshake, presigned PUT, confirm) is the default; the
+// "local" and "s3" backends let the daemon's watch and prune machinery work
+// without the hosted API.
+type Backend interface {
+	// RequestUpload reserves an upload of the file at path, described by req.
+	RequestUpload(ctx context.Context, req api.IngestRequest, path string) (*UploadTarget, error)
+	// Upload writes the content of the file at path to target.
+	Upload(ctx context.Context, target *UploadTarget, path string) error
+	// Confirm finalizes target: uploadErr is nil after a successful Upload, or
+	// the reason it failed so the backend can clean up. ctx may already be
+	// cancelled when a shutdown interrupted the upload.
+	Confirm(ctx context.Context, target *UploadTarget, uploadErr error) error
+}
+
+// UploadTarget is an upload reserved by Backend.RequestUpload.
+type UploadTarget struct {
+	ID      string            // Identifies the upload, e.g. the API's handshake ID or an object key
+	URL     string            // Where the content is written: presigned URL, object URL or file path
+	Request api.IngestRequest // The request the upload was reserved for
+
+	// DuplicateOf is set instead of uploading when the content was already
+	// ingested as this file (path relative to WatchPath), see confirmDuplicate.
+	DuplicateOf string
+
+	path  string              // Local file being uploaded, set by sendTo for logs
+	resp  *api.IngestResponse // API backend: the handshake response
+	parts []api.MultipartPart // API backend: parts stored by a multipart upload
+}
+
+// NewBackend returns the backend selected by cfg.IngestBackend ("api",
+// "local" or "s3").
+func NewBackend(cfg *config.Config, u *Uploader) (Backend, error) {
+	switch cfg.IngestBackend {
+	case "", "api":
+		return &httpTransport{u: u, client: u.apiClient, backoff: &u.backoff}, nil
+	case "local":
+		return newLocalBackend(cfg, u)
+	case "s3":
+		return newS3Backend(cfg, u)
+	default:
+		return nil, fmt.Errorf("unknown ingest backend %q", cfg.IngestBackend)
+	}
+}
+
+// sendTo delivers the file at path through b. Errors are prefixed with the
+// failed stage like those of a Transport; a failed upload is confirmed as
+// failed on a best-effort basis. Each stage is traced as a child of the span
+// in ctx.
+func sendTo(ctx context.Context, b Backend, req api.IngestRequest, path string) error {
+	var target *UploadTarget
+	err := traceStage(ctx, "ingest_request", func(ctx context.Context) (err error) {
+		target, err = b.RequestUpload(ctx, req, path)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("ingest: %w", err)
+	}
+	target.path = path
+
+	// Confirms are sent even after a shutdown cancelled the upload.
+	confirmCtx := context.WithoutCancel(ctx)
+	if err := traceStage(ctx, "upload", func(ctx context.Context) error { return b.Upload(ctx, target, path) }); err != nil {
+		_ = traceStage(confirmCtx, "confirm", func(ctx context.Context) error { return b.Confirm(ctx, target, err) })
+		return fmt.Errorf("upload: %w", err)
+	}
+	if err := traceStage(confirmCtx, "confirm", func(ctx context.Context) error { return b.Confirm(ctx, target, nil) }); err != nil {
+		return fmt.Errorf("confirm: %w", err)
+	}
+	return nil
+}
+
+// confirmDuplicate records the file at path as a duplicate of original
+// through b: an upload is reserved and confirmed with target.DuplicateOf set,
+// but the content is not sent.
+func confirmDuplicate(ctx context.Context, b Backend, req api.IngestRequest, path, original string) error {
+	var target *UploadTarget
+	err := traceStage(ctx, "ingest_request", func(ctx context.Context) (err error) {
+		target, err = b.RequestUpload(ctx, req, path)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("ingest: %w", err)
+	}
+	target.path = path
+	target.DuplicateOf = original
+	if err := traceStage(context.WithoutCancel(ctx), "confirm", func(ctx context.Context) error { return b.Confirm(ctx, target, nil) }); err != nil {
+		return fmt.Errorf("confirm: %w", err)
+	}
+	return nil
+}
+
+// duplicateSender is implemented by transports that can record a file as a
+// duplicate of one already ingested without sending its content, see
+// Config.DeduplicateByHash.
+type duplicateSender interface {
+	SendDuplicate(ctx context.Context, req api.IngestRequest, path, original string) error
+}
+
+// duplicateMeta is the metadata stored by the local and s3 backends for a
+// duplicate: the ingest request and the original it refers to.
+type duplicateMeta struct {
+	api.IngestRequest
+	DuplicateOf string `json:"duplicate_of"`
+}
+
+// targetMeta returns the metadata the local and s3 backends store next to the
+// file of target.
+func targetMeta(target *UploadTarget) any {
+	if target.DuplicateOf != "" {
+		return duplicateMeta{target.Request, target.DuplicateOf}
+	}
+	return target.Request
+}
+
+// objectPath returns the path of the file at path relative to WatchPath, with
+// forward slashes, under which the local and s3 backends store it. Unlike the
+// request's directory context it is neither sanitized nor truncated, so
+// distinct files never share a key. A file outside WatchPath (e.g. the target
+// of a followed symlink) is stored under the request's context and filename.
+func (u *Uploader) objectPath(req api.IngestRequest, path string) string {
+	if rel, err := filepath.Rel(u.cfg.WatchPath, path); err == nil && filepath.IsLocal(rel) {
+		return filepath.ToSlash(rel)
+	}
+	return strings.Join(append(slices.Clone(req.FilePathContext), req.Filename), "/")
+}
+
+// tracerName names the tracer of the upload pipeline's spans.
+const tracerName = "fs-ingest-daemon/internal/ingest"
+
+// traceStage runs fn in a span named name, a child of the span in ctx (a
+// no-op without one), and records its error.
+func traceStage(ctx context.Context, name string, fn func(ctx context.Context) error) error {
+	ctx, span := trace.SpanFromContext(ctx).TracerProvider().Tracer(tracerName).Start(ctx, name)
+	defer span.End()
+	err := fn(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+// backendTransport sends files to a Backend other than the Ingestion API.
+// Network failures back off uploads like an unreachable API.
+type backendTransport struct {
+	u       *Uploader
+	backend Backend
+	name    string // Backend name in logs
+}
+
+func (t *backendTransport) Send(ctx context.Context, req api.IngestRequest, path string) error {
+	return t.send(ctx, path, func() error { return sendTo(ctx, t.backend, req, path) })
+}
+
+func (t *backendTransport) SendDuplicate(ctx context.Context, req api.IngestRequest, path, original string) error {
+	return t.send(ctx, path, func() error { return confirmDuplicate(ctx, t.backend, req, path, original) })
+}
+
+// send runs fn, which delivers the file at path, and backs off on network failures.
+func (t *backendTransport) send(ctx context.Context, path string, fn func() error) error {
+	u := t.u
+	if err := fn(); err != nil {
+		if ctx.Err() == nil && api.IsRetryable(err) {
+			wait := u.networkFailed()
+			u.logger.Warn("Ingester: Backend unreachable, backing off", "backend", t.name, "path", path, "backoff", wait, "error", err)
+		} else {
+			u.logger.Error("Ingester: Upload to backend failed", "backend", t.name, "path", path, "error", err)
+		}
+		return err
+	}
+	u.networkRecovered()
+	return nil
+}
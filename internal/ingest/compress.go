@@ -0,0 +1,80 @@
+package ingest
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// compressible reports whether path is uploaded gzipped: CompressUploads is
+// set and its extension is one of CompressibleExtensions.
+func (u *Uploader) compressible(path string) bool {
+	if !u.cfg.CompressUploads {
+		return false
+	}
+	ext := filepath.Ext(path)
+	for _, e := range u.cfg.CompressibleExtensions {
+		if strings.EqualFold(ext, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// uploadCompressed PUTs file gzipped with "Content-Encoding: gzip". Presigned
+// URLs need the exact Content-Length, so the file is compressed into a
+// temporary file first, which is removed afterwards. The compressed size is
+// kept in compressedSizes for the success log.
+func (u *Uploader) uploadCompressed(ctx context.Context, url, path string, file io.Reader, header http.Header) error {
+	tmp, err := toTempFile(func(w io.Writer) error {
+		zw := gzip.NewWriter(w)
+		if _, err := io.Copy(zw, file); err != nil {
+			return err
+		}
+		return zw.Close()
+	})
+	if err != nil {
+		return fmt.Errorf("failed to compress file: %w", err)
+	}
+	defer removeTempFile(tmp)
+
+	info, err := tmp.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat compressed file: %w", err)
+	}
+	header.Set("Content-Encoding", "gzip")
+	if _, err := u.putWithRetry(ctx, url, path, tmp, 0, info.Size(), header); err != nil {
+		return err
+	}
+	u.compressedSizes.Store(path, info.Size())
+	return nil
+}
+
+// toTempFile writes the output of write into a new temporary file and returns
+// it rewound. The caller releases it with removeTempFile.
+func toTempFile(write func(w io.Writer) error) (*os.File, error) {
+	tmp, err := os.CreateTemp("", "fsd-upload-*")
+	if err != nil {
+		return nil, err
+	}
+	err = write(tmp)
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		removeTempFile(tmp)
+		return nil, err
+	}
+	return tmp, nil
+}
+
+// removeTempFile closes and deletes a file from toTempFile.
+func removeTempFile(f *os.File) {
+	f.Close()
+	os.Remove(f.Name())
+}
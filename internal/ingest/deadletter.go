@@ -0,0 +1,95 @@
+package ingest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"fs-ingest-daemon/internal/config"
+	"fs-ingest-daemon/internal/store"
+)
+
+// deadLetterEntry is the manifest written for a file the API rejected
+// permanently. It references the file in place instead of copying it.
+type deadLetterEntry struct {
+	Path        string    `json:"path"`
+	PartnerPath string    `json:"partner_path,omitempty"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"mod_time"`
+	Error       string    `json:"error"`
+	FailedAt    time.Time `json:"failed_at"`
+}
+
+// deadLetterDir keeps one manifest entry per permanently failed file in
+// DeadLetterDir for external tooling. Entries are removed once the file uploads.
+type deadLetterDir struct {
+	cfg *config.Config
+	dir string
+}
+
+// newDeadLetterDir returns nil if no DeadLetterDir is configured.
+func newDeadLetterDir(cfg *config.Config) *deadLetterDir {
+	if cfg.DeadLetterDir == "" {
+		return nil
+	}
+	return &deadLetterDir{cfg: cfg, dir: cfg.DeadLetterDir}
+}
+
+// entryPath names the entry after the file, with a hash of the full path so
+// files with the same name in different directories do not collide.
+func (d *deadLetterDir) entryPath(path string) string {
+	sum := sha256.Sum256([]byte(path))
+	return filepath.Join(d.dir, filepath.Base(path)+"."+hex.EncodeToString(sum[:6])+".json")
+}
+
+// record writes (or replaces) the entry for f.
+func (d *deadLetterDir) record(f store.FileRecord, cause error) error {
+	if d == nil {
+		return nil
+	}
+	if err := d.cfg.MkdirAll(d.dir); err != nil {
+		return err
+	}
+
+	entry := deadLetterEntry{
+		Path:     f.Path,
+		Size:     f.Size,
+		ModTime:  f.ModTime,
+		Error:    cause.Error(),
+		FailedAt: time.Now(),
+	}
+	if f.PartnerPath.Valid {
+		entry.PartnerPath = f.PartnerPath.String
+	}
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temp file and rename, so tooling never reads a partial entry.
+	target := d.entryPath(f.Path)
+	tmp := target + ".tmp"
+	if err := os.WriteFile(tmp, data, d.cfg.FilePerm()); err != nil {
+		return err
+	}
+	if err := d.cfg.ApplyFilePerms(tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, target)
+}
+
+// clear removes the entry for path, if any.
+func (d *deadLetterDir) clear(path string) error {
+	if d == nil {
+		return nil
+	}
+	if err := os.Remove(d.entryPath(path)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
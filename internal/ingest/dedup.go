@@ -0,0 +1,89 @@
+package ingest
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+
+	"fs-ingest-daemon/internal/api"
+	"fs-ingest-daemon/internal/store"
+)
+
+// hashClaims serializes the uploads of files with the same content, so of two
+// identical files processed at the same time one is uploaded and the other
+// finds it UPLOADED afterwards (see Uploader.findDuplicate).
+type hashClaims struct {
+	mu       sync.Mutex
+	inFlight map[string]chan struct{} // Closed when the upload of the hash is done
+}
+
+// claim waits until no other upload holds sum, then holds it until release
+// is called. It fails if ctx is cancelled while waiting.
+func (c *hashClaims) claim(ctx context.Context, sum string) (release func(), err error) {
+	for {
+		c.mu.Lock()
+		done, busy := c.inFlight[sum]
+		if !busy {
+			if c.inFlight == nil {
+				c.inFlight = make(map[string]chan struct{})
+			}
+			done = make(chan struct{})
+			c.inFlight[sum] = done
+			c.mu.Unlock()
+			return func() {
+				c.mu.Lock()
+				delete(c.inFlight, sum)
+				c.mu.Unlock()
+				close(done)
+			}, nil
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// findDuplicate returns the path of an UPLOADED file with the same content
+// (checksum and size) as f, or "" if f must be uploaded itself: deduplication
+// is off, the transport cannot record duplicates, or the checksum does not
+// cover the whole file.
+func (u *Uploader) findDuplicate(f store.FileRecord, req api.IngestRequest) string {
+	if !u.cfg.DeduplicateByHash || req.ChecksumMode != api.ChecksumModeFull {
+		return ""
+	}
+	if _, ok := u.transport.(duplicateSender); !ok {
+		return ""
+	}
+	original, err := u.store.FindUploadedByChecksumAndSize(req.SHA256Checksum, req.FileSizeBytes)
+	if err != nil {
+		u.logger.Warn("Ingester: Failed to look up uploaded files with the same checksum, uploading", "path", f.Path, "error", err)
+		return ""
+	}
+	if original == "" {
+		// Equal checksums of different sizes point at a file that was read or
+		// recorded wrongly; upload to be safe, but flag it.
+		if other, err := u.store.FindUploadedByHash(req.SHA256Checksum); err == nil && other != "" && other != f.Path {
+			u.logger.Warn("Ingester: Checksum matches an uploaded file of a different size, possible integrity issue; uploading",
+				"event", "ChecksumSizeMismatch", "path", f.Path, "size", req.FileSizeBytes, "sha256", req.SHA256Checksum, "uploaded_path", other)
+		}
+		return ""
+	}
+	if original == f.Path {
+		return ""
+	}
+	return original
+}
+
+// sendDuplicate records the file at path as a duplicate of original through
+// the transport. original is sent relative to WatchPath, like file contexts.
+func (u *Uploader) sendDuplicate(ctx context.Context, req api.IngestRequest, path, original string) error {
+	rel, err := filepath.Rel(u.cfg.WatchPath, original)
+	if err != nil {
+		rel = original
+	}
+	return u.transport.(duplicateSender).SendDuplicate(ctx, req, path, filepath.ToSlash(rel))
+}
@@ -0,0 +1,162 @@
+package ingest
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net/http"
+	"strconv"
+
+	"fs-ingest-daemon/internal/api"
+)
+
+// With EncryptUploads, uploads are encrypted with AES-256-GCM in chunks (the
+// STREAM construction), so files of any size are encrypted without holding
+// them in memory:
+//
+//   - the file is split into encryptionChunkSize chunks; an empty file is one
+//     empty chunk;
+//   - chunk i is sealed with the 12-byte nonce: the random 7-byte prefix, i as
+//     a big-endian uint32, then 1 for the last chunk and 0 otherwise;
+//   - the uploaded object is the sealed chunks back to back, each 16 bytes
+//     (the GCM tag) longer than its plaintext.
+//
+// The prefix is sent with the ingest request, see encryptRequest.
+const (
+	encryptionScheme    = "aes-256-gcm-stream"
+	encryptionChunkSize = 64 << 10
+	noncePrefixSize     = 7
+)
+
+// Metadata keys of the ingest request of an encrypted upload.
+const (
+	metaEncryption          = "encryption"            // encryptionScheme
+	metaEncryptionNonce     = "encryption_nonce"      // Nonce prefix, base64
+	metaEncryptionChunkSize = "encryption_chunk_size" // encryptionChunkSize
+)
+
+// newUploadCipher returns the AEAD for key, a base64-encoded 32-byte AES-256 key.
+func newUploadCipher(key string) (cipher.AEAD, error) {
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key is not base64: %w", err)
+	}
+	if len(raw) != 32 {
+		return nil, fmt.Errorf("encryption key has %d bytes, want 32", len(raw))
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptRequest returns req with a fresh nonce prefix and the encryption
+// scheme added to its metadata. The metadata map is copied, so retries of the
+// same request never reuse a nonce.
+func encryptRequest(req api.IngestRequest) (api.IngestRequest, error) {
+	prefix := make([]byte, noncePrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return req, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	meta := maps.Clone(req.Metadata)
+	if meta == nil {
+		meta = make(map[string]string)
+	}
+	meta[metaEncryption] = encryptionScheme
+	meta[metaEncryptionNonce] = base64.StdEncoding.EncodeToString(prefix)
+	meta[metaEncryptionChunkSize] = strconv.Itoa(encryptionChunkSize)
+	req.Metadata = meta
+	return req, nil
+}
+
+// uploadEncrypted PUTs path encrypted with the upload key and nonce, the
+// base64 prefix sent with the ingest request. Like compressed uploads it is
+// encrypted into a temporary file first, for the exact Content-Length, and is
+// not resumed. Encrypted files are not compressed.
+func (u *Uploader) uploadEncrypted(ctx context.Context, url, path, contentType, nonce string) error {
+	if u.uploadCipher == nil {
+		// Never fall back to a plaintext upload.
+		return errors.New("encrypt_uploads is set but encryption_key is not a valid key")
+	}
+	prefix, err := base64.StdEncoding.DecodeString(nonce)
+	if err != nil || len(prefix) != noncePrefixSize {
+		return fmt.Errorf("invalid encryption nonce %q", nonce)
+	}
+
+	file, err := u.openFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	tmp, err := toTempFile(func(w io.Writer) error { return encryptStream(u.uploadCipher, prefix, file, w) })
+	if err != nil {
+		return fmt.Errorf("failed to encrypt file: %w", err)
+	}
+	defer removeTempFile(tmp)
+
+	info, err := tmp.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat encrypted file: %w", err)
+	}
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	_, err = u.putWithRetry(ctx, url, path, tmp, 0, info.Size(), header)
+	return err
+}
+
+// encryptStream writes r to w encrypted as described above.
+func encryptStream(aead cipher.AEAD, prefix []byte, r io.Reader, w io.Writer) error {
+	nonce := make([]byte, aead.NonceSize())
+	copy(nonce, prefix)
+	cur := make([]byte, encryptionChunkSize)
+	next := make([]byte, encryptionChunkSize)
+	var sealed []byte
+
+	n, err := io.ReadFull(r, cur)
+	for counter := uint32(0); ; counter++ {
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		// A short read ends the file; after a full chunk, read ahead to
+		// know whether it is the last.
+		last := err != nil
+		var m int
+		if !last {
+			m, err = io.ReadFull(r, next)
+			if err == io.EOF {
+				last = true
+			} else if err != nil && err != io.ErrUnexpectedEOF {
+				return err
+			}
+		}
+
+		binary.BigEndian.PutUint32(nonce[noncePrefixSize:], counter)
+		nonce[len(nonce)-1] = 0
+		if last {
+			nonce[len(nonce)-1] = 1
+		}
+		sealed = aead.Seal(sealed[:0], nonce, cur[:n], nil)
+		if _, err := w.Write(sealed); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+		if counter == math.MaxUint32 {
+			return errors.New("file is too large to encrypt")
+		}
+		cur, next, n = next, cur, m
+	}
+}
@@ -0,0 +1,89 @@
+package ingest
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"fs-ingest-daemon/internal/api"
+	"fs-ingest-daemon/internal/store"
+)
+
+// Bounds of the in-memory upload attempt buffer.
+const (
+	attemptFlushInterval = time.Second // How often buffered attempts are written to the store
+	maxBufferedAttempts  = 1000        // Oldest attempts are dropped beyond this if the store falls behind
+)
+
+// attemptLog buffers upload attempts and writes them to the store in batches,
+// so recording history does not add a database write to every upload. A nil
+// attemptLog records nothing.
+type attemptLog struct {
+	store *store.Store
+	keep  int // Attempts kept per file
+
+	mu  sync.Mutex
+	buf []store.UploadAttempt
+}
+
+// newAttemptLog returns a log keeping the last keep attempts per file, or nil if keep is not positive.
+func newAttemptLog(s *store.Store, keep int) *attemptLog {
+	if keep <= 0 {
+		return nil
+	}
+	return &attemptLog{store: s, keep: keep}
+}
+
+// add buffers the outcome of an upload of path that started at start.
+func (l *attemptLog) add(path string, start time.Time, duration time.Duration, err error) {
+	if l == nil {
+		return
+	}
+	a := store.UploadAttempt{
+		Path:        path,
+		AttemptedAt: start,
+		Outcome:     store.AttemptSucceeded,
+		Duration:    duration,
+	}
+	if err != nil {
+		a.Outcome = store.AttemptFailed
+		a.HTTPStatus = httpStatus(err)
+		a.Error = err.Error()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.buf) >= maxBufferedAttempts {
+		l.buf = l.buf[1:]
+	}
+	l.buf = append(l.buf, a)
+}
+
+// flush writes the buffered attempts to the store.
+func (l *attemptLog) flush() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	batch := l.buf
+	l.buf = nil
+	l.mu.Unlock()
+	return l.store.AddUploadAttempts(batch, l.keep)
+}
+
+// httpStatus returns the status code of the API or storage response behind err, or 0.
+func httpStatus(err error) int {
+	var statusErr *api.StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode
+	}
+	var ctErr *api.ContentTypeError
+	if errors.As(err, &ctErr) {
+		return ctErr.StatusCode
+	}
+	var putErr *putStatusError
+	if errors.As(err, &putErr) {
+		return putErr.StatusCode
+	}
+	return 0
+}
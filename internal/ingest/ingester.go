@@ -5,50 +5,122 @@ package ingest
 // to the Uploader component.
 
 import (
+	"context"
+	"errors"
 	"fs-ingest-daemon/internal/api"
+	"fs-ingest-daemon/internal/clock"
 	"fs-ingest-daemon/internal/config"
+	"fs-ingest-daemon/internal/metrics"
+	"fs-ingest-daemon/internal/pairing"
 	"fs-ingest-daemon/internal/store"
+	"fs-ingest-daemon/internal/util"
+	"io"
 	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Ingester manages the file ingestion pipeline.
 type Ingester struct {
-	cfg       *config.Config // App configuration
-	store     *store.Store   // Local metadata database
-	uploader  *Uploader      // Worker that handles actual upload logic
-	logger    *slog.Logger   // Structured logger
-	stop      chan struct{}  // Channel to signal shutdown
+	cfg       atomic.Pointer[config.Config] // App configuration, replaced by SetConfig
+	store     *store.Store                  // Local metadata database
+	uploader  *Uploader                     // Worker that handles actual upload logic
+	logger    *slog.Logger                  // Structured logger
+	stop      chan struct{}                 // Channel to signal shutdown
+	reload    chan struct{}                 // Signals the poll loop that the config changed
+	ctx       context.Context               // Cancelled on shutdown to abort in-flight uploads and retry waits
+	cancel    context.CancelFunc
 	jobs      chan store.FileRecord
 	pending   map[string]struct{}
 	pendingMu sync.Mutex
 	wg        sync.WaitGroup
+	cursor    int64 // ID of the last pending file dispatched; the next batch continues after it, 0 = from the head
+
+	schedule        *util.Schedule // Windows during which uploads are dispatched, nil = always
+	rampUp          time.Duration  // RampUpDuration, 0 = no ramp-up
+	rampStart       time.Time      // When the ramp-up began, set by Start
+	clock           clock.Clock    // Drives the poll ticker and the schedule, see SetClock
+	outsideSchedule bool           // Last batch was skipped by the schedule, to log transitions once
+	pairingRequired atomic.Bool    // The API revoked the credentials, see authRevoked
+	pairingPoll     time.Duration  // Pairing status poll interval, 0 = pairing.DefaultPollInterval
+	pairingMetrics  pairing.Metrics
+
+	maintenanceMu    sync.Mutex
+	maintenanceUntil time.Time // Uploads pause until then while the backend is in maintenance, see pauseForMaintenance
+
+	// OnUploadResult, if set, is called after every upload attempt with the
+	// file and nil on success, or the error that left it queued for a retry.
+	// It runs on the upload worker, so it should return quickly; slow handlers
+	// hold up that worker. Set it before Start.
+	OnUploadResult func(store.FileRecord, error)
+
+	// SaveAuthToken, if set, persists the device's auth token: "" when the
+	// API revoked it and the new one once the device was paired again, see
+	// authRevoked. Set it before Start.
+	SaveAuthToken func(token string) error
 }
 
 // NewIngester creates a new Ingester instance.
 func NewIngester(cfg *config.Config, s *store.Store, logger *slog.Logger) *Ingester {
 	client := api.NewClient(cfg.Endpoint, cfg.APITimeout)
+	client.SetAuth(cfg.AuthToken, cfg.AuthScheme)
+	client.SetCompressRequests(cfg.CompressRequests)
+	client.SetCircuitBreaker(newCircuitBreaker(cfg, logger))
+	client.SetLogger(logger)
 	uploader := NewUploader(cfg, s, client, logger)
+	if transport, err := NewTransport(cfg, uploader); err != nil {
+		logger.Error("Failed to set up upload transport, falling back to HTTP", "transport", cfg.Transport, "error", err)
+	} else {
+		uploader.transport = transport
+	}
+	ctx, cancel := context.WithCancel(context.Background())
 
-	return &Ingester{
-		cfg:      cfg,
+	// The schedule is validated by config.Load; a failure here means cfg was built in code.
+	schedule, err := util.ParseSchedule(cfg.UploadSchedule, cfg.UploadScheduleTimezone)
+	if err != nil {
+		logger.Error("Invalid upload schedule, uploading at any time", "error", err)
+	}
+
+	var rampUp time.Duration
+	if cfg.RampUpDuration != "" {
+		if rampUp, err = time.ParseDuration(cfg.RampUpDuration); err != nil {
+			logger.Error("Invalid ramp-up duration, starting all workers at once", "error", err)
+		}
+	}
+
+	i := &Ingester{
 		store:    s,
 		uploader: uploader,
 		logger:   logger,
 		stop:     make(chan struct{}),
+		reload:   make(chan struct{}, 1),
+		ctx:      ctx,
+		cancel:   cancel,
 		jobs:     make(chan store.FileRecord, cfg.IngestBatchSize),
 		pending:  make(map[string]struct{}),
+		schedule: schedule,
+		rampUp:   rampUp,
+		clock:    clock.Real{},
 	}
+	i.cfg.Store(cfg)
+	return i
 }
 
 // Start initiates the background polling loop and workers.
 func (i *Ingester) Start() {
-	workerCount := i.cfg.IngestWorkerCount
+	workerCount := i.cfg.Load().IngestWorkerCount
 	if workerCount <= 0 {
 		workerCount = 1
 	}
 
+	i.rampStart = i.clock.Now()
+	if i.rampUp > 0 {
+		i.logger.Info("Ingester: Ramping up concurrent uploads", "workers", workerCount, "duration", i.rampUp)
+	}
+
 	for n := 0; n < workerCount; n++ {
 		i.wg.Add(1)
 		go func() {
@@ -57,21 +129,31 @@ func (i *Ingester) Start() {
 		}()
 	}
 
+	if i.uploader.attempts != nil {
+		i.wg.Add(1)
+		go func() {
+			defer i.wg.Done()
+			i.flushAttempts()
+		}()
+	}
+
 	i.wg.Add(1)
 	go func() {
 		defer i.wg.Done()
 		// Poll loop
-		interval, err := time.ParseDuration(i.cfg.IngestCheckInterval)
-		if err != nil {
-			interval = 2 * time.Second
-			i.logger.Error("Invalid ingest check interval, defaulting to 2s", "error", err)
-		}
-		ticker := time.NewTicker(interval)
-		defer ticker.Stop()
+		interval := i.pollInterval()
+		ticker := i.clock.NewTicker(interval)
+		defer func() { ticker.Stop() }()
 		for {
 			select {
-			case <-ticker.C:
+			case <-ticker.C():
 				i.processBatch()
+			case <-i.reload:
+				if next := i.pollInterval(); next != interval {
+					interval = next
+					ticker.Stop()
+					ticker = i.clock.NewTicker(interval)
+				}
 			case <-i.stop:
 				close(i.jobs)
 				return
@@ -80,23 +162,237 @@ func (i *Ingester) Start() {
 	}()
 }
 
-// Stop signals the polling loop to exit.
+// flushAttempts periodically writes the buffered upload history until Stop.
+func (i *Ingester) flushAttempts() {
+	ticker := i.clock.NewTicker(attemptFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			if err := i.uploader.attempts.flush(); err != nil {
+				i.logger.Error("Ingester: Failed to record upload history", "error", err)
+			}
+		case <-i.stop:
+			return
+		}
+	}
+}
+
+// pollInterval returns the configured IngestCheckInterval, or 2s if it is invalid.
+func (i *Ingester) pollInterval() time.Duration {
+	interval, err := time.ParseDuration(i.cfg.Load().IngestCheckInterval)
+	if err != nil || interval <= 0 {
+		interval = 2 * time.Second
+		i.logger.Error("Invalid ingest check interval, defaulting to 2s", "error", err)
+	}
+	return interval
+}
+
+// SetConfig replaces the configuration used for polling, e.g. after a reload.
+// A new IngestBatchSize applies to the next batch and a new IngestCheckInterval
+// restarts the poll ticker. Other settings are only read at construction.
+func (i *Ingester) SetConfig(cfg *config.Config) {
+	i.cfg.Store(cfg)
+	select {
+	case i.reload <- struct{}{}:
+	default: // a reload is already pending
+	}
+}
+
+// SetClock replaces the clock driving polling and the upload schedule.
+// It must be called before Start.
+func (i *Ingester) SetClock(c clock.Clock) {
+	i.clock = clock.OrReal(c)
+	i.uploader.clock = i.clock
+}
+
+// UploadMetrics are updated after every primary upload attempt. Nil metrics are not recorded.
+type UploadMetrics struct {
+	Uploaded *metrics.Counter   // Files uploaded and confirmed
+	Bytes    *metrics.Counter   // Bytes of the uploaded files
+	Failures *metrics.Counter   // Failed upload attempts
+	Duration *metrics.Histogram // Seconds from handshake to confirm of successful uploads
+}
+
+// SetMetrics sets the metrics updated by uploads. It must be called before Start.
+func (i *Ingester) SetMetrics(m UploadMetrics) {
+	i.uploader.metrics = m
+}
+
+// SetTracerProvider sets the provider of the spans traced for every upload
+// (see Uploader.Process). It must be called before Start.
+func (i *Ingester) SetTracerProvider(tp trace.TracerProvider) {
+	i.uploader.tracer = tp.Tracer(tracerName)
+}
+
+// Stop signals the polling loop to exit. Uploads in progress get
+// ShutdownGraceSeconds to finish before they are cancelled; their files stay
+// PENDING and are uploaded again after the next start.
 func (i *Ingester) Stop() {
 	close(i.stop)
-	i.wg.Wait()
+
+	done := make(chan struct{})
+	go func() {
+		i.wg.Wait()
+		close(done)
+	}()
+	if grace := time.Duration(i.cfg.Load().ShutdownGraceSeconds) * time.Second; grace > 0 {
+		i.pendingMu.Lock()
+		inFlight := len(i.pending)
+		i.pendingMu.Unlock()
+		if inFlight > 0 {
+			i.logger.Info("Ingester: Waiting for in-flight uploads to finish", "uploads", inFlight, "grace", grace)
+		}
+		select {
+		case <-done:
+		case <-i.clock.After(grace):
+			i.logger.Warn("Ingester: Shutdown grace period elapsed, cancelling in-flight uploads", "grace", grace)
+		}
+	}
+	i.cancel()
+	<-done
+
+	// Workers are done, so this writes the last attempts.
+	if err := i.uploader.attempts.flush(); err != nil {
+		i.logger.Error("Ingester: Failed to record upload history", "error", err)
+	}
+
+	if closer, ok := i.uploader.transport.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			i.logger.Warn("Ingester: Failed to close transport", "error", err)
+		}
+	}
+}
+
+// newCircuitBreaker creates the API circuit breaker from config, or nil if disabled.
+func newCircuitBreaker(cfg *config.Config, logger *slog.Logger) *api.CircuitBreaker {
+	if cfg.CircuitBreakerThreshold <= 0 {
+		return nil
+	}
+	cooldown, err := time.ParseDuration(cfg.CircuitBreakerCooldown)
+	if err != nil || cooldown <= 0 {
+		cooldown = 30 * time.Second
+		logger.Warn("Invalid circuit breaker cooldown, defaulting to 30s", "value", cfg.CircuitBreakerCooldown, "error", err)
+	}
+	b := api.NewCircuitBreaker(cfg.CircuitBreakerThreshold, cooldown)
+	b.OnStateChange = func(from, to api.BreakerState) {
+		if to == api.BreakerOpen {
+			logger.Warn("API circuit breaker opened, pausing uploads", "from", from.String(), "cooldown", cooldown)
+		} else {
+			logger.Info("API circuit breaker state changed", "from", from.String(), "to", to.String())
+		}
+	}
+	return b
+}
+
+// CircuitBreaker returns the API circuit breaker (nil if disabled) so other
+// API clients can share it.
+func (i *Ingester) CircuitBreaker() *api.CircuitBreaker {
+	return i.uploader.apiClient.Breaker()
+}
+
+// Stats returns the upload outcome counters used for health reporting.
+func (i *Ingester) Stats() *Stats {
+	return i.uploader.stats
+}
+
+// InFlight returns the number of files currently queued or being uploaded.
+func (i *Ingester) InFlight() int {
+	i.pendingMu.Lock()
+	defer i.pendingMu.Unlock()
+	return len(i.pending)
 }
 
 // processBatch fetches a batch of PENDING files from the store and triggers their upload.
 func (i *Ingester) processBatch() {
-	// Fetch pending files based on batch size config
-	files, err := i.store.GetPendingFiles(i.cfg.IngestBatchSize)
-	if err != nil {
-		i.logger.Error("Ingester: Error fetching pending files", "error", err)
+	// Nothing can be uploaded until the device is paired again or the
+	// backend's maintenance is over.
+	if i.pairingRequired.Load() || i.inMaintenance() {
+		return
+	}
+
+	// Outside the upload schedule files stay PENDING until the next window.
+	if !i.inSchedule() {
 		return
 	}
 
-	for _, f := range files {
+	// The API was unreachable recently (e.g. DNS not ready yet) or the circuit
+	// breaker is open; leave files PENDING and try again later.
+	batchSize := i.cfg.Load().IngestBatchSize
+	var files []store.FileRecord
+	if !i.uploader.backingOff() && !i.uploader.apiClient.CircuitOpen() {
+		// Fetch pending files based on batch size config. Each batch continues
+		// after the previous one, so files still in flight (e.g. a slow or stuck
+		// upload at the head of the queue) do not take up every batch and keep
+		// newer files from idle workers.
+		var err error
+		files, err = i.store.GetPendingFilesAfter(i.cursor, batchSize)
+		if err != nil {
+			i.logger.Error("Ingester: Error fetching pending files", "error", err)
+			return
+		}
+		n := i.dispatch(files)
+		switch {
+		case n < len(files):
+			// The rest did not fit; the next batch starts with it.
+			if n > 0 {
+				i.cursor = files[n-1].ID
+			}
+		case len(files) == 0 || len(files) < batchSize:
+			// End of the queue; start over at the head, where retries and
+			// higher-priority files are waiting.
+			i.cursor = 0
+		default:
+			i.cursor = files[n-1].ID
+		}
+	}
+
+	// Backup copies only use what is left of the batch, so they never delay
+	// primary uploads.
+	if i.uploader.backup != nil && !i.uploader.backupBackoff.active(i.clock.Now()) && len(files) < batchSize {
+		backups, err := i.store.GetPendingBackups(batchSize - len(files))
+		if err != nil {
+			i.logger.Error("Ingester: Error fetching pending backups", "error", err)
+			return
+		}
+		i.dispatch(backups)
+	}
+}
+
+// rampLimit returns how many files may be in flight during the ramp-up after
+// Start: one at first, growing linearly to IngestWorkerCount by the end of
+// RampUpDuration. It returns 0 (no limit) once the ramp-up is over or if none
+// is configured. MaxUploadBytesPerSec still caps the combined rate on top.
+func (i *Ingester) rampLimit() int {
+	if i.rampUp <= 0 {
+		return 0
+	}
+	elapsed := i.clock.Now().Sub(i.rampStart)
+	if elapsed >= i.rampUp {
+		return 0
+	}
+	workers := i.cfg.Load().IngestWorkerCount
+	if workers <= 0 {
+		workers = 1
+	}
+	return 1 + int(int64(workers-1)*int64(elapsed)/int64(i.rampUp))
+}
+
+// dispatch queues files for the workers, skipping paths already in flight. It
+// returns how many of files it went through; it stops early when the ramp-up
+// limit is reached or the job queue is full.
+func (i *Ingester) dispatch(files []store.FileRecord) int {
+	limit := i.rampLimit()
+	for n, f := range files {
+		// A path stays in pending until its worker finishes, so a file that is
+		// overwritten (and re-registered) mid-upload is not dispatched twice; the
+		// newer version is picked up by a later batch once the upload completes.
 		i.pendingMu.Lock()
+		if limit > 0 && len(i.pending) >= limit {
+			// Ramping up; the rest waits for a later batch.
+			i.pendingMu.Unlock()
+			return n
+		}
 		if _, exists := i.pending[f.Path]; exists {
 			i.pendingMu.Unlock()
 			continue
@@ -108,21 +404,102 @@ func (i *Ingester) processBatch() {
 		case i.jobs <- f:
 			// successfully queued
 		default:
-			// Channel full, release pending lock and skip
+			// Channel full, release pending lock; the rest waits for a later batch
 			i.pendingMu.Lock()
 			delete(i.pending, f.Path)
 			i.pendingMu.Unlock()
 			i.logger.Warn("Ingest job queue full, skipping file", "path", f.Path)
+			return n
 		}
 	}
+	return len(files)
+}
+
+// inSchedule reports whether uploads may run now, logging when a window opens or closes.
+func (i *Ingester) inSchedule() bool {
+	allowed := i.schedule.Allows(i.clock.Now())
+	if allowed == !i.outsideSchedule {
+		return allowed
+	}
+	i.outsideSchedule = !allowed
+	if allowed {
+		i.logger.Info("Ingester: Upload window opened, resuming uploads", "schedule", i.cfg.Load().UploadSchedule)
+	} else {
+		i.logger.Info("Ingester: Outside upload window, queueing files until it opens", "schedule", i.cfg.Load().UploadSchedule)
+	}
+	return allowed
 }
 
 func (i *Ingester) worker() {
 	for f := range i.jobs {
-		i.uploader.Process(f)
+		select {
+		case <-i.stop:
+			// Stopping: files still queued stay PENDING for the next start.
+			i.pendingMu.Lock()
+			delete(i.pending, f.Path)
+			i.pendingMu.Unlock()
+			continue
+		default:
+		}
+		i.process(f)
+	}
+}
 
-		i.pendingMu.Lock()
-		delete(i.pending, f.Path)
-		i.pendingMu.Unlock()
+// process uploads one dispatched file and reacts to failures that concern all
+// uploads rather than the file.
+func (i *Ingester) process(f store.FileRecord) {
+	attempted, err := i.uploader.Process(i.ctx, f)
+	var maintenance *api.MaintenanceError
+	if errors.As(err, &maintenance) {
+		i.pauseForMaintenance(maintenance)
+	} else if err != nil && api.IsAuthRevoked(err) {
+		i.authRevoked(err)
+	}
+	if attempted && i.OnUploadResult != nil {
+		i.OnUploadResult(f, err)
+	}
+
+	i.pendingMu.Lock()
+	delete(i.pending, f.Path)
+	i.pendingMu.Unlock()
+}
+
+// defaultMaintenancePause is how long uploads pause for backend maintenance
+// when the backend does not suggest a duration.
+const defaultMaintenancePause = 5 * time.Minute
+
+// pauseForMaintenance pauses all uploads for the duration suggested by the
+// backend. Files stay PENDING without counting a failed attempt.
+func (i *Ingester) pauseForMaintenance(m *api.MaintenanceError) {
+	pause := m.RetryAfter
+	if pause <= 0 {
+		pause = defaultMaintenancePause
+	}
+	until := i.clock.Now().Add(pause)
+
+	i.maintenanceMu.Lock()
+	defer i.maintenanceMu.Unlock()
+	if !until.After(i.maintenanceUntil) {
+		return
+	}
+	if i.maintenanceUntil.IsZero() {
+		i.logger.Warn("Ingester: Backend is in maintenance, pausing uploads", "pause", pause, "error", m)
+	}
+	i.maintenanceUntil = until
+}
+
+// inMaintenance reports whether uploads are paused for backend maintenance,
+// logging when the pause ends.
+func (i *Ingester) inMaintenance() bool {
+	i.maintenanceMu.Lock()
+	defer i.maintenanceMu.Unlock()
+	if i.maintenanceUntil.IsZero() {
+		return false
+	}
+	if i.clock.Now().Before(i.maintenanceUntil) {
+		return true
 	}
+	i.maintenanceUntil = time.Time{}
+	i.logger.Info("Ingester: Backend maintenance window over, resuming uploads")
+	return false
 }
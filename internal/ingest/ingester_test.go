@@ -0,0 +1,501 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"fs-ingest-daemon/internal/api"
+	"fs-ingest-daemon/internal/clock"
+	"fs-ingest-daemon/internal/config"
+	"fs-ingest-daemon/internal/store"
+)
+
+func TestProcessBatch_RespectsUploadSchedule(t *testing.T) {
+	s, tmpDir := newTestStore(t)
+
+	path := filepath.Join(tmpDir, "img.png")
+	if err := s.RegisterFile(path, 4, time.Now(), false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{
+		Endpoint:               "http://unused.invalid",
+		APITimeout:             "5s",
+		IngestBatchSize:        10,
+		UploadSchedule:         "22:00-06:00",
+		UploadScheduleTimezone: "UTC",
+	}
+	i := NewIngester(cfg, s, slog.New(slog.NewTextHandler(os.Stdout, nil)))
+
+	clk := clock.NewFake(time.Date(2024, 5, 1, 14, 0, 0, 0, time.UTC))
+	i.SetClock(clk)
+
+	i.processBatch()
+	if n := len(i.jobs); n != 0 {
+		t.Fatalf("Expected no uploads dispatched outside the window, got %d", n)
+	}
+
+	// The window opens overnight.
+	clk.Advance(9 * time.Hour)
+	i.processBatch()
+	if n := len(i.jobs); n != 1 {
+		t.Fatalf("Expected the queued file to be dispatched once the window opens, got %d", n)
+	}
+	if f := <-i.jobs; f.Path != path {
+		t.Errorf("Expected %s to be dispatched, got %s", path, f.Path)
+	}
+}
+
+func TestIngester_OnUploadResult(t *testing.T) {
+	s, tmpDir := newTestStore(t)
+	srv := newMockAPI(t)
+	defer srv.Close()
+
+	good := filepath.Join(tmpDir, "good.png")
+	if err := os.WriteFile(good, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(tmpDir, "missing.png")
+	for _, p := range []string{good, missing} {
+		if err := s.RegisterFile(p, 4, time.Now(), false, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := &config.Config{
+		DeviceID:            "dev",
+		WatchPath:           tmpDir,
+		Endpoint:            srv.URL,
+		APITimeout:          "5s",
+		IngestCheckInterval: "10ms",
+		IngestBatchSize:     10,
+		IngestWorkerCount:   1,
+	}
+	i := NewIngester(cfg, s, slog.New(slog.NewTextHandler(os.Stdout, nil)))
+
+	type result struct {
+		f   store.FileRecord
+		err error
+	}
+	results := make(chan result, 10)
+	i.OnUploadResult = func(f store.FileRecord, err error) { results <- result{f, err} }
+	i.Start()
+	defer i.Stop()
+
+	got := make(map[string]error)
+	for len(got) < 2 {
+		select {
+		case r := <-results:
+			got[r.f.Path] = r.err
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timeout waiting for upload results, got %v", got)
+		}
+	}
+
+	if err := got[good]; err != nil {
+		t.Errorf("Expected nil error for %s, got %v", good, err)
+	}
+	if err := got[missing]; !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected a not-exist error for %s, got %v", missing, err)
+	}
+}
+
+func TestDispatchRampsUpConcurrency(t *testing.T) {
+	s, tmpDir := newTestStore(t)
+	for n := 0; n < 10; n++ {
+		path := filepath.Join(tmpDir, fmt.Sprintf("img%d.png", n))
+		if err := s.RegisterFile(path, 4, time.Now().Add(time.Duration(n)*time.Second), false, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := &config.Config{
+		Endpoint:          "http://unused.invalid",
+		APITimeout:        "5s",
+		IngestBatchSize:   10,
+		IngestWorkerCount: 4,
+		RampUpDuration:    "40s",
+	}
+	i := NewIngester(cfg, s, slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	clk := clock.NewFake(time.Now())
+	i.SetClock(clk)
+	i.rampStart = clk.Now() // as set by Start, without running workers
+
+	// Nothing completes, so every dispatched file stays in flight.
+	for _, step := range []struct {
+		advance  time.Duration
+		inFlight int
+	}{
+		{0, 1},
+		{10 * time.Second, 1},
+		{10 * time.Second, 2},
+		{10 * time.Second, 3},
+		{10 * time.Second, 10}, // ramp-up over, the whole batch is queued
+	} {
+		clk.Advance(step.advance)
+		i.processBatch()
+		if got := i.InFlight(); got != step.inFlight {
+			t.Fatalf("After %s: expected %d files in flight, got %d", clk.Now().Sub(i.rampStart), step.inFlight, got)
+		}
+	}
+}
+
+func TestIngesterRepairsAfterAuthRevoked(t *testing.T) {
+	s, tmpDir := newTestStore(t)
+
+	var mu sync.Mutex
+	claimed := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/v1/pairing/request":
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(api.PairingResponse{Code: "ABC123", ExpiresAt: time.Now().Add(time.Hour)})
+		case "/v1/pairing/status":
+			// Claimed on the second poll.
+			key := "new-key"
+			resp := api.PairingStatusResponse{Status: api.PairingStatusWaiting}
+			if claimed {
+				resp = api.PairingStatusResponse{Status: api.PairingStatusClaimed, APIKey: &key}
+			}
+			claimed = true
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(resp)
+		case "/v1/ingest/request":
+			if r.Header.Get("Authorization") != "Bearer new-key" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(api.IngestResponse{HandshakeID: "hs-1", UploadURL: "http://" + r.Host + "/upload", ExpiresAt: time.Now().Add(time.Hour)})
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(tmpDir, "img.png")
+	if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterFile(path, 4, time.Now(), false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{
+		DeviceID:            "dev",
+		WatchPath:           tmpDir,
+		Endpoint:            srv.URL,
+		APITimeout:          "5s",
+		AuthToken:           "revoked-key",
+		IngestCheckInterval: "10ms",
+		IngestBatchSize:     10,
+		IngestWorkerCount:   1,
+		MaxUploadRetries:    1,
+	}
+	i := NewIngester(cfg, s, slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	i.pairingPoll = 10 * time.Millisecond
+
+	saved := make(chan string, 10)
+	i.SaveAuthToken = func(token string) error {
+		saved <- token
+		return nil
+	}
+	results := make(chan error, 10)
+	i.OnUploadResult = func(f store.FileRecord, err error) { results <- err }
+	i.Start()
+	defer i.Stop()
+
+	if err := <-results; !api.IsAuthRevoked(err) {
+		t.Fatalf("Expected the first attempt to fail with revoked credentials, got %v", err)
+	}
+	for _, want := range []string{"", "new-key"} {
+		select {
+		case got := <-saved:
+			if got != want {
+				t.Fatalf("Expected auth token %q to be saved, got %q", want, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timeout waiting for auth token %q to be saved", want)
+		}
+	}
+
+	// Uploads resume with the new token; the revoked attempt did not count
+	// against max_upload_retries.
+	select {
+	case err := <-results:
+		if err != nil {
+			t.Fatalf("Expected the upload to succeed after re-pairing, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for the upload to resume")
+	}
+	if i.PairingRequired() {
+		t.Error("Expected the pairing required state to be cleared")
+	}
+}
+
+func TestIngesterPausesDuringMaintenance(t *testing.T) {
+	s, tmpDir := newTestStore(t)
+
+	var mu sync.Mutex
+	maintenance := true
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/v1/ingest/request":
+			requests++
+			if maintenance {
+				w.Header().Set("X-Maintenance", "true")
+				w.Header().Set("Retry-After", "60")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(api.IngestResponse{HandshakeID: "hs-1", UploadURL: "http://" + r.Host + "/upload", ExpiresAt: time.Now().Add(time.Hour)})
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(tmpDir, "img.png")
+	if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterFile(path, 4, time.Now(), false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{
+		DeviceID:         "dev",
+		WatchPath:        tmpDir,
+		Endpoint:         srv.URL,
+		APITimeout:       "5s",
+		IngestBatchSize:  10,
+		MaxUploadRetries: 1,
+	}
+	i := NewIngester(cfg, s, slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	clk := clock.NewFake(time.Now())
+	i.SetClock(clk)
+	s.SetClock(clk)
+
+	i.processBatch()
+	if n := len(i.jobs); n != 1 {
+		t.Fatalf("Expected 1 dispatched file, got %d", n)
+	}
+	i.process(<-i.jobs)
+
+	files, err := s.ListFiles(store.StatusPending, 10)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected the file to stay PENDING, got %d (err=%v)", len(files), err)
+	}
+	if files[0].RetryCount != 0 || files[0].NextRetryAt.Valid {
+		t.Errorf("Expected maintenance not to count as a failed attempt, got %+v", files[0])
+	}
+
+	// Uploads pause for the suggested minute.
+	clk.Advance(59 * time.Second)
+	i.processBatch()
+	if n := len(i.jobs); n != 0 {
+		t.Fatalf("Expected no uploads during maintenance, got %d", n)
+	}
+
+	mu.Lock()
+	maintenance = false
+	mu.Unlock()
+	clk.Advance(time.Second)
+	i.processBatch()
+	if n := len(i.jobs); n != 1 {
+		t.Fatalf("Expected uploads to resume after maintenance, got %d", n)
+	}
+	if _, err := i.uploader.Process(context.Background(), <-i.jobs); err != nil {
+		t.Fatalf("Expected the upload to succeed after maintenance, got %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if requests != 2 {
+		t.Errorf("Expected 2 ingest requests, got %d", requests)
+	}
+}
+
+func TestStopDrainsInFlightUploads(t *testing.T) {
+	tests := []struct {
+		name         string
+		grace        int
+		finish       bool // The PUT completes within the grace period
+		wantUploaded bool
+	}{
+		{"finishes within grace period", 5, true, true},
+		{"cancelled after grace period", 1, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, tmpDir := newTestStore(t)
+
+			started := make(chan struct{}, 1)
+			release := make(chan struct{})
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/v1/ingest/request":
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusCreated)
+					json.NewEncoder(w).Encode(api.IngestResponse{HandshakeID: "hs-1", UploadURL: "http://" + r.Host + "/upload", ExpiresAt: time.Now().Add(time.Hour)})
+				case "/upload":
+					io.Copy(io.Discard, r.Body)
+					started <- struct{}{}
+					select {
+					case <-release:
+						w.WriteHeader(http.StatusOK)
+					case <-r.Context().Done():
+					}
+				default:
+					w.WriteHeader(http.StatusOK)
+				}
+			}))
+			defer srv.Close()
+
+			path := filepath.Join(tmpDir, "big.png")
+			if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.RegisterFile(path, 4, time.Now(), false, false); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg := &config.Config{
+				DeviceID:             "dev",
+				WatchPath:            tmpDir,
+				Endpoint:             srv.URL,
+				APITimeout:           "30s",
+				IngestCheckInterval:  "10ms",
+				IngestBatchSize:      10,
+				IngestWorkerCount:    1,
+				ShutdownGraceSeconds: tt.grace,
+			}
+			i := NewIngester(cfg, s, slog.New(slog.NewTextHandler(os.Stdout, nil)))
+			i.Start()
+
+			select {
+			case <-started:
+			case <-time.After(5 * time.Second):
+				t.Fatal("Timeout waiting for the upload to start")
+			}
+			if tt.finish {
+				time.AfterFunc(100*time.Millisecond, func() { close(release) })
+			}
+
+			stopped := make(chan struct{})
+			go func() {
+				i.Stop()
+				close(stopped)
+			}()
+			select {
+			case <-stopped:
+			case <-time.After(time.Duration(tt.grace)*time.Second + 5*time.Second):
+				t.Fatal("Stop did not return after the grace period")
+			}
+
+			status := store.StatusPending
+			if tt.wantUploaded {
+				status = store.StatusUploaded
+			}
+			files, err := s.ListFiles(status, 10)
+			if err != nil || len(files) != 1 {
+				t.Fatalf("Expected the file to be %s, got %d such files (err=%v)", status, len(files), err)
+			}
+			if files[0].RetryCount != 0 {
+				t.Errorf("Expected no failed attempt recorded, got %d", files[0].RetryCount)
+			}
+		})
+	}
+}
+
+func TestStuckUploadDoesNotBlockNewerFiles(t *testing.T) {
+	s, tmpDir := newTestStore(t)
+
+	release := make(chan struct{})
+	defer close(release)
+	var mu sync.Mutex
+	var uploaded []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/ingest/request":
+			var req api.IngestRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(api.IngestResponse{HandshakeID: "hs-1", UploadURL: "http://" + r.Host + "/upload/" + req.Filename, ExpiresAt: time.Now().Add(time.Hour)})
+		case "/upload/stuck.png":
+			// Hangs until the test ends, like an upload to a stalled endpoint.
+			io.Copy(io.Discard, r.Body)
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		default:
+			io.Copy(io.Discard, r.Body)
+			mu.Lock()
+			uploaded = append(uploaded, filepath.Base(r.URL.Path))
+			mu.Unlock()
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer srv.Close()
+
+	// The stuck file is the oldest, so it heads every batch fetched from the start.
+	for n, name := range []string{"stuck.png", "a.png", "b.png"} {
+		path := filepath.Join(tmpDir, name)
+		if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.RegisterFile(path, 4, time.Now().Add(time.Duration(n-10)*time.Minute), false, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := &config.Config{
+		DeviceID:            "dev",
+		WatchPath:           tmpDir,
+		Endpoint:            srv.URL,
+		APITimeout:          "30s",
+		IngestCheckInterval: "10ms",
+		IngestBatchSize:     1,
+		IngestWorkerCount:   2,
+	}
+	i := NewIngester(cfg, s, slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	i.Start()
+	defer i.Stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		n := len(uploaded)
+		mu.Unlock()
+		if n == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected a.png and b.png to upload while stuck.png hangs, got %v", uploaded)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
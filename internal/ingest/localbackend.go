@@ -0,0 +1,96 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"fs-ingest-daemon/internal/api"
+	"fs-ingest-daemon/internal/config"
+)
+
+// localBackend copies files into a directory (e.g. a mounted network share),
+// under the same path they had in WatchPath. Next to each file it
+// writes "<name>.ingest.json" with the ingest request, so the checksum,
+// metadata and device context are kept as well.
+type localBackend struct {
+	u   *Uploader
+	dir string
+}
+
+// localMetaSuffix is appended to a copied file's path for its ingest request.
+const localMetaSuffix = ".ingest.json"
+
+func newLocalBackend(cfg *config.Config, u *Uploader) (*localBackend, error) {
+	if cfg.LocalBackendDir == "" {
+		return nil, errors.New("local ingest backend needs local_backend_dir")
+	}
+	return &localBackend{u: u, dir: cfg.LocalBackendDir}, nil
+}
+
+func (b *localBackend) RequestUpload(ctx context.Context, req api.IngestRequest, path string) (*UploadTarget, error) {
+	dest := filepath.Join(b.dir, filepath.FromSlash(b.u.objectPath(req, path)))
+	// Never write outside dir, whatever the path or context holds.
+	if rel, err := filepath.Rel(b.dir, dest); err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return nil, fmt.Errorf("destination %q is outside %s", dest, b.dir)
+	}
+	if err := b.u.cfg.MkdirAll(filepath.Dir(dest)); err != nil {
+		return nil, fmt.Errorf("failed to create destination dir: %w", err)
+	}
+	return &UploadTarget{ID: dest, URL: dest, Request: req}, nil
+}
+
+// Upload copies the file to a temporary name first, so the destination only
+// ever holds complete copies.
+func (b *localBackend) Upload(ctx context.Context, target *UploadTarget, path string) error {
+	src, err := b.u.openFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer src.Close()
+
+	tmp := target.URL + ".part"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, b.u.cfg.FilePerm())
+	if err != nil {
+		return err
+	}
+	b.u.logger.Info("Starting copy", "path", path, "size", target.Request.FileSizeBytes, "destination", target.URL)
+
+	_, err = io.Copy(dst, b.u.limiter.reader(ctx, src))
+	if err == nil {
+		err = dst.Sync()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, target.URL)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return b.u.cfg.ApplyFilePerms(target.URL)
+}
+
+// Confirm writes the ingest request next to a copied file. A duplicate only
+// gets the request and the path of its original, see UploadTarget.DuplicateOf.
+func (b *localBackend) Confirm(ctx context.Context, target *UploadTarget, uploadErr error) error {
+	if uploadErr != nil {
+		return nil // Upload already removed its partial copy
+	}
+	data, err := json.MarshalIndent(targetMeta(target), "", "  ")
+	if err != nil {
+		return err
+	}
+	metaPath := target.URL + localMetaSuffix
+	if err := os.WriteFile(metaPath, data, b.u.cfg.FilePerm()); err != nil {
+		return err
+	}
+	return b.u.cfg.ApplyFilePerms(metaPath)
+}
@@ -0,0 +1,96 @@
+package ingest
+
+import (
+	"fmt"
+	"strings"
+
+	"fs-ingest-daemon/internal/pairing"
+)
+
+// PairingRequired reports whether uploads are paused because the API revoked
+// the device's credentials and the device waits to be claimed again.
+func (i *Ingester) PairingRequired() bool {
+	return i.pairingRequired.Load()
+}
+
+// authRevoked enters the "pairing required" state after the API rejected the
+// device's credentials: uploads stop, the token is cleared and persisted, and
+// repair runs until the device is claimed again. Later calls are ignored
+// until then.
+func (i *Ingester) authRevoked(err error) {
+	if !i.pairingRequired.CompareAndSwap(false, true) {
+		return
+	}
+	i.logger.Error("Ingester: API rejected the device credentials, pausing uploads until the device is paired again",
+		"event", "AuthRevoked", "device_id", i.cfg.Load().DeviceID, "error", err)
+
+	i.uploader.setAuth("")
+	if i.SaveAuthToken != nil {
+		if err := i.SaveAuthToken(""); err != nil {
+			i.logger.Error("Ingester: Failed to clear the auth token in the config", "error", err)
+		}
+	}
+
+	i.wg.Add(1)
+	go func() {
+		defer i.wg.Done()
+		i.repair()
+	}()
+}
+
+// repair requests pairing codes and waits for them to be claimed until the
+// device is claimed or the Ingester stops. A code that expires is replaced by
+// a new one. Once claimed, the new token is saved and uploads resume.
+func (i *Ingester) repair() {
+	cfg := i.cfg.Load()
+	flow := &pairing.Flow{
+		Client:       i.uploader.apiClient,
+		DeviceID:     cfg.DeviceID,
+		PollInterval: i.pairingPoll,
+		Clock:        i.clock,
+		Logger:       i.logger,
+		Metrics:      i.pairingMetrics,
+	}
+	retry := i.pairingPoll
+	if retry <= 0 {
+		retry = pairing.DefaultPollInterval
+	}
+
+	for {
+		code, err := flow.RequestCode()
+		if err != nil {
+			i.logger.Warn("Ingester: Failed to request a pairing code, retrying", "error", err)
+			select {
+			case <-i.clock.After(retry):
+				continue
+			case <-i.stop:
+				return
+			}
+		}
+		i.logger.Warn("Ingester: Device must be claimed again to resume uploads",
+			"code", code.Code, "url", fmt.Sprintf("%s/claim/%s", strings.TrimSuffix(cfg.WebClientURL, "/"), code.Code), "expires_at", code.ExpiresAt)
+
+		result, token := flow.Wait(code, i.stop)
+		switch result {
+		case pairing.Claimed:
+			if i.SaveAuthToken != nil {
+				if err := i.SaveAuthToken(token); err != nil {
+					i.logger.Error("Ingester: Failed to save the new auth token in the config", "error", err)
+				}
+			}
+			i.uploader.setAuth(token)
+			i.pairingRequired.Store(false)
+			i.logger.Info("Ingester: Device paired again, resuming uploads", "event", "AuthRestored")
+			return
+		case pairing.Aborted:
+			return
+		default:
+			i.logger.Warn("Ingester: Pairing code was not claimed in time, requesting a new one", "code", code.Code, "result", result)
+		}
+	}
+}
+
+// SetPairingMetrics sets the metrics updated by re-pairing. It must be called before Start.
+func (i *Ingester) SetPairingMetrics(m pairing.Metrics) {
+	i.pairingMetrics = m
+}
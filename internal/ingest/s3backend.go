@@ -0,0 +1,211 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"fs-ingest-daemon/internal/api"
+	"fs-ingest-daemon/internal/config"
+)
+
+// s3Backend PUTs files straight into an S3 bucket, or a bucket of an
+// S3-compatible store (MinIO, GCS with HMAC keys, ...) at S3Endpoint. Requests
+// go to URLs presigned locally with AWS Signature Version 4, so the regular
+// PUT path with its retries and rate limit is used. Objects are named
+// "<S3Prefix>/<device_id>/<directory context>/<file name>", and the ingest
+// request is stored next to each as "<key>.ingest.json".
+type s3Backend struct {
+	u        *Uploader
+	bucket   string
+	region   string
+	endpoint *url.URL // Path-style endpoint, nil = virtual-hosted AWS S3
+	prefix   string
+	creds    s3Credentials
+	now      func() time.Time
+}
+
+// s3Credentials sign S3 requests.
+type s3Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string // Temporary credentials only
+}
+
+// s3PresignExpiry is how long a presigned URL stays valid. It only has to
+// outlive a single upload attempt.
+const s3PresignExpiry = time.Hour
+
+func newS3Backend(cfg *config.Config, u *Uploader) (*s3Backend, error) {
+	if cfg.S3Bucket == "" || cfg.S3Region == "" {
+		return nil, errors.New("s3 ingest backend needs s3_bucket and s3_region")
+	}
+	creds := s3Credentials{
+		AccessKeyID:     cfg.S3AccessKeyID,
+		SecretAccessKey: cfg.S3SecretAccessKey,
+	}
+	if creds.AccessKeyID == "" && creds.SecretAccessKey == "" {
+		creds = s3Credentials{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return nil, errors.New("s3 ingest backend needs s3_access_key_id and s3_secret_access_key (or AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY)")
+	}
+
+	b := &s3Backend{
+		u:      u,
+		bucket: cfg.S3Bucket,
+		region: cfg.S3Region,
+		prefix: strings.Trim(cfg.S3Prefix, "/"),
+		creds:  creds,
+		now:    time.Now,
+	}
+	if cfg.S3Endpoint != "" {
+		endpoint, err := url.Parse(cfg.S3Endpoint)
+		if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+			return nil, fmt.Errorf("invalid s3_endpoint %q: must be an absolute http(s) URL", cfg.S3Endpoint)
+		}
+		b.endpoint = endpoint
+	}
+	return b, nil
+}
+
+func (b *s3Backend) RequestUpload(ctx context.Context, req api.IngestRequest, file string) (*UploadTarget, error) {
+	key := strings.TrimPrefix(path.Join(b.prefix, req.DeviceID, b.u.objectPath(req, file)), "/")
+	if key == "" || strings.HasPrefix(key, "../") {
+		return nil, fmt.Errorf("invalid object key %q", key)
+	}
+	return &UploadTarget{ID: key, URL: b.presign(http.MethodPut, key), Request: req}, nil
+}
+
+// Upload PUTs the whole file; S3 has no ranged PUTs, so ResumableUploads does
+// not apply.
+func (b *s3Backend) Upload(ctx context.Context, target *UploadTarget, path string) error {
+	file, err := b.u.openFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+	header := http.Header{}
+	if target.Request.ContentType != "" {
+		header.Set("Content-Type", target.Request.ContentType)
+	}
+	b.u.logger.Info("Starting upload", "path", path, "size", info.Size(), "bucket", b.bucket, "key", target.ID)
+	_, err = b.u.putWithRetry(ctx, target.URL, path, file, 0, info.Size(), header)
+	return err
+}
+
+// Confirm stores the ingest request next to an uploaded object. A duplicate
+// only gets the request and the path of its original, see UploadTarget.DuplicateOf.
+func (b *s3Backend) Confirm(ctx context.Context, target *UploadTarget, uploadErr error) error {
+	if uploadErr != nil {
+		return nil // S3 keeps no partial objects of a failed PUT
+	}
+	data, err := json.Marshal(targetMeta(target))
+	if err != nil {
+		return err
+	}
+	header := http.Header{"Content-Type": {"application/json"}}
+	_, err = b.u.put(ctx, b.presign(http.MethodPut, target.ID+localMetaSuffix), bytes.NewReader(data), int64(len(data)), header)
+	return err
+}
+
+// presign returns a URL for method on the object key, valid for s3PresignExpiry.
+func (b *s3Backend) presign(method, key string) string {
+	scheme, host, objectPath := "https", fmt.Sprintf("%s.s3.%s.amazonaws.com", b.bucket, b.region), "/"+s3Escape(key, false)
+	if b.endpoint != nil {
+		scheme, host = b.endpoint.Scheme, b.endpoint.Host
+		objectPath = "/" + s3Escape(b.bucket, true) + objectPath
+	}
+	return presignS3(method, scheme, host, objectPath, b.region, b.creds, b.now(), s3PresignExpiry)
+}
+
+// presignS3 signs a request for escapedPath on host with AWS Signature
+// Version 4 in the query string. Only the host header is signed and the
+// payload is not, so the URL works for any body and content type.
+func presignS3(method, scheme, host, escapedPath, region string, creds s3Credentials, now time.Time, expires time.Duration) string {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	scope := now.Format("20060102") + "/" + region + "/s3/aws4_request"
+
+	query := map[string]string{
+		"X-Amz-Algorithm":     "AWS4-HMAC-SHA256",
+		"X-Amz-Credential":    creds.AccessKeyID + "/" + scope,
+		"X-Amz-Date":          amzDate,
+		"X-Amz-Expires":       strconv.Itoa(int(expires.Seconds())),
+		"X-Amz-SignedHeaders": "host",
+	}
+	if creds.SessionToken != "" {
+		query["X-Amz-Security-Token"] = creds.SessionToken
+	}
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = s3Escape(k, true) + "=" + s3Escape(query[k], true)
+	}
+	canonicalQuery := strings.Join(pairs, "&")
+
+	canonicalRequest := strings.Join([]string{
+		method, escapedPath, canonicalQuery, "host:" + host, "", "host", "UNSIGNED-PAYLOAD",
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{now.Format("20060102"), region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	return scheme + "://" + host + escapedPath + "?" + canonicalQuery + "&X-Amz-Signature=" + signature
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Escape percent-encodes s as SigV4 requires: everything but unreserved
+// characters, and "/" only if encodeSlash is set.
+func s3Escape(s string, encodeSlash bool) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			sb.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
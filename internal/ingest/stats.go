@@ -0,0 +1,63 @@
+package ingest
+
+import (
+	"sync"
+	"time"
+)
+
+// Stats tracks upload outcomes so the daemon can report ingest health to the backend.
+// Counters cover the window since the last reset; the last error is kept across resets.
+type Stats struct {
+	mu          sync.Mutex
+	successes   int
+	failures    int
+	lastError   string
+	lastErrorAt time.Time
+}
+
+// StatsSnapshot is a point-in-time copy of Stats.
+type StatsSnapshot struct {
+	Successes   int
+	Failures    int
+	ErrorRate   float64 // Failures / (Successes + Failures), 0 if there were no attempts
+	LastError   string
+	LastErrorAt time.Time
+}
+
+// RecordSuccess counts a file that was uploaded and confirmed.
+func (s *Stats) RecordSuccess() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.successes++
+}
+
+// RecordFailure counts a failed upload attempt and remembers its error.
+func (s *Stats) RecordFailure(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures++
+	s.lastError = err.Error()
+	s.lastErrorAt = time.Now()
+}
+
+// Snapshot returns the current counters. If reset is true the success/failure
+// counters start a new window afterwards.
+func (s *Stats) Snapshot(reset bool) StatsSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snap := StatsSnapshot{
+		Successes:   s.successes,
+		Failures:    s.failures,
+		LastError:   s.lastError,
+		LastErrorAt: s.lastErrorAt,
+	}
+	if total := s.successes + s.failures; total > 0 {
+		snap.ErrorRate = float64(s.failures) / float64(total)
+	}
+	if reset {
+		s.successes = 0
+		s.failures = 0
+	}
+	return snap
+}
@@ -0,0 +1,257 @@
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+
+	"fs-ingest-daemon/internal/api"
+	"fs-ingest-daemon/internal/config"
+)
+
+// Transport delivers one file and its ingest request to the backend.
+// Errors are prefixed with the failed stage ("ingest:", "upload:", "confirm:")
+// and are recorded against the file by the Uploader.
+type Transport interface {
+	Send(ctx context.Context, req api.IngestRequest, path string) error
+}
+
+// NewTransport returns the transport selected by cfg.Transport ("http" or
+// "grpc"), or the Backend selected by cfg.IngestBackend if it is not the API.
+func NewTransport(cfg *config.Config, u *Uploader) (Transport, error) {
+	if cfg.IngestBackend != "" && cfg.IngestBackend != "api" {
+		backend, err := NewBackend(cfg, u)
+		if err != nil {
+			return nil, err
+		}
+		return &backendTransport{u: u, backend: backend, name: cfg.IngestBackend}, nil
+	}
+	switch cfg.Transport {
+	case "", "http":
+		return &httpTransport{u: u, client: u.apiClient, backoff: &u.backoff}, nil
+	case "grpc":
+		client, err := api.NewGRPCClient(cfg.GRPCEndpoint, cfg.GRPCInsecure)
+		if err != nil {
+			return nil, err
+		}
+		client.SetAuth(cfg.AuthToken, cfg.AuthScheme)
+		return &grpcTransport{u: u, client: client}, nil
+	default:
+		return nil, fmt.Errorf("unknown transport %q", cfg.Transport)
+	}
+}
+
+// httpTransport is the handshake flow: request a presigned URL, PUT the file,
+// confirm. It is the Backend for the Ingestion API.
+type httpTransport struct {
+	u       *Uploader
+	client  *api.Client     // API performing the handshake
+	backoff *networkBackoff // Extended when client is unreachable
+	name    string          // Destination name in logs ("" for the primary API)
+}
+
+func (t *httpTransport) Send(ctx context.Context, req api.IngestRequest, path string) error {
+	return sendTo(ctx, t, req, path)
+}
+
+func (t *httpTransport) SendDuplicate(ctx context.Context, req api.IngestRequest, path, original string) error {
+	return confirmDuplicate(ctx, t, req, path, original)
+}
+
+// logger returns the Uploader's logger, tagged with the destination name if set.
+func (t *httpTransport) logger() *slog.Logger {
+	if t.name != "" {
+		return t.u.logger.With("destination", t.name)
+	}
+	return t.u.logger
+}
+
+// RequestUpload asks the API for permission and upload URLs (step 3). A grant
+// without usable URLs is confirmed as failed right away.
+func (t *httpTransport) RequestUpload(ctx context.Context, req api.IngestRequest, path string) (*UploadTarget, error) {
+	logger := t.logger()
+	if t.u.cfg.EncryptUploads {
+		// Encrypted files are uploaded in one PUT: their size differs from
+		// the one part URLs would be granted for.
+		var err error
+		if req, err = encryptRequest(req); err != nil {
+			return nil, err
+		}
+	} else if mb := t.u.cfg.MultipartThresholdMB; mb > 0 && req.FileSizeBytes >= int64(mb)<<20 {
+		req.Multipart = true
+	}
+	resp, err := t.client.Ingest(ctx, req)
+	if err != nil {
+		if api.IsMaintenance(err) {
+			// The Ingester pauses all uploads; this is not a network problem.
+			logger.Info("Ingester: API is in maintenance", "file", req.Filename, "error", err)
+		} else if api.IsRetryable(err) {
+			wait := t.backoff.failed(t.u.clock.Now())
+			logger.Warn("Ingester: API unreachable, backing off", "file", req.Filename, "backoff", wait, "error", err)
+		} else {
+			logger.Error("Ingester: Ingest request failed", "file", req.Filename, "error", err)
+		}
+		return nil, err
+	}
+	t.backoff.recovered()
+
+	// A 201 without a usable upload URL would otherwise fail in the PUT with a
+	// confusing error; report it to the API and record a clear reason instead.
+	urls := resp.PartURLs
+	if len(urls) == 0 {
+		urls = []string{resp.UploadURL}
+	}
+	for i, raw := range urls {
+		if err := validateUploadURL(raw); err != nil {
+			if len(resp.PartURLs) > 0 {
+				err = fmt.Errorf("part %d: %w", i+1, err)
+			}
+			logger.Error("Ingester: Ingest response is unusable", "file", req.Filename, "handshake_id", resp.HandshakeID, "error", err)
+			t.confirmFailed(context.WithoutCancel(ctx), resp.HandshakeID, err, nil)
+			return nil, err
+		}
+	}
+	return &UploadTarget{ID: resp.HandshakeID, URL: resp.UploadURL, Request: req, resp: resp}, nil
+}
+
+// Upload PUTs the file to the presigned URL (step 4), or uploads it in the
+// granted parts and finalizes them with CompleteMultipart.
+func (t *httpTransport) Upload(ctx context.Context, target *UploadTarget, path string) error {
+	logger := t.logger()
+	resp := target.resp
+	nonce := target.Request.Metadata[metaEncryptionNonce]
+
+	if len(resp.PartURLs) > 0 && nonce != "" {
+		err := errors.New("ingest response has part URLs for an encrypted upload")
+		logger.Error("Ingester: Upload failed", "path", path, "error", err)
+		return err
+	}
+	if len(resp.PartURLs) > 0 {
+		logger.Info("Starting multipart upload", "path", path, "size", target.Request.FileSizeBytes, "parts", len(resp.PartURLs), "part_size", resp.PartSizeBytes)
+
+		parts, err := t.u.uploadMultipart(ctx, resp, path)
+		target.parts = parts
+		if err != nil {
+			logger.Error("Ingester: Upload failed", "path", path, "uploaded_parts", len(parts), "error", err)
+			return err
+		}
+		if err := t.client.CompleteMultipart(ctx, api.CompleteMultipartRequest{HandshakeID: resp.HandshakeID, Parts: parts}); err != nil {
+			logger.Error("Ingester: Complete multipart request failed", "path", path, "handshake_id", resp.HandshakeID, "error", err)
+			return err
+		}
+		return nil
+	}
+
+	logger.Info("Starting upload", "path", path, "size", target.Request.FileSizeBytes, "upload_url", resp.UploadURL, "encrypted", nonce != "")
+	var err error
+	if nonce != "" {
+		err = t.u.uploadEncrypted(ctx, resp.UploadURL, path, target.Request.ContentType, nonce)
+	} else {
+		err = t.u.uploadFile(ctx, resp.UploadURL, path, target.Request.ContentType)
+	}
+	if err != nil {
+		logger.Error("Ingester: Upload failed", "path", path, "error", err)
+		return err
+	}
+	return nil
+}
+
+// Confirm reports the outcome to the API (step 5). A failed upload is
+// reported with the parts that were already stored, so the server can abort
+// it cleanly.
+func (t *httpTransport) Confirm(ctx context.Context, target *UploadTarget, uploadErr error) error {
+	if uploadErr != nil {
+		t.confirmFailed(ctx, target.ID, uploadErr, target.parts)
+		return nil
+	}
+
+	confirmReq := api.ConfirmRequest{HandshakeID: target.ID, Status: api.StatusSuccess}
+	if target.DuplicateOf != "" {
+		confirmReq.Status = api.StatusDuplicate
+		confirmReq.DuplicateOf = target.DuplicateOf
+	} else if len(target.resp.PartURLs) == 0 {
+		// We capture the path component of the upload URL to store/log if needed.
+		if pUrl, err := url.Parse(target.URL); err == nil {
+			p := pUrl.Path
+			confirmReq.UploadedPath = &p
+		}
+	}
+	if err := t.client.Confirm(ctx, confirmReq); err != nil {
+		t.logger().Error("Ingester: Confirm request failed", "path", target.path, "handshake_id", target.ID, "error", err)
+		return err
+	}
+	return nil
+}
+
+// confirmFailed reports a failed handshake to the API on a best-effort basis.
+func (t *httpTransport) confirmFailed(ctx context.Context, handshakeID string, err error, parts []api.MultipartPart) {
+	errMsg := err.Error()
+	_ = t.client.Confirm(ctx, api.ConfirmRequest{
+		HandshakeID:   handshakeID,
+		Status:        api.StatusFailed,
+		ErrorMessage:  &errMsg,
+		UploadedParts: parts,
+	})
+}
+
+// validateUploadURL checks that the API returned an absolute http(s) URL.
+func validateUploadURL(raw string) error {
+	if raw == "" {
+		return errors.New("ingest response has no upload_url")
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("ingest response has a malformed upload_url: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("ingest response upload_url %q is not an absolute http(s) URL", raw)
+	}
+	return nil
+}
+
+// grpcTransport streams the file to the backend's IngestService in a single call;
+// the returned ack replaces the separate confirm step.
+type grpcTransport struct {
+	u      *Uploader
+	client *api.GRPCClient
+}
+
+func (t *grpcTransport) Send(ctx context.Context, req api.IngestRequest, path string) error {
+	u := t.u
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("upload: failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	u.logger.Info("Starting gRPC upload", "path", path, "size", req.FileSizeBytes)
+
+	var ack *api.UploadAck
+	err = traceStage(ctx, "upload", func(ctx context.Context) (err error) {
+		ack, err = t.client.Upload(ctx, req, u.limiter.reader(ctx, bufio.NewReader(file)))
+		return err
+	})
+	if err != nil {
+		if api.IsRetryable(err) {
+			wait := u.networkFailed()
+			u.logger.Warn("Ingester: gRPC backend unreachable, backing off", "path", path, "backoff", wait, "error", err)
+		} else {
+			u.logger.Error("Ingester: gRPC upload failed", "path", path, "error", err)
+		}
+		return fmt.Errorf("upload: %w", err)
+	}
+	u.networkRecovered()
+
+	u.logger.Debug("gRPC upload acknowledged", "path", path, "uploaded_path", ack.UploadedPath)
+	return nil
+}
+
+// Close releases the gRPC connection.
+func (t *grpcTransport) Close() error {
+	return t.client.Close()
+}
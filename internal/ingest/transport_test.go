@@ -0,0 +1,162 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"fs-ingest-daemon/internal/api"
+	"fs-ingest-daemon/internal/config"
+	"fs-ingest-daemon/internal/store"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// mockIngestService is an in-process gRPC IngestService recording what it receives.
+type mockIngestService struct {
+	mu      sync.Mutex
+	request *api.IngestRequest
+	data    bytes.Buffer
+	failErr error // Returned instead of an ack when set
+}
+
+func (m *mockIngestService) Upload(stream api.IngestUploadServer) error {
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		m.mu.Lock()
+		if chunk.Request != nil {
+			m.request = chunk.Request
+		}
+		m.data.Write(chunk.Data)
+		m.mu.Unlock()
+	}
+	if m.failErr != nil {
+		return m.failErr
+	}
+	return stream.SendAndClose(&api.UploadAck{Accepted: true, UploadedPath: "bucket/img.png"})
+}
+
+// startMockIngestService serves svc on a loopback port and returns its address.
+func startMockIngestService(t *testing.T, svc *mockIngestService) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	api.RegisterIngestServiceServer(srv, svc)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return lis.Addr().String()
+}
+
+// newGRPCUploader creates an Uploader using the gRPC transport against addr.
+func newGRPCUploader(t *testing.T, s *store.Store, watchPath, addr string) *Uploader {
+	t.Helper()
+	cfg := &config.Config{
+		DeviceID:     "dev",
+		WatchPath:    watchPath,
+		Transport:    "grpc",
+		GRPCEndpoint: addr,
+		GRPCInsecure: true,
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	u := NewUploader(cfg, s, api.NewClient("http://unused.invalid", "5s"), logger)
+	transport, err := NewTransport(cfg, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { transport.(io.Closer).Close() })
+	u.transport = transport
+	return u
+}
+
+func TestProcess_GRPCTransportStreamsFile(t *testing.T) {
+	s, tmpDir := newTestStore(t)
+	svc := &mockIngestService{}
+	addr := startMockIngestService(t, svc)
+
+	// Larger than one chunk so the file is streamed in several messages.
+	content := bytes.Repeat([]byte("0123456789"), api.GRPCChunkSize/5)
+	path := filepath.Join(tmpDir, "cam1", "img.png")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterFile(path, int64(len(content)), time.Now(), false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	u := newGRPCUploader(t, s, tmpDir, addr)
+	files, err := s.GetPendingFiles(1)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected 1 pending file, got %d (err=%v)", len(files), err)
+	}
+	u.Process(context.Background(), files[0])
+
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	if svc.request == nil {
+		t.Fatal("Expected the ingest request in the first message")
+	}
+	if svc.request.Filename != "img.png" || svc.request.DeviceID != "dev" {
+		t.Errorf("Unexpected ingest request: %+v", svc.request)
+	}
+	if !bytes.Equal(svc.data.Bytes(), content) {
+		t.Errorf("Expected %d streamed bytes to match the file, got %d", len(content), svc.data.Len())
+	}
+
+	uploaded, err := s.ListFiles(store.StatusUploaded, 10)
+	if err != nil || len(uploaded) != 1 {
+		t.Fatalf("Expected file to be UPLOADED, got %d (err=%v)", len(uploaded), err)
+	}
+}
+
+func TestProcess_GRPCTransportBacksOffWhenUnavailable(t *testing.T) {
+	s, tmpDir := newTestStore(t)
+	svc := &mockIngestService{failErr: status.Error(codes.Unavailable, "backend draining")}
+	addr := startMockIngestService(t, svc)
+
+	path := filepath.Join(tmpDir, "img.png")
+	if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterFile(path, 4, time.Now(), false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	u := newGRPCUploader(t, s, tmpDir, addr)
+	files, err := s.GetPendingFiles(1)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected 1 pending file, got %d (err=%v)", len(files), err)
+	}
+	u.Process(context.Background(), files[0])
+
+	if !u.backingOff() {
+		t.Error("Expected uploader to back off when the gRPC backend is unavailable")
+	}
+	failed, err := s.GetFailedFiles(10)
+	if err != nil || len(failed) != 1 {
+		t.Fatalf("Expected the failure to be recorded, got %d (err=%v)", len(failed), err)
+	}
+	if !failed[0].LastError.Valid || failed[0].Status == store.StatusUploaded {
+		t.Errorf("Expected file to stay pending with an error, got %+v", failed[0])
+	}
+}
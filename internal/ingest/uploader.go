@@ -1,39 +1,118 @@
 package ingest
 
 import (
+	"context"
+	"crypto/cipher"
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"fs-ingest-daemon/internal/api"
+	"fs-ingest-daemon/internal/clock"
 	"fs-ingest-daemon/internal/config"
 	"fs-ingest-daemon/internal/store"
 	"fs-ingest-daemon/internal/util"
 	"io"
 	"log/slog"
+	"math"
 	"net/http"
-	"net/url"
 	"os"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 // Uploader handles the details of uploading a single file.
 type Uploader struct {
-	cfg       *config.Config
-	apiClient *api.Client
-	store     *store.Store
-	logger    *slog.Logger
+	cfg         *config.Config
+	apiClient   *api.Client
+	store       *store.Store
+	logger      *slog.Logger
+	stats       *Stats
+	transport   Transport                               // How files reach the backend, HTTP handshake by default
+	openFile    func(name string) (uploadSource, error) // Opens files for upload (replaced in tests)
+	metaRules   *util.MetadataRules                     // Derived metadata from MetadataRules, nil if none
+	backup      Transport                               // Best-effort copy to BackupEndpoint, nil if not configured
+	deadLetters *deadLetterDir                          // Manifest of permanently rejected files, nil if not configured
+	clock       clock.Clock                             // Schedules per-file retries, set by Ingester.SetClock
+	limiter     *rateLimiter                            // Caps the upload rate shared by all workers, nil if unlimited
+	attempts    *attemptLog                             // Per-file upload history, nil if disabled
+	metrics     UploadMetrics                           // Exported upload metrics, see Ingester.SetMetrics
+	tracer      trace.Tracer                            // Traces each file's upload, no-op unless set by Ingester.SetTracerProvider
+	hashes      hashClaims                              // Checksums of files being uploaded, see Config.DeduplicateByHash
+
+	compressedSizes sync.Map    // Path -> gzipped bytes sent by its upload, see uploadCompressed
+	uploadCipher    cipher.AEAD // Encrypts uploads with EncryptionKey, nil if disabled, see uploadEncrypted
+
+	backoff       networkBackoff // Primary API backoff after network failures
+	backupBackoff networkBackoff // Backup endpoint backoff, independent of the primary
 }
 
+// Network backoff bounds applied when the API is unreachable (DNS, connection errors).
+const (
+	minNetworkBackoff = 2 * time.Second
+	maxNetworkBackoff = 1 * time.Minute
+)
+
+// minRetryBackoff is the delay before a file that failed to upload is retried
+// for the first time, doubled per failed attempt.
+const minRetryBackoff = 2 * time.Second
+
 // NewUploader creates a new Uploader.
 func NewUploader(cfg *config.Config, s *store.Store, client *api.Client, logger *slog.Logger) *Uploader {
-	return &Uploader{
+	u := &Uploader{
 		cfg:       cfg,
 		store:     s,
 		apiClient: client,
 		logger:    logger,
+		stats:     &Stats{},
+		clock:     clock.Real{},
+		tracer:    noop.NewTracerProvider().Tracer(tracerName),
+		openFile: func(name string) (uploadSource, error) {
+			return os.Open(name)
+		},
+	}
+	u.transport = &httpTransport{u: u, client: client, backoff: &u.backoff}
+	u.deadLetters = newDeadLetterDir(cfg)
+	u.limiter = newRateLimiter(cfg.MaxUploadBytesPerSec)
+	u.attempts = newAttemptLog(s, cfg.UploadHistoryPerFile)
+
+	if cfg.BackupEndpoint != "" {
+		backupClient := api.NewClient(cfg.BackupEndpoint, cfg.APITimeout)
+		backupClient.SetAuth(cfg.AuthToken, cfg.AuthScheme)
+		backupClient.SetCompressRequests(cfg.CompressRequests)
+		u.backup = &httpTransport{u: u, client: backupClient, backoff: &u.backupBackoff, name: "backup"}
 	}
+
+	// Like the rules below, the key is validated by config.Load. Without a
+	// valid key encrypted uploads fail instead of being sent in plaintext.
+	if cfg.EncryptUploads {
+		aead, err := newUploadCipher(cfg.EncryptionKey)
+		if err != nil {
+			logger.Error("Invalid encryption key, uploads will fail", "error", err)
+		} else {
+			u.uploadCipher = aead
+		}
+	}
+
+	// Rules are validated by config.Load; a failure here means cfg was built in code.
+	if len(cfg.MetadataRules) > 0 {
+		rules, err := util.ParseMetadataRules(cfg.MetadataRules)
+		if err != nil {
+			logger.Error("Invalid metadata rules, ignoring them", "error", err)
+		} else {
+			u.metaRules = rules
+		}
+	}
+	return u
 }
 
 // Process handles the full lifecycle of a single file upload:
@@ -42,37 +121,165 @@ func NewUploader(cfg *config.Config, s *store.Store, client *api.Client, logger
 // 3. Request ingest URL from API.
 // 4. Upload file content to the provided URL.
 // 5. Confirm success with the API.
+// (Steps 3-5 are performed by the configured Transport.)
 // 6. Mark file as UPLOADED in local store.
-func (u *Uploader) Process(f store.FileRecord) {
+// 7. Copy the file to the backup endpoint, if configured.
+//
+// Files already UPLOADED whose backup is pending only go through step 7.
+//
+// Process reports whether an upload of f was attempted (false for sidecars
+// handled by their partner, backup-only copies and uploads cancelled by
+// shutdown) and, if so, the error that left it queued (nil on success).
+//
+// Each call is traced as an "upload_file" span with the hash and the
+// transport's stages as children.
+func (u *Uploader) Process(ctx context.Context, f store.FileRecord) (bool, error) {
+	ctx, span := u.tracer.Start(ctx, "upload_file", trace.WithAttributes(
+		attribute.String("file.path", f.Path),
+		attribute.Int64("file.size", f.Size),
+	))
+	defer span.End()
+
+	attempted, err := u.process(ctx, f)
+	status := "uploaded"
+	switch {
+	case err != nil:
+		status = "failed"
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	case !attempted:
+		status = "skipped"
+	}
+	span.SetAttributes(attribute.String("upload.status", status))
+	return attempted, err
+}
+
+func (u *Uploader) process(ctx context.Context, f store.FileRecord) (bool, error) {
 	// 0. Check if this is a metadata file
 	// If it is a .json file AND it has a partner path, we skip it.
 	// The partner (the image) will handle the upload and mark this one as done.
 	if filepath.Ext(f.Path) == ".json" {
 		if f.PartnerPath.Valid && f.PartnerPath.String != "" {
 			u.logger.Info("Skipping metadata file, waiting for partner", "path", f.Path, "partner", f.PartnerPath.String)
-			return
+			return false, nil
 		}
 		// If it's an orphan json (no partner detected or partner lost), we process it.
 	}
 
-	// 0.5. Load DeviceContext from partner if available
-	var deviceContext map[string]interface{}
-	if f.PartnerPath.Valid && f.PartnerPath.String != "" {
-		// Attempt to read the JSON file
-		jsonFile, err := os.Open(f.PartnerPath.String)
-		if err == nil {
-			defer jsonFile.Close()
-			if err := json.NewDecoder(jsonFile).Decode(&deviceContext); err != nil {
-				u.logger.Warn("Failed to decode device context from partner", "partner", f.PartnerPath.String, "error", err)
-			}
-		} else {
-			u.logger.Warn("Failed to open partner file for context", "partner", f.PartnerPath.String, "error", err)
+	req, err := u.prepareRequest(ctx, f)
+	if err != nil {
+		return f.Status != store.StatusUploaded, err
+	}
+
+	if f.Status == store.StatusUploaded {
+		// The primary upload is done; only the backup copy is outstanding.
+		u.sendBackup(ctx, req, f)
+		return false, nil
+	}
+
+	// With DeduplicateByHash a file whose content is already UPLOADED is only
+	// confirmed as a duplicate. Files with the same content are processed one
+	// at a time, so simultaneous copies are not all uploaded.
+	var original string
+	if u.cfg.DeduplicateByHash {
+		release, err := u.hashes.claim(ctx, req.SHA256Checksum)
+		if err != nil {
+			return false, err // Cancelled by shutdown while an identical file uploaded
+		}
+		defer release()
+		original = u.findDuplicate(f, req)
+	}
+
+	// 3-5. Hand the file to the transport (handshake, PUT and confirm for HTTP;
+	// a single stream for gRPC)
+	uploadStart := time.Now()
+	send := u.transport.Send
+	if original != "" {
+		send = func(ctx context.Context, req api.IngestRequest, path string) error {
+			return u.sendDuplicate(ctx, req, path, original)
 		}
 	}
+	defer u.compressedSizes.Delete(f.Path)
+	if err := send(ctx, req, f.Path); err != nil {
+		u.attempts.add(f.Path, uploadStart, time.Since(uploadStart), err)
+		if ctx.Err() != nil {
+			// Cancelled on shutdown; the file stays PENDING for the next start.
+			u.logger.Info("Ingester: Upload interrupted by shutdown, will resume after restart", "path", f.Path)
+			return false, err
+		}
+		u.metrics.Failures.Inc()
+		// Note: If any stage fails, we do NOT mark as uploaded locally.
+		// This ensures the file is retried in the next batch.
+		// The API rejected the file; retries will not help without intervention.
+		// Revoked credentials are handled by re-pairing (see Ingester), not per file.
+		if failed := u.recordError(f, err); failed || (!api.IsRetryable(err) && !api.IsAuthRevoked(err)) {
+			u.writeDeadLetter(f, err)
+		}
+		return true, err
+	}
+	uploadDuration := time.Since(uploadStart)
+	u.attempts.add(f.Path, uploadStart, uploadDuration, nil)
+	if original == "" {
+		u.metrics.Uploaded.Inc()
+		u.metrics.Bytes.Add(f.Size)
+		u.metrics.Duration.Observe(uploadDuration.Seconds())
+	}
+
+	// Flag the backup copy as outstanding before marking the file UPLOADED, so
+	// it is neither lost on a crash nor pruned before the backup succeeds.
+	if u.backup != nil {
+		u.setBackupStatus(f, store.StatusPending)
+	}
+
+	// 6. Mark as Uploaded in local DB
+	// Only if the file was not overwritten while we uploaded it: a newer version
+	// re-registered mid-upload must stay PENDING and be uploaded next.
+	var marked bool
+	if original != "" {
+		marked, err = u.store.MarkDuplicateVersion(f.Path, f.Version, original)
+	} else {
+		marked, err = u.store.MarkUploadedVersion(f.Path, f.Version)
+	}
+	if err != nil {
+		u.logger.Error("Ingester: Failed to mark as uploaded", "path", f.Path, "error", err)
+		return true, fmt.Errorf("mark uploaded: %w", err)
+	}
+	if !marked {
+		u.logger.Info("File was overwritten during upload, newer version stays queued", "path", f.Path, "duration", uploadDuration)
+		u.stats.RecordSuccess()
+	} else {
+		if original != "" {
+			u.logger.Info("Upload skipped, same content already uploaded", "path", f.Path, "duplicate_of", original)
+		} else {
+			attrs := []any{"path", f.Path, "duration", uploadDuration,
+				"bytes_per_sec", bytesPerSec(f.Size, uploadDuration), "rate_limit", u.cfg.MaxUploadBytesPerSec}
+			if compressed, ok := u.compressedSizes.Load(f.Path); ok {
+				attrs = append(attrs, "size", f.Size, "compressed_size", compressed)
+			}
+			u.logger.Info("Upload success", attrs...)
+		}
+		u.stats.RecordSuccess()
+		if err := u.deadLetters.clear(f.Path); err != nil {
+			u.logger.Error("Ingester: Failed to remove dead-letter entry", "path", f.Path, "error", err)
+		}
+		// If we have partners (sidecars), mark them as uploaded too
+		for _, partner := range u.partners(f) {
+			if err := u.store.MarkUploaded(partner); err != nil {
+				u.logger.Error("Ingester: Failed to mark partner as uploaded", "partner", partner, "error", err)
+			}
+		}
 
-	if deviceContext == nil {
-		deviceContext = make(map[string]interface{})
+		// 7. Best-effort backup copy; failures are retried separately.
+		u.sendBackup(ctx, req, f)
 	}
+	return true, nil
+}
+
+// prepareRequest builds the ingest request for f (steps 1-2). It returns an
+// error if the file cannot be uploaded right now; the reason is logged and recorded.
+func (u *Uploader) prepareRequest(ctx context.Context, f store.FileRecord) (api.IngestRequest, error) {
+	// 0.5. Load DeviceContext from partners if available
+	deviceContext := u.loadDeviceContext(f)
 
 	// 1. Calculate SHA256 for integrity check
 	// Run in a goroutine to allow metadata extraction and request prep to overlap
@@ -82,7 +289,11 @@ func (u *Uploader) Process(f store.FileRecord) {
 	}
 	hashCh := make(chan hashResult, 1)
 	go func() {
-		sum, err := u.calculateSHA256(f.Path)
+		var sum string
+		err := traceStage(ctx, "hash", func(context.Context) (err error) {
+			sum, err = u.checksum(f.Path)
+			return err
+		})
 		hashCh <- hashResult{sum, err}
 	}()
 
@@ -91,16 +302,37 @@ func (u *Uploader) Process(f store.FileRecord) {
 	if context == nil {
 		context = []string{}
 	}
+	for k, v := range u.metaRules.Apply(u.cfg.WatchPath, f.Path) {
+		meta[k] = v
+	}
+	for k, v := range util.SidecarMetadata(deviceContext) {
+		meta[k] = v
+	}
+	if u.cfg.ExtractImageDimensions {
+		dims, err := util.ImageMetadata(f.Path)
+		if err != nil {
+			// Upload anyway; the backend can still read the file itself.
+			u.logger.Warn("Failed to read image dimensions", "path", f.Path, "error", err)
+		}
+		for k, v := range dims {
+			meta[k] = v
+		}
+	}
+	if sessionID := u.sessionID(); sessionID != "" {
+		meta["session_id"] = sessionID
+	}
 
 	// 3. Ingest Request - Ask API for permission and upload URL
 	req := api.IngestRequest{
 		DeviceID:        u.cfg.DeviceID,
 		Filename:        filepath.Base(f.Path),
 		FileSizeBytes:   f.Size,
+		ContentType:     util.UploadContentType(f.Path),
 		FilePathContext: context,
 		DeviceContext:   deviceContext,
 		Metadata:        meta,
-		Timestamp:       time.Now(),
+		Timestamp:       captureTime(f, deviceContext),
+		UploadedAt:      u.clock.Now(),
 	}
 
 	// Wait for checksum
@@ -109,77 +341,343 @@ func (u *Uploader) Process(f store.FileRecord) {
 		if os.IsNotExist(res.err) {
 			u.logger.Warn("Ingester: File vanished before processing, removing from DB", "path", f.Path)
 			_ = u.store.RemoveFile(f.Path)
-			return
+			return req, fmt.Errorf("file vanished: %w", res.err)
 		}
 		u.logger.Error("Ingester: Failed to calculate checksum", "path", f.Path, "error", res.err)
-		return
+		err := fmt.Errorf("checksum: %w", res.err)
+		if u.recordError(f, err) {
+			u.writeDeadLetter(f, err)
+		}
+		return req, err
 	}
 	req.SHA256Checksum = res.sum
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("file.sha256", res.sum))
+	req.ChecksumMode = api.ChecksumModeFull
+	if u.prefixChecksum() {
+		req.ChecksumMode = api.ChecksumModePrefix
+		req.ChecksumPrefix = u.cfg.ChecksumPrefixBytes
+	}
+	return req, nil
+}
 
-	resp, err := u.apiClient.Ingest(req)
+// partners returns the paths uploaded together with f: all sidecars of a data
+// file (see store.GetSidecars), ordered by merge precedence, or just its
+// partner_path.
+func (u *Uploader) partners(f store.FileRecord) []string {
+	var single []string
+	if f.PartnerPath.Valid && f.PartnerPath.String != "" {
+		single = []string{f.PartnerPath.String}
+	}
+	if filepath.Ext(f.Path) == ".json" {
+		return single
+	}
+	sidecars, err := u.store.GetSidecars(f.Path)
 	if err != nil {
-		u.logger.Error("Ingester: Ingest request failed", "path", f.Path, "error", err)
+		u.logger.Warn("Ingester: Failed to list sidecars, using the partner only", "path", f.Path, "error", err)
+		return single
+	}
+	if len(sidecars) == 0 {
+		return single
+	}
+	return sidecars
+}
+
+// loadDeviceContext decodes the JSON partners of f into one DeviceContext.
+// With split sidecars (img.json, img.exif.json) later partners overwrite
+// conflicting keys of earlier ones, so the primary sidecar wins.
+func (u *Uploader) loadDeviceContext(f store.FileRecord) map[string]interface{} {
+	deviceContext := make(map[string]interface{})
+	for _, partner := range u.partners(f) {
+		// Attempt to read the JSON file
+		jsonFile, err := os.Open(partner)
+		if err != nil {
+			u.logger.Warn("Failed to open partner file for context", "partner", partner, "error", err)
+			continue
+		}
+		var sidecar map[string]interface{}
+		err = json.NewDecoder(jsonFile).Decode(&sidecar)
+		jsonFile.Close()
+		if err != nil {
+			u.logger.Warn("Failed to decode device context from partner", "partner", partner, "error", err)
+			continue
+		}
+		for k, v := range sidecar {
+			deviceContext[k] = v
+		}
+	}
+	return deviceContext
+}
+
+// captureTime returns when f was captured: the "timestamp" of its sidecar
+// metadata if that is an RFC 3339 time or Unix seconds, else its mod time,
+// so delayed uploads keep their real time.
+func captureTime(f store.FileRecord, deviceContext map[string]interface{}) time.Time {
+	switch ts := deviceContext["timestamp"].(type) {
+	case string:
+		if t, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+			return t
+		}
+	case float64:
+		sec, frac := math.Modf(ts)
+		return time.Unix(int64(sec), int64(frac*1e9)).UTC()
+	}
+	return f.ModTime
+}
+
+// sendBackup copies an uploaded file to the backup endpoint. Failures leave the
+// backup PENDING for a later retry and never affect the primary upload.
+func (u *Uploader) sendBackup(ctx context.Context, req api.IngestRequest, f store.FileRecord) {
+	if u.backup == nil || u.backupBackoff.active(u.clock.Now()) {
 		return
 	}
+	if err := u.backup.Send(ctx, req, f.Path); err != nil {
+		// Pace retries of rejected copies too, not only unreachable endpoints.
+		wait := u.backupBackoff.failed(u.clock.Now())
+		u.logger.Warn("Ingester: Backup upload failed, will retry", "path", f.Path, "retry_in", wait, "error", err)
+		return
+	}
+	u.logger.Info("Backup upload success", "path", f.Path)
+	u.setBackupStatus(f, store.StatusUploaded)
+}
 
-	// 4. Upload to Presigned URL
-	u.logger.Info("Starting upload", "path", f.Path, "size", f.Size, "upload_url", resp.UploadURL)
+// setBackupStatus records the backup state of f and its partners, which are
+// uploaded together with it.
+func (u *Uploader) setBackupStatus(f store.FileRecord, status store.FileStatus) {
+	paths := append([]string{f.Path}, u.partners(f)...)
+	for _, p := range paths {
+		if err := u.store.SetBackupStatus(p, status); err != nil {
+			u.logger.Error("Ingester: Failed to record backup status", "path", p, "status", status, "error", err)
+		}
+	}
+}
 
-	uploadStart := time.Now()
-	if err := u.uploadFile(resp.UploadURL, f.Path); err != nil {
-		u.logger.Error("Ingester: Upload failed", "path", f.Path, "error", err)
+// sessionID returns the current capture session ID, falling back to the configured one.
+func (u *Uploader) sessionID() string {
+	id, err := u.store.GetSessionID()
+	if err != nil {
+		u.logger.Warn("Ingester: Failed to read session ID", "error", err)
+	}
+	if id == "" {
+		id = u.cfg.SessionID
+	}
+	return id
+}
 
-		// Report failure to API so it can handle the failed handshake
-		errMsg := err.Error()
-		failReq := api.ConfirmRequest{
-			HandshakeID:  resp.HandshakeID,
-			Status:       api.StatusFailed,
-			ErrorMessage: &errMsg,
+// networkBackoff pauses requests to an endpoint after transient network failures,
+// doubling the pause on each consecutive failure.
+type networkBackoff struct {
+	mu    sync.Mutex
+	until time.Time     // Requests are paused until this time
+	step  time.Duration // Current backoff, doubled on each consecutive failure
+}
+
+// failed extends the backoff after a transient failure at now and returns it.
+func (b *networkBackoff) failed(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.step == 0 {
+		b.step = minNetworkBackoff
+	} else if b.step < maxNetworkBackoff {
+		b.step *= 2
+		if b.step > maxNetworkBackoff {
+			b.step = maxNetworkBackoff
 		}
-		_ = u.apiClient.Confirm(failReq)
-		return
 	}
-	uploadDuration := time.Since(uploadStart)
+	b.until = now.Add(b.step)
+	return b.step
+}
 
-	// 5. Confirm Success with API
-	var uploadedPath *string
-	pUrl, err := url.Parse(resp.UploadURL)
-	if err == nil {
-		p := pUrl.Path
-		// We capture the path component of the upload URL to store/log if needed.
-		uploadedPath = &p
+// recovered clears the backoff once the endpoint answers again.
+func (b *networkBackoff) recovered() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.step = 0
+	b.until = time.Time{}
+}
+
+// active reports whether requests are paused at now.
+func (b *networkBackoff) active(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return now.Before(b.until)
+}
+
+// networkFailed extends the primary API's network backoff and returns it.
+func (u *Uploader) networkFailed() time.Duration {
+	return u.backoff.failed(u.clock.Now())
+}
+
+// networkRecovered clears the primary API's network backoff.
+func (u *Uploader) networkRecovered() {
+	u.backoff.recovered()
+}
+
+// backingOff reports whether ingest requests are paused after a network failure.
+func (u *Uploader) backingOff() bool {
+	return u.backoff.active(u.clock.Now())
+}
+
+// recordError persists the reason for a failed attempt so operators can see it
+// in `fsd list`/`fsd stats` without searching the logs, and holds the file back
+// for an exponentially growing delay. Once MaxUploadRetries attempts failed the
+// file moves to the dead_letters table and recordError returns true.
+func (u *Uploader) recordError(f store.FileRecord, err error) bool {
+	u.stats.RecordFailure(err)
+	if dbErr := u.store.RecordError(f.Path, err.Error()); dbErr != nil {
+		u.logger.Error("Ingester: Failed to record error", "path", f.Path, "error", dbErr)
 	}
 
-	confirmReq := api.ConfirmRequest{
-		HandshakeID:  resp.HandshakeID,
-		Status:       api.StatusSuccess,
-		UploadedPath: uploadedPath,
+	// Nothing was sent while the circuit breaker is open; that is not an attempt.
+	// Neither is a request rejected for revoked credentials or backend
+	// maintenance, the file is fine.
+	if errors.Is(err, api.ErrCircuitOpen) || api.IsAuthRevoked(err) || api.IsMaintenance(err) {
+		return false
 	}
 
-	if err := u.apiClient.Confirm(confirmReq); err != nil {
-		u.logger.Error("Ingester: Confirm request failed", "path", f.Path, "handshake_id", resp.HandshakeID, "error", err)
-		// Note: If confirm fails, we do NOT mark as uploaded locally.
-		// This ensures the file is retried in the next batch.
-		return
+	attempts := f.RetryCount + 1
+	wait := u.retryBackoff(attempts)
+	if dbErr := u.store.RecordFailure(f.Path, u.clock.Now().Add(wait)); dbErr != nil {
+		u.logger.Error("Ingester: Failed to schedule retry", "path", f.Path, "error", dbErr)
 	}
 
-	// 6. Mark as Uploaded in local DB
-	if err := u.store.MarkUploaded(f.Path); err != nil {
-		u.logger.Error("Ingester: Failed to mark as uploaded", "path", f.Path, "error", err)
-	} else {
-		u.logger.Info("Upload success", "path", f.Path, "duration", uploadDuration)
-		// If we have a partner, mark it as uploaded too
-		if f.PartnerPath.Valid && f.PartnerPath.String != "" {
-			if err := u.store.MarkUploaded(f.PartnerPath.String); err != nil {
-				u.logger.Error("Ingester: Failed to mark partner as uploaded", "partner", f.PartnerPath.String, "error", err)
-			}
-		}
+	if u.cfg.MaxUploadRetries <= 0 || attempts < u.cfg.MaxUploadRetries {
+		u.logger.Info("Ingester: Upload will be retried", "path", f.Path, "attempts", attempts, "retry_in", wait)
+		return false
+	}
+	u.logger.Error("Ingester: Giving up on file after repeated failures", "path", f.Path, "attempts", attempts, "error", err)
+	if dbErr := u.store.MoveToDeadLetter(f.Path, err.Error()); dbErr != nil {
+		u.logger.Error("Ingester: Failed to move file to dead letters", "path", f.Path, "error", dbErr)
+	}
+	return true
+}
+
+// retryBackoff returns the delay before attempt number attempts+1: it starts at
+// minRetryBackoff and doubles per failed attempt, up to RetryMaxBackoff.
+func (u *Uploader) retryBackoff(attempts int) time.Duration {
+	maxWait, err := time.ParseDuration(u.cfg.RetryMaxBackoff)
+	if err != nil || maxWait <= 0 {
+		maxWait = 10 * time.Minute
+	}
+	wait := minRetryBackoff
+	for n := 1; n < attempts && wait < maxWait; n++ {
+		wait *= 2
 	}
+	return min(wait, maxWait)
 }
 
-// uploadFile performs a PUT request to upload the file content to the destination URL.
-func (u *Uploader) uploadFile(url, path string) error {
-	file, err := os.Open(path)
+// setAuth replaces the credentials of the API clients, e.g. after re-pairing.
+func (u *Uploader) setAuth(token string) {
+	u.apiClient.SetAuth(token, u.cfg.AuthScheme)
+	if t, ok := u.backup.(*httpTransport); ok {
+		t.client.SetAuth(token, u.cfg.AuthScheme)
+	}
+	if t, ok := u.transport.(*grpcTransport); ok {
+		t.client.SetAuth(token, u.cfg.AuthScheme)
+	}
+}
+
+// writeDeadLetter records f in the dead-letter directory, if configured.
+func (u *Uploader) writeDeadLetter(f store.FileRecord, err error) {
+	if dlErr := u.deadLetters.record(f, err); dlErr != nil {
+		u.logger.Error("Ingester: Failed to write dead-letter entry", "path", f.Path, "error", dlErr)
+	}
+}
+
+// putStatusError is returned when the storage endpoint answers a PUT with a non-2xx status.
+type putStatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *putStatusError) Error() string {
+	return fmt.Sprintf("server responded with status %d: %s", e.StatusCode, e.Body)
+}
+
+// uploadSource is the file being uploaded; *os.File satisfies it.
+type uploadSource interface {
+	io.ReadSeekCloser
+	Stat() (os.FileInfo, error)
+}
+
+// readError is returned when reading the local file fails partway through a PUT.
+// The server may have received (and even accepted) a partial body, so this is
+// always a hard failure: the request is aborted and never confirmed.
+type readError struct {
+	err error
+}
+
+func (e *readError) Error() string {
+	return fmt.Sprintf("read failed mid-upload: %v", e.err)
+}
+
+func (e *readError) Unwrap() error {
+	return e.err
+}
+
+// abortingReader is a PUT body that cancels the request as soon as the
+// underlying reader fails. Closing it does not close the underlying file, so the
+// file stays open (and seekable) for retries; it only signals that the transport
+// is done with the body.
+type abortingReader struct {
+	r      io.Reader
+	cancel context.CancelFunc
+	closed chan struct{}
+	once   sync.Once
+
+	mu  sync.Mutex
+	err error
+}
+
+func newAbortingReader(r io.Reader, cancel context.CancelFunc) *abortingReader {
+	return &abortingReader{r: r, cancel: cancel, closed: make(chan struct{})}
+}
+
+func (a *abortingReader) Read(p []byte) (int, error) {
+	n, err := a.r.Read(p)
+	if err != nil && err != io.EOF {
+		a.mu.Lock()
+		a.err = err
+		a.mu.Unlock()
+		a.cancel()
+	}
+	return n, err
+}
+
+func (a *abortingReader) Close() error {
+	a.once.Do(func() { close(a.closed) })
+	return nil
+}
+
+// Err returns the first read error, if any.
+func (a *abortingReader) Err() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.err
+}
+
+// isTransientPutError reports whether a failed PUT is worth retrying.
+// Network errors and 5xx/408/429 responses are transient; other 4xx responses
+// (expired or invalid presigned URL, etc.) and local read errors will not succeed on retry.
+func isTransientPutError(err error) bool {
+	var readErr *readError
+	if errors.As(err, &readErr) {
+		return false
+	}
+	var statusErr *putStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500 ||
+			statusErr.StatusCode == http.StatusRequestTimeout ||
+			statusErr.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// uploadFile performs a PUT request to upload the file content to the destination URL
+// with the given Content-Type ("application/octet-stream" if empty). Transient failures are retried up to cfg.PutMaxRetries times with exponential backoff,
+// rewinding the file between attempts. This is independent of the per-file retry
+// performed by the ingest loop.
+func (u *Uploader) uploadFile(ctx context.Context, url, path, contentType string) error {
+	file, err := u.openFile(path)
 	if err != nil {
 		return fmt.Errorf("failed to open file: %w", err)
 	}
@@ -190,26 +688,273 @@ func (u *Uploader) uploadFile(url, path string) error {
 		return fmt.Errorf("failed to stat file: %w", err)
 	}
 
-	req, err := http.NewRequest("PUT", url, file)
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	if u.compressible(path) {
+		// Not resumable: offsets of the compressed body are not recorded.
+		return u.uploadCompressed(ctx, url, path, file, header)
+	}
+	if u.cfg.ResumableUploads && u.store != nil {
+		return u.uploadResumable(ctx, url, path, file, info.Size(), header)
+	}
+	_, err = u.putWithRetry(ctx, url, path, file, 0, info.Size(), header)
+	return err
+}
+
+// uploadResumable continues an interrupted upload of path with a Content-Range
+// PUT from the offset recorded by the previous attempt. If the server rejects
+// the range with a 4xx, the whole file is uploaded instead. When the upload
+// fails, the bytes read into the request are recorded for the next attempt;
+// this counts bytes handed to the connection, not bytes the server confirmed.
+// Headers in header are added to every PUT.
+func (u *Uploader) uploadResumable(ctx context.Context, url, path string, file io.ReadSeeker, size int64, header http.Header) error {
+	offset, err := u.store.GetUploadedBytes(path)
+	if err != nil {
+		u.logger.Warn("Failed to read upload progress, uploading from the start", "path", path, "error", err)
+		offset = 0
+	}
+	if offset < 0 || offset >= size {
+		offset = 0
+	}
+
+	src := &progressReader{ReadSeeker: file}
+	if offset > 0 {
+		src.high.Store(offset)
+		rangeHeader := header.Clone()
+		rangeHeader.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, size-1, size))
+		u.logger.Info("Resuming upload", "path", path, "offset", offset, "size", size)
+
+		_, err := u.putWithRetry(ctx, url, path, src, offset, size-offset, rangeHeader)
+		var statusErr *putStatusError
+		if !errors.As(err, &statusErr) || statusErr.StatusCode < 400 || statusErr.StatusCode >= 500 {
+			return u.saveProgress(path, src, err)
+		}
+
+		u.logger.Warn("Server rejected resumed upload, falling back to a full upload", "path", path, "offset", offset, "status", statusErr.StatusCode)
+		if err := u.store.SetUploadedBytes(path, 0); err != nil {
+			u.logger.Error("Failed to reset upload progress", "path", path, "error", err)
+		}
+		src = &progressReader{ReadSeeker: file}
+	}
+
+	_, err = u.putWithRetry(ctx, url, path, src, 0, size, header)
+	return u.saveProgress(path, src, err)
+}
+
+// saveProgress records how far src was read when err is an upload failure, and returns err.
+func (u *Uploader) saveProgress(path string, src *progressReader, err error) error {
+	if err == nil {
+		return nil
+	}
+	n := src.high.Load()
+	if serr := u.store.SetUploadedBytes(path, n); serr != nil {
+		u.logger.Error("Failed to record upload progress", "path", path, "error", serr)
+	} else {
+		u.logger.Info("Upload interrupted, recorded progress for resume", "path", path, "uploaded_bytes", n)
+	}
+	return err
+}
+
+// progressReader tracks the furthest file position read through it.
+type progressReader struct {
+	io.ReadSeeker
+	pos  atomic.Int64
+	high atomic.Int64
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.ReadSeeker.Read(b)
+	pos := p.pos.Add(int64(n))
+	if pos > p.high.Load() {
+		p.high.Store(pos)
+	}
+	return n, err
+}
+
+func (p *progressReader) Seek(offset int64, whence int) (int64, error) {
+	n, err := p.ReadSeeker.Seek(offset, whence)
+	if err == nil {
+		p.pos.Store(n)
+	}
+	return n, err
+}
+
+// uploadMultipart PUTs each part of path to the matching resp.PartURLs entry.
+// On failure it also returns the parts that were already stored, so the caller
+// can report them and the server can abort the upload cleanly.
+func (u *Uploader) uploadMultipart(ctx context.Context, resp *api.IngestResponse, path string) ([]api.MultipartPart, error) {
+	file, err := u.openFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	size, partSize := info.Size(), resp.PartSizeBytes
+	if partSize <= 0 {
+		return nil, fmt.Errorf("ingest response has invalid part_size_bytes %d", partSize)
+	}
+	if need := int((size + partSize - 1) / partSize); need > len(resp.PartURLs) || (size > 0 && need < len(resp.PartURLs)) {
+		return nil, fmt.Errorf("ingest response has %d part URLs, file of %d bytes needs %d", len(resp.PartURLs), size, need)
+	}
+
+	parts := make([]api.MultipartPart, 0, len(resp.PartURLs))
+	for i, partURL := range resp.PartURLs {
+		offset := int64(i) * partSize
+		n := min(partSize, size-offset)
+		u.logger.Debug("Uploading part", "path", path, "part", i+1, "parts", len(resp.PartURLs), "size", n)
+
+		etag, err := u.putWithRetry(ctx, partURL, path, file, offset, n, nil)
+		if err != nil {
+			return parts, fmt.Errorf("part %d: %w", i+1, err)
+		}
+		parts = append(parts, api.MultipartPart{PartNumber: i + 1, ETag: etag})
+	}
+	return parts, nil
+}
+
+// putWithRetry PUTs size bytes of file starting at offset, retrying transient
+// failures with exponential backoff. Headers in header are added to each PUT.
+// It returns the ETag of the stored object.
+func (u *Uploader) putWithRetry(ctx context.Context, url, path string, file io.ReadSeeker, offset, size int64, header http.Header) (string, error) {
+	maxRetries := u.cfg.PutMaxRetries
+	if maxRetries < 0 {
+		maxRetries = 0
+	}
+	backoff, err := time.ParseDuration(u.cfg.PutRetryBackoff)
+	if err != nil || backoff <= 0 {
+		backoff = 1 * time.Second
+	}
+
+	for attempt := 0; ; attempt++ {
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			return "", fmt.Errorf("failed to rewind file: %w", err)
+		}
+
+		etag, err := u.put(ctx, url, u.limiter.reader(ctx, io.LimitReader(file, size)), size, header)
+		if err == nil {
+			return etag, nil
+		}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		if attempt >= maxRetries || !isTransientPutError(err) {
+			return "", err
+		}
+
+		wait := backoff << attempt
+		u.logger.Warn("Upload PUT failed, retrying", "path", path, "attempt", attempt+1, "max_retries", maxRetries, "backoff", wait, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-u.clock.After(wait):
+		}
+	}
+}
+
+// put sends a single PUT of the given body to the destination URL.
+// A read error on body aborts the request and is reported as a *readError even
+// if the server already answered with a success status. It returns the ETag
+// response header, which identifies the part in a multipart upload.
+func (u *Uploader) put(ctx context.Context, url string, body io.Reader, size int64, header http.Header) (string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	src := newAbortingReader(body, cancel)
+	var reqBody io.Reader = http.NoBody
+	if size > 0 {
+		reqBody = src
+	} else {
+		src.Close()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "PUT", url, reqBody)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return "", fmt.Errorf("failed to create request: %w", err)
 	}
 
-	req.ContentLength = info.Size()
+	req.ContentLength = size
 	req.Header.Set("Content-Type", "application/octet-stream")
+	for k, v := range header {
+		req.Header[k] = v
+	}
 
 	resp, err := u.apiClient.HTTPClient.Do(req)
+	if readErr := src.Err(); readErr != nil {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return "", &readError{err: readErr}
+	}
 	if err != nil {
-		return fmt.Errorf("http request failed: %w", err)
+		return "", fmt.Errorf("http request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("server responded with status %d: %s", resp.StatusCode, string(body))
+		return "", &putStatusError{StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
-	return nil
+	// The transport may still be writing the body when an early response arrives;
+	// wait until it has released the body before trusting the success.
+	select {
+	case <-src.closed:
+	case <-ctx.Done():
+	}
+	if readErr := src.Err(); readErr != nil {
+		return "", &readError{err: readErr}
+	}
+	return resp.Header.Get("ETag"), nil
+}
+
+// bytesPerSec returns the average rate of transferring size bytes in d.
+func bytesPerSec(size int64, d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64(float64(size) / d.Seconds())
+}
+
+// prefixChecksum reports whether files are fingerprinted by a prefix of their
+// content (ChecksumMode "prefix") instead of hashed in full.
+func (u *Uploader) prefixChecksum() bool {
+	return u.cfg.ChecksumMode == string(api.ChecksumModePrefix) && u.cfg.ChecksumPrefixBytes > 0
+}
+
+// checksum returns the SHA256 of the file at path. A checksum cached in the
+// store is reused while the file's mod time is unchanged, so retries of large
+// files are not re-hashed. In prefix mode the cheap prefix fingerprint is
+// computed every time instead, so it never mixes with cached full checksums.
+func (u *Uploader) checksum(path string) (string, error) {
+	if u.prefixChecksum() {
+		return u.calculatePrefixSHA256(path, u.cfg.ChecksumPrefixBytes)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if sum, err := u.store.GetChecksum(path, info.ModTime()); err != nil {
+		u.logger.Warn("Ingester: Failed to read cached checksum", "path", path, "error", err)
+	} else if sum != "" {
+		return sum, nil
+	}
+
+	sum, err := u.calculateSHA256(path)
+	if err != nil {
+		return "", err
+	}
+	if err := u.store.SetChecksum(path, info.ModTime(), sum); err != nil {
+		u.logger.Warn("Ingester: Failed to cache checksum", "path", path, "error", err)
+	}
+	return sum, nil
 }
 
 // calculateSHA256 computes the SHA256 hash of a file.
@@ -227,3 +972,26 @@ func (u *Uploader) calculateSHA256(path string) (string, error) {
 
 	return hex.EncodeToString(h.Sum(nil)), nil
 }
+
+// calculatePrefixSHA256 computes the SHA256 of the first n bytes of a file
+// followed by its size as a big-endian uint64 (see api.ChecksumModePrefix),
+// reading at most n bytes.
+func (u *Uploader) calculatePrefixSHA256(path string, n int64) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := io.CopyN(h, f, n); err != nil && err != io.EOF {
+		return "", err
+	}
+	binary.Write(h, binary.BigEndian, uint64(info.Size()))
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
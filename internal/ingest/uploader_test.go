@@ -0,0 +1,1839 @@
+package ingest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"fs-ingest-daemon/internal/api"
+	"fs-ingest-daemon/internal/clock"
+	"fs-ingest-daemon/internal/config"
+	"fs-ingest-daemon/internal/store"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// mockAPI is an in-process Ingestion API recording the ingest requests it receives.
+type mockAPI struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []api.IngestRequest
+	confirms []api.ConfirmRequest
+	putTypes []string // Content-Type of each upload PUT
+	parents  []string // traceparent header of each ingest request
+	bodies   [][]byte // Body of each upload PUT
+}
+
+func newMockAPI(t *testing.T) *mockAPI {
+	t.Helper()
+	m := &mockAPI{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/ingest/request", func(w http.ResponseWriter, r *http.Request) {
+		var req api.IngestRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Failed to decode ingest request: %v", err)
+		}
+		m.mu.Lock()
+		m.requests = append(m.requests, req)
+		m.parents = append(m.parents, r.Header.Get("traceparent"))
+		m.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(api.IngestResponse{
+			HandshakeID: "hs-1",
+			UploadURL:   m.URL + "/upload",
+			ExpiresAt:   time.Now().Add(time.Hour),
+		})
+	})
+	mux.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		m.mu.Lock()
+		m.putTypes = append(m.putTypes, r.Header.Get("Content-Type"))
+		m.bodies = append(m.bodies, body)
+		m.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/v1/ingest/confirm", func(w http.ResponseWriter, r *http.Request) {
+		var req api.ConfirmRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Failed to decode confirm request: %v", err)
+		}
+		m.mu.Lock()
+		m.confirms = append(m.confirms, req)
+		m.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	})
+	m.Server = httptest.NewServer(mux)
+	return m
+}
+
+// lastRequest returns the most recent ingest request received.
+func (m *mockAPI) lastRequest(t *testing.T) api.IngestRequest {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.requests) == 0 {
+		t.Fatal("Expected at least one ingest request")
+	}
+	return m.requests[len(m.requests)-1]
+}
+
+// newTestStore opens a store in a fresh temp dir and returns it with the dir.
+func newTestStore(t *testing.T) (*store.Store, string) {
+	t.Helper()
+	tmpDir, err := os.MkdirTemp("", "uploader_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tmpDir) })
+
+	s, err := store.NewStore(filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s, tmpDir
+}
+
+func TestUploadFile_RetriesTransientPutFailures(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "uploader_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	content := "image bytes"
+	path := filepath.Join(tmpDir, "img.png")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// PUT target fails twice with 500, then succeeds.
+	// Every attempt must carry the full body (reader rewound between attempts).
+	var attempts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&attempts, 1)
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != content {
+			t.Errorf("Attempt %d: expected body %q, got %q", n, content, string(body))
+		}
+		if n <= 2 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	cfg := &config.Config{
+		PutMaxRetries:   3,
+		PutRetryBackoff: "10ms",
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	u := NewUploader(cfg, nil, api.NewClient(srv.URL, "5s"), logger)
+
+	if err := u.uploadFile(context.Background(), srv.URL, path, ""); err != nil {
+		t.Fatalf("Expected upload to succeed after retries, got: %v", err)
+	}
+	if got := atomic.LoadInt32(&attempts); got != 3 {
+		t.Errorf("Expected 3 PUT attempts, got %d", got)
+	}
+}
+
+func TestUploadFile_CompressesConfiguredExtensions(t *testing.T) {
+	tmpDir := t.TempDir()
+	sidecar := filepath.Join(tmpDir, "img.json")
+	content := strings.Repeat(`{"camera": "cam1", "exposure": "1/250"}`, 100)
+	if err := os.WriteFile(sidecar, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	img := filepath.Join(tmpDir, "img.png")
+	if err := os.WriteFile(img, []byte("image bytes"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	type put struct {
+		encoding string
+		length   int64
+		body     []byte
+	}
+	var mu sync.Mutex
+	var puts []put
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		puts = append(puts, put{r.Header.Get("Content-Encoding"), r.ContentLength, body})
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	cfg := &config.Config{CompressUploads: true, CompressibleExtensions: []string{".json"}}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	u := NewUploader(cfg, nil, api.NewClient(srv.URL, "5s"), logger)
+
+	for _, path := range []string{sidecar, img} {
+		if err := u.uploadFile(context.Background(), srv.URL, path, ""); err != nil {
+			t.Fatalf("Upload of %s failed: %v", path, err)
+		}
+	}
+
+	if len(puts) != 2 {
+		t.Fatalf("Expected 2 PUTs, got %d", len(puts))
+	}
+	gz := puts[0]
+	if gz.encoding != "gzip" || gz.length != int64(len(gz.body)) || gz.length >= int64(len(content)) {
+		t.Errorf("Expected a smaller gzipped body with its exact length, got encoding %q, length %d (body %d, original %d)",
+			gz.encoding, gz.length, len(gz.body), len(content))
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(gz.body))
+	if err != nil {
+		t.Fatalf("Body is not gzip: %v", err)
+	}
+	if got, _ := io.ReadAll(zr); string(got) != content {
+		t.Errorf("Decompressed body does not match the file")
+	}
+	if size, ok := u.compressedSizes.Load(sidecar); !ok || size != gz.length {
+		t.Errorf("Expected the compressed size %d recorded for the success log, got %v", gz.length, size)
+	}
+
+	if png := puts[1]; png.encoding != "" || string(png.body) != "image bytes" {
+		t.Errorf("Expected the PNG to be uploaded as is, got encoding %q, body %q", png.encoding, png.body)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(os.TempDir(), "fsd-upload-*")); len(leftovers) != 0 {
+		t.Errorf("Expected the temporary file to be removed, found %v", leftovers)
+	}
+}
+
+func TestUploadFile_DoesNotRetryClientErrors(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "uploader_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "img.png")
+	if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var attempts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	cfg := &config.Config{
+		PutMaxRetries:   3,
+		PutRetryBackoff: "10ms",
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	u := NewUploader(cfg, nil, api.NewClient(srv.URL, "5s"), logger)
+
+	if err := u.uploadFile(context.Background(), srv.URL, path, ""); err == nil {
+		t.Fatal("Expected upload to fail on 403")
+	}
+	if got := atomic.LoadInt32(&attempts); got != 1 {
+		t.Errorf("Expected a single PUT attempt for a 4xx, got %d", got)
+	}
+}
+
+func TestUploadFile_RespectsRateLimit(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "uploader_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Two files of 64 KiB at 256 KiB/s: concurrently they must take ~0.5s in
+	// total, not ~0.25s each, because the limit is shared.
+	data := make([]byte, 64<<10)
+	var paths []string
+	for _, name := range []string{"a.png", "b.png"} {
+		path := filepath.Join(tmpDir, name)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	cfg := &config.Config{MaxUploadBytesPerSec: 256 << 10}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	u := NewUploader(cfg, nil, api.NewClient(srv.URL, "5s"), logger)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for _, path := range paths {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := u.uploadFile(context.Background(), srv.URL, path, ""); err != nil {
+				t.Errorf("Upload failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if elapsed := time.Since(start); elapsed < 350*time.Millisecond {
+		t.Errorf("Expected the shared rate limit to slow the uploads down, took %v", elapsed)
+	}
+}
+
+func TestProcess_AttachesSessionID(t *testing.T) {
+	s, tmpDir := newTestStore(t)
+	srv := newMockAPI(t)
+	defer srv.Close()
+
+	path := filepath.Join(tmpDir, "img.png")
+	if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{DeviceID: "dev", WatchPath: tmpDir}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	u := NewUploader(cfg, s, api.NewClient(srv.URL, "5s"), logger)
+
+	upload := func() {
+		if err := s.RegisterFile(path, 4, time.Now(), false, false); err != nil {
+			t.Fatal(err)
+		}
+		files, err := s.GetPendingFiles(1)
+		if err != nil || len(files) != 1 {
+			t.Fatalf("Expected 1 pending file, got %d (err=%v)", len(files), err)
+		}
+		u.Process(context.Background(), files[0])
+	}
+
+	if err := s.SetSessionID("campaign-a"); err != nil {
+		t.Fatal(err)
+	}
+	upload()
+	if got := srv.lastRequest(t).Metadata["session_id"]; got != "campaign-a" {
+		t.Errorf("Expected session_id campaign-a, got %q", got)
+	}
+
+	rotated, err := s.RotateSessionID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload()
+	got := srv.lastRequest(t).Metadata["session_id"]
+	if got != rotated || got == "campaign-a" {
+		t.Errorf("Expected rotated session_id %q, got %q", rotated, got)
+	}
+}
+
+func TestProcess_BacksOffOnDNSFailure(t *testing.T) {
+	s, tmpDir := newTestStore(t)
+	srv := newMockAPI(t)
+	defer srv.Close()
+
+	path := filepath.Join(tmpDir, "img.png")
+	if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterFile(path, 4, time.Now(), false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first dial fails name resolution (resolver not ready on boot), later dials succeed.
+	var dials int32
+	client := api.NewClient(srv.URL, "5s")
+	dialer := &net.Dialer{}
+	client.HTTPClient.Transport = &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if atomic.AddInt32(&dials, 1) == 1 {
+				return nil, &net.DNSError{Err: "no such host", Name: "api.example", IsTemporary: true}
+			}
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}
+
+	cfg := &config.Config{DeviceID: "dev", WatchPath: tmpDir}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	u := NewUploader(cfg, s, client, logger)
+	clk := clock.NewFake(time.Now())
+	s.SetClock(clk)
+	u.clock = clk
+
+	process := func() {
+		files, err := s.GetPendingFiles(1)
+		if err != nil || len(files) != 1 {
+			t.Fatalf("Expected 1 pending file, got %d (err=%v)", len(files), err)
+		}
+		u.Process(context.Background(), files[0])
+	}
+
+	process()
+	if !u.backingOff() {
+		t.Error("Expected uploader to back off after a DNS failure")
+	}
+	files, err := s.ListFiles(store.StatusPending, 10)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected file to stay PENDING after DNS failure, got %d (err=%v)", len(files), err)
+	}
+
+	// The file is held back until its retry is due.
+	if files, _ := s.GetPendingFiles(1); len(files) != 0 {
+		t.Fatalf("Expected file to wait for its retry, got %d pending", len(files))
+	}
+	// The network backoff expires on the uploader's clock.
+	clk.Advance(minNetworkBackoff)
+	if u.backingOff() {
+		t.Error("Expected the network backoff to expire after its step")
+	}
+	clk.Advance(time.Minute)
+	process()
+	if u.backingOff() {
+		t.Error("Expected backoff to clear once the API is reachable")
+	}
+	uploaded, err := s.ListFiles(store.StatusUploaded, 10)
+	if err != nil || len(uploaded) != 1 {
+		t.Fatalf("Expected file to be UPLOADED on retry, got %d (err=%v)", len(uploaded), err)
+	}
+}
+
+// failingSource is an upload source whose reads fail after failAfter bytes,
+// like a disk error or a dropped network mount mid-upload.
+type failingSource struct {
+	uploadSource
+	failAfter int64
+	read      int64
+}
+
+func (f *failingSource) Read(p []byte) (int, error) {
+	if f.read >= f.failAfter {
+		return 0, errors.New("input/output error")
+	}
+	if remaining := f.failAfter - f.read; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := f.uploadSource.Read(p)
+	f.read += int64(n)
+	return n, err
+}
+
+func TestProcess_MidStreamReadErrorIsNotConfirmed(t *testing.T) {
+	s, tmpDir := newTestStore(t)
+	srv := newMockAPI(t)
+	defer srv.Close()
+
+	content := make([]byte, 1<<20)
+	path := filepath.Join(tmpDir, "img.png")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterFile(path, int64(len(content)), time.Now(), false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{DeviceID: "dev", WatchPath: tmpDir, PutMaxRetries: 3, PutRetryBackoff: "10ms"}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	u := NewUploader(cfg, s, api.NewClient(srv.URL, "5s"), logger)
+	u.openFile = func(name string) (uploadSource, error) {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		return &failingSource{uploadSource: f, failAfter: 64 * 1024}, nil
+	}
+
+	files, err := s.GetPendingFiles(1)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected 1 pending file, got %d (err=%v)", len(files), err)
+	}
+	u.Process(context.Background(), files[0])
+
+	if uploaded, _ := s.ListFiles(store.StatusUploaded, 10); len(uploaded) != 0 {
+		t.Fatal("Expected file not to be marked UPLOADED after a read error")
+	}
+	failed, err := s.GetFailedFiles(10)
+	if err != nil || len(failed) != 1 {
+		t.Fatalf("Expected the read error to be recorded, got %d (err=%v)", len(failed), err)
+	}
+	if !strings.Contains(failed[0].LastError.String, "read failed mid-upload") {
+		t.Errorf("Expected a mid-upload read error, got %q", failed[0].LastError.String)
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for _, c := range srv.confirms {
+		if c.Status == api.StatusSuccess {
+			t.Error("Expected no successful confirm after a read error")
+		}
+	}
+}
+
+func TestProcess_OverwriteDuringUploadStaysQueued(t *testing.T) {
+	s, tmpDir := newTestStore(t)
+	srv := newMockAPI(t)
+	defer srv.Close()
+
+	path := filepath.Join(tmpDir, "img.png")
+	if err := os.WriteFile(path, []byte("first"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterFile(path, 5, time.Now(), false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{DeviceID: "dev", WatchPath: tmpDir}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	u := NewUploader(cfg, s, api.NewClient(srv.URL, "5s"), logger)
+
+	// Simulate the file being overwritten (and re-detected) once the first upload has started.
+	var overwritten bool
+	u.openFile = func(name string) (uploadSource, error) {
+		f, err := os.Open(name)
+		if err != nil || overwritten {
+			return f, err
+		}
+		overwritten = true
+		if err := os.WriteFile(path, []byte("second!"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.RegisterFile(path, 7, time.Now(), false, false); err != nil {
+			t.Fatal(err)
+		}
+		return f, nil
+	}
+
+	files, err := s.GetPendingFiles(1)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected 1 pending file, got %d (err=%v)", len(files), err)
+	}
+	u.Process(context.Background(), files[0])
+
+	pending, err := s.GetPendingFiles(10)
+	if err != nil || len(pending) != 1 {
+		t.Fatalf("Expected the overwritten file to stay PENDING, got %d (err=%v)", len(pending), err)
+	}
+	if pending[0].Size != 7 || pending[0].Version <= files[0].Version {
+		t.Errorf("Expected the newer version to be queued, got %+v", pending[0])
+	}
+
+	u.Process(context.Background(), pending[0])
+	if uploaded, _ := s.ListFiles(store.StatusUploaded, 10); len(uploaded) != 1 {
+		t.Fatal("Expected the newer version to be UPLOADED after the second pass")
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if n := len(srv.requests); n != 2 {
+		t.Errorf("Expected 2 ingest requests, got %d", n)
+	}
+}
+
+func TestProcess_EmptyUploadURLFailsCleanly(t *testing.T) {
+	s, tmpDir := newTestStore(t)
+
+	var puts, failedConfirms int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/ingest/request", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(api.IngestResponse{HandshakeID: "hs-1", ExpiresAt: time.Now().Add(time.Hour)})
+	})
+	mux.HandleFunc("/v1/ingest/confirm", func(w http.ResponseWriter, r *http.Request) {
+		var req api.ConfirmRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Status == api.StatusFailed {
+			atomic.AddInt32(&failedConfirms, 1)
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&puts, 1)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	path := filepath.Join(tmpDir, "img.png")
+	if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterFile(path, 4, time.Now(), false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{DeviceID: "dev", WatchPath: tmpDir}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	u := NewUploader(cfg, s, api.NewClient(srv.URL, "5s"), logger)
+
+	files, err := s.GetPendingFiles(1)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected 1 pending file, got %d (err=%v)", len(files), err)
+	}
+	u.Process(context.Background(), files[0])
+
+	if n := atomic.LoadInt32(&puts); n != 0 {
+		t.Errorf("Expected no upload attempt without an upload URL, got %d", n)
+	}
+	if n := atomic.LoadInt32(&failedConfirms); n != 1 {
+		t.Errorf("Expected the handshake to be confirmed as failed, got %d", n)
+	}
+	failed, err := s.GetFailedFiles(10)
+	if err != nil || len(failed) != 1 {
+		t.Fatalf("Expected the failure to be recorded, got %d (err=%v)", len(failed), err)
+	}
+	if !strings.Contains(failed[0].LastError.String, "no upload_url") {
+		t.Errorf("Expected a clear upload_url error, got %q", failed[0].LastError.String)
+	}
+	if u.backingOff() {
+		t.Error("An unusable ingest response must not trigger network backoff")
+	}
+}
+
+func TestValidateUploadURL(t *testing.T) {
+	for raw, ok := range map[string]bool{
+		"https://bucket.example.com/obj?sig=1": true,
+		"http://127.0.0.1:9000/obj":            true,
+		"":                                     false,
+		"/relative/path":                       false,
+		"ftp://example.com/obj":                false,
+		"https://":                             false,
+		"://bad":                               false,
+	} {
+		if err := validateUploadURL(raw); (err == nil) != ok {
+			t.Errorf("validateUploadURL(%q) = %v, want ok=%v", raw, err, ok)
+		}
+	}
+}
+
+func TestProcess_UploadsToBackupEndpoint(t *testing.T) {
+	s, tmpDir := newTestStore(t)
+	primary := newMockAPI(t)
+	defer primary.Close()
+	backup := newMockAPI(t)
+	defer backup.Close()
+
+	path := filepath.Join(tmpDir, "img.png")
+	if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterFile(path, 4, time.Now(), false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{DeviceID: "dev", WatchPath: tmpDir, BackupEndpoint: backup.URL, APITimeout: "5s"}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	u := NewUploader(cfg, s, api.NewClient(primary.URL, "5s"), logger)
+
+	files, err := s.GetPendingFiles(1)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected 1 pending file, got %d (err=%v)", len(files), err)
+	}
+	u.Process(context.Background(), files[0])
+
+	if primary.lastRequest(t).Filename != "img.png" || backup.lastRequest(t).Filename != "img.png" {
+		t.Error("Expected both destinations to receive the file")
+	}
+	uploaded, err := s.ListFiles(store.StatusUploaded, 10)
+	if err != nil || len(uploaded) != 1 {
+		t.Fatalf("Expected 1 uploaded file, got %d (err=%v)", len(uploaded), err)
+	}
+	if got := uploaded[0].BackupStatus.String; got != string(store.StatusUploaded) {
+		t.Errorf("Expected backup status UPLOADED, got %q", got)
+	}
+}
+
+func TestProcess_BackupFailureDoesNotBlockPrimary(t *testing.T) {
+	s, tmpDir := newTestStore(t)
+	primary := newMockAPI(t)
+	defer primary.Close()
+
+	var backupDown atomic.Bool
+	backupDown.Store(true)
+	backup := newMockAPI(t)
+	defer backup.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if backupDown.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		backup.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer failing.Close()
+
+	path := filepath.Join(tmpDir, "img.png")
+	if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterFile(path, 4, time.Now(), false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{DeviceID: "dev", WatchPath: tmpDir, BackupEndpoint: failing.URL, APITimeout: "5s"}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	u := NewUploader(cfg, s, api.NewClient(primary.URL, "5s"), logger)
+
+	files, err := s.GetPendingFiles(1)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected 1 pending file, got %d (err=%v)", len(files), err)
+	}
+	u.Process(context.Background(), files[0])
+
+	if u.backingOff() {
+		t.Error("Backup failure must not back off the primary endpoint")
+	}
+	backups, err := s.GetPendingBackups(10)
+	if err != nil || len(backups) != 1 {
+		t.Fatalf("Expected 1 pending backup, got %d (err=%v)", len(backups), err)
+	}
+	if backups[0].Status != store.StatusUploaded {
+		t.Errorf("Expected primary status UPLOADED, got %s", backups[0].Status)
+	}
+	candidates, err := s.GetPruneCandidates(10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 0 {
+		t.Errorf("Expected file with pending backup not to be prunable, got %d candidates", len(candidates))
+	}
+
+	// Once the backup endpoint recovers, the retry only sends the backup copy.
+	backupDown.Store(false)
+	u.backupBackoff.recovered()
+	primaryRequests := len(primary.requests)
+	u.Process(context.Background(), backups[0])
+
+	if len(primary.requests) != primaryRequests {
+		t.Error("Expected the backup retry not to re-upload to the primary endpoint")
+	}
+	if backup.lastRequest(t).Filename != "img.png" {
+		t.Error("Expected the backup endpoint to receive the file")
+	}
+	if backups, _ := s.GetPendingBackups(10); len(backups) != 0 {
+		t.Errorf("Expected no pending backups, got %d", len(backups))
+	}
+}
+
+func TestProcess_WritesDeadLetterEntryForRejectedFile(t *testing.T) {
+	s, tmpDir := newTestStore(t)
+	srv := newMockAPI(t)
+	defer srv.Close()
+
+	var rejecting atomic.Bool
+	rejecting.Store(true)
+	apiSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rejecting.Load() && r.URL.Path == "/v1/ingest/request" {
+			http.Error(w, "unsupported file", http.StatusBadRequest)
+			return
+		}
+		srv.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer apiSrv.Close()
+
+	path := filepath.Join(tmpDir, "img.png")
+	if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterFile(path, 4, time.Now(), false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	deadLetterDir := filepath.Join(tmpDir, "dead-letter")
+	cfg := &config.Config{DeviceID: "dev", WatchPath: tmpDir, DeadLetterDir: deadLetterDir}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	u := NewUploader(cfg, s, api.NewClient(apiSrv.URL, "5s"), logger)
+	clk := clock.NewFake(time.Now())
+	s.SetClock(clk)
+	u.clock = clk
+
+	process := func() {
+		files, err := s.GetPendingFiles(1)
+		if err != nil || len(files) != 1 {
+			t.Fatalf("Expected 1 pending file, got %d (err=%v)", len(files), err)
+		}
+		u.Process(context.Background(), files[0])
+	}
+
+	process()
+	entries, err := filepath.Glob(filepath.Join(deadLetterDir, "img.png.*.json"))
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("Expected 1 dead-letter entry, got %v (err=%v)", entries, err)
+	}
+	data, err := os.ReadFile(entries[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry deadLetterEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatalf("Invalid dead-letter entry: %v", err)
+	}
+	if entry.Path != path || entry.Size != 4 || !strings.Contains(entry.Error, "400") {
+		t.Errorf("Unexpected dead-letter entry: %+v", entry)
+	}
+
+	// Once the file uploads after being requeued, the entry is removed.
+	rejecting.Store(false)
+	clk.Advance(time.Minute)
+	process()
+	if _, err := os.Stat(entries[0]); !os.IsNotExist(err) {
+		t.Errorf("Expected dead-letter entry to be removed after a successful upload, got err=%v", err)
+	}
+}
+
+func TestProcess_RetriesWithBackoffThenFails(t *testing.T) {
+	s, tmpDir := newTestStore(t)
+	apiSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unsupported file", http.StatusBadRequest)
+	}))
+	defer apiSrv.Close()
+
+	path := filepath.Join(tmpDir, "img.png")
+	if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterFile(path, 4, time.Now(), false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{DeviceID: "dev", WatchPath: tmpDir, MaxUploadRetries: 3, RetryMaxBackoff: "3s"}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	u := NewUploader(cfg, s, api.NewClient(apiSrv.URL, "5s"), logger)
+	clk := clock.NewFake(time.Now())
+	s.SetClock(clk)
+	u.clock = clk
+
+	// Delays double from 2s and are capped at 3s.
+	for attempt, wait := range []time.Duration{2 * time.Second, 3 * time.Second} {
+		files, err := s.GetPendingFiles(1)
+		if err != nil || len(files) != 1 {
+			t.Fatalf("Attempt %d: expected 1 pending file, got %d (err=%v)", attempt+1, len(files), err)
+		}
+		u.Process(context.Background(), files[0])
+
+		clk.Advance(wait - time.Millisecond)
+		if files, _ := s.GetPendingFiles(1); len(files) != 0 {
+			t.Fatalf("Attempt %d: expected the retry to wait %v", attempt+1, wait)
+		}
+		clk.Advance(time.Millisecond)
+	}
+
+	files, err := s.GetPendingFiles(1)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected 1 pending file, got %d (err=%v)", len(files), err)
+	}
+	u.Process(context.Background(), files[0])
+
+	failed, err := s.ListDeadLetters(10)
+	if err != nil || len(failed) != 1 {
+		t.Fatalf("Expected the file to be dead-lettered after 3 attempts, got %d (err=%v)", len(failed), err)
+	}
+	if failed[0].RetryCount != 3 || !failed[0].LastError.Valid {
+		t.Errorf("Expected retry count 3 and the last error, got %+v", failed[0])
+	}
+	clk.Advance(time.Hour)
+	if files, _ := s.GetPendingFiles(1); len(files) != 0 {
+		t.Errorf("Expected a dead-lettered file not to be retried, got %d pending", len(files))
+	}
+}
+
+func TestProcess_AttachesImageDimensions(t *testing.T) {
+	s, tmpDir := newTestStore(t)
+	srv := newMockAPI(t)
+	defer srv.Close()
+
+	path := filepath.Join(tmpDir, "img.png")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, image.NewGray(image.Rect(0, 0, 4, 2))); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterFile(path, info.Size(), info.ModTime(), false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{DeviceID: "dev", WatchPath: tmpDir, ExtractImageDimensions: true}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	u := NewUploader(cfg, s, api.NewClient(srv.URL, "5s"), logger)
+
+	files, err := s.GetPendingFiles(1)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected 1 pending file, got %d (err=%v)", len(files), err)
+	}
+	u.Process(context.Background(), files[0])
+
+	meta := srv.lastRequest(t).Metadata
+	if meta["image_width"] != "4" || meta["image_height"] != "2" || meta["image_mime"] != "image/png" {
+		t.Errorf("Expected image dimensions in metadata, got %v", meta)
+	}
+}
+
+func TestProcess_SendsDetectedContentType(t *testing.T) {
+	s, tmpDir := newTestStore(t)
+	srv := newMockAPI(t)
+	defer srv.Close()
+
+	pngPath := filepath.Join(tmpDir, "img.png")
+	f, err := os.Create(pngPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	files := map[string]string{
+		pngPath:                              "image/png",
+		filepath.Join(tmpDir, "blob.xyz123"): "application/octet-stream",
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "blob.xyz123"), []byte{0x00, 0x01, 0x02}, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{DeviceID: "dev", WatchPath: tmpDir}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	u := NewUploader(cfg, s, api.NewClient(srv.URL, "5s"), logger)
+
+	for path, want := range files {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.RegisterFile(path, info.Size(), info.ModTime(), false, false); err != nil {
+			t.Fatal(err)
+		}
+		pending, err := s.GetPendingFiles(1)
+		if err != nil || len(pending) != 1 {
+			t.Fatalf("Expected 1 pending file, got %d (err=%v)", len(pending), err)
+		}
+		if _, err := u.Process(context.Background(), pending[0]); err != nil {
+			t.Fatalf("Upload of %s failed: %v", filepath.Base(path), err)
+		}
+
+		if got := srv.lastRequest(t).ContentType; !strings.HasPrefix(got, want) {
+			t.Errorf("%s: expected content_type %q in the ingest request, got %q", filepath.Base(path), want, got)
+		}
+		srv.mu.Lock()
+		got := srv.putTypes[len(srv.putTypes)-1]
+		srv.mu.Unlock()
+		if !strings.HasPrefix(got, want) {
+			t.Errorf("%s: expected PUT Content-Type %q, got %q", filepath.Base(path), want, got)
+		}
+	}
+}
+
+func TestProcess_ReusesCachedChecksumUntilModified(t *testing.T) {
+	s, tmpDir := newTestStore(t)
+	srv := newMockAPI(t)
+	defer srv.Close()
+
+	path := filepath.Join(tmpDir, "img.png")
+	if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterFile(path, info.Size(), info.ModTime(), false, false); err != nil {
+		t.Fatal(err)
+	}
+	// A checksum cached by an earlier attempt is trusted while the mod time matches.
+	if err := s.SetChecksum(path, info.ModTime(), "cached"); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{DeviceID: "dev", WatchPath: tmpDir}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	u := NewUploader(cfg, s, api.NewClient(srv.URL, "5s"), logger)
+
+	files, err := s.GetPendingFiles(1)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected 1 pending file, got %d (err=%v)", len(files), err)
+	}
+	u.Process(context.Background(), files[0])
+	if sum := srv.lastRequest(t).SHA256Checksum; sum != "cached" {
+		t.Fatalf("Expected the cached checksum to be reused, got %q", sum)
+	}
+
+	// Once modified, the file is hashed again and the new checksum cached.
+	if err := os.WriteFile(path, []byte("other"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	modTime := info.ModTime().Add(time.Minute)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterFile(path, 5, modTime, false, false); err != nil {
+		t.Fatal(err)
+	}
+	files, err = s.GetPendingFiles(1)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected the modified file to be pending, got %d (err=%v)", len(files), err)
+	}
+	u.Process(context.Background(), files[0])
+
+	want := "d9298a10d1b0735837dc4bd85dac641b0f3cef27a47e5d53a54f2f3f5b2fcffa" // sha256("other")
+	if sum := srv.lastRequest(t).SHA256Checksum; sum != want {
+		t.Fatalf("Expected the modified file to be re-hashed, got %q", sum)
+	}
+	if cached, err := s.GetChecksum(path, modTime); err != nil || cached != want {
+		t.Errorf("Expected the new checksum to be cached, got %q (err=%v)", cached, err)
+	}
+}
+
+func TestProcess_PrefixChecksumModeHashesOnlyPrefix(t *testing.T) {
+	s, tmpDir := newTestStore(t)
+	srv := newMockAPI(t)
+	defer srv.Close()
+
+	const size, prefix = 4096, 1024
+	content := bytes.Repeat([]byte("0123456789abcdef"), size/16)
+	path := filepath.Join(tmpDir, "big.bin")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{DeviceID: "dev", WatchPath: tmpDir, ChecksumMode: "prefix", ChecksumPrefixBytes: prefix}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	u := NewUploader(cfg, s, api.NewClient(srv.URL, "5s"), logger)
+
+	process := func(modTime time.Time) api.IngestRequest {
+		t.Helper()
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.RegisterFile(path, size, modTime, false, false); err != nil {
+			t.Fatal(err)
+		}
+		files, err := s.GetPendingFiles(1)
+		if err != nil || len(files) != 1 {
+			t.Fatalf("Expected 1 pending file, got %d (err=%v)", len(files), err)
+		}
+		if _, err := u.Process(context.Background(), files[0]); err != nil {
+			t.Fatalf("Upload failed: %v", err)
+		}
+		return srv.lastRequest(t)
+	}
+
+	h := sha256.New()
+	h.Write(content[:prefix])
+	binary.Write(h, binary.BigEndian, uint64(size))
+	want := hex.EncodeToString(h.Sum(nil))
+
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	req := process(modTime)
+	if req.SHA256Checksum != want {
+		t.Errorf("Expected the fingerprint of the first %d bytes and the size, got %q", prefix, req.SHA256Checksum)
+	}
+	if req.ChecksumMode != api.ChecksumModePrefix || req.ChecksumPrefix != prefix {
+		t.Errorf("Expected the request to be labeled prefix/%d, got %q/%d", prefix, req.ChecksumMode, req.ChecksumPrefix)
+	}
+
+	// Bytes past the prefix are not read: changing them keeps the fingerprint.
+	content[prefix+10] ^= 0xff
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := process(modTime.Add(time.Minute)).SHA256Checksum; got != want {
+		t.Errorf("Expected a stable fingerprint when only bytes past the prefix change, got %q", got)
+	}
+
+	// Within the prefix they change it.
+	content[10] ^= 0xff
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := process(modTime.Add(2 * time.Minute)).SHA256Checksum; got == want {
+		t.Error("Expected the fingerprint to change with the prefix")
+	}
+}
+
+func TestProcess_TracesUploadStages(t *testing.T) {
+	s, tmpDir := newTestStore(t)
+	srv := newMockAPI(t)
+	defer srv.Close()
+
+	content := []byte("data")
+	path := filepath.Join(tmpDir, "img.png")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterFile(path, int64(len(content)), time.Now(), false, false); err != nil {
+		t.Fatal(err)
+	}
+	files, err := s.GetPendingFiles(1)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected 1 pending file, got %d (err=%v)", len(files), err)
+	}
+
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	cfg := &config.Config{DeviceID: "dev", WatchPath: tmpDir}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	u := NewUploader(cfg, s, api.NewClient(srv.URL, "5s"), logger)
+	u.tracer = tp.Tracer(tracerName)
+
+	if _, err := u.Process(context.Background(), files[0]); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range rec.Ended() {
+		spans[span.Name()] = span
+	}
+	root, ok := spans["upload_file"]
+	if !ok {
+		t.Fatalf("Expected an upload_file span, got %v", spans)
+	}
+	for _, name := range []string{"hash", "ingest_request", "upload", "confirm"} {
+		span, ok := spans[name]
+		if !ok {
+			t.Errorf("Expected a %s span", name)
+			continue
+		}
+		if span.Parent().SpanID() != root.SpanContext().SpanID() || span.SpanContext().TraceID() != root.SpanContext().TraceID() {
+			t.Errorf("Expected the %s span to be a child of upload_file", name)
+		}
+	}
+
+	sum := sha256.Sum256(content)
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range root.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	if got := attrs["file.size"].AsInt64(); got != int64(len(content)) {
+		t.Errorf("Expected file.size %d, got %d", len(content), got)
+	}
+	if got := attrs["file.sha256"].AsString(); got != hex.EncodeToString(sum[:]) {
+		t.Errorf("Expected file.sha256 of the content, got %q", got)
+	}
+	if got := attrs["upload.status"].AsString(); got != "uploaded" {
+		t.Errorf("Expected upload.status uploaded, got %q", got)
+	}
+
+	// The API sees the ingest_request span as the parent of its request.
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	sc := spans["ingest_request"].SpanContext()
+	want := fmt.Sprintf("00-%s-%s-01", sc.TraceID(), sc.SpanID())
+	if len(srv.parents) != 1 || srv.parents[0] != want {
+		t.Errorf("Expected traceparent %q, got %q", want, srv.parents)
+	}
+}
+
+func TestProcess_DeduplicatesIdenticalFiles(t *testing.T) {
+	s, tmpDir := newTestStore(t)
+	srv := newMockAPI(t)
+	defer srv.Close()
+
+	files := map[string]string{"a.png": "same", "b.png": "same", "c.png": "different"}
+	for name, content := range files {
+		path := filepath.Join(tmpDir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.RegisterFile(path, int64(len(content)), time.Now(), false, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pending, err := s.GetPendingFiles(10)
+	if err != nil || len(pending) != 3 {
+		t.Fatalf("Expected 3 pending files, got %d (err=%v)", len(pending), err)
+	}
+
+	cfg := &config.Config{DeviceID: "dev", WatchPath: tmpDir, DeduplicateByHash: true}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	u := NewUploader(cfg, s, api.NewClient(srv.URL, "5s"), logger)
+	// Slow uploads make a.png and b.png race: both are hashed before either is uploaded.
+	u.openFile = func(name string) (uploadSource, error) {
+		time.Sleep(50 * time.Millisecond)
+		return os.Open(name)
+	}
+
+	var wg sync.WaitGroup
+	for _, f := range pending {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := u.Process(context.Background(), f); err != nil {
+				t.Errorf("Process %s failed: %v", f.Path, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if len(srv.putTypes) != 2 {
+		t.Errorf("Expected a.png or b.png and c.png to be uploaded, got %d uploads", len(srv.putTypes))
+	}
+	var duplicates []api.ConfirmRequest
+	for _, c := range srv.confirms {
+		if c.Status == api.StatusDuplicate {
+			duplicates = append(duplicates, c)
+		}
+	}
+	if len(srv.confirms) != 3 || len(duplicates) != 1 {
+		t.Fatalf("Expected 3 confirms with 1 duplicate, got %+v", srv.confirms)
+	}
+
+	// The duplicate refers to the file that was uploaded.
+	original := filepath.Join(tmpDir, duplicates[0].DuplicateOf)
+	if name := filepath.Base(original); name != "a.png" && name != "b.png" {
+		t.Fatalf("Expected the duplicate to refer to a.png or b.png, got %q", duplicates[0].DuplicateOf)
+	}
+	if other, _ := s.GetDuplicateOf(original); other != "" {
+		t.Errorf("Expected the original %s to be uploaded itself, got a duplicate of %q", original, other)
+	}
+	if n, _ := s.CountNotUploaded(); n != 0 {
+		t.Errorf("Expected all files to be UPLOADED, got %d left", n)
+	}
+}
+
+func TestProcess_DoesNotDeduplicateOnSizeMismatch(t *testing.T) {
+	s, tmpDir := newTestStore(t)
+	srv := newMockAPI(t)
+	defer srv.Close()
+
+	content := []byte("same")
+	sum := sha256.Sum256(content)
+	// An uploaded record with the same checksum but another size, e.g. from a truncation bug.
+	modTime := time.Now().Add(-time.Hour)
+	other := filepath.Join(tmpDir, "other.png")
+	if err := s.RegisterFile(other, 99, modTime, false, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetChecksum(other, modTime, hex.EncodeToString(sum[:])); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MarkUploaded(other); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(tmpDir, "img.png")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterFile(path, int64(len(content)), time.Now(), false, false); err != nil {
+		t.Fatal(err)
+	}
+	files, err := s.GetPendingFiles(1)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected 1 pending file, got %d (err=%v)", len(files), err)
+	}
+
+	cfg := &config.Config{DeviceID: "dev", WatchPath: tmpDir, DeduplicateByHash: true}
+	logs := &bytes.Buffer{}
+	u := NewUploader(cfg, s, api.NewClient(srv.URL, "5s"), slog.New(slog.NewTextHandler(logs, nil)))
+	if _, err := u.Process(context.Background(), files[0]); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if len(srv.putTypes) != 1 || len(srv.confirms) != 1 || srv.confirms[0].Status != api.StatusSuccess {
+		t.Errorf("Expected the file to be uploaded despite the checksum match, got %d uploads and confirms %+v", len(srv.putTypes), srv.confirms)
+	}
+	if got, _ := s.GetDuplicateOf(path); got != "" {
+		t.Errorf("Expected no duplicate reference, got %q", got)
+	}
+	if !strings.Contains(logs.String(), "ChecksumSizeMismatch") {
+		t.Errorf("Expected a checksum/size mismatch warning, got logs:\n%s", logs)
+	}
+}
+
+func TestProcess_MergesSplitSidecars(t *testing.T) {
+	s, tmpDir := newTestStore(t)
+	srv := newMockAPI(t)
+	defer srv.Close()
+
+	img := filepath.Join(tmpDir, "img.png")
+	primary := filepath.Join(tmpDir, "img.json")
+	exif := filepath.Join(tmpDir, "img.exif.json")
+	files := map[string]string{
+		img:     "data",
+		primary: `{"camera": "cam1", "exposure": "primary"}`,
+		exif:    `{"exposure": "1/250", "iso": 400}`,
+	}
+	// The split sidecar arrives first, then the image, then the primary sidecar.
+	for _, path := range []string{exif, img, primary} {
+		if err := os.WriteFile(path, []byte(files[path]), 0644); err != nil {
+			t.Fatal(err)
+		}
+		isMeta := filepath.Ext(path) == ".json"
+		if err := s.RegisterFile(path, int64(len(files[path])), time.Now(), isMeta, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sidecars, err := s.GetSidecars(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sidecars) != 2 || sidecars[0] != exif || sidecars[1] != primary {
+		t.Fatalf("Expected both sidecars with the primary last, got %v", sidecars)
+	}
+
+	cfg := &config.Config{DeviceID: "dev", WatchPath: tmpDir}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	u := NewUploader(cfg, s, api.NewClient(srv.URL, "5s"), logger)
+
+	pending, err := s.GetPendingFiles(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range pending {
+		u.Process(context.Background(), f)
+	}
+
+	srv.mu.Lock()
+	requests := len(srv.requests)
+	srv.mu.Unlock()
+	if requests != 1 {
+		t.Fatalf("Expected only the image to be uploaded, got %d requests", requests)
+	}
+	dc := srv.lastRequest(t).DeviceContext
+	if dc["camera"] != "cam1" || dc["iso"] != float64(400) || dc["exposure"] != "primary" {
+		t.Errorf("Expected merged device context with the primary sidecar winning, got %v", dc)
+	}
+	meta := srv.lastRequest(t).Metadata
+	if meta["sidecar_camera"] != "cam1" || meta["sidecar_iso"] != "400" || meta["sidecar_exposure"] != "primary" {
+		t.Errorf("Expected the sidecar fields as metadata tags, got %v", meta)
+	}
+
+	uploaded, err := s.ListFiles(store.StatusUploaded, 10)
+	if err != nil || len(uploaded) != 3 {
+		t.Errorf("Expected the image and both sidecars to be UPLOADED, got %d (err=%v)", len(uploaded), err)
+	}
+}
+
+func TestProcess_UploadsContentJSONSeparatelyFromSidecars(t *testing.T) {
+	s, tmpDir := newTestStore(t)
+	s.SetSidecarMaxSize(64)
+	srv := newMockAPI(t)
+	defer srv.Close()
+
+	content := `{"rows": [` + strings.Repeat(`{"v": 1}, `, 10) + `{"v": 1}]}`
+	files := []struct {
+		name, body string
+		isMeta     bool
+	}{
+		{"img.json", `{"camera": "cam1"}`, true}, // Small: sidecar of img.png
+		{"img.png", "data", false},
+		{"report.json", content, true}, // Large, no partner: content
+		{"photo.json", content, true},  // Large: content, even next to photo.png
+		{"photo.png", "data", false},
+	}
+	for _, f := range files {
+		path := filepath.Join(tmpDir, f.name)
+		if err := os.WriteFile(path, []byte(f.body), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.RegisterFile(path, int64(len(f.body)), time.Now(), f.isMeta, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// photo.png waits for a sidecar of its own; let it go as an orphan.
+	if err := s.MarkOrphans(-time.Hour, false); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{DeviceID: "dev", WatchPath: tmpDir}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	u := NewUploader(cfg, s, api.NewClient(srv.URL, "5s"), logger)
+
+	pending, err := s.GetPendingFiles(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range pending {
+		u.Process(context.Background(), f)
+	}
+
+	srv.mu.Lock()
+	requests := make(map[string]api.IngestRequest)
+	for _, r := range srv.requests {
+		requests[r.Filename] = r
+	}
+	srv.mu.Unlock()
+	if len(requests) != 4 {
+		t.Fatalf("Expected img.png, report.json, photo.json and photo.png to be uploaded, got %v", requests)
+	}
+	if _, ok := requests["img.json"]; ok {
+		t.Error("Expected the sidecar not to be uploaded on its own")
+	}
+	if dc := requests["img.png"].DeviceContext; dc["camera"] != "cam1" {
+		t.Errorf("Expected the sidecar folded into the device context of img.png, got %v", dc)
+	}
+	for _, name := range []string{"report.json", "photo.json"} {
+		if ct := requests[name].ContentType; ct != "application/json" {
+			t.Errorf("Expected %s uploaded as application/json, got %q", name, ct)
+		}
+	}
+	if dc := requests["photo.png"].DeviceContext; len(dc) != 0 {
+		t.Errorf("Expected no device context for photo.png, got %v", dc)
+	}
+}
+
+func TestProcess_AuthenticatesAPICallsButNotPresignedPut(t *testing.T) {
+	s, tmpDir := newTestStore(t)
+
+	var mu sync.Mutex
+	auth := make(map[string]string) // Authorization header per request path
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		mu.Lock()
+		auth[r.URL.Path] = r.Header.Get("Authorization")
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/v1/ingest/request":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(api.IngestResponse{HandshakeID: "hs-1", UploadURL: srv.URL + "/upload", ExpiresAt: time.Now().Add(time.Hour)})
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(tmpDir, "img.png")
+	if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterFile(path, 4, time.Now(), false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{DeviceID: "dev", WatchPath: tmpDir}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	client := api.NewClient(srv.URL, "5s")
+	client.SetAuth("tok-123", "")
+	u := NewUploader(cfg, s, client, logger)
+
+	files, err := s.GetPendingFiles(1)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected 1 pending file, got %d (err=%v)", len(files), err)
+	}
+	if _, err := u.Process(context.Background(), files[0]); err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, p := range []string{"/v1/ingest/request", "/v1/ingest/confirm"} {
+		if auth[p] != "Bearer tok-123" {
+			t.Errorf("Expected %s to carry the bearer token, got %q", p, auth[p])
+		}
+	}
+	if v, ok := auth["/upload"]; !ok || v != "" {
+		t.Errorf("Expected the presigned PUT without an Authorization header, got %q (sent=%v)", v, ok)
+	}
+}
+
+// multipartAPI grants three 512 KiB part URLs for multipart ingest requests and
+// records the stored parts, the complete call and the confirms.
+type multipartAPI struct {
+	*httptest.Server
+	failPart int // Part number answered with 403, 0 = none
+
+	mu        sync.Mutex
+	request   api.IngestRequest
+	parts     map[int][]byte
+	completed *api.CompleteMultipartRequest
+	confirms  []api.ConfirmRequest
+}
+
+const testPartSize = 512 << 10
+
+func newMultipartAPI(t *testing.T, failPart int) *multipartAPI {
+	t.Helper()
+	m := &multipartAPI{failPart: failPart, parts: make(map[int][]byte)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/ingest/request", func(w http.ResponseWriter, r *http.Request) {
+		var req api.IngestRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		m.mu.Lock()
+		m.request = req
+		m.mu.Unlock()
+
+		resp := api.IngestResponse{HandshakeID: "hs-1", ExpiresAt: time.Now().Add(time.Hour)}
+		if req.Multipart {
+			resp.PartSizeBytes = testPartSize
+			for i := 1; i <= 3; i++ {
+				resp.PartURLs = append(resp.PartURLs, fmt.Sprintf("%s/part/%d", m.URL, i))
+			}
+		} else {
+			resp.UploadURL = m.URL + "/upload"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(resp)
+	})
+	mux.HandleFunc("/part/", func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/part/"))
+		body, _ := io.ReadAll(r.Body)
+		if n == m.failPart {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		m.mu.Lock()
+		m.parts[n] = body
+		m.mu.Unlock()
+		w.Header().Set("ETag", fmt.Sprintf(`"etag-%d"`, n))
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/v1/ingest/multipart/complete", func(w http.ResponseWriter, r *http.Request) {
+		var req api.CompleteMultipartRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		m.mu.Lock()
+		m.completed = &req
+		m.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/v1/ingest/confirm", func(w http.ResponseWriter, r *http.Request) {
+		var req api.ConfirmRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		m.mu.Lock()
+		m.confirms = append(m.confirms, req)
+		m.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	})
+	m.Server = httptest.NewServer(mux)
+	return m
+}
+
+// processLargeFile uploads a file of 2.5 parts with a 1 MB multipart threshold.
+func processLargeFile(t *testing.T, m *multipartAPI) []byte {
+	t.Helper()
+	s, tmpDir := newTestStore(t)
+
+	data := make([]byte, 2*testPartSize+testPartSize/2)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	path := filepath.Join(tmpDir, "img.png")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterFile(path, int64(len(data)), time.Now(), false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{DeviceID: "dev", WatchPath: tmpDir, MultipartThresholdMB: 1}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	u := NewUploader(cfg, s, api.NewClient(m.URL, "5s"), logger)
+
+	files, err := s.GetPendingFiles(1)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected 1 pending file, got %d (err=%v)", len(files), err)
+	}
+	u.Process(context.Background(), files[0])
+	return data
+}
+
+func TestProcess_MultipartUpload(t *testing.T) {
+	m := newMultipartAPI(t, 0)
+	defer m.Close()
+	data := processLargeFile(t, m)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.request.Multipart {
+		t.Fatal("Expected a file above the threshold to request a multipart upload")
+	}
+	for i := 1; i <= 3; i++ {
+		start := (i - 1) * testPartSize
+		end := min(start+testPartSize, len(data))
+		if !bytes.Equal(m.parts[i], data[start:end]) {
+			t.Errorf("Part %d: got %d bytes, expected bytes %d-%d of the file", i, len(m.parts[i]), start, end)
+		}
+	}
+	if m.completed == nil || len(m.completed.Parts) != 3 {
+		t.Fatalf("Expected the upload to be completed with 3 parts, got %+v", m.completed)
+	}
+	for i, p := range m.completed.Parts {
+		if p.PartNumber != i+1 || p.ETag != fmt.Sprintf(`"etag-%d"`, i+1) {
+			t.Errorf("Unexpected part %d in complete request: %+v", i, p)
+		}
+	}
+	if len(m.confirms) != 1 || m.confirms[0].Status != api.StatusSuccess {
+		t.Errorf("Expected a single SUCCESS confirm, got %+v", m.confirms)
+	}
+}
+
+func TestProcess_MultipartFailureReportsUploadedParts(t *testing.T) {
+	m := newMultipartAPI(t, 3)
+	defer m.Close()
+	processLargeFile(t, m)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.completed != nil {
+		t.Error("A failed multipart upload must not be completed")
+	}
+	if len(m.confirms) != 1 || m.confirms[0].Status != api.StatusFailed {
+		t.Fatalf("Expected a single FAILED confirm, got %+v", m.confirms)
+	}
+	if parts := m.confirms[0].UploadedParts; len(parts) != 2 || parts[1].PartNumber != 2 {
+		t.Errorf("Expected parts 1 and 2 to be reported as uploaded, got %+v", parts)
+	}
+}
+
+func TestUploadFile_ResumesFromRecordedOffset(t *testing.T) {
+	s, tmpDir := newTestStore(t)
+
+	content := "0123456789abcdef"
+	path := filepath.Join(tmpDir, "img.png")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterFile(path, int64(len(content)), time.Now(), false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var ranges, bodies []string
+	rejectRanges := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if int64(len(body)) != r.ContentLength {
+			return // Aborted by the client, may finish after the next request started
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		ranges = append(ranges, r.Header.Get("Content-Range"))
+		bodies = append(bodies, string(body))
+		if rejectRanges && r.Header.Get("Content-Range") != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	cfg := &config.Config{ResumableUploads: true}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	u := NewUploader(cfg, s, api.NewClient(srv.URL, "5s"), logger)
+
+	// An interrupted upload records how far the file was read.
+	u.openFile = func(name string) (uploadSource, error) {
+		f, err := os.Open(name)
+		return &failingSource{uploadSource: f, failAfter: 6}, err
+	}
+	if err := u.uploadFile(context.Background(), srv.URL, path, ""); err == nil {
+		t.Fatal("Expected the interrupted upload to fail")
+	}
+	if n, err := s.GetUploadedBytes(path); err != nil || n != 6 {
+		t.Fatalf("Expected 6 uploaded bytes to be recorded, got %d (err=%v)", n, err)
+	}
+
+	// The next attempt only sends the rest of the file.
+	u.openFile = func(name string) (uploadSource, error) { return os.Open(name) }
+	mu.Lock()
+	ranges, bodies = nil, nil
+	mu.Unlock()
+	if err := u.uploadFile(context.Background(), srv.URL, path, ""); err != nil {
+		t.Fatalf("Expected the resumed upload to succeed, got: %v", err)
+	}
+	mu.Lock()
+	if len(ranges) != 1 || ranges[0] != "bytes 6-15/16" || bodies[0] != content[6:] {
+		t.Errorf("Expected a single ranged PUT of the remaining bytes, got ranges %q bodies %q", ranges, bodies)
+	}
+	mu.Unlock()
+
+	// A server without range support gets the whole file.
+	if err := s.SetUploadedBytes(path, 6); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	ranges, bodies, rejectRanges = nil, nil, true
+	mu.Unlock()
+	if err := u.uploadFile(context.Background(), srv.URL, path, ""); err != nil {
+		t.Fatalf("Expected the fallback upload to succeed, got: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(bodies) != 2 || ranges[1] != "" || bodies[1] != content {
+		t.Errorf("Expected a rejected ranged PUT followed by a full PUT, got ranges %q bodies %q", ranges, bodies)
+	}
+	if n, _ := s.GetUploadedBytes(path); n != 0 {
+		t.Errorf("Expected the recorded offset to be reset after the fallback, got %d", n)
+	}
+}
+
+func TestProcess_SendsCaptureTime(t *testing.T) {
+	s, tmpDir := newTestStore(t)
+	srv := newMockAPI(t)
+	defer srv.Close()
+
+	cfg := &config.Config{DeviceID: "dev", WatchPath: tmpDir}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	u := NewUploader(cfg, s, api.NewClient(srv.URL, "5s"), logger)
+
+	upload := func(paths map[string]string, modTime time.Time) api.IngestRequest {
+		t.Helper()
+		for path, content := range paths {
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			isMeta := filepath.Ext(path) == ".json"
+			if err := s.RegisterFile(path, int64(len(content)), modTime, isMeta, len(paths) > 1); err != nil {
+				t.Fatal(err)
+			}
+		}
+		pending, err := s.GetPendingFiles(10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range pending {
+			u.Process(context.Background(), f)
+		}
+		return srv.lastRequest(t)
+	}
+
+	// Captured hours before the upload, e.g. during an outage.
+	captured := time.Now().Add(-3 * time.Hour).UTC().Truncate(time.Second)
+	req := upload(map[string]string{filepath.Join(tmpDir, "a.png"): "data"}, captured)
+	if !req.Timestamp.Equal(captured) {
+		t.Errorf("Expected the mod time %v as timestamp, got %v", captured, req.Timestamp)
+	}
+	if time.Since(req.UploadedAt) > time.Minute {
+		t.Errorf("Expected uploaded_at to be the upload time, got %v", req.UploadedAt)
+	}
+
+	// A timestamp in the sidecar wins over the mod time.
+	req = upload(map[string]string{
+		filepath.Join(tmpDir, "b.png"):      "data",
+		filepath.Join(tmpDir, "b.png.json"): `{"timestamp": "2026-03-01T12:00:00Z"}`,
+	}, captured)
+	if want := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC); !req.Timestamp.Equal(want) {
+		t.Errorf("Expected the sidecar timestamp %v, got %v", want, req.Timestamp)
+	}
+}
+
+// decryptStream reverses encryptStream, following the format documented in
+// encrypt.go, and fails on reordered, truncated or tampered chunks.
+func decryptStream(aead cipher.AEAD, prefix, data []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	copy(nonce, prefix)
+	sealedSize := encryptionChunkSize + aead.Overhead()
+	var plain []byte
+	for counter := uint32(0); ; counter++ {
+		n := min(sealedSize, len(data))
+		last := n == len(data)
+		binary.BigEndian.PutUint32(nonce[noncePrefixSize:], counter)
+		nonce[len(nonce)-1] = 0
+		if last {
+			nonce[len(nonce)-1] = 1
+		}
+		chunk, err := aead.Open(nil, nonce, data[:n], nil)
+		if err != nil {
+			return nil, fmt.Errorf("chunk %d: %w", counter, err)
+		}
+		plain = append(plain, chunk...)
+		if last {
+			return plain, nil
+		}
+		data = data[n:]
+	}
+}
+
+func TestProcess_EncryptsUploads(t *testing.T) {
+	s, tmpDir := newTestStore(t)
+	srv := newMockAPI(t)
+	defer srv.Close()
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Empty, exactly one chunk, and a partial last chunk.
+	for _, size := range []int{0, encryptionChunkSize, 2*encryptionChunkSize + 5} {
+		content := make([]byte, size)
+		rand.Read(content)
+		path := filepath.Join(tmpDir, fmt.Sprintf("img-%d.png", size))
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.RegisterFile(path, int64(size), time.Now(), false, false); err != nil {
+			t.Fatal(err)
+		}
+
+		cfg := &config.Config{DeviceID: "dev", WatchPath: tmpDir, EncryptUploads: true, EncryptionKey: base64.StdEncoding.EncodeToString(key)}
+		logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+		u := NewUploader(cfg, s, api.NewClient(srv.URL, "5s"), logger)
+		files, err := s.GetPendingFiles(1)
+		if err != nil || len(files) != 1 {
+			t.Fatalf("Expected 1 pending file, got %d (err=%v)", len(files), err)
+		}
+		if _, err := u.Process(context.Background(), files[0]); err != nil {
+			t.Fatalf("Upload of %d bytes failed: %v", size, err)
+		}
+
+		meta := srv.lastRequest(t).Metadata
+		if meta["encryption"] != "aes-256-gcm-stream" || meta["encryption_chunk_size"] != "65536" {
+			t.Errorf("Expected the encryption scheme in the metadata, got %v", meta)
+		}
+		prefix, err := base64.StdEncoding.DecodeString(meta["encryption_nonce"])
+		if err != nil || len(prefix) != noncePrefixSize {
+			t.Fatalf("Expected a %d-byte nonce prefix, got %q", noncePrefixSize, meta["encryption_nonce"])
+		}
+
+		srv.mu.Lock()
+		body := srv.bodies[len(srv.bodies)-1]
+		srv.mu.Unlock()
+		if bytes.Contains(body, content) && size > 0 {
+			t.Errorf("Expected the upload of %d bytes not to contain the plaintext", size)
+		}
+		plain, err := decryptStream(aead, prefix, body)
+		if err != nil {
+			t.Fatalf("Failed to decrypt upload of %d bytes: %v", size, err)
+		}
+		if !bytes.Equal(plain, content) {
+			t.Errorf("Decrypted upload of %d bytes does not match the file", size)
+		}
+	}
+}
@@ -23,6 +23,9 @@ type LogRotator struct {
 	MaxBackups int
 	MaxAgeDays int
 	Compress   bool
+	FileMode   os.FileMode             // Mode for new log files. Default 0644
+	DirMode    os.FileMode             // Mode for a missing log directory. Default 0755
+	OnCreate   func(name string) error // Optional hook run on each newly created file (e.g. to set ownership)
 
 	// Internal
 	size int64
@@ -100,13 +103,13 @@ func (l *LogRotator) openExistingOrNew(writeLen int) error {
 
 // openNew opens a new log file, truncating if it exists (though strictly we rely on rotation logic).
 func (l *LogRotator) openNew() error {
-	err := os.MkdirAll(filepath.Dir(l.Filename), 0755)
+	err := os.MkdirAll(filepath.Dir(l.Filename), l.dirMode())
 	if err != nil {
 		return fmt.Errorf("can't make directories for new logfile: %s", err)
 	}
 
 	name := l.Filename
-	mode := os.FileMode(0644)
+	mode := l.fileMode()
 	info, err := os.Stat(name)
 	if err == nil {
 		mode = info.Mode()
@@ -119,11 +122,30 @@ func (l *LogRotator) openNew() error {
 	if err != nil {
 		return fmt.Errorf("can't open new logfile: %s", err)
 	}
+	if l.OnCreate != nil {
+		if err := l.OnCreate(name); err != nil {
+			fmt.Fprintf(os.Stderr, "logrotator: failed to prepare %s: %v\n", name, err)
+		}
+	}
 	l.file = f
 	l.size = 0
 	return nil
 }
 
+func (l *LogRotator) fileMode() os.FileMode {
+	if l.FileMode == 0 {
+		return 0644
+	}
+	return l.FileMode
+}
+
+func (l *LogRotator) dirMode() os.FileMode {
+	if l.DirMode == 0 {
+		return 0755
+	}
+	return l.DirMode
+}
+
 // rotate closes the current file, renames it, and opens a new one.
 func (l *LogRotator) rotate() error {
 	if err := l.close(); err != nil {
@@ -159,6 +181,27 @@ func (l *LogRotator) backupName() string {
 	return filepath.Join(dir, fmt.Sprintf("%s-%s%s", prefix, timestamp, ext))
 }
 
+// IsLogFile reports whether path is the log file filename or one of its rotated
+// (and possibly compressed) backups.
+func IsLogFile(filename, path string) bool {
+	if filepath.Dir(path) != filepath.Dir(filename) {
+		return false
+	}
+	base := filepath.Base(filename)
+	name := filepath.Base(path)
+	if name == base {
+		return true
+	}
+	ext := filepath.Ext(base)
+	prefix := base[:len(base)-len(ext)]
+	ts, ok := strings.CutPrefix(strings.TrimSuffix(name, ".gz"), prefix+"-")
+	if !ok || !strings.HasSuffix(ts, ext) {
+		return false
+	}
+	_, err := time.Parse("2006-01-02T15-04-05.000", strings.TrimSuffix(ts, ext))
+	return err == nil
+}
+
 func (l *LogRotator) max() int64 {
 	if l.MaxSizeMB == 0 {
 		return int64(10 * 1024 * 1024) // Default 10MB
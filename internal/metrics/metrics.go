@@ -0,0 +1,176 @@
+package metrics
+
+// Package metrics implements the few metric types the daemon exports and
+// serves them in the Prometheus text exposition format, so fleets can be
+// scraped without parsing logs. All metric methods are safe on a nil receiver,
+// which lets components record metrics unconditionally.
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// Registry holds metrics in the order they were registered and serves them.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+type metric interface {
+	write(w io.Writer) error
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// NewCounter registers a monotonically increasing counter.
+func (r *Registry) NewCounter(name, help string) *Counter {
+	c := &Counter{name: name, help: help}
+	r.register(c)
+	return c
+}
+
+// NewGaugeFunc registers a gauge whose value is computed by fn on every scrape.
+// If fn fails the gauge is left out of that scrape.
+func (r *Registry) NewGaugeFunc(name, help string, fn func() (float64, error)) {
+	r.register(&gaugeFunc{name: name, help: help, fn: fn})
+}
+
+// NewHistogram registers a histogram with the given upper bucket bounds (ascending).
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	h := &Histogram{name: name, help: help, buckets: buckets, counts: make([]uint64, len(buckets))}
+	r.register(h)
+	return h
+}
+
+// ServeHTTP writes all metrics in the text exposition format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, m := range metrics {
+		if err := m.write(w); err != nil {
+			return
+		}
+	}
+}
+
+// Counter is a value that only goes up.
+type Counter struct {
+	name, help string
+	value      atomic.Int64
+}
+
+// Inc adds one.
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add adds n, which must not be negative.
+func (c *Counter) Add(n int64) {
+	if c == nil {
+		return
+	}
+	c.value.Add(n)
+}
+
+// Value returns the current count.
+func (c *Counter) Value() int64 {
+	if c == nil {
+		return 0
+	}
+	return c.value.Load()
+}
+
+func (c *Counter) write(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "%s%s %d\n", header(c.name, c.help, "counter"), c.name, c.value.Load())
+	return err
+}
+
+type gaugeFunc struct {
+	name, help string
+	fn         func() (float64, error)
+}
+
+func (g *gaugeFunc) write(w io.Writer) error {
+	v, err := g.fn()
+	if err != nil {
+		return nil
+	}
+	_, err = fmt.Fprintf(w, "%s%s %s\n", header(g.name, g.help, "gauge"), g.name, formatFloat(v))
+	return err
+}
+
+// Histogram counts observations in cumulative buckets.
+type Histogram struct {
+	name, help string
+	buckets    []float64
+
+	mu     sync.Mutex
+	counts []uint64 // Observations per bucket (not cumulative)
+	count  uint64
+	sum    float64
+}
+
+// Observe records one value.
+func (h *Histogram) Observe(v float64) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.buckets {
+		if v <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *Histogram) write(w io.Writer) error {
+	h.mu.Lock()
+	counts := append([]uint64(nil), h.counts...)
+	count, sum := h.count, h.sum
+	h.mu.Unlock()
+
+	if _, err := io.WriteString(w, header(h.name, h.help, "histogram")); err != nil {
+		return err
+	}
+	var cumulative uint64
+	for i, bound := range h.buckets {
+		cumulative += counts[i]
+		if _, err := fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.name, formatFloat(bound), cumulative); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n%s_sum %s\n%s_count %d\n", h.name, count, h.name, formatFloat(sum), h.name, count)
+	return err
+}
+
+func header(name, help, kind string) string {
+	return fmt.Sprintf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryServesTextFormat(t *testing.T) {
+	r := NewRegistry()
+	uploaded := r.NewCounter("fsd_files_uploaded_total", "Files uploaded.")
+	r.NewGaugeFunc("fsd_tracked_bytes", "Tracked bytes.", func() (float64, error) { return 1536, nil })
+	r.NewGaugeFunc("fsd_broken", "Fails to collect.", func() (float64, error) { return 0, errors.New("db locked") })
+	duration := r.NewHistogram("fsd_upload_duration_seconds", "Upload duration.", []float64{0.5, 1, 5})
+
+	uploaded.Inc()
+	uploaded.Add(2)
+	duration.Observe(0.2)
+	duration.Observe(0.7)
+	duration.Observe(10)
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	got := string(body)
+
+	for _, want := range []string{
+		"# TYPE fsd_files_uploaded_total counter\nfsd_files_uploaded_total 3\n",
+		"# TYPE fsd_tracked_bytes gauge\nfsd_tracked_bytes 1536\n",
+		"fsd_upload_duration_seconds_bucket{le=\"0.5\"} 1\n",
+		"fsd_upload_duration_seconds_bucket{le=\"1\"} 2\n",
+		"fsd_upload_duration_seconds_bucket{le=\"5\"} 2\n",
+		"fsd_upload_duration_seconds_bucket{le=\"+Inf\"} 3\n",
+		"fsd_upload_duration_seconds_sum 10.9\nfsd_upload_duration_seconds_count 3\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "fsd_broken") {
+		t.Errorf("Expected a failing gauge to be left out, got:\n%s", got)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Unexpected content type %q", ct)
+	}
+
+	var nilCounter *Counter
+	nilCounter.Inc() // must not panic
+}
@@ -0,0 +1,136 @@
+package pairing
+
+// Package pairing runs the device pairing flow shared by `fsd install` and the
+// daemon's re-pairing after revoked credentials: a pairing code is requested
+// and shown to the user, then its status is polled until the device is claimed
+// in the web client.
+
+import (
+	"log/slog"
+	"time"
+
+	"fs-ingest-daemon/internal/api"
+	"fs-ingest-daemon/internal/clock"
+	"fs-ingest-daemon/internal/metrics"
+)
+
+// DefaultPollInterval is how often the status of a pairing code is checked.
+const DefaultPollInterval = 5 * time.Second
+
+// ExpiryGrace is how long Wait keeps polling after a code's ExpiresAt, to
+// allow for a claim in the last moment and for clock skew with the API. Once
+// it is over the code counts as expired even if the API never said so.
+const ExpiryGrace = 30 * time.Second
+
+// Result is how a pairing attempt ended.
+type Result string
+
+const (
+	Claimed  Result = "claimed"   // The device was claimed
+	Expired  Result = "expired"   // The code expired (or its ExpiresAt plus ExpiryGrace passed) before it was claimed
+	TimedOut Result = "timed_out" // Flow.Timeout elapsed first
+	Aborted  Result = "aborted"   // The caller stopped waiting
+)
+
+// Metrics count the outcomes of pairing attempts, e.g. to spot provisioning
+// issues across a fleet. Nil counters are not recorded.
+type Metrics struct {
+	Requested *metrics.Counter // Pairing codes issued by the API
+	Claimed   *metrics.Counter
+	Expired   *metrics.Counter
+	TimedOut  *metrics.Counter
+}
+
+// Flow runs pairing attempts for a device. Client and DeviceID are required.
+type Flow struct {
+	Client       *api.Client
+	DeviceID     string
+	PollInterval time.Duration // Status poll interval, 0 = DefaultPollInterval
+	Timeout      time.Duration // Maximum wait for a code to be claimed, 0 = until it expires
+	Clock        clock.Clock   // Nil means the real clock
+	Logger       *slog.Logger  // Receives a summary of every attempt, nil = none
+	Metrics      Metrics
+}
+
+// RequestCode requests a new pairing code for the device.
+func (f *Flow) RequestCode() (*api.PairingResponse, error) {
+	resp, err := f.Client.RequestPairingCode(f.DeviceID)
+	if err != nil {
+		return nil, err
+	}
+	f.Metrics.Requested.Inc()
+	return resp, nil
+}
+
+// Wait polls the status of code until the device is claimed, the code expires,
+// Timeout elapses or stop is closed (a nil stop never is), then counts and logs
+// the outcome. A code with an ExpiresAt is given up ExpiryGrace after it, so an
+// API that never answers cannot keep Wait polling forever. Failed status checks
+// are retried on the next poll. On Claimed it also returns the API key, or
+// "provisioned" if the API issued none.
+func (f *Flow) Wait(code *api.PairingResponse, stop <-chan struct{}) (Result, string) {
+	clk := clock.OrReal(f.Clock)
+	start := clk.Now()
+	interval := f.PollInterval
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	ticker := clk.NewTicker(interval)
+	defer ticker.Stop()
+	var timeout <-chan time.Time
+	if f.Timeout > 0 {
+		timeout = clk.After(f.Timeout)
+	}
+	var expiry <-chan time.Time
+	if !code.ExpiresAt.IsZero() {
+		expiry = clk.After(max(code.ExpiresAt.Sub(start), 0) + ExpiryGrace)
+	}
+
+	polls, failed := 0, 0
+	result, apiKey := func() (Result, string) {
+		for {
+			select {
+			case <-ticker.C():
+			case <-timeout:
+				return TimedOut, ""
+			case <-expiry:
+				return Expired, ""
+			case <-stop:
+				return Aborted, ""
+			}
+
+			polls++
+			status, err := f.Client.CheckPairingStatus(f.DeviceID, code.Code)
+			if err != nil {
+				failed++
+				if f.Logger != nil {
+					f.Logger.Debug("Pairing: Failed to check the pairing status", "code", code.Code, "error", err)
+				}
+				continue
+			}
+			switch status.Status {
+			case api.PairingStatusClaimed:
+				if status.APIKey != nil {
+					return Claimed, *status.APIKey
+				}
+				return Claimed, "provisioned"
+			case api.PairingStatusExpired:
+				return Expired, ""
+			}
+		}
+	}()
+
+	switch result {
+	case Claimed:
+		f.Metrics.Claimed.Inc()
+	case Expired:
+		f.Metrics.Expired.Inc()
+	case TimedOut:
+		f.Metrics.TimedOut.Inc()
+	}
+	if f.Logger != nil {
+		f.Logger.Info("Pairing attempt finished", "device_id", f.DeviceID, "code", code.Code, "result", result,
+			"duration", clk.Now().Sub(start), "polls", polls, "failed_polls", failed)
+	}
+	return result, apiKey
+}
@@ -0,0 +1,113 @@
+package pairing
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"fs-ingest-daemon/internal/api"
+	"fs-ingest-daemon/internal/clock"
+	"fs-ingest-daemon/internal/metrics"
+)
+
+func newTestFlow(t *testing.T, status api.PairingStatus) *Flow {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/pairing/request":
+			json.NewEncoder(w).Encode(api.PairingResponse{Code: "ABC123", ExpiresAt: time.Now().Add(time.Minute)})
+		case "/v1/pairing/status":
+			key := "new-key"
+			json.NewEncoder(w).Encode(api.PairingStatusResponse{Status: status, APIKey: &key})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	r := metrics.NewRegistry()
+	return &Flow{
+		Client:       api.NewClient(srv.URL, "5s"),
+		DeviceID:     "dev",
+		PollInterval: 10 * time.Millisecond,
+		Logger:       slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		Metrics: Metrics{
+			Requested: r.NewCounter("requested", ""),
+			Claimed:   r.NewCounter("claimed", ""),
+			Expired:   r.NewCounter("expired", ""),
+			TimedOut:  r.NewCounter("timed_out", ""),
+		},
+	}
+}
+
+func TestWaitCountsExpiredCode(t *testing.T) {
+	f := newTestFlow(t, api.PairingStatusExpired)
+
+	code, err := f.RequestCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, apiKey := f.Wait(code, nil); result != Expired || apiKey != "" {
+		t.Errorf("Expected an expired code without API key, got %q %q", result, apiKey)
+	}
+
+	m := f.Metrics
+	if m.Requested.Value() != 1 || m.Expired.Value() != 1 {
+		t.Errorf("Expected 1 requested and 1 expired, got %d and %d", m.Requested.Value(), m.Expired.Value())
+	}
+	if m.Claimed.Value() != 0 || m.TimedOut.Value() != 0 {
+		t.Errorf("Expected no claimed or timed out attempts, got %d and %d", m.Claimed.Value(), m.TimedOut.Value())
+	}
+}
+
+func TestWaitCountsClaimedAndTimedOut(t *testing.T) {
+	f := newTestFlow(t, api.PairingStatusClaimed)
+	code := &api.PairingResponse{Code: "ABC123"}
+	if result, apiKey := f.Wait(code, nil); result != Claimed || apiKey != "new-key" {
+		t.Errorf("Expected a claimed code with its API key, got %q %q", result, apiKey)
+	}
+
+	f = newTestFlow(t, api.PairingStatusWaiting)
+	f.Timeout = 50 * time.Millisecond
+	if result, _ := f.Wait(code, nil); result != TimedOut {
+		t.Errorf("Expected the attempt to time out, got %q", result)
+	}
+	if f.Metrics.TimedOut.Value() != 1 {
+		t.Errorf("Expected 1 timed out attempt, got %d", f.Metrics.TimedOut.Value())
+	}
+}
+
+func TestWaitGivesUpAfterExpiresAtAndStops(t *testing.T) {
+	f := newTestFlow(t, api.PairingStatusWaiting)
+	clk := clock.NewFake(time.Now())
+	f.Clock = clk
+	code := &api.PairingResponse{Code: "ABC123", ExpiresAt: clk.Now().Add(time.Minute)}
+
+	// The API keeps answering "waiting", but the code is given up once its
+	// ExpiresAt plus the grace period is over.
+	done := make(chan Result, 1)
+	go func() {
+		result, _ := f.Wait(code, nil)
+		done <- result
+	}()
+	clk.BlockUntil(2) // Poll ticker and expiry
+	clk.Advance(time.Minute + ExpiryGrace)
+	select {
+	case result := <-done:
+		if result != Expired {
+			t.Errorf("Expected the code to expire, got %q", result)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait did not give up after the code expired")
+	}
+
+	stop := make(chan struct{})
+	close(stop)
+	if result, _ := f.Wait(code, stop); result != Aborted {
+		t.Errorf("Expected a closed stop channel to abort the wait, got %q", result)
+	}
+}
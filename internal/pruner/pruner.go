@@ -5,45 +5,98 @@ package pruner
 // It deletes files that have been successfully UPLOADED, starting with the least recently modified (LRM).
 
 import (
+	"fmt"
+	"fs-ingest-daemon/internal/clock"
 	"fs-ingest-daemon/internal/config"
+	"fs-ingest-daemon/internal/metrics"
 	"fs-ingest-daemon/internal/store"
 	"log/slog"
 	"os"
+	"path/filepath"
+	"sync/atomic"
 	"time"
 )
 
 // Pruner manages the file eviction process.
 type Pruner struct {
-	cfg    *config.Config // App configuration
-	store  *store.Store   // Reference to the database to find candidates
-	logger *slog.Logger   // Structured logger
-	stop   chan struct{}  // Channel to signal shutdown
+	cfg    atomic.Pointer[config.Config] // App configuration, replaced by SetConfig
+	store  *store.Store                  // Reference to the database to find candidates
+	logger *slog.Logger                  // Structured logger
+	stop   chan struct{}                 // Channel to signal shutdown
+
+	// OnSpaceRecovered, if set, is called when usage drops below the low watermark
+	// after a backpressure episode, so deferred work can resume.
+	OnSpaceRecovered func()
+
+	// DryRun logs the files an eviction cycle would delete instead of deleting
+	// them, like PruneDryRun in the configuration.
+	DryRun bool
+
+	// Clock drives the check interval. Nil means the real clock. The PruneMinAge
+	// check uses the store's clock.
+	Clock clock.Clock
+
+	// PrunedFiles and PrunedBytes, if set, count the files deleted by eviction cycles.
+	PrunedFiles *metrics.Counter
+	PrunedBytes *metrics.Counter
+
+	// PruneFailed, if set, counts files excluded from pruning after
+	// PruneMaxDeleteAttempts failed deletes.
+	PruneFailed *metrics.Counter
+
+	// ActiveUploads, if set, returns the number of uploads in flight. Cycles
+	// started by the check interval are deferred while it exceeds
+	// PruneDeferActiveUploads, for at most PruneMaxDefer. Set it before Start.
+	ActiveUploads func() int
+
+	backpressure atomic.Bool // Set while usage is high and nothing is deletable
+
+	diskUsage   int64     // Bytes under WatchPath at diskUsageAt, see currentUsage
+	diskUsageAt time.Time // When diskUsage was walked, zero = never
+
+	deferredSince time.Time // First cycle deferred for active uploads in a row, zero = none, see scheduledPrune
 }
 
+// diskUsageCacheTTL is how long a walk of WatchPath is reused by later prune
+// cycles when PruneUseRealDiskUsage is set, since walking a large tree is
+// expensive. Files deleted by the pruner are subtracted in the meantime.
+const diskUsageCacheTTL = 5 * time.Minute
+
+// defaultMaxDefer bounds how long cycles yield to uploads if PruneMaxDefer is
+// unset or invalid, so the pruner still runs under sustained load.
+const defaultMaxDefer = 10 * time.Minute
+
 // NewPruner creates a new Pruner instance.
 func NewPruner(cfg *config.Config, s *store.Store, logger *slog.Logger) *Pruner {
-	return &Pruner{
-		cfg:    cfg,
+	p := &Pruner{
 		store:  s,
 		logger: logger,
 		stop:   make(chan struct{}),
 	}
+	p.cfg.Store(cfg)
+	return p
+}
+
+// SetConfig replaces the configuration used by later prune cycles, e.g. after a
+// reload with new watermarks. The check interval is only read by Start.
+func (p *Pruner) SetConfig(cfg *config.Config) {
+	p.cfg.Store(cfg)
 }
 
 // Start runs the pruning logic in a background goroutine, checking based on config interval.
 func (p *Pruner) Start() {
-	interval, err := time.ParseDuration(p.cfg.PruneCheckInterval)
+	interval, err := time.ParseDuration(p.cfg.Load().PruneCheckInterval)
 	if err != nil {
 		interval = 1 * time.Minute
 		p.logger.Error("Invalid prune check interval, defaulting to 1m", "error", err)
 	}
 
-	ticker := time.NewTicker(interval)
+	ticker := clock.OrReal(p.Clock).NewTicker(interval)
 	go func() {
 		for {
 			select {
-			case <-ticker.C:
-				p.Prune()
+			case <-ticker.C():
+				p.scheduledPrune()
 			case <-p.stop:
 				ticker.Stop()
 				return
@@ -57,16 +110,89 @@ func (p *Pruner) Stop() {
 	close(p.stop)
 }
 
-// Prune checks the total size of files and evicts old uploaded files if the limit is exceeded.
-func (p *Pruner) Prune() {
-	maxBytes := int64(p.cfg.MaxDataSizeGB * 1024 * 1024 * 1024)
+// scheduledPrune runs a prune cycle for the check interval, unless more than
+// PruneDeferActiveUploads uploads are in flight: os.Remove calls compete with
+// them for the disk on slow devices, so the cycle waits for a quieter tick.
+// Once cycles were deferred for PruneMaxDefer, one runs regardless.
+func (p *Pruner) scheduledPrune() {
+	cfg := p.cfg.Load()
+	if cfg.PruneDeferActiveUploads <= 0 || p.ActiveUploads == nil {
+		p.deferredSince = time.Time{}
+		p.Prune()
+		return
+	}
+	active := p.ActiveUploads()
+	if active <= cfg.PruneDeferActiveUploads {
+		p.deferredSince = time.Time{}
+		p.Prune()
+		return
+	}
+
+	now := clock.OrReal(p.Clock).Now()
+	if p.deferredSince.IsZero() {
+		p.deferredSince = now
+	}
+	if maxDefer := p.maxDefer(cfg); now.Sub(p.deferredSince) >= maxDefer {
+		p.logger.Info("Pruner: Running deferred cycle despite active uploads", "active_uploads", active, "deferred_for", now.Sub(p.deferredSince))
+		p.deferredSince = time.Time{}
+		p.Prune()
+		return
+	}
+	p.logger.Debug("Pruner: Deferring cycle while uploads are active", "active_uploads", active, "threshold", cfg.PruneDeferActiveUploads)
+}
+
+// maxDefer returns the configured PruneMaxDefer, or defaultMaxDefer if unset or invalid.
+func (p *Pruner) maxDefer(cfg *config.Config) time.Duration {
+	d, err := time.ParseDuration(cfg.PruneMaxDefer)
+	if err != nil || d <= 0 {
+		return defaultMaxDefer
+	}
+	return d
+}
+
+// Backpressured reports whether the pruner is currently unable to free space
+// because no UPLOADED files are left to delete.
+func (p *Pruner) Backpressured() bool {
+	return p.backpressure.Load()
+}
+
+// clearBackpressure leaves backpressure mode and notifies OnSpaceRecovered.
+func (p *Pruner) clearBackpressure(currentSize int64) {
+	if !p.backpressure.CompareAndSwap(true, false) {
+		return
+	}
+	p.logger.Info("Pruner: Disk usage recovered below low watermark, backpressure cleared", "current_size", currentSize)
+	if p.OnSpaceRecovered != nil {
+		p.OnSpaceRecovered()
+	}
+}
+
+// minAge returns the configured PruneMinAge, or 0 if unset or invalid.
+func (p *Pruner) minAge(cfg *config.Config) time.Duration {
+	if cfg.PruneMinAge == "" {
+		return 0
+	}
+	minAge, err := time.ParseDuration(cfg.PruneMinAge)
+	if err != nil {
+		p.logger.Error("Invalid prune min age, ignoring", "value", cfg.PruneMinAge, "error", err)
+		return 0
+	}
+	return minAge
+}
+
+// Prune checks the total size of files and evicts old uploaded files if the
+// limit is exceeded. It returns the number of files deleted, or in dry-run mode
+// the number that would have been.
+func (p *Pruner) Prune() int {
+	cfg := p.cfg.Load()
+	maxBytes := int64(cfg.MaxDataSizeGB * 1024 * 1024 * 1024)
 
 	// Calculate Hysteresis Watermarks
-	highMark := p.cfg.PruneHighWatermarkPercent
+	highMark := cfg.PruneHighWatermarkPercent
 	if highMark <= 0 {
 		highMark = 90
 	}
-	lowMark := p.cfg.PruneLowWatermarkPercent
+	lowMark := cfg.PruneLowWatermarkPercent
 	if lowMark <= 0 {
 		lowMark = 75
 	}
@@ -74,15 +200,21 @@ func (p *Pruner) Prune() {
 	highWatermarkBytes := int64(float64(maxBytes) * float64(highMark) / 100.0)
 	lowWatermarkBytes := int64(float64(maxBytes) * float64(lowMark) / 100.0)
 
-	// Get total tracked size from DB
-	currentSize, err := p.store.GetTotalSize()
+	minAge := p.minAge(cfg)
+	dryRun := p.DryRun || cfg.PruneDryRun
+
+	currentSize, err := p.currentUsage(cfg)
 	if err != nil {
 		p.logger.Error("Pruner: Error getting total size", "error", err)
-		return
+		return 0
+	}
+
+	if currentSize <= lowWatermarkBytes {
+		p.clearBackpressure(currentSize)
 	}
 
 	if currentSize <= highWatermarkBytes {
-		return // usage is within limits
+		return 0 // usage is within limits
 	}
 
 	p.logger.Info("Pruner: High watermark exceeded",
@@ -92,31 +224,70 @@ func (p *Pruner) Prune() {
 		"target_low_watermark_bytes", lowWatermarkBytes,
 		"status", "starting_eviction")
 
+	// In dry-run mode nothing is removed from the DB, so candidates already
+	// counted are skipped when fetching the next batch.
+	var simulated map[string]struct{}
+	if dryRun {
+		simulated = make(map[string]struct{})
+	}
+
 	// Eviction Loop
+	evicted := 0
 	for currentSize > lowWatermarkBytes {
 		// Fetch candidates for deletion.
 		// Only files with status='UPLOADED' are eligible.
-		candidates, err := p.store.GetPruneCandidates(p.cfg.PruneBatchSize)
+		candidates, err := p.store.GetPruneCandidates(cfg.PruneBatchSize+len(simulated), minAge)
 		if err != nil {
 			p.logger.Error("Pruner: Error fetching candidates", "error", err)
-			return
+			return evicted
+		}
+		if dryRun {
+			candidates = skipSimulated(candidates, simulated)
 		}
 
 		// Backpressure mechanism:
 		// If the disk is full but we have no uploaded files to delete, we are in a critical state.
 		// We cannot delete PENDING files as that would mean data loss.
+		// Recently uploaded files protected by PruneMinAge are deferred, not deleted.
+		if len(candidates) == 0 && dryRun {
+			p.logger.Warn("Pruner: Dry run ran out of UPLOADED files to delete, backpressure would activate", "projected_final_size", currentSize)
+			return evicted
+		}
 		if len(candidates) == 0 {
-			p.logger.Warn("Pruner: Disk usage high but no UPLOADED files to delete! Backpressure active.", "current_size", currentSize)
-			return
+			p.logger.Warn("Pruner: Disk usage high but no UPLOADED files to delete! Backpressure active.", "current_size", currentSize, "prune_min_age", minAge)
+			p.backpressure.Store(true)
+			return evicted
 		}
 
 		deletedCount := 0
 		// Evict candidates
 		for _, f := range candidates {
+			if dryRun {
+				p.logger.Info("Pruner: Dry run, would prune file", "path", f.Path, "size", f.Size)
+				simulated[f.Path] = struct{}{}
+				currentSize -= f.Size
+				deletedCount++
+				if currentSize <= lowWatermarkBytes {
+					break
+				}
+				continue
+			}
+
+			// With real disk usage, count what the file occupies now rather
+			// than its size when it was registered.
+			size := f.Size
+			if cfg.PruneUseRealDiskUsage {
+				if info, err := os.Stat(f.Path); err == nil {
+					size = info.Size()
+				} else if os.IsNotExist(err) {
+					size = 0
+				}
+			}
+
 			// Attempt to remove the file from filesystem
 			err := os.Remove(f.Path)
 			if err != nil && !os.IsNotExist(err) {
-				p.logger.Error("Pruner: Failed to remove file", "path", f.Path, "error", err)
+				p.removeFailed(cfg, f, err)
 				continue
 			}
 
@@ -124,8 +295,11 @@ func (p *Pruner) Prune() {
 			if err := p.store.RemoveFile(f.Path); err != nil {
 				p.logger.Error("Pruner: Failed to remove DB record", "path", f.Path, "error", err)
 			} else {
-				p.logger.Info("Pruned file", "path", f.Path, "size", f.Size)
-				currentSize -= f.Size // Decrement local tracker
+				p.logger.Info("Pruned file", "path", f.Path, "size", size)
+				p.PrunedFiles.Inc()
+				p.PrunedBytes.Add(size)
+				currentSize -= size // Decrement local tracker
+				p.diskUsage -= size
 				deletedCount++
 			}
 
@@ -134,6 +308,7 @@ func (p *Pruner) Prune() {
 			}
 		}
 
+		evicted += deletedCount
 		if deletedCount == 0 {
 			// Avoid infinite loop if we have candidates but fail to delete them
 			p.logger.Error("Pruner: Failed to delete any files in batch, aborting cycle")
@@ -141,5 +316,77 @@ func (p *Pruner) Prune() {
 		}
 	}
 
-	p.logger.Info("Pruner: Eviction cycle complete", "final_size", currentSize)
+	if dryRun {
+		p.logger.Info("Pruner: Dry run complete, nothing deleted", "would_prune", evicted, "projected_final_size", currentSize)
+		return evicted
+	}
+
+	p.logger.Info("Pruner: Eviction cycle complete", "pruned", evicted, "final_size", currentSize)
+
+	if currentSize <= lowWatermarkBytes {
+		p.clearBackpressure(currentSize)
+	}
+	return evicted
+}
+
+// removeFailed records a failed delete of f. After PruneMaxDeleteAttempts
+// failures the file is excluded from later cycles, so an undeletable file (e.g.
+// permission denied) does not block eviction and spam the log forever.
+func (p *Pruner) removeFailed(cfg *config.Config, f store.FileRecord, err error) {
+	attempts, excluded, dbErr := p.store.RecordPruneFailure(f.Path, cfg.PruneMaxDeleteAttempts)
+	if dbErr != nil {
+		p.logger.Error("Pruner: Failed to remove file", "path", f.Path, "error", err)
+		p.logger.Error("Pruner: Failed to record the failed delete", "path", f.Path, "error", dbErr)
+		return
+	}
+	if !excluded {
+		p.logger.Error("Pruner: Failed to remove file", "path", f.Path, "attempts", attempts, "error", err)
+		return
+	}
+	p.logger.Error("Pruner: Giving up on deleting file, excluding it from pruning until it changes",
+		"event", "PruneFailed", "path", f.Path, "size", f.Size, "attempts", attempts, "error", err)
+	p.PruneFailed.Inc()
+}
+
+// currentUsage returns the bytes counted against MaxDataSizeGB: the sizes of
+// the tracked files as recorded in the DB, or with PruneUseRealDiskUsage the
+// size of all files under WatchPath, walked at most every diskUsageCacheTTL.
+func (p *Pruner) currentUsage(cfg *config.Config) (int64, error) {
+	if !cfg.PruneUseRealDiskUsage {
+		return p.store.GetTotalSize()
+	}
+
+	now := clock.OrReal(p.Clock).Now()
+	if !p.diskUsageAt.IsZero() && now.Sub(p.diskUsageAt) < diskUsageCacheTTL {
+		return p.diskUsage, nil
+	}
+	var total int64
+	err := filepath.Walk(cfg.WatchPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) { // Deleted while walking
+				return nil
+			}
+			return err
+		}
+		if info.Mode().IsRegular() {
+			total += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("walk %s: %w", cfg.WatchPath, err)
+	}
+	p.diskUsage, p.diskUsageAt = total, now
+	return total, nil
+}
+
+// skipSimulated drops candidates already counted by an earlier dry-run batch.
+func skipSimulated(candidates []store.FileRecord, simulated map[string]struct{}) []store.FileRecord {
+	var remaining []store.FileRecord
+	for _, f := range candidates {
+		if _, ok := simulated[f.Path]; !ok {
+			remaining = append(remaining, f)
+		}
+	}
+	return remaining
 }
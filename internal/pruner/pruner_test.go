@@ -1,11 +1,14 @@
 package pruner
 
 import (
+	"bytes"
+	"fs-ingest-daemon/internal/clock"
 	"fs-ingest-daemon/internal/config"
 	"fs-ingest-daemon/internal/store"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -181,3 +184,362 @@ func exists(path string) bool {
 	_, err := os.Stat(path)
 	return !os.IsNotExist(err)
 }
+
+func TestPruner_MinAgeProtectsRecentUploads(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "pruner_min_age_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s, err := store.NewStore(filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	cfg := &config.Config{
+		MaxDataSizeGB:  float64(100) / (1024 * 1024 * 1024), // 100 bytes
+		PruneBatchSize: 10,
+		PruneMinAge:    "1h",
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	p := NewPruner(cfg, s, logger)
+
+	// Uploaded just now, well over the watermark, but younger than PruneMinAge.
+	recent := filepath.Join(tmpDir, "recent.dat")
+	createFile(t, recent, 1024)
+	s.RegisterFile(recent, 1024, time.Now().Add(-2*time.Hour), false, true)
+	s.MarkUploaded(recent)
+
+	p.Prune()
+
+	if !exists(recent) {
+		t.Error("Recently uploaded file was deleted despite PruneMinAge")
+	}
+	if !p.Backpressured() {
+		t.Error("Expected pruner to report backpressure when only recent uploads remain")
+	}
+}
+
+func TestPruner_FakeClockDrivesIntervalAndMinAge(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "pruner_clock_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s, err := store.NewStore(filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	s.SetClock(clk)
+
+	cfg := &config.Config{
+		MaxDataSizeGB:      float64(100) / (1024 * 1024 * 1024), // 100 bytes
+		PruneBatchSize:     10,
+		PruneMinAge:        "10m",
+		PruneCheckInterval: "1m",
+	}
+	p := NewPruner(cfg, s, slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	p.Clock = clk
+
+	uploaded := filepath.Join(tmpDir, "uploaded.dat")
+	createFile(t, uploaded, 1024)
+	s.RegisterFile(uploaded, 1024, clk.Now().Add(-time.Hour), false, true)
+	s.MarkUploaded(uploaded) // uploaded_at = fake now
+
+	p.Start()
+	defer p.Stop()
+	clk.BlockUntil(1)
+
+	// First tick: the upload is only a minute old, so it is protected.
+	clk.Advance(time.Minute)
+	waitFor(t, p.Backpressured)
+	if !exists(uploaded) {
+		t.Fatal("File was pruned before PruneMinAge elapsed")
+	}
+
+	// Ten minutes later the file is old enough and the next tick prunes it.
+	clk.Advance(10 * time.Minute)
+	waitFor(t, func() bool { return !exists(uploaded) })
+}
+
+// waitFor polls cond until it holds or the test times out.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Condition not met within 5s")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestPruner_DryRunFromConfig(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "pruner_dry_run_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s, err := store.NewStore(filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// Same setup as the hysteresis test: a real run would delete f1-f4.
+	cfg := &config.Config{
+		MaxDataSizeGB:             float64(100) / (1024 * 1024 * 1024), // 100 bytes
+		PruneBatchSize:            1,
+		PruneHighWatermarkPercent: 80,
+		PruneLowWatermarkPercent:  40,
+		PruneDryRun:               true,
+	}
+	var logs bytes.Buffer
+	p := NewPruner(cfg, s, slog.New(slog.NewTextHandler(&logs, nil)))
+
+	files := []string{"f1", "f2", "f3", "f4", "f5", "f6"}
+	for i, name := range files {
+		path := filepath.Join(tmpDir, name)
+		createFile(t, path, 20)
+		s.RegisterFile(path, 20, time.Now().Add(time.Duration(-len(files)+i)*time.Minute), false, true)
+		s.MarkUploaded(path)
+	}
+
+	p.Prune()
+
+	for _, name := range files {
+		if !exists(filepath.Join(tmpDir, name)) {
+			t.Errorf("%s was deleted in dry-run mode", name)
+		}
+	}
+	if size, _ := s.GetTotalSize(); size != 120 {
+		t.Errorf("Expected DB records to be kept (120 bytes), got %d", size)
+	}
+
+	output := logs.String()
+	for i, name := range files {
+		logged := strings.Contains(output, "path="+filepath.Join(tmpDir, name)+" size=20")
+		if want := i < 4; logged != want {
+			t.Errorf("Expected %s logged as would-prune=%v, got:\n%s", name, want, output)
+		}
+	}
+	if !strings.Contains(output, "projected_final_size=40") {
+		t.Errorf("Expected the projected final size of a real run, got:\n%s", output)
+	}
+}
+
+func TestPruner_RealDiskUsage(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "pruner_disk_usage_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s, err := store.NewStore(filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	watchDir := filepath.Join(tmpDir, "data")
+	if err := os.MkdirAll(watchDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// The files grew after registration: the DB tracks 10 bytes each, the
+	// disk holds 100 each, for 300 of a 500 byte limit (high 80%, low 40%).
+	files := []string{"f1", "f2", "f3"}
+	for i, name := range files {
+		path := filepath.Join(watchDir, name)
+		createFile(t, path, 100)
+		s.RegisterFile(path, 10, time.Now().Add(time.Duration(-len(files)+i)*time.Minute), false, true)
+		s.MarkUploaded(path)
+	}
+	// An untracked file still takes up space.
+	createFile(t, filepath.Join(watchDir, "untracked"), 150)
+
+	cfg := &config.Config{
+		WatchPath:                 watchDir,
+		MaxDataSizeGB:             float64(500) / (1024 * 1024 * 1024),
+		PruneBatchSize:            10,
+		PruneHighWatermarkPercent: 80,
+		PruneLowWatermarkPercent:  40,
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	// Tracked sizes (30 bytes) are far below the watermark.
+	if n := NewPruner(cfg, s, logger).Prune(); n != 0 {
+		t.Fatalf("Expected no eviction based on tracked sizes, got %d", n)
+	}
+
+	// Real usage is 450 bytes, above the 400 byte high watermark: f1, f2 and f3
+	// go to get below the 200 byte low watermark.
+	cfg.PruneUseRealDiskUsage = true
+	clk := clock.NewFake(time.Now())
+	p := NewPruner(cfg, s, logger)
+	p.Clock = clk
+	if n := p.Prune(); n != 3 {
+		t.Errorf("Expected 3 files evicted based on real usage, got %d", n)
+	}
+	for _, name := range files {
+		if exists(filepath.Join(watchDir, name)) {
+			t.Errorf("%s should have been deleted", name)
+		}
+	}
+
+	// The cached usage (150 bytes) is reused until it expires, so a file
+	// written in between is only seen after the next walk.
+	path := filepath.Join(watchDir, "f4")
+	createFile(t, path, 300)
+	s.RegisterFile(path, 10, time.Now(), false, true)
+	s.MarkUploaded(path)
+	if n := p.Prune(); n != 0 {
+		t.Errorf("Expected the cached usage to be reused, got %d evictions", n)
+	}
+	clk.Advance(diskUsageCacheTTL)
+	if n := p.Prune(); n != 1 || exists(path) {
+		t.Errorf("Expected f4 to be evicted after the cache expired, got %d evictions", n)
+	}
+}
+
+func TestPruner_SkipsUndeletableFileAfterRetries(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "pruner_undeletable_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s, err := store.NewStore(filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// The oldest upload cannot be deleted: a non-empty directory fails to be
+	// removed even when the test runs as root, like a permission-denied file.
+	stuck := filepath.Join(tmpDir, "stuck")
+	if err := os.MkdirAll(filepath.Join(stuck, "child"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterFile(stuck, 100, time.Now().Add(-time.Hour), false, false)
+	s.MarkUploaded(stuck)
+	files := []string{"f1", "f2"}
+	for i, name := range files {
+		path := filepath.Join(tmpDir, name)
+		createFile(t, path, 100)
+		s.RegisterFile(path, 100, time.Now().Add(time.Duration(-len(files)+i)*time.Minute), false, false)
+		s.MarkUploaded(path)
+	}
+
+	// 300 of a 300 byte limit: above the high watermark, and the low
+	// watermark (40%) needs two files gone.
+	cfg := &config.Config{
+		MaxDataSizeGB:             float64(300) / (1024 * 1024 * 1024),
+		PruneBatchSize:            1,
+		PruneHighWatermarkPercent: 80,
+		PruneLowWatermarkPercent:  40,
+		PruneMaxDeleteAttempts:    3,
+	}
+	var logs bytes.Buffer
+	p := NewPruner(cfg, s, slog.New(slog.NewTextHandler(&logs, nil)))
+
+	// One candidate per batch: the stuck file blocks each cycle until the
+	// pruner gives up on it.
+	for cycle := 1; cycle <= 3; cycle++ {
+		if n := p.Prune(); n != 0 {
+			t.Fatalf("Cycle %d: expected nothing pruned while the stuck file heads the queue, got %d", cycle, n)
+		}
+	}
+	if !strings.Contains(logs.String(), "event=PruneFailed") {
+		t.Errorf("Expected a PruneFailed event after 3 failed deletes, got logs:\n%s", logs.String())
+	}
+
+	if n := p.Prune(); n != 2 {
+		t.Fatalf("Expected f1 and f2 to be pruned once the stuck file is skipped, got %d", n)
+	}
+	for _, name := range files {
+		if exists(filepath.Join(tmpDir, name)) {
+			t.Errorf("%s should have been deleted", name)
+		}
+	}
+	if !exists(stuck) {
+		t.Error("The undeletable file should still exist")
+	}
+	candidates, err := s.GetPruneCandidates(10, 0)
+	if err != nil || len(candidates) != 0 {
+		t.Errorf("Expected the stuck file to be excluded from candidates, got %v (err=%v)", candidates, err)
+	}
+
+	if n, err := s.ClearPruneFailures(); err != nil || n != 1 {
+		t.Errorf("Expected ClearPruneFailures to reset 1 file, got %d (err=%v)", n, err)
+	}
+	if candidates, _ := s.GetPruneCandidates(10, 0); len(candidates) != 1 {
+		t.Errorf("Expected the stuck file to be a candidate again, got %v", candidates)
+	}
+}
+
+func TestPruner_DefersWhileUploadsAreActive(t *testing.T) {
+	tmpDir := t.TempDir()
+	s, err := store.NewStore(filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	cfg := &config.Config{
+		MaxDataSizeGB:           float64(100) / (1024 * 1024 * 1024), // 100 bytes
+		PruneBatchSize:          10,
+		PruneDeferActiveUploads: 1,
+		PruneMaxDefer:           "5m",
+	}
+	p := NewPruner(cfg, s, slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	p.Clock = clk
+	active := 2
+	p.ActiveUploads = func() int { return active }
+
+	addUploaded := func(name string) string {
+		path := filepath.Join(tmpDir, name)
+		createFile(t, path, 1024)
+		s.RegisterFile(path, 1024, clk.Now().Add(-time.Hour), false, true)
+		s.MarkUploaded(path)
+		return path
+	}
+
+	first := addUploaded("first.dat")
+	p.scheduledPrune()
+	clk.Advance(time.Minute)
+	p.scheduledPrune()
+	if !exists(first) {
+		t.Fatal("Expected pruning to be deferred while uploads are active")
+	}
+
+	// Once the uploads drain, the next cycle runs.
+	active = 1
+	p.scheduledPrune()
+	if exists(first) {
+		t.Fatal("Expected the deferred cycle to run once uploads drained")
+	}
+
+	// Under sustained load a cycle runs after PruneMaxDefer anyway.
+	second := addUploaded("second.dat")
+	active = 5
+	p.scheduledPrune()
+	clk.Advance(4 * time.Minute)
+	p.scheduledPrune()
+	if !exists(second) {
+		t.Fatal("Expected pruning to be deferred before PruneMaxDefer")
+	}
+	clk.Advance(time.Minute)
+	p.scheduledPrune()
+	if exists(second) {
+		t.Fatal("Expected a cycle to run after PruneMaxDefer despite active uploads")
+	}
+}
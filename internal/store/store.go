@@ -6,10 +6,15 @@ package store
 
 import (
 	"database/sql"
+	"fmt"
+	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
+	"fs-ingest-daemon/internal/clock"
+
 	_ "modernc.org/sqlite"
 )
 
@@ -23,20 +28,71 @@ const (
 	StatusOrphan          FileStatus = "ORPHAN"           // Partner did not arrive in time
 )
 
+// statusFailed marked files whose retries were exhausted before they moved to
+// the dead_letters table; migrate moves any left in an existing database.
+const statusFailed FileStatus = "FAILED"
+
 // FileRecord represents a row in the 'files' table.
 type FileRecord struct {
-	ID          int64
-	Path        string
-	Size        int64
-	ModTime     time.Time
-	Status      FileStatus
-	UploadedAt  sql.NullTime
-	PartnerPath sql.NullString
+	ID            int64
+	Path          string
+	Size          int64
+	ModTime       time.Time
+	Status        FileStatus
+	UploadedAt    sql.NullTime
+	PartnerPath   sql.NullString
+	LastError     sql.NullString // Reason for the most recent failed upload attempt, cleared on success
+	Version       int64          // Incremented every time the path is (re-)registered, e.g. when overwritten
+	Priority      int            // Higher values are uploaded first, see SetPriority
+	BackupStatus  sql.NullString // Status of the copy at the backup endpoint (PENDING/UPLOADED), NULL if none
+	RetryCount    int            // Failed upload attempts since the file was last registered
+	NextRetryAt   sql.NullTime   // The file is not handed out for upload before this time, see RecordFailure
+	UploadedBytes int64          // Bytes of the current version sent before an interrupted upload, see SetUploadedBytes
+}
+
+// fileColumns is the column list matching scanFile.
+const fileColumns = `id, path, size, mod_time, status, uploaded_at, partner_path, last_error, version, priority, backup_status, retry_count, next_retry_at, uploaded_bytes`
+
+// rowScanner is satisfied by *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanFile scans a row selected with fileColumns into a FileRecord.
+func scanFile(r rowScanner) (FileRecord, error) {
+	var f FileRecord
+	err := r.Scan(&f.ID, &f.Path, &f.Size, &f.ModTime, &f.Status, &f.UploadedAt, &f.PartnerPath, &f.LastError, &f.Version, &f.Priority, &f.BackupStatus, &f.RetryCount, &f.NextRetryAt, &f.UploadedBytes)
+	return f, err
+}
+
+// queryFiles runs a query selecting fileColumns and collects the resulting records.
+func (s *Store) queryFiles(query string, args ...any) ([]FileRecord, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []FileRecord
+	for rows.Next() {
+		f, err := scanFile(rows)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
 }
 
 // Store wraps the SQL database connection.
 type Store struct {
-	db *sql.DB
+	db    *sql.DB
+	clock clock.Clock // Source of "now" for timestamps and age checks
+
+	prioritizePairs  bool          // GetPendingFiles returns complete pairs before orphans, see SetPrioritizePairs
+	uploadDelay      time.Duration // GetPendingFiles skips files modified more recently, see SetUploadDelay
+	reuploadOnModify bool          // RegisterFile re-queues modified UPLOADED files, see SetReuploadOnModify
+	sidecarMaxSize   int64         // Larger .json files are content, not sidecars, see SetSidecarMaxSize
 }
 
 // NewStore initializes the SQLite database connection and runs migrations.
@@ -63,7 +119,7 @@ func NewStore(dbPath string) (*Store, error) {
 		return nil, err
 	}
 
-	s := &Store{db: db}
+	s := &Store{db: db, clock: clock.Real{}, reuploadOnModify: true}
 	if err := s.migrate(); err != nil {
 		db.Close()
 		return nil, err
@@ -72,11 +128,115 @@ func NewStore(dbPath string) (*Store, error) {
 	return s, nil
 }
 
+// OpenReadOnly opens an existing database for queries only, e.g. from the CLI
+// while the daemon is running. Thanks to WAL mode, reads are not blocked by the
+// daemon's writes; the busy timeout covers checkpoints. The schema is not migrated.
+func OpenReadOnly(dbPath string) (*Store, error) {
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", "file:"+dbPath+"?mode=ro&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{db: db, clock: clock.Real{}, reuploadOnModify: true}, nil
+}
+
+// SetClock replaces the clock used for timestamps and age checks (e.g. with a
+// clock.Fake in tests).
+func (s *Store) SetClock(c clock.Clock) {
+	s.clock = clock.OrReal(c)
+}
+
+// SetPrioritizePairs makes GetPendingFiles return PENDING files (complete pairs)
+// before ORPHAN files of the same priority, regardless of mod_time, so complete
+// data lands first when the worker pool is small.
+func (s *Store) SetPrioritizePairs(enabled bool) {
+	s.prioritizePairs = enabled
+}
+
+// SetUploadDelay makes GetPendingFiles hold back files modified less than d ago,
+// so related files (e.g. the frames of a burst) accumulate and upload together.
+func (s *Store) SetUploadDelay(d time.Duration) {
+	s.uploadDelay = d
+}
+
+// SetReuploadOnModify controls what RegisterFile does with an UPLOADED file:
+// re-queue it for upload (the default) or keep it UPLOADED, only recording a
+// changed size or mod time.
+func (s *Store) SetReuploadOnModify(enabled bool) {
+	s.reuploadOnModify = enabled
+}
+
+// SetSidecarMaxSize makes RegisterFile treat .json files larger than n bytes
+// as content: they are uploaded on their own like a data file and are never
+// paired with one. 0 (the default) treats every .json file as a sidecar.
+func (s *Store) SetSidecarMaxSize(n int64) {
+	s.sidecarMaxSize = n
+}
+
 // Close closes the database connection.
 func (s *Store) Close() error {
 	return s.db.Close()
 }
 
+// MaintenanceReport is the outcome of a Maintenance run.
+type MaintenanceReport struct {
+	SizeBefore int64 // Bytes of the database (pages in use and free) before the run
+	SizeAfter  int64 // Bytes of the database after the run
+	Vacuumed   bool  // The database was rebuilt with VACUUM
+	WALBusy    bool  // The WAL could not be checkpointed completely, e.g. because a reader held it
+}
+
+// Maintenance keeps the database file from growing without bound: it updates
+// the query planner statistics (ANALYZE), rebuilds the file without the pages
+// freed by deleted records if vacuum is set (VACUUM), and checkpoints and
+// truncates the WAL. VACUUM rewrites the whole database and blocks writers
+// until it is done, so callers skip it while the database is busy.
+func (s *Store) Maintenance(vacuum bool) (MaintenanceReport, error) {
+	var r MaintenanceReport
+	var err error
+	if r.SizeBefore, err = s.dbSize(); err != nil {
+		return r, err
+	}
+	if _, err := s.db.Exec(`ANALYZE`); err != nil {
+		return r, fmt.Errorf("analyze: %w", err)
+	}
+	if vacuum {
+		if _, err := s.db.Exec(`VACUUM`); err != nil {
+			return r, fmt.Errorf("vacuum: %w", err)
+		}
+		r.Vacuumed = true
+	}
+	// Last, as VACUUM writes the rebuilt pages to the WAL.
+	var busy, walPages, checkpointed int
+	if err := s.db.QueryRow(`PRAGMA wal_checkpoint(TRUNCATE)`).Scan(&busy, &walPages, &checkpointed); err != nil {
+		return r, fmt.Errorf("wal checkpoint: %w", err)
+	}
+	r.WALBusy = busy != 0
+	if r.SizeAfter, err = s.dbSize(); err != nil {
+		return r, err
+	}
+	return r, nil
+}
+
+// dbSize returns the size of the database in bytes.
+func (s *Store) dbSize() (int64, error) {
+	var pages, pageSize int64
+	if err := s.db.QueryRow(`PRAGMA page_count`).Scan(&pages); err != nil {
+		return 0, err
+	}
+	if err := s.db.QueryRow(`PRAGMA page_size`).Scan(&pageSize); err != nil {
+		return 0, err
+	}
+	return pages * pageSize, nil
+}
+
 // migrate creates the necessary tables and indexes if they don't exist.
 func (s *Store) migrate() error {
 	query := `
@@ -90,17 +250,130 @@ func (s *Store) migrate() error {
 		partner_path TEXT
 	);
 	CREATE INDEX IF NOT EXISTS idx_status_mod_time ON files(status, mod_time);
+	CREATE TABLE IF NOT EXISTS state (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS upload_attempts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		path TEXT NOT NULL,
+		attempted_at DATETIME NOT NULL,
+		outcome TEXT NOT NULL,
+		http_status INTEGER NOT NULL DEFAULT 0,
+		duration_ms INTEGER NOT NULL DEFAULT 0,
+		error TEXT
+	);
+	CREATE INDEX IF NOT EXISTS idx_upload_attempts_path ON upload_attempts(path, id);
+	CREATE TABLE IF NOT EXISTS dead_letters (
+		id INTEGER PRIMARY KEY,
+		path TEXT NOT NULL UNIQUE,
+		size INTEGER NOT NULL,
+		mod_time DATETIME NOT NULL,
+		status TEXT NOT NULL,
+		uploaded_at DATETIME,
+		partner_path TEXT,
+		last_error TEXT,
+		version INTEGER NOT NULL DEFAULT 0,
+		priority INTEGER NOT NULL DEFAULT 0,
+		backup_status TEXT,
+		retry_count INTEGER NOT NULL DEFAULT 0,
+		next_retry_at DATETIME,
+		uploaded_bytes INTEGER NOT NULL DEFAULT 0,
+		failed_at DATETIME NOT NULL
+	);
 	`
-	_, err := s.db.Exec(query)
-	// Check if partner_path column exists (migration for existing db)
-	if err == nil {
-		_, err = s.db.Exec("ALTER TABLE files ADD COLUMN partner_path TEXT;")
-		if err != nil {
-			// Ignore error if column likely already exists
-			// In a real app we'd check PRAGMA table_info
+	if _, err := s.db.Exec(query); err != nil {
+		return err
+	}
+
+	// Columns added after the initial schema (migration for existing db)
+	columns := []struct{ name, def string }{
+		{"partner_path", "TEXT"},
+		{"last_error", "TEXT"},
+		{"version", "INTEGER NOT NULL DEFAULT 0"},
+		{"priority", "INTEGER NOT NULL DEFAULT 0"},
+		{"backup_status", "TEXT"},
+		{"retry_count", "INTEGER NOT NULL DEFAULT 0"},
+		{"next_retry_at", "DATETIME"},
+		{"uploaded_bytes", "INTEGER NOT NULL DEFAULT 0"},
+		{"sha256", "TEXT"},
+		{"sha256_mod_time", "DATETIME"},
+		{"prune_failures", "INTEGER NOT NULL DEFAULT 0"},
+		{"prune_failed", "INTEGER NOT NULL DEFAULT 0"},
+		{"duplicate_of", "TEXT"},
+		{"missing_since", "DATETIME"},
+		{"detected_at", "DATETIME"},
+	}
+	for _, c := range columns {
+		if err := s.addColumnIfMissing("files", c.name, c.def); err != nil {
+			return err
+		}
+	}
+	// Files tracked before detected_at existed count as detected now, so an
+	// orphan sidecar among them still waits the full timeout.
+	if _, err := s.db.Exec(`UPDATE files SET detected_at = ? WHERE detected_at IS NULL`, s.clock.Now()); err != nil {
+		return err
+	}
+
+	// Indexes on added columns can only be created once the columns exist.
+	if _, err := s.db.Exec(`
+	CREATE INDEX IF NOT EXISTS idx_status_priority ON files(status, priority DESC, mod_time);
+	CREATE INDEX IF NOT EXISTS idx_sha256 ON files(sha256);
+	`); err != nil {
+		return err
+	}
+	return s.migrateFailedFiles()
+}
+
+// migrateFailedFiles moves files left FAILED by an older version to dead_letters.
+func (s *Store) migrateFailedFiles() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT OR REPLACE INTO dead_letters (`+fileColumns+`, failed_at)
+		SELECT `+fileColumns+`, ? FROM files WHERE status = ?`, s.clock.Now(), statusFailed)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM files WHERE status = ?`, statusFailed); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// addColumnIfMissing adds a column to a table unless PRAGMA table_info already lists it.
+func (s *Store) addColumnIfMissing(table, column, definition string) error {
+	rows, err := s.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
 			return nil
 		}
 	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = s.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
 	return err
 }
 
@@ -112,22 +385,76 @@ func (s *Store) RegisterFile(path string, size int64, modTime time.Time, isMeta
 	}
 	defer tx.Rollback()
 
+	// A dead-lettered file is only given another chance once it changes.
+	var dlSize int64
+	var dlModTime time.Time
+	err = tx.QueryRow(`SELECT size, mod_time FROM dead_letters WHERE path = ?`, path).Scan(&dlSize, &dlModTime)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err == nil {
+		if dlSize == size && dlModTime.Equal(modTime) {
+			return nil
+		}
+		if _, err := tx.Exec(`DELETE FROM dead_letters WHERE path = ?`, path); err != nil {
+			return err
+		}
+	}
+
+	// An UPLOADED file seen again unchanged (e.g. by a rescan) stays uploaded.
+	// A modified one is re-queued, or without reuploadOnModify stays uploaded
+	// with only its new size and mod time recorded.
+	var curStatus FileStatus
+	var curSize int64
+	var curModTime time.Time
+	err = tx.QueryRow(`SELECT status, size, mod_time FROM files WHERE path = ?`, path).Scan(&curStatus, &curSize, &curModTime)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err == nil && curStatus == StatusUploaded {
+		if curSize == size && curModTime.Equal(modTime) {
+			return nil
+		}
+		if !s.reuploadOnModify {
+			if _, err := tx.Exec(`UPDATE files SET size = ?, mod_time = ? WHERE path = ?`, size, modTime, path); err != nil {
+				return err
+			}
+			return tx.Commit()
+		}
+	}
+
 	var partnerID int64
 	var partnerStatus FileStatus
 	var partnerPath string
 	var foundPartner bool
 
+	// A .json file too large for a sidecar is content and is not paired.
+	content := isMeta && s.sidecarMaxSize > 0 && size > s.sidecarMaxSize
+
 	if !isMeta {
 		// I am an image (data).
 		// Strict/Double Extension: img.png -> img.png.json
 		doubleExtPartner := path + ".json"
 		// Single Extension: img.png -> img.json
-		singleExtPartner := strings.TrimSuffix(path, filepath.Ext(path)) + ".json"
+		stem := strings.TrimSuffix(path, filepath.Ext(path))
+		singleExtPartner := stem + ".json"
 
-		// Check if either partner exists
-		// We prioritize Double Extension if both exist (rare)
-		// We search for both
-		err = tx.QueryRow("SELECT id, status, path FROM files WHERE path = ? OR path = ?", doubleExtPartner, singleExtPartner).Scan(&partnerID, &partnerStatus, &partnerPath)
+		// Check if either partner exists, or else a split sidecar (img.exif.json,
+		// img.png.exif.json) not claimed by another file.
+		// We prioritize Double Extension, then Single Extension.
+		err = tx.QueryRow(`SELECT id, status, path FROM files
+			WHERE (path = ? OR path = ?) AND (? <= 0 OR size <= ?)
+			ORDER BY path = ? DESC
+			LIMIT 1`,
+			doubleExtPartner, singleExtPartner, s.sidecarMaxSize, s.sidecarMaxSize, doubleExtPartner).Scan(&partnerID, &partnerStatus, &partnerPath)
+		if err == sql.ErrNoRows {
+			var split []fileRef
+			if split, err = s.splitSidecars(tx, path); err == nil && len(split) > 0 {
+				partnerID, partnerStatus, partnerPath = split[0].id, split[0].status, split[0].path
+			} else if err == nil {
+				err = sql.ErrNoRows
+			}
+		}
 		if err == nil {
 			foundPartner = true
 		} else if err != sql.ErrNoRows {
@@ -140,25 +467,24 @@ func (s *Store) RegisterFile(path string, size int64, modTime time.Time, isMeta
 			partnerPath = doubleExtPartner
 		}
 
-	} else {
+	} else if !content {
 		// I am metadata (.json).
 		// Double Extension: img.png.json -> img.png
 		// Single Extension: img.json -> img.png (or img.jpg, etc.)
 		base := strings.TrimSuffix(path, ".json")
 
 		// 1. Try Exact Match (Double Extension Case: base is likely "img.png")
-		// 2. Try Prefix Match (Single Extension Case: base is "img", looking for "img.%")
-		// We use a LIKE query to find the image partner.
-		// Note: We exclude myself (if I happened to be named img.json and img.json.json existed? Unlikely logic loop here but good to keep in mind)
-		// We also want to ensure we find a valid partner (not another json file, but !isMeta checks usually prevent that or app logic).
-		// But here we rely on the fact that images don't end in .json usually.
-
-		// SQLite GLOB or LIKE. LIKE is case insensitive by default in SQLite for ASCII.
-		// We look for path = base OR path LIKE base + ".%"
-		query := `SELECT id, status, path FROM files WHERE path = ? OR path LIKE ? LIMIT 1`
-		err = tx.QueryRow(query, base, base+".%").Scan(&partnerID, &partnerStatus, &partnerPath)
+		// 2. Try Stem Match (Single Extension Case: base is "img", looking for "img.<ext>")
+		// A split sidecar (img.exif.json, img.png.exif.json) has no match for
+		// its full base, so its last extension (the tag) is stripped once.
+		var ref fileRef
+		ref, err = findDataFile(tx, base)
+		if err == sql.ErrNoRows && filepath.Ext(base) != "" {
+			ref, err = findDataFile(tx, strings.TrimSuffix(base, filepath.Ext(base)))
+		}
 		if err == nil {
 			foundPartner = true
+			partnerID, partnerStatus, partnerPath = ref.id, ref.status, ref.path
 		} else if err != sql.ErrNoRows {
 			return err
 		}
@@ -171,6 +497,7 @@ func (s *Store) RegisterFile(path string, size int64, modTime time.Time, isMeta
 		// Partner not found -> I am waiting.
 		// If I am an image: partner_path is set to doubleExtPartner (default).
 		// If I am meta: partner_path is unknown (NULL).
+		// If I am content JSON: there is no partner (NULL) and I am PENDING.
 
 		var pp sql.NullString
 		if partnerPath != "" {
@@ -180,24 +507,41 @@ func (s *Store) RegisterFile(path string, size int64, modTime time.Time, isMeta
 
 		// Determine initial status based on configuration
 		initialStatus := StatusAwaitingPartner
-		if !isMeta && !expectSidecar {
+		if (!isMeta && !expectSidecar) || content {
 			initialStatus = StatusPending
 		}
 
 		query := `
-		INSERT INTO files (path, size, mod_time, status, partner_path)
-		VALUES (?, ?, ?, ?, ?)
+		INSERT INTO files (path, size, mod_time, status, partner_path, detected_at)
+		VALUES (?, ?, ?, ?, ?, ?)
 		ON CONFLICT(path) DO UPDATE SET
 			size = excluded.size,
 			mod_time = excluded.mod_time,
+			detected_at = excluded.detected_at,
 			status = ?,
-			partner_path = ?;
+			partner_path = ?,
+			version = files.version + 1,
+			backup_status = NULL,
+			retry_count = 0,
+			next_retry_at = NULL,
+			uploaded_bytes = 0,
+			prune_failures = 0,
+			prune_failed = 0;
 		`
 		// Reset status to initialStatus even if it was previously something else (re-ingest)
-		_, err = tx.Exec(query, path, size, modTime, initialStatus, pp, initialStatus, pp)
+		_, err = tx.Exec(query, path, size, modTime, initialStatus, pp, s.clock.Now(), initialStatus, pp)
 		if err != nil {
 			return err
 		}
+
+		// A data file expecting me as its sidecar must not read me as context.
+		if content {
+			_, err = tx.Exec(`UPDATE files SET partner_path = NULL
+				WHERE partner_path = ? AND LOWER(path) NOT LIKE '%.json'`, path)
+			if err != nil {
+				return err
+			}
+		}
 	} else {
 		// Partner found!
 		// Logic:
@@ -207,15 +551,23 @@ func (s *Store) RegisterFile(path string, size int64, modTime time.Time, isMeta
 		// Insert/Update ME
 		// Note: We always have partnerPath set here (from the Scan).
 		queryMe := `
-		INSERT INTO files (path, size, mod_time, status, partner_path)
-		VALUES (?, ?, ?, ?, ?)
+		INSERT INTO files (path, size, mod_time, status, partner_path, detected_at)
+		VALUES (?, ?, ?, ?, ?, ?)
 		ON CONFLICT(path) DO UPDATE SET
 			size = excluded.size,
 			mod_time = excluded.mod_time,
+			detected_at = excluded.detected_at,
 			status = ?,
-			partner_path = ?;
+			partner_path = ?,
+			version = files.version + 1,
+			backup_status = NULL,
+			retry_count = 0,
+			next_retry_at = NULL,
+			uploaded_bytes = 0,
+			prune_failures = 0,
+			prune_failed = 0;
 		`
-		_, err = tx.Exec(queryMe, path, size, modTime, StatusPending, partnerPath, StatusPending, partnerPath)
+		_, err = tx.Exec(queryMe, path, size, modTime, StatusPending, partnerPath, s.clock.Now(), StatusPending, partnerPath)
 		if err != nil {
 			return err
 		}
@@ -226,28 +578,212 @@ func (s *Store) RegisterFile(path string, size int64, modTime time.Time, isMeta
 		// This is vital for the Single Extension case:
 		// If Image was waiting for img.png.json, but img.json (ME) arrived and claimed it,
 		// we MUST update Image's partner_path to img.json (ME).
-		queryPartner := `UPDATE files SET status = ?, partner_path = ? WHERE id = ?`
-		_, err = tx.Exec(queryPartner, StatusPending, path, partnerID)
+		// With split sidecars an image keeps pointing at its primary sidecar
+		// (img.png.json or img.json); another sidecar only takes its place if
+		// the current partner is not tracked (yet).
+		primary := !isMeta || isPrimarySidecar(path, partnerPath)
+		queryPartner := `UPDATE files SET status = ?, partner_path = CASE
+			WHEN ? OR partner_path IS NULL OR partner_path NOT IN (SELECT path FROM files) THEN ?
+			ELSE partner_path END
+		WHERE id = ?`
+		_, err = tx.Exec(queryPartner, StatusPending, primary, path, partnerID)
 		if err != nil {
 			return err
 		}
+
+		if !isMeta {
+			// A data file that took this sidecar as a split one before its own
+			// data file arrived goes back to waiting for its standard sidecar.
+			if isPrimarySidecar(partnerPath, path) {
+				_, err = tx.Exec(`UPDATE files SET partner_path = path || '.json'
+					WHERE partner_path = ? AND path != ? AND LOWER(path) NOT LIKE '%.json'`, partnerPath, path)
+				if err != nil {
+					return err
+				}
+			}
+
+			// An image claims any further split sidecars that arrived before it.
+			split, err := s.splitSidecars(tx, path)
+			if err != nil {
+				return err
+			}
+			for _, ref := range split {
+				if ref.path == partnerPath {
+					continue
+				}
+				if _, err := tx.Exec(`UPDATE files SET status = ?, partner_path = ? WHERE id = ? AND partner_path IS NULL`, StatusPending, path, ref.id); err != nil {
+					return err
+				}
+			}
+		}
 	}
 
 	return tx.Commit()
 }
 
+// fileRef identifies a tracked file found while pairing.
+type fileRef struct {
+	id     int64
+	status FileStatus
+	path   string
+}
+
+// globEscape escapes the GLOB metacharacters in s, so it only matches itself.
+// Unlike LIKE, GLOB is case-sensitive, like paths on most filesystems.
+func globEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[':
+			b.WriteByte('[')
+			b.WriteRune(r)
+			b.WriteByte(']')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// findDataFile returns the data file whose sidecar has base as its path
+// without .json: the file at base itself (img.png.json -> img.png), or else
+// one whose path is base plus a single extension (img.json -> img.png).
+// It returns sql.ErrNoRows if there is none.
+func findDataFile(tx *sql.Tx, base string) (fileRef, error) {
+	rows, err := tx.Query(`SELECT id, status, path FROM files
+		WHERE (path = ? OR path GLOB ?) AND LOWER(path) NOT LIKE '%.json'
+		ORDER BY path = ? DESC, path`, base, globEscape(base)+".*", base)
+	if err != nil {
+		return fileRef{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ref fileRef
+		if err := rows.Scan(&ref.id, &ref.status, &ref.path); err != nil {
+			return fileRef{}, err
+		}
+		// The GLOB also matches img.v2.png for img; its sidecar is img.v2.json.
+		if ref.path == base || strings.TrimSuffix(ref.path, filepath.Ext(ref.path)) == base {
+			return ref, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fileRef{}, err
+	}
+	return fileRef{}, sql.ErrNoRows
+}
+
+// splitSidecars returns, by path, the tracked split sidecars of the data file
+// at dataPath that no other file claimed: stem.<tag>.json or path.<tag>.json
+// (img.exif.json, img.png.exif.json for img.png), where the tag has no dot
+// and stem.<tag> or path.<tag> is not another tracked data file, whose
+// sidecar it would be (shot.v2.json belongs to shot.v2.png, not shot.png).
+func (s *Store) splitSidecars(tx *sql.Tx, dataPath string) ([]fileRef, error) {
+	stem := strings.TrimSuffix(dataPath, filepath.Ext(dataPath))
+	rows, err := tx.Query(`SELECT id, status, path FROM files
+		WHERE path GLOB ? AND LOWER(path) LIKE '%.json' AND (partner_path IS NULL OR partner_path = ?)
+			AND (? <= 0 OR size <= ?)
+		ORDER BY path`, globEscape(stem)+".*", dataPath, s.sidecarMaxSize, s.sidecarMaxSize)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []fileRef
+	for rows.Next() {
+		var ref fileRef
+		if err := rows.Scan(&ref.id, &ref.status, &ref.path); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		candidates = append(candidates, ref)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var split []fileRef
+	for _, ref := range candidates {
+		if isPrimarySidecar(ref.path, dataPath) {
+			continue
+		}
+		base := ref.path[:len(ref.path)-len(".json")]
+		tag := filepath.Ext(base)
+		if owner := strings.TrimSuffix(base, tag); len(tag) < 2 || (owner != stem && owner != dataPath) {
+			continue
+		}
+		if _, err := findDataFile(tx, base); err != sql.ErrNoRows {
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		split = append(split, ref)
+	}
+	return split, nil
+}
+
+// isPrimarySidecar reports whether sidecar is the standard sidecar of the data
+// file at dataPath (img.png.json or img.json) rather than a split one (img.exif.json).
+func isPrimarySidecar(sidecar, dataPath string) bool {
+	return sidecar == dataPath+".json" || sidecar == strings.TrimSuffix(dataPath, filepath.Ext(dataPath))+".json"
+}
+
+// GetSidecars returns the sidecars paired with the data file at dataPath,
+// ordered by precedence for merging: split sidecars by path, then the primary
+// sidecar last, so that on conflicting keys later sidecars win.
+func (s *Store) GetSidecars(dataPath string) ([]string, error) {
+	rows, err := s.db.Query(`SELECT path FROM files
+		WHERE partner_path = ? AND LOWER(path) LIKE '%.json'
+		ORDER BY path = (SELECT partner_path FROM files WHERE path = ?), path`, dataPath, dataPath)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var paths []string
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			return nil, err
+		}
+		paths = append(paths, p)
+	}
+	return paths, rows.Err()
+}
+
 // MarkOrphans checks for files that have been waiting too long and marks them as orphans.
-func (s *Store) MarkOrphans(timeout time.Duration) error {
-	deadline := time.Now().Add(-timeout)
+// Like GetStaleSidecars, the wait is counted from when a file was registered.
+// If excludeSidecars is true, waiting .json sidecars are left AWAITING_PARTNER.
+func (s *Store) MarkOrphans(timeout time.Duration, excludeSidecars bool) error {
+	deadline := s.clock.Now().Add(-timeout)
 	query := `
 	UPDATE files
 	SET status = ?
-	WHERE status = ? AND mod_time < ?
+	WHERE status = ? AND detected_at < ?
 	`
+	if excludeSidecars {
+		query += ` AND LOWER(path) NOT LIKE '%.json'`
+	}
 	_, err := s.db.Exec(query, StatusOrphan, StatusAwaitingPartner, deadline)
 	return err
 }
 
+// GetStaleSidecars returns .json sidecars that have waited longer than timeout
+// for a data partner that never arrived, counted from when they were
+// registered rather than their mod time, which may be much older (e.g. files
+// copied in with their timestamps preserved).
+func (s *Store) GetStaleSidecars(timeout time.Duration) ([]FileRecord, error) {
+	deadline := s.clock.Now().Add(-timeout)
+	query := `
+	SELECT ` + fileColumns + `
+	FROM files
+	WHERE status = ? AND detected_at < ? AND LOWER(path) LIKE '%.json'
+	ORDER BY detected_at ASC
+	`
+	return s.queryFiles(query, StatusAwaitingPartner, deadline)
+}
+
 // AddOrUpdateFile inserts a new file or updates an existing one.
 // Deprecated: Use RegisterFile for pairing logic.
 func (s *Store) AddOrUpdateFile(path string, size int64, modTime time.Time) error {
@@ -258,13 +794,92 @@ func (s *Store) AddOrUpdateFile(path string, size int64, modTime time.Time) erro
 func (s *Store) MarkUploaded(path string) error {
 	query := `
 	UPDATE files 
-	SET status = ?, uploaded_at = ?
+	SET status = ?, uploaded_at = ?, last_error = NULL, retry_count = 0, next_retry_at = NULL, uploaded_bytes = 0, duplicate_of = NULL
 	WHERE path = ?;
 	`
-	_, err := s.db.Exec(query, StatusUploaded, time.Now(), path)
+	_, err := s.db.Exec(query, StatusUploaded, s.clock.Now(), path)
 	return err
 }
 
+// MarkUploadedVersion marks a file UPLOADED only if it has not been re-registered
+// since version was read. It reports false when the file was overwritten in the
+// meantime, leaving the newer version PENDING so it is uploaded next.
+func (s *Store) MarkUploadedVersion(path string, version int64) (bool, error) {
+	query := `
+	UPDATE files
+	SET status = ?, uploaded_at = ?, last_error = NULL, retry_count = 0, next_retry_at = NULL, uploaded_bytes = 0, duplicate_of = NULL
+	WHERE path = ? AND version = ?;
+	`
+	res, err := s.db.Exec(query, StatusUploaded, s.clock.Now(), path, version)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// MarkDuplicateVersion is MarkUploadedVersion for a file that was not uploaded
+// because original, an UPLOADED file with the same content, already was. The
+// file is recorded as a duplicate of original until it is uploaded again.
+func (s *Store) MarkDuplicateVersion(path string, version int64, original string) (bool, error) {
+	query := `
+	UPDATE files
+	SET status = ?, uploaded_at = ?, last_error = NULL, retry_count = 0, next_retry_at = NULL, uploaded_bytes = 0, duplicate_of = ?
+	WHERE path = ? AND version = ?;
+	`
+	res, err := s.db.Exec(query, StatusUploaded, s.clock.Now(), original, path, version)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// FindUploadedByHash returns the path of an UPLOADED file whose content has
+// the SHA256 hash (as cached by SetChecksum), or "" if there is none. A file
+// recorded as a duplicate resolves to its original, so duplicates never chain.
+func (s *Store) FindUploadedByHash(hash string) (string, error) {
+	var path string
+	err := s.db.QueryRow(`
+	SELECT COALESCE(duplicate_of, path) FROM files
+	WHERE status = ? AND sha256 = ?
+	ORDER BY duplicate_of IS NOT NULL, uploaded_at
+	LIMIT 1
+	`, StatusUploaded, hash).Scan(&path)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return path, err
+}
+
+// FindUploadedByChecksumAndSize is FindUploadedByHash restricted to files of
+// the given size, so a checksum match of files with different lengths (e.g.
+// a truncated copy hashed wrongly) never counts as the same content.
+func (s *Store) FindUploadedByChecksumAndSize(hash string, size int64) (string, error) {
+	var path string
+	err := s.db.QueryRow(`
+	SELECT COALESCE(duplicate_of, path) FROM files
+	WHERE status = ? AND sha256 = ? AND size = ?
+	ORDER BY duplicate_of IS NOT NULL, uploaded_at
+	LIMIT 1
+	`, StatusUploaded, hash, size).Scan(&path)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return path, err
+}
+
+// GetDuplicateOf returns the original recorded by MarkDuplicateVersion for
+// path, or "" if the file was uploaded itself.
+func (s *Store) GetDuplicateOf(path string) (string, error) {
+	var original sql.NullString
+	err := s.db.QueryRow(`SELECT duplicate_of FROM files WHERE path = ?`, path).Scan(&original)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return original.String, err
+}
+
 // GetTotalSize returns the sum of the size of all tracked files.
 func (s *Store) GetTotalSize() (int64, error) {
 	query := `SELECT COALESCE(SUM(size), 0) FROM files`
@@ -274,36 +889,89 @@ func (s *Store) GetTotalSize() (int64, error) {
 }
 
 // GetPruneCandidates returns a list of files that are safe to delete (Status=UPLOADED).
+// Files uploaded less than minAge ago are excluded so they stay available locally,
+// and so are files the pruner gave up deleting (see RecordPruneFailure).
 // Files are returned in order of Modification Time (oldest first).
-func (s *Store) GetPruneCandidates(limit int) ([]FileRecord, error) {
+func (s *Store) GetPruneCandidates(limit int, minAge time.Duration) ([]FileRecord, error) {
+	uploadedBefore := s.clock.Now().Add(-minAge)
 	query := `
-	SELECT id, path, size, mod_time, status, uploaded_at, partner_path
+	SELECT ` + fileColumns + `
 	FROM files
-	WHERE status = ?
+	WHERE status = ? AND (uploaded_at IS NULL OR uploaded_at <= ?)
+	AND (backup_status IS NULL OR backup_status != ?)
+	AND prune_failed = 0
 	ORDER BY mod_time ASC
 	LIMIT ?
 	`
-	rows, err := s.db.Query(query, StatusUploaded, limit)
-	if err != nil {
-		return nil, err
+	return s.queryFiles(query, StatusUploaded, uploadedBefore, StatusPending, limit)
+}
+
+// RecordPruneFailure counts a failed attempt to delete a file while pruning.
+// It returns the number of failed attempts and whether the file is now
+// excluded: once maxAttempts attempts failed (0 = never), it is no longer
+// returned by GetPruneCandidates. The count resets when the file is
+// re-registered or with ClearPruneFailures.
+func (s *Store) RecordPruneFailure(path string, maxAttempts int) (int, bool, error) {
+	var attempts int
+	var failed bool
+	err := s.db.QueryRow(`UPDATE files SET prune_failures = prune_failures + 1,
+		prune_failed = (? > 0 AND prune_failures + 1 >= ?)
+		WHERE path = ?
+		RETURNING prune_failures, prune_failed`, maxAttempts, maxAttempts, path).Scan(&attempts, &failed)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
 	}
-	defer rows.Close()
+	return attempts, failed, err
+}
 
-	var candidates []FileRecord
-	for rows.Next() {
-		var f FileRecord
-		err := rows.Scan(&f.ID, &f.Path, &f.Size, &f.ModTime, &f.Status, &f.UploadedAt, &f.PartnerPath)
-		if err != nil {
-			return nil, err
-		}
-		candidates = append(candidates, f)
+// ClearPruneFailures makes files the pruner gave up deleting candidates again,
+// e.g. after their permissions were fixed. It returns the number of files affected.
+func (s *Store) ClearPruneFailures() (int, error) {
+	res, err := s.db.Exec(`UPDATE files SET prune_failures = 0, prune_failed = 0 WHERE prune_failures > 0`)
+	if err != nil {
+		return 0, err
 	}
-	return candidates, nil
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// SetBackupStatus records the state of a file's copy at the backup endpoint.
+// Files whose backup is PENDING are not pruned.
+func (s *Store) SetBackupStatus(path string, status FileStatus) error {
+	_, err := s.db.Exec(`UPDATE files SET backup_status = ? WHERE path = ?`, status, path)
+	return err
+}
+
+// GetPendingBackups returns files uploaded to the primary endpoint whose backup
+// copy is still outstanding, oldest first. Paired sidecars are left out since
+// they are copied together with their data file.
+func (s *Store) GetPendingBackups(limit int) ([]FileRecord, error) {
+	query := `
+	SELECT ` + fileColumns + `
+	FROM files
+	WHERE status = ? AND backup_status = ?
+	AND NOT (LOWER(path) LIKE '%.json' AND partner_path IS NOT NULL)
+	ORDER BY mod_time ASC
+	LIMIT ?
+	`
+	return s.queryFiles(query, StatusUploaded, StatusPending, limit)
 }
 
 // RemoveFile deletes a file record from the database.
 // It also clears any references to this file in the partner_path column of other records.
 func (s *Store) RemoveFile(path string) error {
+	return s.deleteRecord(path)
+}
+
+// ForgetPath drops the record for a path that is managed outside the daemon
+// (moved or deleted by another process) and unlinks any partner referencing it.
+// Unlike pruning, the file on disk is never touched.
+func (s *Store) ForgetPath(path string) error {
+	return s.deleteRecord(path)
+}
+
+// deleteRecord removes a file record and clears partner references to it.
+func (s *Store) deleteRecord(path string) error {
 	tx, err := s.db.Begin()
 	if err != nil {
 		return err
@@ -323,33 +991,555 @@ func (s *Store) RemoveFile(path string) error {
 		return err
 	}
 
+	// 3. Drop its upload history
+	if _, err := tx.Exec(`DELETE FROM upload_attempts WHERE path = ?`, path); err != nil {
+		return err
+	}
+
 	return tx.Commit()
 }
 
 // GetPendingFiles returns a list of files waiting to be uploaded.
 // This now includes both PENDING (paired) and ORPHAN files.
+// Higher-priority files come first, then the oldest. Files waiting for their
+// next retry (see RecordFailure) or, with an upload delay, modified too recently
+// (see SetUploadDelay) are skipped.
 func (s *Store) GetPendingFiles(limit int) ([]FileRecord, error) {
+	return s.GetPendingFilesAfter(0, limit)
+}
+
+// GetPendingFilesAfter is GetPendingFiles continuing after the file with ID
+// afterID, as returned by an earlier call, so a caller can page through the
+// queue instead of fetching the same files at its head again. afterID 0 starts
+// at the head; if that file is no longer tracked, nothing is returned.
+func (s *Store) GetPendingFilesAfter(afterID int64, limit int) ([]FileRecord, error) {
+	// The sort key, ascending, so the cursor can be compared as a row value.
+	key := "-priority, mod_time, id"
+	order := "priority DESC, mod_time ASC, id ASC"
+	if s.prioritizePairs {
+		key = "-priority, status = '" + string(StatusOrphan) + "', mod_time, id"
+		order = "priority DESC, status = '" + string(StatusOrphan) + "' ASC, mod_time ASC, id ASC"
+	}
+	now := s.clock.Now()
+	args := []any{StatusPending, StatusOrphan, now}
+	delayed := ""
+	if s.uploadDelay > 0 {
+		delayed = "AND mod_time <= ?"
+		args = append(args, now.Add(-s.uploadDelay))
+	}
+	cursor := ""
+	if afterID > 0 {
+		cursor = "AND (" + key + ") > (SELECT " + key + " FROM files WHERE id = ?)"
+		args = append(args, afterID)
+	}
 	query := `
-	SELECT id, path, size, mod_time, status, uploaded_at, partner_path
+	SELECT ` + fileColumns + `
 	FROM files
-	WHERE status IN (?, ?)
-	ORDER BY mod_time ASC
+	WHERE status IN (?, ?) AND (next_retry_at IS NULL OR next_retry_at <= ?) ` + delayed + ` ` + cursor + `
+	ORDER BY ` + order + `
+	LIMIT ?
+	`
+	return s.queryFiles(query, append(args, limit)...)
+}
+
+// SetPriority changes the upload priority of a tracked file. Files with a higher
+// priority are uploaded before older files with a lower one. The priority is
+// kept when the file is re-registered.
+func (s *Store) SetPriority(path string, priority int) error {
+	res, err := s.db.Exec(`UPDATE files SET priority = ? WHERE path = ?`, priority, path)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%s is not tracked", path)
+	}
+	return nil
+}
+
+// RecordError stores the reason for the most recent failed upload attempt of a file.
+func (s *Store) RecordError(path string, errMsg string) error {
+	_, err := s.db.Exec(`UPDATE files SET last_error = ? WHERE path = ?`, errMsg, path)
+	return err
+}
+
+// RecordFailure counts a failed upload attempt and holds the file back from
+// GetPendingFiles until nextRetry.
+func (s *Store) RecordFailure(path string, nextRetry time.Time) error {
+	_, err := s.db.Exec(`UPDATE files SET retry_count = retry_count + 1, next_retry_at = ? WHERE path = ?`, nextRetry, path)
+	return err
+}
+
+// SetUploadedBytes records how many bytes of the current version of a file were
+// sent before its upload was interrupted, so the next attempt can resume there.
+func (s *Store) SetUploadedBytes(path string, n int64) error {
+	_, err := s.db.Exec(`UPDATE files SET uploaded_bytes = ? WHERE path = ?`, n, path)
+	return err
+}
+
+// GetUploadedBytes returns the bytes recorded by SetUploadedBytes, 0 if the path is not tracked.
+func (s *Store) GetUploadedBytes(path string) (int64, error) {
+	var n int64
+	err := s.db.QueryRow(`SELECT uploaded_bytes FROM files WHERE path = ?`, path).Scan(&n)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return n, err
+}
+
+// GetFailedFiles returns files that are not yet uploaded and whose last attempt failed,
+// together with the recorded error. Most recently modified files come first.
+func (s *Store) GetFailedFiles(limit int) ([]FileRecord, error) {
+	query := `
+	SELECT ` + fileColumns + `
+	FROM files
+	WHERE last_error IS NOT NULL AND status != ?
+	ORDER BY mod_time DESC
 	LIMIT ?
 	`
-	rows, err := s.db.Query(query, StatusPending, StatusOrphan, limit)
+	return s.queryFiles(query, StatusUploaded, limit)
+}
+
+// SetChecksum caches the SHA256 of a file's content as of modTime, see GetChecksum.
+func (s *Store) SetChecksum(path string, modTime time.Time, sum string) error {
+	_, err := s.db.Exec(`UPDATE files SET sha256 = ?, sha256_mod_time = ? WHERE path = ?`, sum, modTime, path)
+	return err
+}
+
+// GetChecksum returns the SHA256 cached by SetChecksum if it was computed for
+// the same modTime, so an unchanged file is not re-hashed on every retry. It
+// returns "" if nothing is cached or the file was modified since.
+func (s *Store) GetChecksum(path string, modTime time.Time) (string, error) {
+	var sum sql.NullString
+	var cachedModTime sql.NullTime
+	err := s.db.QueryRow(`SELECT sha256, sha256_mod_time FROM files WHERE path = ?`, path).Scan(&sum, &cachedModTime)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if !sum.Valid || !cachedModTime.Valid || !cachedModTime.Time.Equal(modTime) {
+		return "", nil
+	}
+	return sum.String, nil
+}
+
+// DeadLetter is a row in the 'dead_letters' table: a file whose upload retries
+// were exhausted, see MoveToDeadLetter.
+type DeadLetter struct {
+	FileRecord
+	FailedAt time.Time
+}
+
+// MoveToDeadLetter moves a file whose upload retries are exhausted from the
+// files table to dead_letters, recording errMsg as its last error, so it no
+// longer takes up room in pending batches. It stays there until it changes on
+// disk (see RegisterFile) or is moved back with RetryDeadLetter. Its upload
+// history is kept.
+func (s *Store) MoveToDeadLetter(path, errMsg string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`INSERT OR REPLACE INTO dead_letters (`+fileColumns+`, failed_at)
+		SELECT `+fileColumns+`, ? FROM files WHERE path = ?`, s.clock.Now(), path)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("%s is not tracked", path)
+	}
+	if _, err := tx.Exec(`UPDATE dead_letters SET last_error = ?, next_retry_at = NULL WHERE path = ?`, errMsg, path); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE files SET partner_path = NULL WHERE partner_path = ?`, path); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM files WHERE path = ?`, path); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListDeadLetters returns dead-lettered files, most recently failed first.
+func (s *Store) ListDeadLetters(limit int) ([]DeadLetter, error) {
+	rows, err := s.db.Query(`SELECT `+fileColumns+`, failed_at FROM dead_letters ORDER BY failed_at DESC LIMIT ?`, limit)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var files []FileRecord
+	var letters []DeadLetter
 	for rows.Next() {
-		var f FileRecord
-		err := rows.Scan(&f.ID, &f.Path, &f.Size, &f.ModTime, &f.Status, &f.UploadedAt, &f.PartnerPath)
+		var d DeadLetter
+		f := &d.FileRecord
+		err := rows.Scan(&f.ID, &f.Path, &f.Size, &f.ModTime, &f.Status, &f.UploadedAt, &f.PartnerPath, &f.LastError, &f.Version, &f.Priority, &f.BackupStatus, &f.RetryCount, &f.NextRetryAt, &f.UploadedBytes, &d.FailedAt)
 		if err != nil {
 			return nil, err
 		}
-		files = append(files, f)
+		letters = append(letters, d)
+	}
+	return letters, rows.Err()
+}
+
+// CountDeadLetters returns the number of dead-lettered files.
+func (s *Store) CountDeadLetters() (int, error) {
+	var count int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM dead_letters`).Scan(&count)
+	return count, err
+}
+
+// RetryDeadLetter moves a dead-lettered file back to PENDING with its retry
+// count reset. Its last error is kept until the next attempt succeeds.
+func (s *Store) RetryDeadLetter(path string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`INSERT INTO files (path, size, mod_time, status, last_error, version, priority, backup_status)
+		SELECT path, size, mod_time, ?, last_error, version, priority, backup_status FROM dead_letters WHERE path = ?`, StatusPending, path)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("%s is not a dead letter", path)
+	}
+	if _, err := tx.Exec(`DELETE FROM dead_letters WHERE path = ?`, path); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListFiles returns tracked files ordered by modification time (oldest first).
+// An empty status returns files in any state.
+func (s *Store) ListFiles(status FileStatus, limit int) ([]FileRecord, error) {
+	if status == "" {
+		query := `SELECT ` + fileColumns + ` FROM files ORDER BY mod_time ASC LIMIT ?`
+		return s.queryFiles(query, limit)
+	}
+	query := `SELECT ` + fileColumns + ` FROM files WHERE status = ? ORDER BY mod_time ASC LIMIT ?`
+	return s.queryFiles(query, status, limit)
+}
+
+// ListFilesAfter returns up to limit files with an id greater than afterID in id
+// order, optionally filtered by status. Passing the last id of a page as afterID
+// returns the next page, so callers can walk any number of files with bounded
+// memory and without holding a read transaction open.
+func (s *Store) ListFilesAfter(status FileStatus, afterID int64, limit int) ([]FileRecord, error) {
+	if status == "" {
+		query := `SELECT ` + fileColumns + ` FROM files WHERE id > ? ORDER BY id LIMIT ?`
+		return s.queryFiles(query, afterID, limit)
+	}
+	query := `SELECT ` + fileColumns + ` FROM files WHERE id > ? AND status = ? ORDER BY id LIMIT ?`
+	return s.queryFiles(query, afterID, status, limit)
+}
+
+// Fingerprint is the part of a file record used to detect unchanged files.
+type Fingerprint struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// Fingerprints returns the size and mod time of every tracked file, keyed by path.
+func (s *Store) Fingerprints() (map[string]Fingerprint, error) {
+	return s.queryFingerprints(``, `SELECT path, size, mod_time FROM files`)
+}
+
+// FingerprintsInDir returns the fingerprints of the files tracked directly in dir
+// (not in its subdirectories), so callers can compare a tree one directory at a
+// time without loading every record.
+func (s *Store) FingerprintsInDir(dir string) (map[string]Fingerprint, error) {
+	// All paths under dir sort between "dir/" and "dir0" ('0' follows '/'), which
+	// lets SQLite use the path index instead of a LIKE scan.
+	prefix := strings.TrimSuffix(dir, string(filepath.Separator)) + string(filepath.Separator)
+	upper := prefix[:len(prefix)-1] + string(filepath.Separator+1)
+	return s.queryFingerprints(prefix, `SELECT path, size, mod_time FROM files WHERE path >= ? AND path < ?`, prefix, upper)
+}
+
+// queryFingerprints collects (path, size, mod_time) rows. If dirPrefix is set,
+// rows in subdirectories of it are skipped.
+func (s *Store) queryFingerprints(dirPrefix string, query string, args ...any) (map[string]Fingerprint, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	fingerprints := make(map[string]Fingerprint)
+	for rows.Next() {
+		var path string
+		var fp Fingerprint
+		if err := rows.Scan(&path, &fp.Size, &fp.ModTime); err != nil {
+			return nil, err
+		}
+		if dirPrefix != "" && strings.ContainsRune(path[len(dirPrefix):], filepath.Separator) {
+			continue
+		}
+		fingerprints[path] = fp
+	}
+	return fingerprints, rows.Err()
+}
+
+// RemoveMissingFiles checks up to limit records for files that no longer exist
+// on disk and deletes their records, together with any error state. A file is
+// only forgotten once it has been missing for grace, since the watcher may
+// still be catching up (e.g. a file being moved) or the disk may come back:
+// the first pass that finds it missing records missing_since, and a pass that
+// finds it again clears it. Each call resumes after the last record checked
+// and wraps around, so the whole table is covered over several calls.
+// It returns the number of records removed.
+func (s *Store) RemoveMissingFiles(limit int, grace time.Duration) (int, error) {
+	cursorValue, err := s.getState(stateKeyMissingCursor)
+	if err != nil {
+		return 0, err
+	}
+	cursor, _ := strconv.ParseInt(cursorValue, 10, 64)
+
+	removed, cursor, err := s.RemoveMissingFilesAfter(cursor, limit, grace)
+	if err != nil {
+		return removed, err
+	}
+	return removed, s.setState(stateKeyMissingCursor, strconv.FormatInt(cursor, 10))
+}
+
+// RemoveMissingFilesAfter is RemoveMissingFiles for up to limit records with an
+// id above afterID, ignoring the stored cursor. It returns the number of records
+// removed and the id to pass as afterID next, which is 0 once the end of the
+// table is reached.
+func (s *Store) RemoveMissingFilesAfter(afterID int64, limit int, grace time.Duration) (int, int64, error) {
+	rows, err := s.db.Query(`SELECT id, path, missing_since FROM files WHERE id > ? ORDER BY id ASC LIMIT ?`, afterID, limit)
+	if err != nil {
+		return 0, afterID, err
+	}
+	type record struct {
+		id           int64
+		path         string
+		missingSince sql.NullTime
+	}
+	var records []record
+	for rows.Next() {
+		var r record
+		if err := rows.Scan(&r.id, &r.path, &r.missingSince); err != nil {
+			rows.Close()
+			return 0, afterID, err
+		}
+		records = append(records, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, afterID, err
+	}
+
+	now := s.clock.Now()
+	removed := 0
+	for _, r := range records {
+		afterID = r.id
+		if _, err := os.Stat(r.path); !os.IsNotExist(err) {
+			if r.missingSince.Valid {
+				if _, err := s.db.Exec(`UPDATE files SET missing_since = NULL WHERE id = ?`, r.id); err != nil {
+					return removed, afterID, err
+				}
+			}
+			continue
+		}
+		if !r.missingSince.Valid {
+			if _, err := s.db.Exec(`UPDATE files SET missing_since = ? WHERE id = ?`, now, r.id); err != nil {
+				return removed, afterID, err
+			}
+			r.missingSince = sql.NullTime{Time: now, Valid: true}
+		}
+		if now.Sub(r.missingSince.Time) < grace {
+			continue
+		}
+		if err := s.deleteRecord(r.path); err != nil {
+			return removed, afterID, err
+		}
+		removed++
+	}
+
+	// Start over from the beginning once the end of the table is reached.
+	if len(records) < limit {
+		afterID = 0
+	}
+	return removed, afterID, nil
+}
+
+// HasFile reports whether a path is already tracked.
+func (s *Store) HasFile(path string) (bool, error) {
+	var exists bool
+	err := s.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM files WHERE path = ?)`, path).Scan(&exists)
+	return exists, err
+}
+
+// CountNotUploaded returns the number of tracked files that have not been uploaded yet.
+// Dead-lettered files are not counted since they are no longer retried.
+func (s *Store) CountNotUploaded() (int, error) {
+	var count int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM files WHERE status != ?`, StatusUploaded).Scan(&count)
+	return count, err
+}
+
+// CountByStatus returns the number of tracked files per status. Statuses
+// without files are absent from the map.
+func (s *Store) CountByStatus() (map[FileStatus]int, error) {
+	rows, err := s.db.Query(`SELECT status, COUNT(*) FROM files GROUP BY status`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[FileStatus]int)
+	for rows.Next() {
+		var status FileStatus
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+		counts[status] = count
+	}
+	return counts, rows.Err()
+}
+
+const (
+	// stateKeyScanWatermark holds the newest mod_time seen by the last complete scan.
+	stateKeyScanWatermark = "scan_watermark"
+	// stateKeySessionID holds the capture session/campaign ID attached to uploads.
+	stateKeySessionID = "session_id"
+	// stateKeyMissingCursor holds the id where the next RemoveMissingFiles pass resumes.
+	stateKeyMissingCursor = "missing_files_cursor"
+)
+
+// setState stores a key/value pair in the state table.
+func (s *Store) setState(key, value string) error {
+	_, err := s.db.Exec(`INSERT INTO state (key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value`, key, value)
+	return err
+}
+
+// getState returns the value stored for key, or "" if it is not set.
+func (s *Store) getState(key string) (string, error) {
+	var value string
+	err := s.db.QueryRow(`SELECT value FROM state WHERE key = ?`, key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return value, err
+}
+
+// SetScanWatermark records the newest file mod_time covered by a complete scan.
+// After suspected event loss, only files newer than this need to be re-examined.
+func (s *Store) SetScanWatermark(t time.Time) error {
+	return s.setState(stateKeyScanWatermark, t.UTC().Format(time.RFC3339Nano))
+}
+
+// GetScanWatermark returns the last scan watermark, or the zero time if no scan completed yet.
+func (s *Store) GetScanWatermark() (time.Time, error) {
+	value, err := s.getState(stateKeyScanWatermark)
+	if err != nil || value == "" {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+// SetSessionID sets the capture session ID attached to subsequent uploads.
+// The daemon and CLI share it through the database.
+func (s *Store) SetSessionID(id string) error {
+	return s.setState(stateKeySessionID, id)
+}
+
+// GetSessionID returns the current capture session ID, or "" if none is set.
+func (s *Store) GetSessionID() (string, error) {
+	return s.getState(stateKeySessionID)
+}
+
+// RotateSessionID starts a new capture session with a generated, time-based ID and returns it.
+func (s *Store) RotateSessionID() (string, error) {
+	id := "session-" + s.clock.Now().UTC().Format("20060102T150405.000Z")
+	return id, s.SetSessionID(id)
+}
+
+// Outcomes of an UploadAttempt.
+const (
+	AttemptSucceeded = "SUCCESS"
+	AttemptFailed    = "FAILED"
+)
+
+// UploadAttempt is a row in the 'upload_attempts' table, see AddUploadAttempts.
+type UploadAttempt struct {
+	Path        string
+	AttemptedAt time.Time
+	Outcome     string        // AttemptSucceeded or AttemptFailed
+	HTTPStatus  int           // Status code of the failing API or storage response, 0 if there was none
+	Duration    time.Duration // Time spent in the upload (handshake, transfer and confirm)
+	Error       string        // Empty on success
+}
+
+// AddUploadAttempts records a batch of upload attempts in one transaction and
+// keeps only the newest keep attempts of each affected file.
+func (s *Store) AddUploadAttempts(attempts []UploadAttempt, keep int) error {
+	if len(attempts) == 0 {
+		return nil
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	paths := make(map[string]struct{})
+	for _, a := range attempts {
+		var errMsg sql.NullString
+		if a.Error != "" {
+			errMsg = sql.NullString{String: a.Error, Valid: true}
+		}
+		_, err := tx.Exec(`INSERT INTO upload_attempts (path, attempted_at, outcome, http_status, duration_ms, error) VALUES (?, ?, ?, ?, ?, ?)`,
+			a.Path, a.AttemptedAt, a.Outcome, a.HTTPStatus, a.Duration.Milliseconds(), errMsg)
+		if err != nil {
+			return err
+		}
+		paths[a.Path] = struct{}{}
+	}
+
+	for path := range paths {
+		_, err := tx.Exec(`DELETE FROM upload_attempts WHERE path = ? AND id NOT IN (
+			SELECT id FROM upload_attempts WHERE path = ? ORDER BY id DESC LIMIT ?)`, path, path, keep)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetUploadAttempts returns the recorded upload attempts of path, oldest first.
+func (s *Store) GetUploadAttempts(path string) ([]UploadAttempt, error) {
+	rows, err := s.db.Query(`SELECT path, attempted_at, outcome, http_status, duration_ms, error FROM upload_attempts WHERE path = ? ORDER BY id`, path)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []UploadAttempt
+	for rows.Next() {
+		var a UploadAttempt
+		var durationMs int64
+		var errMsg sql.NullString
+		if err := rows.Scan(&a.Path, &a.AttemptedAt, &a.Outcome, &a.HTTPStatus, &durationMs, &errMsg); err != nil {
+			return nil, err
+		}
+		a.Duration = time.Duration(durationMs) * time.Millisecond
+		a.Error = errMsg.String
+		attempts = append(attempts, a)
 	}
-	return files, nil
+	return attempts, rows.Err()
 }
@@ -1,10 +1,14 @@
 package store
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
+
+	"fs-ingest-daemon/internal/clock"
 )
 
 func TestRemoveFileUnlinksPartner(t *testing.T) {
@@ -87,3 +91,882 @@ func TestRemoveFileUnlinksPartner(t *testing.T) {
 		t.Errorf("Expected JSON partner_path to be NULL after partner removal, but got: %s", jsonFile.PartnerPath.String)
 	}
 }
+
+func TestForgetPathKeepsFileOnDisk(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "store_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s, err := NewStore(filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer s.Close()
+
+	imagePath := filepath.Join(tmpDir, "img.png")
+	jsonPath := imagePath + ".json"
+	if err := os.WriteFile(imagePath, []byte("image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	modTime := time.Now()
+	if err := s.RegisterFile(imagePath, 5, modTime, false, true); err != nil {
+		t.Fatalf("Failed to register image: %v", err)
+	}
+	if err := s.RegisterFile(jsonPath, 2, modTime, true, true); err != nil {
+		t.Fatalf("Failed to register json: %v", err)
+	}
+
+	if err := s.ForgetPath(imagePath); err != nil {
+		t.Fatalf("ForgetPath failed: %v", err)
+	}
+
+	files, err := s.GetPendingFiles(10)
+	if err != nil {
+		t.Fatalf("Failed to get pending files: %v", err)
+	}
+	if len(files) != 1 || files[0].Path != jsonPath {
+		t.Fatalf("Expected only %s to remain, got %+v", jsonPath, files)
+	}
+	if files[0].PartnerPath.Valid {
+		t.Errorf("Expected partner to be unlinked, got %s", files[0].PartnerPath.String)
+	}
+
+	// The file on disk must be untouched.
+	if _, err := os.Stat(imagePath); err != nil {
+		t.Errorf("Expected %s to still exist on disk: %v", imagePath, err)
+	}
+}
+
+func TestScanWatermark(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "store_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s, err := NewStore(filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer s.Close()
+
+	got, err := s.GetScanWatermark()
+	if err != nil {
+		t.Fatalf("GetScanWatermark failed: %v", err)
+	}
+	if !got.IsZero() {
+		t.Errorf("Expected zero watermark before any scan, got %v", got)
+	}
+
+	want := time.Date(2026, 1, 6, 12, 30, 0, 123, time.UTC)
+	if err := s.SetScanWatermark(want); err != nil {
+		t.Fatalf("SetScanWatermark failed: %v", err)
+	}
+	got, err = s.GetScanWatermark()
+	if err != nil {
+		t.Fatalf("GetScanWatermark failed: %v", err)
+	}
+	if !got.Equal(want) {
+		t.Errorf("Expected watermark %v, got %v", want, got)
+	}
+}
+
+func TestGetPendingFilesOrdersByPriority(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "store_priority_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s, err := NewStore(filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer s.Close()
+
+	old := "/data/old.png"
+	important := "/data/important.png"
+	if err := s.RegisterFile(old, 10, time.Now().Add(-time.Hour), false, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterFile(important, 10, time.Now(), false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.SetPriority(important, 5); err != nil {
+		t.Fatalf("SetPriority failed: %v", err)
+	}
+	if err := s.SetPriority("/data/missing.png", 5); err == nil {
+		t.Error("Expected SetPriority to fail for an untracked path")
+	}
+
+	files, err := s.GetPendingFiles(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0].Path != important || files[1].Path != old {
+		t.Fatalf("Expected %s before %s, got %+v", important, old, files)
+	}
+	if files[0].Priority != 5 {
+		t.Errorf("Expected priority 5, got %d", files[0].Priority)
+	}
+
+	// Re-registering (e.g. an overwrite) keeps the priority.
+	if err := s.RegisterFile(important, 20, time.Now(), false, false); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := s.GetPendingFiles(1); len(files) != 1 || files[0].Path != important {
+		t.Error("Expected priority to survive re-registration")
+	}
+}
+
+func TestRemoveMissingFiles(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "store_missing_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s, err := NewStore(filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer s.Close()
+
+	clk := clock.NewFake(time.Now())
+	s.SetClock(clk)
+
+	old := time.Now().Add(-48 * time.Hour)
+	present := filepath.Join(tmpDir, "present.png")
+	gone1 := filepath.Join(tmpDir, "gone1.png")
+	gone2 := filepath.Join(tmpDir, "gone2.png")
+	recent := filepath.Join(tmpDir, "recent.png") // Goes missing later, within the grace period
+	back := filepath.Join(tmpDir, "back.png")     // Missing for a while, then restored
+	for _, p := range []string{present, recent} {
+		if err := os.WriteFile(p, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, p := range []string{present, gone1, gone2, recent, back} {
+		if err := s.RegisterFile(p, 4, old, false, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.RecordError(gone1, "upload: connection reset"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A small limit needs several passes; the cursor must carry over between them.
+	removeAll := func() int {
+		t.Helper()
+		total := 0
+		for i := 0; i < 3; i++ {
+			removed, err := s.RemoveMissingFiles(2, 24*time.Hour)
+			if err != nil {
+				t.Fatalf("RemoveMissingFiles failed: %v", err)
+			}
+			total += removed
+		}
+		return total
+	}
+
+	// The grace period starts when a file is first found missing, however
+	// old its mod time.
+	if removed := removeAll(); removed != 0 {
+		t.Errorf("Expected no records removed before the grace period, got %d", removed)
+	}
+
+	clk.Advance(12 * time.Hour)
+	if err := os.WriteFile(back, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	removeAll()
+	if err := os.Remove(back); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(recent); err != nil {
+		t.Fatal(err)
+	}
+
+	clk.Advance(13 * time.Hour)
+	if removed := removeAll(); removed != 2 {
+		t.Errorf("Expected 2 records removed, got %d", removed)
+	}
+
+	for path, want := range map[string]bool{present: true, gone1: false, gone2: false, recent: true, back: true} {
+		tracked, err := s.HasFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tracked != want {
+			t.Errorf("HasFile(%s) = %v, want %v", path, tracked, want)
+		}
+	}
+	if failed, _ := s.GetFailedFiles(10); len(failed) != 0 {
+		t.Errorf("Expected the error of the removed file to be gone, got %d", len(failed))
+	}
+}
+
+func TestGetPendingFilesPrioritizesPairs(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "store_pairs_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s, err := NewStore(filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer s.Close()
+	clk := clock.NewFake(time.Now())
+	s.SetClock(clk)
+
+	// An old image whose sidecar never arrived becomes an orphan.
+	orphan := "/data/orphan.png"
+	if err := s.RegisterFile(orphan, 10, time.Now().Add(-2*time.Hour), false, true); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(2 * time.Hour)
+	if err := s.MarkOrphans(time.Hour, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// A newer complete pair.
+	image := "/data/pair.png"
+	if err := s.RegisterFile(image, 10, time.Now(), false, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterFile(image+".json", 2, time.Now(), true, true); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := s.GetPendingFiles(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 || files[0].Path != orphan {
+		t.Fatalf("Expected the older orphan first by default, got %+v", files)
+	}
+
+	s.SetPrioritizePairs(true)
+	files, err = s.GetPendingFiles(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 || files[2].Path != orphan {
+		t.Fatalf("Expected the orphan after the complete pair, got %+v", files)
+	}
+	for _, f := range files[:2] {
+		if f.Status != StatusPending {
+			t.Errorf("Expected %s to be PENDING, got %s", f.Path, f.Status)
+		}
+	}
+}
+
+func TestRecordFailureDelaysRetry(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "store_retry_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s, err := NewStore(filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer s.Close()
+
+	clk := clock.NewFake(time.Now())
+	s.SetClock(clk)
+
+	path := "/data/img.png"
+	if err := s.RegisterFile(path, 10, time.Now(), false, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RecordFailure(path, clk.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("RecordFailure failed: %v", err)
+	}
+
+	files, err := s.GetPendingFiles(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("Expected file to be skipped until its retry, got %+v", files)
+	}
+
+	clk.Advance(time.Minute)
+	files, err = s.GetPendingFiles(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].RetryCount != 1 {
+		t.Fatalf("Expected file with retry count 1 once due, got %+v", files)
+	}
+
+	// Re-registering (e.g. an overwrite) starts over.
+	if err := s.MoveToDeadLetter(path, "rejected"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterFile(path, 12, time.Now(), false, false); err != nil {
+		t.Fatal(err)
+	}
+	files, err = s.GetPendingFiles(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].RetryCount != 0 || files[0].Status != StatusPending {
+		t.Fatalf("Expected re-registered file to be PENDING with no retries, got %+v", files)
+	}
+}
+
+func TestGetPendingFilesWaitsForUploadDelay(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "store_delay_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s, err := NewStore(filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer s.Close()
+
+	clk := clock.NewFake(time.Now())
+	s.SetClock(clk)
+	s.SetUploadDelay(5 * time.Second)
+
+	path := "/data/burst_001.png"
+	if err := s.RegisterFile(path, 10, clk.Now(), false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := s.GetPendingFiles(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("Expected a freshly detected file to wait for the upload delay, got %d", len(files))
+	}
+
+	clk.Advance(5 * time.Second)
+	files, err = s.GetPendingFiles(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Path != path {
+		t.Fatalf("Expected the file once the delay elapsed, got %+v", files)
+	}
+}
+
+func TestRegisterFileReuploadOnModify(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		t.Run(fmt.Sprintf("enabled=%v", enabled), func(t *testing.T) {
+			tmpDir, err := os.MkdirTemp("", "store_reupload_test")
+			if err != nil {
+				t.Fatalf("Failed to create temp dir: %v", err)
+			}
+			defer os.RemoveAll(tmpDir)
+
+			s, err := NewStore(filepath.Join(tmpDir, "test.db"))
+			if err != nil {
+				t.Fatalf("Failed to create store: %v", err)
+			}
+			defer s.Close()
+			s.SetReuploadOnModify(enabled)
+
+			path := filepath.Join(tmpDir, "img.png")
+			if err := os.WriteFile(path, []byte("first"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.RegisterFile(path, info.Size(), info.ModTime(), false, false); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.MarkUploaded(path); err != nil {
+				t.Fatal(err)
+			}
+
+			// Seeing the unchanged file again (e.g. on a rescan) keeps it uploaded.
+			if err := s.RegisterFile(path, info.Size(), info.ModTime(), false, false); err != nil {
+				t.Fatal(err)
+			}
+			if files, _ := s.ListFiles(StatusUploaded, 10); len(files) != 1 || files[0].Version != 0 {
+				t.Fatalf("Expected the unchanged file to stay UPLOADED and keep its version, got %+v", files)
+			}
+
+			// Overwrite the file with new content.
+			if err := os.WriteFile(path, []byte("second version"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			modTime := info.ModTime().Add(time.Second)
+			if err := os.Chtimes(path, modTime, modTime); err != nil {
+				t.Fatal(err)
+			}
+			info, err = os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.RegisterFile(path, info.Size(), info.ModTime(), false, false); err != nil {
+				t.Fatal(err)
+			}
+
+			want := StatusUploaded
+			if enabled {
+				want = StatusPending
+			}
+			files, err := s.ListFiles(want, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != 1 {
+				t.Fatalf("Expected the modified file to be %s, got %d such files", want, len(files))
+			}
+			rec := files[0]
+			if rec.Size != info.Size() || !rec.ModTime.Equal(info.ModTime()) {
+				t.Errorf("Expected the new size and mod time to be recorded, got %d %v", rec.Size, rec.ModTime)
+			}
+		})
+	}
+}
+
+func TestUploadAttemptsAreRecordedAndCapped(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "store_attempts_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s, err := NewStore(filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer s.Close()
+
+	path := "/data/flaky.png"
+	other := "/data/other.png"
+	start := time.Now()
+	var batch []UploadAttempt
+	for n := 0; n < 5; n++ {
+		batch = append(batch, UploadAttempt{
+			Path:        path,
+			AttemptedAt: start.Add(time.Duration(n) * time.Second),
+			Outcome:     AttemptFailed,
+			HTTPStatus:  503,
+			Duration:    time.Duration(n+1) * 100 * time.Millisecond,
+			Error:       fmt.Sprintf("attempt %d: service unavailable", n),
+		})
+	}
+	batch = append(batch, UploadAttempt{Path: other, AttemptedAt: start, Outcome: AttemptSucceeded})
+	if err := s.AddUploadAttempts(batch, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddUploadAttempts([]UploadAttempt{{Path: path, AttemptedAt: start.Add(time.Minute), Outcome: AttemptSucceeded, Duration: time.Second}}, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	attempts, err := s.GetUploadAttempts(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attempts) != 3 {
+		t.Fatalf("Expected the history to be capped at 3 attempts, got %d", len(attempts))
+	}
+	if attempts[0].Error != "attempt 3: service unavailable" || attempts[0].HTTPStatus != 503 || attempts[0].Duration != 400*time.Millisecond {
+		t.Errorf("Expected the oldest kept attempt to be attempt 3, got %+v", attempts[0])
+	}
+	if last := attempts[2]; last.Outcome != AttemptSucceeded || last.Error != "" || !last.AttemptedAt.Equal(start.Add(time.Minute)) {
+		t.Errorf("Expected the newest attempt last, got %+v", last)
+	}
+
+	if others, err := s.GetUploadAttempts(other); err != nil || len(others) != 1 {
+		t.Errorf("Expected the other file's history to be kept, got %d (err=%v)", len(others), err)
+	}
+
+	if err := s.RegisterFile(path, 10, start, false, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RemoveFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if attempts, _ := s.GetUploadAttempts(path); len(attempts) != 0 {
+		t.Errorf("Expected the history to be removed with the file, got %d attempts", len(attempts))
+	}
+}
+
+func TestCountByStatusReadOnly(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "store_count_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "test.db")
+	s, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer s.Close()
+
+	now := time.Now()
+	for _, p := range []string{"/data/a.png", "/data/b.png", "/data/c.png"} {
+		if err := s.RegisterFile(p, 10, now, false, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.MarkUploaded("/data/c.png"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterFile("/data/d.png", 10, now, false, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// The daemon's store stays open while the CLI reads.
+	ro, err := OpenReadOnly(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open read-only: %v", err)
+	}
+	defer ro.Close()
+
+	counts, err := ro.CountByStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts[StatusPending] != 2 || counts[StatusUploaded] != 1 || counts[StatusAwaitingPartner] != 1 || counts[StatusOrphan] != 0 {
+		t.Errorf("Unexpected counts %v", counts)
+	}
+	if err := ro.RegisterFile("/data/e.png", 10, now, false, false); err == nil {
+		t.Error("Expected writes through a read-only store to fail")
+	}
+
+	if _, err := OpenReadOnly(filepath.Join(tmpDir, "missing.db")); !os.IsNotExist(err) {
+		t.Errorf("Expected a missing database to be reported, got %v", err)
+	}
+}
+
+func TestMoveToDeadLetterAndRetry(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "store_deadletter_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s, err := NewStore(filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer s.Close()
+
+	path := "/data/bad name.png"
+	modTime := time.Now().Truncate(time.Second)
+	if err := s.RegisterFile(path, 10, modTime, false, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RecordFailure(path, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MoveToDeadLetter(path, "400 Bad Request: invalid name"); err != nil {
+		t.Fatalf("MoveToDeadLetter failed: %v", err)
+	}
+
+	if files, _ := s.GetPendingFiles(10); len(files) != 0 {
+		t.Fatalf("Expected a dead-lettered file not to be pending, got %+v", files)
+	}
+	letters, err := s.ListDeadLetters(10)
+	if err != nil || len(letters) != 1 {
+		t.Fatalf("Expected 1 dead letter, got %d (err=%v)", len(letters), err)
+	}
+	if d := letters[0]; d.Path != path || d.RetryCount != 1 || d.LastError.String != "400 Bad Request: invalid name" || d.FailedAt.IsZero() {
+		t.Errorf("Unexpected dead letter %+v", d)
+	}
+
+	// The watcher or a scan seeing the unchanged file does not revive it.
+	if err := s.RegisterFile(path, 10, modTime, false, false); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := s.GetPendingFiles(10); len(files) != 0 {
+		t.Fatalf("Expected an unchanged dead-lettered file to stay dead-lettered, got %+v", files)
+	}
+
+	if err := s.RetryDeadLetter(path); err != nil {
+		t.Fatalf("RetryDeadLetter failed: %v", err)
+	}
+	files, err := s.GetPendingFiles(10)
+	if err != nil || len(files) != 1 || files[0].RetryCount != 0 || files[0].Status != StatusPending {
+		t.Fatalf("Expected the file to be PENDING with no retries, got %+v (err=%v)", files, err)
+	}
+	if n, _ := s.CountDeadLetters(); n != 0 {
+		t.Errorf("Expected no dead letters after retry, got %d", n)
+	}
+	if err := s.RetryDeadLetter(path); err == nil {
+		t.Error("Expected an error retrying a file that is not dead-lettered")
+	}
+}
+
+func TestGetPendingFilesAfterPagesThroughQueue(t *testing.T) {
+	s, err := NewStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer s.Close()
+
+	// Same mod time for two files, so the ID breaks the tie.
+	base := time.Now().Add(-time.Hour)
+	for _, f := range []struct {
+		path string
+		age  time.Duration
+	}{
+		{"/data/a.png", 3 * time.Minute},
+		{"/data/b.png", 2 * time.Minute},
+		{"/data/c.png", 2 * time.Minute},
+		{"/data/d.png", time.Minute},
+	} {
+		if err := s.RegisterFile(f.path, 10, base.Add(-f.age), false, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SetPriority("/data/d.png", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	var after int64
+	for {
+		files, err := s.GetPendingFilesAfter(after, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range files {
+			got = append(got, filepath.Base(f.Path))
+		}
+		if len(files) < 2 {
+			break
+		}
+		after = files[len(files)-1].ID
+	}
+	if want := "d.png a.png b.png c.png"; strings.Join(got, " ") != want {
+		t.Errorf("Expected pages in queue order %q, got %q", want, strings.Join(got, " "))
+	}
+
+	// A cursor at a file that is no longer tracked ends the walk.
+	files, err := s.GetPendingFilesAfter(999, 2)
+	if err != nil || len(files) != 0 {
+		t.Errorf("Expected no files after an untracked cursor, got %d (err=%v)", len(files), err)
+	}
+}
+
+func TestFindUploadedByHashResolvesDuplicates(t *testing.T) {
+	s, err := NewStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer s.Close()
+
+	modTime := time.Now().Truncate(time.Second)
+	for _, path := range []string{"/data/a.png", "/data/b.png"} {
+		if err := s.RegisterFile(path, 10, modTime, false, false); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.SetChecksum(path, modTime, "abc"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Only UPLOADED files count.
+	if got, err := s.FindUploadedByHash("abc"); err != nil || got != "" {
+		t.Fatalf("Expected no uploaded match while both files are pending, got %q (err=%v)", got, err)
+	}
+	if err := s.MarkUploaded("/data/a.png"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.FindUploadedByHash("abc"); got != "/data/a.png" {
+		t.Fatalf("Expected /data/a.png, got %q", got)
+	}
+	if got, _ := s.FindUploadedByHash("other"); got != "" {
+		t.Fatalf("Expected no match for another hash, got %q", got)
+	}
+
+	files, err := s.GetPendingFiles(10)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected 1 pending file, got %d (err=%v)", len(files), err)
+	}
+	if marked, err := s.MarkDuplicateVersion("/data/b.png", files[0].Version, "/data/a.png"); err != nil || !marked {
+		t.Fatalf("MarkDuplicateVersion = %v, %v", marked, err)
+	}
+	if got, _ := s.GetDuplicateOf("/data/b.png"); got != "/data/a.png" {
+		t.Errorf("Expected b.png to be recorded as a duplicate of a.png, got %q", got)
+	}
+
+	// Once the original is gone, its duplicate still refers to it.
+	if err := s.RemoveFile("/data/a.png"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.FindUploadedByHash("abc"); got != "/data/a.png" {
+		t.Errorf("Expected the duplicate to resolve to its original, got %q", got)
+	}
+
+	// Uploading the file itself clears the reference.
+	if err := s.MarkUploaded("/data/b.png"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.GetDuplicateOf("/data/b.png"); got != "" {
+		t.Errorf("Expected no duplicate reference after an upload, got %q", got)
+	}
+}
+
+func TestMaintenanceCompactsDatabase(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	s, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer s.Close()
+
+	// Churn: many records registered and deleted again leave free pages behind.
+	modTime := time.Now()
+	path := func(i int) string { return fmt.Sprintf("/data/frames/%s-%04d.png", strings.Repeat("x", 100), i) }
+	for i := 0; i < 1000; i++ {
+		if err := s.RegisterFile(path(i), 10, modTime, false, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		if err := s.RemoveFile(path(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := s.Maintenance(false)
+	if err != nil {
+		t.Fatalf("Maintenance without VACUUM failed: %v", err)
+	}
+	if report.Vacuumed || report.SizeAfter != report.SizeBefore {
+		t.Errorf("Expected the database to keep its size without VACUUM, got %+v", report)
+	}
+
+	report, err = s.Maintenance(true)
+	if err != nil {
+		t.Fatalf("Maintenance failed: %v", err)
+	}
+	if !report.Vacuumed || report.SizeAfter >= report.SizeBefore {
+		t.Errorf("Expected VACUUM to shrink the database, got %+v", report)
+	}
+	if info, err := os.Stat(dbPath + "-wal"); err == nil && info.Size() != 0 {
+		t.Errorf("Expected the WAL to be truncated, got %d bytes", info.Size())
+	}
+}
+
+// partners returns the partner_path of every tracked file.
+func partners(t *testing.T, s *Store) map[string]string {
+	t.Helper()
+	files, err := s.ListFiles("", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := make(map[string]string)
+	for _, f := range files {
+		m[f.Path] = f.PartnerPath.String
+	}
+	return m
+}
+
+func TestSplitSidecarsMatchPathsLiterally(t *testing.T) {
+	// Neither "_" nor a different case makes a sidecar match another stem.
+	for _, tc := range []struct{ data, sidecar string }{
+		{"/data/img_1.png", "/data/imgA1.exif.json"},
+		{"/data/Cam.png", "/data/cam.exif.json"},
+	} {
+		for _, sidecarFirst := range []bool{true, false} {
+			s, err := NewStore(filepath.Join(t.TempDir(), "test.db"))
+			if err != nil {
+				t.Fatalf("Failed to create store: %v", err)
+			}
+			order := []string{tc.data, tc.sidecar}
+			if sidecarFirst {
+				order = []string{tc.sidecar, tc.data}
+			}
+			for _, p := range order {
+				if err := s.RegisterFile(p, 10, time.Now(), p == tc.sidecar, true); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if got := partners(t, s)[tc.sidecar]; got != "" {
+				t.Errorf("Expected %s to stay unpaired (sidecar first: %v), got partner %s", tc.sidecar, sidecarFirst, got)
+			}
+			if sidecars, _ := s.GetSidecars(tc.data); len(sidecars) != 0 {
+				t.Errorf("Expected no sidecars for %s (sidecar first: %v), got %v", tc.data, sidecarFirst, sidecars)
+			}
+			s.Close()
+		}
+	}
+}
+
+func TestSplitSidecarsSkipSidecarsOfOtherDataFiles(t *testing.T) {
+	// shot.v2.json is the sidecar of shot.v2.png, not a split sidecar of shot.png.
+	shot, shotV2, sidecar := "/data/shot.png", "/data/shot.v2.png", "/data/shot.v2.json"
+	orders := [][]string{
+		{shot, shotV2, sidecar},
+		{shot, sidecar, shotV2},
+		{shotV2, shot, sidecar},
+		{shotV2, sidecar, shot},
+		{sidecar, shot, shotV2},
+		{sidecar, shotV2, shot},
+	}
+	for _, order := range orders {
+		s, err := NewStore(filepath.Join(t.TempDir(), "test.db"))
+		if err != nil {
+			t.Fatalf("Failed to create store: %v", err)
+		}
+		for _, p := range order {
+			if err := s.RegisterFile(p, 10, time.Now(), p == sidecar, true); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		got := partners(t, s)
+		if got[sidecar] != shotV2 {
+			t.Errorf("Order %v: expected %s paired with %s, got %q", order, sidecar, shotV2, got[sidecar])
+		}
+		if got[shot] == sidecar {
+			t.Errorf("Order %v: expected %s not to point at %s", order, shot, sidecar)
+		}
+		if sidecars, _ := s.GetSidecars(shot); len(sidecars) != 0 {
+			t.Errorf("Order %v: expected no sidecars for %s, got %v", order, shot, sidecars)
+		}
+		s.Close()
+	}
+}
+
+func TestGetStaleSidecarsCountsFromDetection(t *testing.T) {
+	s, err := NewStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer s.Close()
+	clk := clock.NewFake(time.Now())
+	s.SetClock(clk)
+
+	// Copied in with its old mod time preserved: it only just arrived.
+	sidecar := "/data/lonely.png.json"
+	if err := s.RegisterFile(sidecar, 2, time.Now().Add(-48*time.Hour), true, true); err != nil {
+		t.Fatal(err)
+	}
+	if stale, err := s.GetStaleSidecars(time.Hour); err != nil || len(stale) != 0 {
+		t.Fatalf("Expected a just detected sidecar not to be stale, got %d (err=%v)", len(stale), err)
+	}
+
+	clk.Advance(time.Hour + time.Second)
+	if stale, err := s.GetStaleSidecars(time.Hour); err != nil || len(stale) != 1 {
+		t.Fatalf("Expected the sidecar to be stale after the timeout, got %d (err=%v)", len(stale), err)
+	}
+}
@@ -0,0 +1,72 @@
+package util
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sniffLen is the number of bytes http.DetectContentType considers.
+const sniffLen = 512
+
+// octetStream is the content type of data that could not be identified.
+const octetStream = "application/octet-stream"
+
+// DetectContentType sniffs the MIME type of a file from its first bytes.
+// It returns "application/octet-stream" when the content is not recognized.
+func DetectContentType(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+// UploadContentType returns the MIME type to upload the file at path with. The
+// content is sniffed first; when that is inconclusive (unknown binary data or
+// plain text, e.g. JSON) the type registered for the file extension is used
+// instead. It falls back to "application/octet-stream".
+func UploadContentType(path string) string {
+	sniffed, err := DetectContentType(path)
+	if err != nil || sniffed == octetStream || strings.HasPrefix(sniffed, "text/plain") {
+		if byExt := mime.TypeByExtension(filepath.Ext(path)); byExt != "" {
+			return byExt
+		}
+	}
+	if err != nil {
+		return octetStream
+	}
+	return sniffed
+}
+
+// ContentTypeAllowed reports whether contentType matches one of the allowed
+// media types. Parameters (e.g. "; charset=utf-8") are ignored and entries may
+// use a wildcard subtype such as "image/*".
+func ContentTypeAllowed(contentType string, allowed []string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = contentType
+	}
+	mediaType = strings.ToLower(mediaType)
+
+	for _, a := range allowed {
+		a = strings.ToLower(strings.TrimSpace(a))
+		if a == mediaType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(a, "/*"); ok && strings.HasPrefix(mediaType, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
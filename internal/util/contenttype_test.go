@@ -0,0 +1,54 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestContentTypeAllowed(t *testing.T) {
+	allowed := []string{"image/png", "video/*"}
+
+	tests := []struct {
+		contentType string
+		want        bool
+	}{
+		{"image/png", true},
+		{"IMAGE/PNG", true},
+		{"video/mp4", true},
+		{"image/jpeg", false},
+		{"text/plain; charset=utf-8", false},
+	}
+	for _, tt := range tests {
+		if got := ContentTypeAllowed(tt.contentType, allowed); got != tt.want {
+			t.Errorf("ContentTypeAllowed(%q) = %v, want %v", tt.contentType, got, tt.want)
+		}
+	}
+}
+
+func TestUploadContentType(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content []byte
+		want    string
+	}{
+		{"photo.jpg", []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00"), "image/jpeg"},
+		{"scan.dat", []byte("\x89PNG\r\n\x1a\n"), "image/png"},          // Sniffed, extension is ignored
+		{"meta.json", []byte(`{"camera": "cam1"}`), "application/json"}, // Text, typed by extension
+		{"blob.xyz123", []byte{0x00, 0x01, 0x02}, "application/octet-stream"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name)
+		if err := os.WriteFile(path, tt.content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if got := UploadContentType(path); !strings.HasPrefix(got, tt.want) {
+			t.Errorf("UploadContentType(%s) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	if got := UploadContentType(filepath.Join(dir, "missing.bin")); got != "application/octet-stream" {
+		t.Errorf("UploadContentType(missing file) = %q, want application/octet-stream", got)
+	}
+}
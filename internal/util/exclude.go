@@ -0,0 +1,102 @@
+package util
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ExcludePatterns decides which files are ignored, e.g.:
+//
+//	*.tmp, ~$*, .*, cache/**, **/thumbnails/*
+//
+// A pattern without a "/" is matched against the file's base name. A pattern
+// with a "/" is matched against the slash-separated path relative to the watch
+// root, where a "**" segment matches any number of directories (including none).
+// Other segments use filepath.Match syntax, and a trailing "/" excludes a whole
+// directory. A nil ExcludePatterns matches nothing.
+type ExcludePatterns struct {
+	names []string   // Patterns for the base name
+	paths [][]string // Segmented patterns for the relative path
+}
+
+// ParseExcludePatterns validates and compiles glob patterns. It returns nil if
+// there are none.
+func ParseExcludePatterns(patterns []string) (*ExcludePatterns, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	e := &ExcludePatterns{}
+	for _, p := range patterns {
+		p = strings.TrimSpace(filepath.ToSlash(p))
+		if p == "" {
+			return nil, fmt.Errorf("empty pattern")
+		}
+		segments := strings.Split(strings.Trim(p, "/"), "/")
+		if strings.HasSuffix(p, "/") { // "dir/" excludes everything below dir
+			segments = append(segments, "**")
+		}
+		for _, s := range segments {
+			if _, err := path.Match(s, ""); err != nil {
+				return nil, fmt.Errorf("pattern %q: %w", p, err)
+			}
+		}
+		if !strings.Contains(p, "/") {
+			e.names = append(e.names, p)
+		} else {
+			e.paths = append(e.paths, segments)
+		}
+	}
+	return e, nil
+}
+
+// Match reports whether the file at path (under root) is excluded.
+func (e *ExcludePatterns) Match(root, file string) bool {
+	if e == nil {
+		return false
+	}
+	base := filepath.Base(file)
+	for _, p := range e.names {
+		if ok, _ := path.Match(p, base); ok {
+			return true
+		}
+	}
+	if len(e.paths) == 0 {
+		return false
+	}
+	rel, err := filepath.Rel(root, file)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for _, p := range e.paths {
+		if matchSegments(p, parts) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchSegments matches path segments against pattern segments, where "**"
+// matches zero or more segments.
+func matchSegments(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(parts); i++ {
+				if matchSegments(pattern[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
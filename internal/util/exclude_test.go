@@ -0,0 +1,47 @@
+package util
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestExcludePatterns(t *testing.T) {
+	root := filepath.FromSlash("/data")
+	e, err := ParseExcludePatterns([]string{"*.tmp", ".*", "~$*", "cache/**", "**/thumbs/*", "scratch/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path     string
+		excluded bool
+	}{
+		{"img.png", false},
+		{"img.png.tmp", true},
+		{"cam1/2024/upload.tmp", true},
+		{".DS_Store", true},
+		{"cam1/.hidden.png", true},
+		{"~$report.json", true},
+		{"cache/img.png", true},
+		{"cache/deep/nested/img.png", true},
+		{"cam1/cache/img.png", false},
+		{"thumbs/img.png", true},
+		{"cam1/2024/thumbs/img.png", true},
+		{"cam1/2024/thumbs/sub/img.png", false},
+		{"scratch/a/b.png", true},
+		{"cam1/img.png", false},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(root, filepath.FromSlash(tt.path))
+		if got := e.Match(root, path); got != tt.excluded {
+			t.Errorf("Match(%q) = %v, want %v", tt.path, got, tt.excluded)
+		}
+	}
+
+	if e, err := ParseExcludePatterns(nil); err != nil || e.Match(root, filepath.Join(root, "a.tmp")) {
+		t.Errorf("Expected no patterns to exclude nothing (err=%v)", err)
+	}
+	if _, err := ParseExcludePatterns([]string{"[abc"}); err == nil {
+		t.Error("Expected a malformed pattern to be rejected")
+	}
+}
@@ -0,0 +1,74 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+)
+
+// IgnoreRules are the patterns of an ignore file in gitignore style, e.g.:
+//
+//	# Editor leftovers
+//	*.swp
+//	cache/
+//	!cache/keep.png
+//
+// Each line is a pattern with the same syntax as ExcludePatterns. Blank lines
+// and lines starting with "#" are skipped, and a leading "!" re-includes files
+// excluded by an earlier line. The last matching line decides. A nil
+// IgnoreRules matches nothing.
+type IgnoreRules struct {
+	rules []ignoreRule
+}
+
+type ignoreRule struct {
+	pattern *ExcludePatterns
+	negate  bool
+}
+
+// ParseIgnoreRules compiles the lines of an ignore file. Invalid lines are
+// skipped and reported as errors; the valid ones still apply.
+func ParseIgnoreRules(content string) (*IgnoreRules, []error) {
+	r := &IgnoreRules{}
+	var errs []error
+	for n, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		negate := strings.HasPrefix(line, "!")
+		line = strings.TrimPrefix(line, "!")
+		if strings.HasPrefix(line, `\`) { // "\#" and "\!" match a literal first character
+			line = line[1:]
+		}
+		p, err := ParseExcludePatterns([]string{line})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %w", n+1, err))
+			continue
+		}
+		r.rules = append(r.rules, ignoreRule{pattern: p, negate: negate})
+	}
+	return r, errs
+}
+
+// Len returns the number of valid patterns.
+func (r *IgnoreRules) Len() int {
+	if r == nil {
+		return 0
+	}
+	return len(r.rules)
+}
+
+// Match reports whether the file at path (under root) is ignored.
+func (r *IgnoreRules) Match(root, file string) bool {
+	if r == nil {
+		return false
+	}
+	ignored := false
+	for _, rule := range r.rules {
+		// Only rules that would flip the current result need to be matched.
+		if rule.negate == ignored && rule.pattern.Match(root, file) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
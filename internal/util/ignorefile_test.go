@@ -0,0 +1,43 @@
+package util
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestIgnoreRules(t *testing.T) {
+	root := filepath.FromSlash("/data")
+	content := `# Editor leftovers
+*.swp
+
+cache/
+!cache/keep.png
+[invalid
+\#notes.txt
+`
+	r, errs := ParseIgnoreRules(content)
+	if len(errs) != 1 {
+		t.Fatalf("Expected the invalid line to be reported, got %v", errs)
+	}
+	if r.Len() != 4 {
+		t.Errorf("Expected 4 valid patterns, got %d", r.Len())
+	}
+
+	tests := []struct {
+		path    string
+		ignored bool
+	}{
+		{"img.png", false},
+		{"cam1/img.png.swp", true},
+		{"cache/img.png", true},
+		{"cache/keep.png", false},
+		{"#notes.txt", true},
+		{"[invalid", false},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(root, filepath.FromSlash(tt.path))
+		if got := r.Match(root, path); got != tt.ignored {
+			t.Errorf("Match(%q) = %v, want %v", tt.path, got, tt.ignored)
+		}
+	}
+}
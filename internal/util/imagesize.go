@@ -0,0 +1,43 @@
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	_ "image/gif" // Register decoders for image.DecodeConfig
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// imageExtensions are the extensions ImageMetadata reads headers of.
+var imageExtensions = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true}
+
+// ImageMetadata reads only the header of a JPEG, PNG or GIF file and returns
+// its dimensions and MIME type as metadata ("image_width", "image_height",
+// "image_mime"). Other extensions yield nil; unreadable or corrupt images
+// yield an error.
+func ImageMetadata(path string) (map[string]string, error) {
+	if !imageExtensions[strings.ToLower(filepath.Ext(path))] {
+		return nil, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cfg, format, err := image.DecodeConfig(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image header: %w", err)
+	}
+	return map[string]string{
+		"image_width":  strconv.Itoa(cfg.Width),
+		"image_height": strconv.Itoa(cfg.Height),
+		"image_mime":   "image/" + format,
+	}, nil
+}
@@ -0,0 +1,57 @@
+package util
+
+import (
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestImageMetadata(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "imagesize_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	img := image.NewRGBA(image.Rect(0, 0, 7, 3))
+	write := func(name string, encode func(f *os.File) error) string {
+		path := filepath.Join(tmpDir, name)
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if err := encode(f); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	pngPath := write("img.png", func(f *os.File) error { return png.Encode(f, img) })
+	jpegPath := write("img.JPG", func(f *os.File) error { return jpeg.Encode(f, img, nil) })
+
+	for path, mime := range map[string]string{pngPath: "image/png", jpegPath: "image/jpeg"} {
+		meta, err := ImageMetadata(path)
+		if err != nil {
+			t.Fatalf("ImageMetadata(%s) failed: %v", path, err)
+		}
+		if meta["image_width"] != "7" || meta["image_height"] != "3" || meta["image_mime"] != mime {
+			t.Errorf("Unexpected metadata for %s: %v", path, meta)
+		}
+	}
+
+	corrupt := filepath.Join(tmpDir, "corrupt.png")
+	if err := os.WriteFile(corrupt, []byte("not a png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if meta, err := ImageMetadata(corrupt); err == nil {
+		t.Errorf("Expected an error for a corrupt image, got %v", meta)
+	}
+
+	sidecar := filepath.Join(tmpDir, "img.png.json")
+	if meta, err := ImageMetadata(sidecar); err != nil || meta != nil {
+		t.Errorf("Expected non-image files to be ignored, got %v (err=%v)", meta, err)
+	}
+}
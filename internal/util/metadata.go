@@ -4,9 +4,25 @@ package util
 
 import (
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// MaxMetadataSegments caps how many directory segments are turned into context/metadata.
+	// Pathologically deep trees (or symlink loops flattened into a path) would otherwise
+	// produce unbounded arrays in the ingest request.
+	MaxMetadataSegments = 32
+	// MaxMetadataBytes caps the combined size of metadata keys and values.
+	MaxMetadataBytes = 4096
+	// MaxSegmentBytes caps a single directory segment, the usual file name limit.
+	MaxSegmentBytes = 255
 )
 
 // ExtractMetadata returns the context (directory parts) and a map of tags
@@ -22,6 +38,10 @@ import (
 //	context: A slice of directory names (e.g., ["cam1", "2023"])
 //	meta: A map where keys are "dir_N" and values are the directory names.
 //	      (e.g., {"dir_0": "cam1", "dir_1": "2023"})
+//
+// Segments are cleaned with SanitizeSegment. Output is capped at
+// MaxMetadataSegments segments and MaxMetadataBytes in total; anything beyond
+// is dropped with a logged warning.
 func ExtractMetadata(root, path string) ([]string, map[string]string) {
 	meta := make(map[string]string)
 	var context []string
@@ -40,13 +60,86 @@ func ExtractMetadata(root, path string) ([]string, map[string]string) {
 
 	parts := strings.Split(dir, string(os.PathSeparator))
 
+	totalBytes := 0
 	for i, part := range parts {
 		if part == "." || part == "" {
 			continue
 		}
+		part = SanitizeSegment(part)
+		key := fmt.Sprintf("dir_%d", i)
+		if len(context) >= MaxMetadataSegments || totalBytes+len(key)+len(part) > MaxMetadataBytes {
+			slog.Warn("Directory metadata truncated", "path", path, "segments", len(parts), "kept", len(context))
+			break
+		}
+		totalBytes += len(key) + len(part)
 		context = append(context, part)
-		meta[fmt.Sprintf("dir_%d", i)] = part
+		meta[key] = part
 	}
 
 	return context, meta
 }
+
+// SidecarMetadataPrefix namespaces the metadata keys taken from a sidecar, so
+// they never collide with the "dir_N" keys or other tags.
+const SidecarMetadataPrefix = "sidecar_"
+
+// SidecarMetadata returns the top-level scalar fields of decoded sidecar JSON
+// as metadata tags, keyed SidecarMetadataPrefix + field name. Numbers and
+// booleans are formatted as strings; nulls, arrays and objects are left out
+// (they stay in the device context). Keys and values are cleaned with
+// SanitizeSegment and the result is capped at MaxMetadataBytes, dropping the
+// fields that come last alphabetically with a logged warning.
+func SidecarMetadata(sidecar map[string]interface{}) map[string]string {
+	keys := make([]string, 0, len(sidecar))
+	for k := range sidecar {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	meta := make(map[string]string)
+	totalBytes := 0
+	for _, k := range keys {
+		var value string
+		switch v := sidecar[k].(type) {
+		case string:
+			value = v
+		case float64:
+			value = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			value = strconv.FormatBool(v)
+		default:
+			continue
+		}
+		key := SidecarMetadataPrefix + SanitizeSegment(k)
+		value = SanitizeSegment(value)
+		if totalBytes+len(key)+len(value) > MaxMetadataBytes {
+			slog.Warn("Sidecar metadata truncated", "fields", len(sidecar), "kept", len(meta))
+			break
+		}
+		totalBytes += len(key) + len(value)
+		meta[key] = value
+	}
+	return meta
+}
+
+// SanitizeSegment makes a path segment safe to send as metadata: invalid UTF-8
+// and control characters (newlines, tabs, escapes) are replaced with "_" and the
+// result is cut to MaxSegmentBytes on a character boundary.
+func SanitizeSegment(segment string) string {
+	segment = strings.ToValidUTF8(segment, "_")
+	segment = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return '_'
+		}
+		return r
+	}, segment)
+
+	if len(segment) <= MaxSegmentBytes {
+		return segment
+	}
+	cut := MaxSegmentBytes
+	for cut > 0 && !utf8.RuneStart(segment[cut]) {
+		cut--
+	}
+	return segment[:cut]
+}
@@ -0,0 +1,125 @@
+package util
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestExtractMetadata(t *testing.T) {
+	root := filepath.Join("/data")
+	path := filepath.Join(root, "cam1", "2023", "img.jpg")
+
+	context, meta := ExtractMetadata(root, path)
+
+	if len(context) != 2 || context[0] != "cam1" || context[1] != "2023" {
+		t.Errorf("Unexpected context: %v", context)
+	}
+	if meta["dir_0"] != "cam1" || meta["dir_1"] != "2023" {
+		t.Errorf("Unexpected metadata: %v", meta)
+	}
+}
+
+func TestExtractMetadata_CapsDeepPaths(t *testing.T) {
+	root := filepath.Join("/data")
+
+	// 500 nested directories, far beyond the segment cap.
+	segments := make([]string, 500)
+	for i := range segments {
+		segments[i] = "level"
+	}
+	path := filepath.Join(root, filepath.Join(segments...), "img.jpg")
+
+	context, meta := ExtractMetadata(root, path)
+
+	if len(context) != MaxMetadataSegments {
+		t.Errorf("Expected context capped at %d segments, got %d", MaxMetadataSegments, len(context))
+	}
+	if len(meta) != MaxMetadataSegments {
+		t.Errorf("Expected metadata capped at %d keys, got %d", MaxMetadataSegments, len(meta))
+	}
+	if _, ok := meta["dir_32"]; ok {
+		t.Error("Expected dir_32 to be truncated")
+	}
+}
+
+func TestExtractMetadata_CapsTotalSize(t *testing.T) {
+	root := filepath.Join("/data")
+
+	// Many maximum-length segments exceed the byte budget before the segment cap.
+	long := strings.Repeat("x", MaxSegmentBytes)
+	parts := []string{root}
+	for i := 0; i < 20; i++ {
+		parts = append(parts, long)
+	}
+	path := filepath.Join(append(parts, "img.jpg")...)
+
+	_, meta := ExtractMetadata(root, path)
+
+	total := 0
+	for k, v := range meta {
+		total += len(k) + len(v)
+	}
+	if total > MaxMetadataBytes {
+		t.Errorf("Expected metadata size <= %d bytes, got %d", MaxMetadataBytes, total)
+	}
+	// dir_0..dir_9 take 260 bytes each, dir_10 onwards 261.
+	if len(meta) != 15 {
+		t.Errorf("Expected 15 segments to fit, got %d", len(meta))
+	}
+}
+
+func TestExtractMetadata_SanitizesSegments(t *testing.T) {
+	root := filepath.Join("/data")
+	long := strings.Repeat("é", MaxSegmentBytes) // 2 bytes per rune
+	path := filepath.Join(root, "cam\n1", "a\x00b\tc\x1b", "bad\xffutf8", long, "img.jpg")
+
+	context, meta := ExtractMetadata(root, path)
+
+	want := []string{"cam_1", "a_b_c_", "bad_utf8"}
+	for i, w := range want {
+		if context[i] != w {
+			t.Errorf("Segment %d: expected %q, got %q", i, w, context[i])
+		}
+	}
+	if meta["dir_0"] != "cam_1" {
+		t.Errorf("Expected sanitized metadata, got %q", meta["dir_0"])
+	}
+
+	capped := context[3]
+	if len(capped) > MaxSegmentBytes || !utf8.ValidString(capped) || !strings.HasPrefix(long, capped) {
+		t.Errorf("Expected segment cut to %d bytes on a rune boundary, got %d bytes", MaxSegmentBytes, len(capped))
+	}
+}
+
+func TestSidecarMetadata(t *testing.T) {
+	sidecar := map[string]interface{}{
+		"camera":   "cam1",
+		"exposure": 0.004,
+		"iso":      float64(400),
+		"flash":    false,
+		"dir_0":    "spoofed",
+		"note":     nil,
+		"tags":     []interface{}{"a", "b"},
+		"gps":      map[string]interface{}{"lat": 52.5, "lon": 13.4},
+	}
+
+	meta := SidecarMetadata(sidecar)
+
+	want := map[string]string{
+		"sidecar_camera":   "cam1",
+		"sidecar_exposure": "0.004",
+		"sidecar_iso":      "400",
+		"sidecar_flash":    "false",
+		"sidecar_dir_0":    "spoofed",
+	}
+	if len(meta) != len(want) {
+		t.Errorf("Expected only the scalar fields %v, got %v", want, meta)
+	}
+	for k, v := range want {
+		if meta[k] != v {
+			t.Errorf("Expected %s=%q, got %q", k, v, meta[k])
+		}
+	}
+}
@@ -0,0 +1,395 @@
+package util
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// MetadataRules computes derived metadata from a file's path using small
+// assignment expressions, e.g.:
+//
+//	date = parts[1]; camera = upper(parts[0]); id = name + "-" + parts[-1]
+//
+// Expressions are strings only and can use:
+//
+//	parts[N]  directory segments relative to the watch root (negative N counts from the end)
+//	filename  base name ("img.jpg"), name (without extension, "img"), ext (".jpg")
+//	dir, path directory and full path relative to the watch root
+//	"text"    string literals (single or double quotes), joined with +
+//	upper(s), lower(s), trim(s), replace(s, old, new), slice(s, start, end),
+//	split(s, sep, n) (n-th field), default(s, fallback) (fallback if s is empty)
+//
+// There are no loops, variables or side effects; an out-of-range index yields "".
+// Values are cleaned with SanitizeSegment; keys whose value is "" are omitted.
+type MetadataRules struct {
+	rules []metadataRule
+}
+
+type metadataRule struct {
+	key  string
+	expr ruleExpr
+}
+
+// ParseMetadataRules compiles rules. Each entry holds one or more
+// "key = expression" assignments separated by ";".
+func ParseMetadataRules(rules []string) (*MetadataRules, error) {
+	m := &MetadataRules{}
+	for _, src := range rules {
+		p := &ruleParser{src: src}
+		parsed, err := p.parseProgram()
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", src, err)
+		}
+		m.rules = append(m.rules, parsed...)
+	}
+	return m, nil
+}
+
+// Apply evaluates the rules for a file under root. A nil MetadataRules yields nil.
+func (m *MetadataRules) Apply(root, path string) map[string]string {
+	if m == nil || len(m.rules) == 0 {
+		return nil
+	}
+
+	env := ruleEnv{
+		filename: filepath.Base(path),
+		ext:      filepath.Ext(path),
+	}
+	env.name = strings.TrimSuffix(env.filename, env.ext)
+	if rel, err := filepath.Rel(root, path); err == nil {
+		env.path = filepath.ToSlash(rel)
+		if dir := filepath.Dir(rel); dir != "." {
+			env.dir = filepath.ToSlash(dir)
+			env.parts = strings.Split(env.dir, "/")
+		}
+	}
+
+	out := make(map[string]string)
+	for _, r := range m.rules {
+		if v := SanitizeSegment(r.expr.eval(&env)); v != "" {
+			out[r.key] = v
+		}
+	}
+	return out
+}
+
+// ruleEnv holds the values expressions can refer to.
+type ruleEnv struct {
+	parts               []string
+	filename, name, ext string
+	dir, path           string
+}
+
+func (e *ruleEnv) variable(name string) (string, bool) {
+	switch name {
+	case "filename":
+		return e.filename, true
+	case "name":
+		return e.name, true
+	case "ext":
+		return e.ext, true
+	case "dir":
+		return e.dir, true
+	case "path":
+		return e.path, true
+	}
+	return "", false
+}
+
+// ruleExpr is a node of a compiled expression.
+type ruleExpr interface {
+	eval(env *ruleEnv) string
+}
+
+type literalExpr string
+
+func (l literalExpr) eval(*ruleEnv) string { return string(l) }
+
+type variableExpr string
+
+func (v variableExpr) eval(env *ruleEnv) string {
+	s, _ := env.variable(string(v))
+	return s
+}
+
+type partExpr int
+
+func (p partExpr) eval(env *ruleEnv) string {
+	i := int(p)
+	if i < 0 {
+		i += len(env.parts)
+	}
+	if i < 0 || i >= len(env.parts) {
+		return ""
+	}
+	return env.parts[i]
+}
+
+type concatExpr []ruleExpr
+
+func (c concatExpr) eval(env *ruleEnv) string {
+	var b strings.Builder
+	for _, e := range c {
+		b.WriteString(e.eval(env))
+	}
+	return b.String()
+}
+
+type callExpr struct {
+	fn   ruleFunc
+	args []ruleExpr
+}
+
+func (c callExpr) eval(env *ruleEnv) string {
+	args := make([]string, len(c.args))
+	for i, a := range c.args {
+		args[i] = a.eval(env)
+	}
+	return c.fn.call(args)
+}
+
+// ruleFunc is a built-in function with a fixed number of arguments.
+type ruleFunc struct {
+	arity int
+	call  func(args []string) string
+}
+
+var ruleFuncs = map[string]ruleFunc{
+	"upper": {1, func(a []string) string { return strings.ToUpper(a[0]) }},
+	"lower": {1, func(a []string) string { return strings.ToLower(a[0]) }},
+	"trim":  {1, func(a []string) string { return strings.TrimSpace(a[0]) }},
+	"replace": {3, func(a []string) string {
+		return strings.ReplaceAll(a[0], a[1], a[2])
+	}},
+	"slice": {3, func(a []string) string {
+		start, err1 := strconv.Atoi(a[1])
+		end, err2 := strconv.Atoi(a[2])
+		if err1 != nil || err2 != nil {
+			return ""
+		}
+		if end > len(a[0]) {
+			end = len(a[0])
+		}
+		if start < 0 || start >= end {
+			return ""
+		}
+		return a[0][start:end]
+	}},
+	"split": {3, func(a []string) string {
+		n, err := strconv.Atoi(a[2])
+		fields := strings.Split(a[0], a[1])
+		if err != nil || a[1] == "" || n < 0 || n >= len(fields) {
+			return ""
+		}
+		return fields[n]
+	}},
+	"default": {2, func(a []string) string {
+		if a[0] == "" {
+			return a[1]
+		}
+		return a[0]
+	}},
+}
+
+// ruleParser is a recursive descent parser over a single rule string.
+//
+//	program := assign { ";" assign } [ ";" ]
+//	assign  := ident "=" expr
+//	expr    := term { "+" term }
+//	term    := string | number | ident | ident "[" number "]" | ident "(" [ expr { "," expr } ] ")"
+type ruleParser struct {
+	src string
+	pos int
+}
+
+func (p *ruleParser) parseProgram() ([]metadataRule, error) {
+	var rules []metadataRule
+	for {
+		p.skipSpace()
+		if p.eof() {
+			break
+		}
+		key := p.ident()
+		if key == "" {
+			return nil, p.errorf("expected a metadata key")
+		}
+		if !p.consume('=') {
+			return nil, p.errorf("expected '=' after %q", key)
+		}
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, metadataRule{key: key, expr: expr})
+
+		p.skipSpace()
+		if p.eof() {
+			break
+		}
+		if !p.consume(';') {
+			return nil, p.errorf("expected ';' between assignments")
+		}
+	}
+	if len(rules) == 0 {
+		return nil, p.errorf("no assignments")
+	}
+	return rules, nil
+}
+
+func (p *ruleParser) parseExpr() (ruleExpr, error) {
+	first, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	terms := concatExpr{first}
+	for p.consume('+') {
+		t, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, t)
+	}
+	if len(terms) == 1 {
+		return first, nil
+	}
+	return terms, nil
+}
+
+func (p *ruleParser) parseTerm() (ruleExpr, error) {
+	p.skipSpace()
+	if p.eof() {
+		return nil, p.errorf("unexpected end of rule")
+	}
+
+	switch c := p.src[p.pos]; {
+	case c == '"' || c == '\'':
+		return p.parseString(c)
+	case c == '-' || (c >= '0' && c <= '9'):
+		n, err := p.number()
+		if err != nil {
+			return nil, err
+		}
+		return literalExpr(strconv.Itoa(n)), nil
+	}
+
+	name := p.ident()
+	if name == "" {
+		return nil, p.errorf("unexpected character %q", p.src[p.pos])
+	}
+
+	if p.consume('[') {
+		if name != "parts" {
+			return nil, p.errorf("only parts can be indexed, not %q", name)
+		}
+		p.skipSpace()
+		n, err := p.number()
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume(']') {
+			return nil, p.errorf("expected ']'")
+		}
+		return partExpr(n), nil
+	}
+
+	if p.consume('(') {
+		fn, ok := ruleFuncs[name]
+		if !ok {
+			return nil, p.errorf("unknown function %q", name)
+		}
+		var args []ruleExpr
+		if !p.consume(')') {
+			for {
+				arg, err := p.parseExpr()
+				if err != nil {
+					return nil, err
+				}
+				args = append(args, arg)
+				if p.consume(')') {
+					break
+				}
+				if !p.consume(',') {
+					return nil, p.errorf("expected ',' or ')' in call to %s", name)
+				}
+			}
+		}
+		if len(args) != fn.arity {
+			return nil, p.errorf("%s takes %d argument(s), got %d", name, fn.arity, len(args))
+		}
+		return callExpr{fn: fn, args: args}, nil
+	}
+
+	if name == "parts" {
+		return nil, p.errorf("parts must be indexed, e.g. parts[0]")
+	}
+	if _, ok := (&ruleEnv{}).variable(name); !ok {
+		return nil, p.errorf("unknown variable %q", name)
+	}
+	return variableExpr(name), nil
+}
+
+func (p *ruleParser) parseString(quote byte) (ruleExpr, error) {
+	p.pos++ // opening quote
+	end := strings.IndexByte(p.src[p.pos:], quote)
+	if end < 0 {
+		return nil, p.errorf("unterminated string")
+	}
+	s := p.src[p.pos : p.pos+end]
+	p.pos += end + 1
+	return literalExpr(s), nil
+}
+
+func (p *ruleParser) number() (int, error) {
+	start := p.pos
+	if !p.eof() && p.src[p.pos] == '-' {
+		p.pos++
+	}
+	for !p.eof() && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+		p.pos++
+	}
+	n, err := strconv.Atoi(p.src[start:p.pos])
+	if err != nil {
+		return 0, p.errorf("invalid number %q", p.src[start:p.pos])
+	}
+	return n, nil
+}
+
+func (p *ruleParser) ident() string {
+	p.skipSpace()
+	start := p.pos
+	for !p.eof() {
+		r := rune(p.src[p.pos])
+		if r != '_' && !unicode.IsLetter(r) && !(p.pos > start && unicode.IsDigit(r)) {
+			break
+		}
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+// consume skips spaces and reports whether the next character is c, consuming it.
+func (p *ruleParser) consume(c byte) bool {
+	p.skipSpace()
+	if !p.eof() && p.src[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *ruleParser) skipSpace() {
+	for !p.eof() && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *ruleParser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *ruleParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("at offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,61 @@
+package util
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestMetadataRules_Apply(t *testing.T) {
+	rules, err := ParseMetadataRules([]string{
+		`date = parts[1]; camera = upper(parts[0])`,
+		`id = name + "-" + parts[-1]`,
+		`site = default(split(parts[0], "_", 1), "unknown")`,
+		`year = slice(parts[1], 0, 4)`,
+		`missing = parts[7]`,
+	})
+	if err != nil {
+		t.Fatalf("ParseMetadataRules failed: %v", err)
+	}
+
+	root := filepath.Join("/data")
+	meta := rules.Apply(root, filepath.Join(root, "cam_north", "2024-05-01", "img.jpg"))
+
+	want := map[string]string{
+		"date":   "2024-05-01",
+		"camera": "CAM_NORTH",
+		"id":     "img-2024-05-01",
+		"site":   "north",
+		"year":   "2024",
+	}
+	for k, v := range want {
+		if meta[k] != v {
+			t.Errorf("%s = %q, want %q", k, meta[k], v)
+		}
+	}
+	if _, ok := meta["missing"]; ok {
+		t.Error("Expected an out-of-range part to be omitted")
+	}
+
+	// A file in the watch root has no parts; default() still applies.
+	meta = rules.Apply(root, filepath.Join(root, "img.jpg"))
+	if meta["site"] != "unknown" || meta["id"] != "img-" {
+		t.Errorf("Unexpected metadata for a root file: %v", meta)
+	}
+}
+
+func TestParseMetadataRules_RejectsInvalidRules(t *testing.T) {
+	for _, rule := range []string{
+		`date parts[1]`,             // missing '='
+		`date = parts`,              // parts must be indexed
+		`date = exec("rm -rf /")`,   // unknown function
+		`date = upper(parts[0], 1)`, // wrong arity
+		`date = "unterminated`,
+		`date = secret`, // unknown variable
+		`a = name b = ext`,
+		``,
+	} {
+		if _, err := ParseMetadataRules([]string{rule}); err == nil {
+			t.Errorf("Expected rule %q to be rejected", rule)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a set of daily time windows, e.g. "22:00-06:00,12:00-13:00".
+// A window whose end is before its start spans midnight. A nil Schedule
+// allows every time.
+type Schedule struct {
+	windows []scheduleWindow
+	loc     *time.Location
+}
+
+// scheduleWindow is a daily window in minutes since midnight, end exclusive.
+type scheduleWindow struct {
+	start, end int
+}
+
+// ParseSchedule parses comma-separated "HH:MM-HH:MM" windows evaluated in the
+// IANA time zone tz (empty means the system's local time). An empty spec
+// returns nil, meaning no restriction.
+func ParseSchedule(spec, tz string) (*Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+
+	loc := time.Local
+	if tz != "" {
+		var err error
+		if loc, err = time.LoadLocation(tz); err != nil {
+			return nil, fmt.Errorf("invalid time zone %q: %w", tz, err)
+		}
+	}
+
+	s := &Schedule{loc: loc}
+	for _, part := range strings.Split(spec, ",") {
+		bounds := strings.Split(strings.TrimSpace(part), "-")
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("invalid window %q: expected HH:MM-HH:MM", part)
+		}
+		start, err := parseClock(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid window %q: %w", part, err)
+		}
+		end, err := parseClock(bounds[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid window %q: %w", part, err)
+		}
+		if start == end {
+			return nil, fmt.Errorf("invalid window %q: start equals end", part)
+		}
+		s.windows = append(s.windows, scheduleWindow{start: start, end: end})
+	}
+	return s, nil
+}
+
+// parseClock parses "HH:MM" (24h, "24:00" allowed as an end of day) into minutes since midnight.
+func parseClock(value string) (int, error) {
+	hh, mm, ok := strings.Cut(strings.TrimSpace(value), ":")
+	h, errH := strconv.Atoi(hh)
+	m, errM := strconv.Atoi(mm)
+	if !ok || errH != nil || errM != nil || len(mm) != 2 ||
+		h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	return h*60 + m, nil
+}
+
+// Allows reports whether t falls inside one of the windows.
+func (s *Schedule) Allows(t time.Time) bool {
+	if s == nil {
+		return true
+	}
+	t = t.In(s.loc)
+	minute := t.Hour()*60 + t.Minute()
+	for _, w := range s.windows {
+		if w.start < w.end {
+			if minute >= w.start && minute < w.end {
+				return true
+			}
+		} else if minute >= w.start || minute < w.end { // Overnight window
+			return true
+		}
+	}
+	return false
+}
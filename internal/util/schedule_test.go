@@ -0,0 +1,68 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedule_Allows(t *testing.T) {
+	s, err := ParseSchedule("22:00-06:00, 12:00-13:00", "UTC")
+	if err != nil {
+		t.Fatalf("ParseSchedule failed: %v", err)
+	}
+
+	at := func(hhmm string) time.Time {
+		tm, err := time.Parse("15:04", hhmm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return time.Date(2024, 5, 1, tm.Hour(), tm.Minute(), 0, 0, time.UTC)
+	}
+	for hhmm, want := range map[string]bool{
+		"21:59": false,
+		"22:00": true,
+		"23:30": true,
+		"00:00": true,
+		"05:59": true,
+		"06:00": false,
+		"12:30": true,
+		"13:00": false,
+	} {
+		if got := s.Allows(at(hhmm)); got != want {
+			t.Errorf("Allows(%s) = %v, want %v", hhmm, got, want)
+		}
+	}
+
+	var none *Schedule
+	if !none.Allows(time.Now()) {
+		t.Error("Expected a nil schedule to allow every time")
+	}
+}
+
+func TestSchedule_UsesTimezone(t *testing.T) {
+	s, err := ParseSchedule("09:00-10:00", "America/New_York")
+	if err != nil {
+		t.Skipf("time zone data not available: %v", err)
+	}
+	// 13:30 UTC is 09:30 in New York (EDT).
+	if !s.Allows(time.Date(2024, 7, 1, 13, 30, 0, 0, time.UTC)) {
+		t.Error("Expected 13:30 UTC to be inside 09:00-10:00 New York time")
+	}
+	if s.Allows(time.Date(2024, 7, 1, 9, 30, 0, 0, time.UTC)) {
+		t.Error("Expected 09:30 UTC to be outside 09:00-10:00 New York time")
+	}
+}
+
+func TestParseSchedule_RejectsInvalidWindows(t *testing.T) {
+	for _, spec := range []string{"22:00", "25:00-01:00", "10:60-11:00", "10:00-10:00", "ten-eleven", "1:5-2:00"} {
+		if _, err := ParseSchedule(spec, ""); err == nil {
+			t.Errorf("Expected %q to be rejected", spec)
+		}
+	}
+	if _, err := ParseSchedule("22:00-06:00", "Mars/Olympus"); err == nil {
+		t.Error("Expected an unknown time zone to be rejected")
+	}
+	if s, err := ParseSchedule("", ""); err != nil || s != nil {
+		t.Errorf("Expected an empty schedule to mean no restriction, got %v, %v", s, err)
+	}
+}
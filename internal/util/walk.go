@@ -0,0 +1,99 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Walk is filepath.Walk, optionally following symlinks. When followSymlinks
+// is set, a link to a directory is walked like a directory under the link's
+// path, and a link to a file is passed to fn by its resolved path (so a file
+// reachable through several links is seen under one name). A directory whose
+// target was walked already is skipped, which also ends link loops. Broken
+// links are passed to fn as they are.
+func Walk(root string, followSymlinks bool, fn filepath.WalkFunc) error {
+	if !followSymlinks {
+		return filepath.Walk(root, fn)
+	}
+	info, err := os.Lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walkFollowing(root, info, make(map[string]bool), fn)
+	}
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+// walkFollowing walks path like filepath.Walk does, resolving symlinks as
+// described for Walk. visited holds the resolved directories walked so far.
+func walkFollowing(path string, info os.FileInfo, visited map[string]bool, fn filepath.WalkFunc) error {
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return fn(path, info, nil)
+		}
+		targetInfo, err := os.Stat(target)
+		if err != nil {
+			return fn(path, info, nil)
+		}
+		if !targetInfo.IsDir() {
+			return fn(target, targetInfo, nil)
+		}
+		info = targetInfo
+	}
+	if !info.IsDir() {
+		return fn(path, info, nil)
+	}
+
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		if visited[real] {
+			return nil
+		}
+		visited[real] = true
+	}
+
+	names, err := readDirNames(path)
+	err1 := fn(path, info, err)
+	if err != nil || err1 != nil {
+		return err1
+	}
+	for _, name := range names {
+		filename := filepath.Join(path, name)
+		fileInfo, err := os.Lstat(filename)
+		if err != nil {
+			if err := fn(filename, fileInfo, err); err != nil && err != filepath.SkipDir {
+				return err
+			}
+			continue
+		}
+		if err := walkFollowing(filename, fileInfo, visited, fn); err != nil {
+			if err != filepath.SkipDir {
+				return err
+			}
+			// SkipDir from a file (not a link) skips the rest of its directory.
+			if !fileInfo.IsDir() && fileInfo.Mode()&os.ModeSymlink == 0 {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// readDirNames returns the sorted names of the entries of dir.
+func readDirNames(dir string) ([]string, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
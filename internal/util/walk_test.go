@@ -0,0 +1,85 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// walkFiles returns the files Walk passes to its callback, relative to root
+// where they are below it.
+func walkFiles(t *testing.T, root string, follow bool) []string {
+	t.Helper()
+	var files []string
+	err := Walk(root, follow, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			if rel, err := filepath.Rel(root, path); err == nil && filepath.IsLocal(rel) {
+				path = filepath.ToSlash(rel)
+			}
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk failed: %v", err)
+	}
+	return files
+}
+
+func TestWalkFollowsSymlinkedDirectory(t *testing.T) {
+	tmp := t.TempDir()
+	root := filepath.Join(tmp, "watch")
+	images := filepath.Join(tmp, "images")
+	for _, dir := range []string{root, images} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(images, "img.png"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(images, filepath.Join(root, "cam1")); err != nil {
+		t.Skipf("Symlinks not supported: %v", err)
+	}
+	if err := os.Symlink(filepath.Join(images, "img.png"), filepath.Join(root, "latest.png")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := walkFiles(t, root, false); !reflect.DeepEqual(got, []string{"cam1", "latest.png"}) {
+		t.Errorf("Without following, expected the links themselves, got %v", got)
+	}
+	resolved, err := filepath.EvalSymlinks(filepath.Join(images, "img.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"cam1/img.png", resolved}
+	if got := walkFiles(t, root, true); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestWalkStopsAtSymlinkLoop(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(sub, "img.png"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// sub/self points back at sub, sub/up at the root.
+	if err := os.Symlink(".", filepath.Join(sub, "self")); err != nil {
+		t.Skipf("Symlinks not supported: %v", err)
+	}
+	if err := os.Symlink("..", filepath.Join(sub, "up")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := walkFiles(t, root, true); !reflect.DeepEqual(got, []string{"sub/img.png"}) {
+		t.Errorf("Expected every file once, got %v", got)
+	}
+}
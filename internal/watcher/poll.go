@@ -0,0 +1,161 @@
+package watcher
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"fs-ingest-daemon/internal/util"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// fileState is what a poll remembers of a path to detect changes.
+type fileState struct {
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+// NewPollingWatcher creates a watcher that walks the tree under root every
+// interval instead of using kernel events, for trees with more directories
+// than the inotify watch limit allows. New and changed files go through the
+// same debounce as with NewWatcher, so the callback contract is the same;
+// changes are only seen up to interval later.
+func NewPollingWatcher(root string, debounce, interval time.Duration, eventCallback func(string), logger *slog.Logger, opts ...Option) (*Watcher, error) {
+	w := &Watcher{
+		logger:     logger,
+		debounce:   debounce,
+		callback:   eventCallback,
+		root:       filepath.Clean(root),
+		done:       make(chan struct{}),
+		timers:     make(map[string]*debounceTimer),
+		triggerOps: defaultTriggerOps,
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+
+	state, err := w.scan()
+	if err != nil {
+		return nil, err
+	}
+	if !state[w.root].dir {
+		return nil, fmt.Errorf("%s is not a directory", w.root)
+	}
+
+	// Like NewWatcher, call back for the files present at startup.
+	var files []string
+	for path, st := range state {
+		if !st.dir {
+			files = append(files, path)
+		}
+	}
+	sort.Strings(files)
+	for _, path := range files {
+		w.callback(path)
+	}
+
+	logger.Info("Polling watch directory", "path", w.root, "interval", interval)
+	go w.poll(interval, state)
+	return w, nil
+}
+
+// poll rescans the tree every interval and turns the differences to the
+// previous scan into events, until Close.
+func (w *Watcher) poll(interval time.Duration, state map[string]fileState) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+
+		w.mu.Lock()
+		recovering := w.recovering
+		w.mu.Unlock()
+		if recovering {
+			continue
+		}
+
+		current, err := w.scan()
+		if err != nil || !current[w.root].dir {
+			if os.IsNotExist(err) || err == nil {
+				w.rootRemoved()
+			} else {
+				w.logger.Error("Failed to poll watch directory", "path", w.root, "error", err)
+			}
+			continue
+		}
+		w.compare(state, current)
+		state = current
+	}
+}
+
+// scan returns the state of the root and of every directory and file below
+// it. Excluded directories are not entered.
+func (w *Watcher) scan() (map[string]fileState, error) {
+	w.mu.Lock()
+	exclude := w.exclude
+	w.mu.Unlock()
+
+	state := make(map[string]fileState)
+	err := util.Walk(w.root, w.followSymlinks, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == w.root {
+				return err
+			}
+			// Removed while walking, or unreadable: the next poll tries again.
+			return nil
+		}
+		if info.IsDir() && path != w.root && exclude != nil && exclude(path) {
+			return filepath.SkipDir
+		}
+		state[path] = fileState{size: info.Size(), modTime: info.ModTime(), dir: info.IsDir()}
+		return nil
+	})
+	return state, err
+}
+
+// compare passes the files created, changed and removed between two scans to
+// handleEvent as the events fsnotify would have sent, and reports new
+// directories to the OnNewDirectory handler.
+func (w *Watcher) compare(before, after map[string]fileState) {
+	w.mu.Lock()
+	onNewDirectory := w.onNewDirectory
+	w.mu.Unlock()
+
+	var newDirs []string
+	for path, st := range after {
+		prev, seen := before[path]
+		switch {
+		case st.dir:
+			if !seen || !prev.dir {
+				newDirs = append(newDirs, path)
+			}
+		case !seen || prev.dir:
+			w.handleEvent(fsnotify.Event{Name: path, Op: fsnotify.Create})
+		case st.size != prev.size || !st.modTime.Equal(prev.modTime):
+			w.handleEvent(fsnotify.Event{Name: path, Op: fsnotify.Write})
+		}
+	}
+	for path, prev := range before {
+		if st, ok := after[path]; !prev.dir && (!ok || st.dir) {
+			w.handleEvent(fsnotify.Event{Name: path, Op: fsnotify.Remove})
+		}
+	}
+
+	if onNewDirectory != nil {
+		// Parents before their subdirectories, as with kernel events.
+		sort.Strings(newDirs)
+		for _, path := range newDirs {
+			onNewDirectory(path)
+		}
+	}
+}
@@ -6,24 +6,85 @@ package watcher
 // It automatically adds subdirectories to the watch list.
 
 import (
+	"errors"
+	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	"fs-ingest-daemon/internal/util"
+
 	"github.com/fsnotify/fsnotify"
 )
 
 // Watcher handles the file system events using fsnotify.
 type Watcher struct {
-	fsWatcher *fsnotify.Watcher
+	fsWatcher *fsnotify.Watcher // nil when polling, see NewPollingWatcher
 	logger    *slog.Logger
 	debounce  time.Duration
 	callback  func(string)
+	root      string
+	done      chan struct{} // Closed by Close
+	closeOnce sync.Once
+
+	mu             sync.Mutex
+	timers         map[string]*debounceTimer
+	generation     uint64            // Incremented for every timer started, see debounceTimer
+	onEventsLost   func()            // Called when the kernel event queue overflowed
+	onRootRestored func()            // Called after the watch root was recreated, see SetOnRootRestored
+	onNewDirectory func(string)      // Called for directories created while watching, see SetOnNewDirectory
+	triggerOps     fsnotify.Op       // Events that (re)start the debounce timer
+	exclude        func(string) bool // Reports files to ignore, see SetExclude
+	recovering     bool              // Waiting for a deleted watch root to reappear
+	rewatching     bool              // A rewatch of the tree is scheduled, see scheduleRewatch
+	stability      time.Duration     // Interval of the size check before the callback, 0 = none, see SetStabilityCheck
+	followSymlinks bool              // Walk symlinked directories, see FollowSymlinks
+}
+
+// rootRecheckInterval is how often a deleted watch root is checked for again.
+var rootRecheckInterval = time.Second
+
+// rewatchBackoff and rewatchMaxBackoff bound the wait before the watches are
+// re-added after a watcher error; the wait doubles with every failed attempt.
+var (
+	rewatchBackoff    = time.Second
+	rewatchMaxBackoff = time.Minute
+)
+
+// watchLimitSysctl is the Linux setting that limits the number of inotify
+// watches (one per watched directory) of a user.
+const watchLimitSysctl = "fs.inotify.max_user_watches"
+
+// IsWatchLimit reports whether err means that the inotify watch limit was
+// reached (see watchLimitSysctl), leaving directories unwatched.
+func IsWatchLimit(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
+
+// defaultTriggerOps are the events that trigger ingestion unless configured otherwise.
+const defaultTriggerOps = fsnotify.Create | fsnotify.Write
 
-	mu     sync.Mutex
-	timers map[string]*time.Timer
+// debounceTimer is a pending callback for one path.
+// A fired timer only invokes the callback if it is still the registered timer
+// for its path (same generation); otherwise it was cancelled or superseded while
+// firing and Stop() came too late.
+type debounceTimer struct {
+	timer      *time.Timer
+	generation uint64
+}
+
+// Option configures a Watcher when it is created, before the initial walk.
+type Option func(*Watcher)
+
+// FollowSymlinks makes the watcher watch symlinked directories like real ones
+// and report symlinked files by their resolved path (see util.Walk). Link
+// loops are walked once.
+func FollowSymlinks() Option {
+	return func(w *Watcher) { w.followSymlinks = true }
 }
 
 // NewWatcher creates and initializes a recursive watcher on the specified root directory.
@@ -34,18 +95,25 @@ type Watcher struct {
 //	debounce: The duration to wait after the last write event before triggering the callback.
 //	eventCallback: A function to call when a file is ready (debounced).
 //	logger: Structured logger.
-func NewWatcher(root string, debounce time.Duration, eventCallback func(string), logger *slog.Logger) (*Watcher, error) {
+//	opts: Options, e.g. FollowSymlinks.
+func NewWatcher(root string, debounce time.Duration, eventCallback func(string), logger *slog.Logger, opts ...Option) (*Watcher, error) {
 	fs, err := fsnotify.NewWatcher()
 	if err != nil {
 		return nil, err
 	}
 
 	w := &Watcher{
-		fsWatcher: fs,
-		logger:    logger,
-		debounce:  debounce,
-		callback:  eventCallback,
-		timers:    make(map[string]*time.Timer),
+		fsWatcher:  fs,
+		logger:     logger,
+		debounce:   debounce,
+		callback:   eventCallback,
+		root:       filepath.Clean(root),
+		done:       make(chan struct{}),
+		timers:     make(map[string]*debounceTimer),
+		triggerOps: defaultTriggerOps,
+	}
+	for _, opt := range opts {
+		opt(w)
 	}
 
 	// Go routine to process events
@@ -54,6 +122,9 @@ func NewWatcher(root string, debounce time.Duration, eventCallback func(string),
 	err = w.AddRecursive(root)
 	if err != nil {
 		w.Close()
+		if IsWatchLimit(err) {
+			return nil, fmt.Errorf("inotify watch limit reached, raise %s: %w", watchLimitSysctl, err)
+		}
 		return nil, err
 	}
 	return w, nil
@@ -67,76 +138,425 @@ func (w *Watcher) start() {
 				return
 			}
 
-			// If a new directory is created, watch it too (Recursive)
-			// We check for fsnotify.Create events.
-			if event.Has(fsnotify.Create) {
-				info, err := os.Stat(event.Name)
-				if err == nil && info.IsDir() {
-					// Add the new directory to the watcher
-					w.AddRecursive(event.Name)
-					// Directories don't trigger the file callback
-					continue
-				}
-			}
-
-			// Handle File Events (Create or Write) for Debouncing
-			if event.Has(fsnotify.Create) || event.Has(fsnotify.Write) {
-				w.resetTimer(event.Name)
-			} else if event.Has(fsnotify.Rename) || event.Has(fsnotify.Remove) {
-				w.cancelTimer(event.Name)
-			}
+			w.handleEvent(event)
 
 		case err, ok := <-w.fsWatcher.Errors:
 			if !ok {
 				return
 			}
-			w.logger.Error("Watcher error", "error", err)
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				// Dropped events can include the creation of directories,
+				// which would then not be watched.
+				w.logger.Warn("Event queue overflowed, events were dropped; re-adding watches and rescanning", "path", w.root)
+			} else {
+				w.logger.Error("Watcher error, re-adding watches", "path", w.root, "error", err)
+			}
+			w.scheduleRewatch()
+		}
+	}
+}
+
+// scheduleRewatch re-adds the watches of the whole tree after a backoff,
+// unless that is already scheduled or the watch root is being waited for.
+func (w *Watcher) scheduleRewatch() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.rewatching || w.recovering {
+		return
+	}
+	w.rewatching = true
+	go w.rewatch()
+}
+
+// rewatch re-adds the watch root and every directory below it, retrying with
+// a growing backoff until that succeeds, then calls the OnEventsLost handler
+// so the caller can rescan for the files whose events were missed.
+func (w *Watcher) rewatch() {
+	backoff := rewatchBackoff
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-time.After(backoff):
+		}
+
+		w.mu.Lock()
+		if w.recovering {
+			// The root is gone; awaitRoot re-adds the watches and rescans.
+			w.rewatching = false
+			w.mu.Unlock()
+			return
+		}
+		w.mu.Unlock()
+
+		// Adding a directory that is watched already is a no-op.
+		if err := w.addRecursive(w.root, false); err != nil {
+			backoff = min(2*backoff, rewatchMaxBackoff)
+			w.logger.Error("Failed to re-add watches, retrying", "path", w.root, "retry_in", backoff, "error", err)
+			continue
+		}
+
+		w.mu.Lock()
+		w.rewatching = false
+		onEventsLost := w.onEventsLost
+		w.mu.Unlock()
+
+		w.logger.Info("Watches re-added", "path", w.root)
+		if onEventsLost != nil {
+			onEventsLost()
+		}
+		return
+	}
+}
+
+// handleEvent starts, resets or cancels the debounce timer for a file event.
+func (w *Watcher) handleEvent(event fsnotify.Event) {
+	// The watch on a deleted root is gone for good; wait for it to come back.
+	if (event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename)) && w.root != "" && filepath.Clean(event.Name) == w.root {
+		w.rootRemoved()
+		return
+	}
+
+	// If a new directory is created, watch it too (Recursive)
+	// We check for fsnotify.Create events.
+	if event.Has(fsnotify.Create) {
+		info, err := os.Stat(event.Name)
+		if err == nil && info.IsDir() {
+			// Add the new directory to the watcher
+			watched := w.watchedIfReporting()
+			if err := w.AddRecursive(event.Name); err != nil {
+				if IsWatchLimit(err) {
+					w.logger.Error("Inotify watch limit reached, new files in the directory are not detected until reconciliation; raise the limit",
+						"path", event.Name, "sysctl", watchLimitSysctl, "error", err)
+				} else {
+					w.logger.Error("Failed to watch new directory", "path", event.Name, "error", err)
+				}
+			}
+			w.reportNewDirectories(event.Name, watched)
+			// Directories don't trigger the file callback
+			return
+		}
+	}
+
+	// Handle File Events (Create and/or Write, see SetTriggerEvents) for Debouncing
+	w.mu.Lock()
+	triggers, exclude := w.triggerOps, w.exclude
+	w.mu.Unlock()
+	if exclude != nil && exclude(event.Name) {
+		return
+	}
+	if event.Op&triggers != 0 {
+		w.resetTimer(event.Name)
+	} else if event.Has(fsnotify.Rename) || event.Has(fsnotify.Remove) {
+		w.cancelTimer(event.Name)
+	}
+}
+
+// SetExclude installs a filter for files that must be ignored. Events for them
+// never start a debounce timer and the files are not reported while walking.
+func (w *Watcher) SetExclude(fn func(path string) bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.exclude = fn
+}
+
+// SetTriggerEvents selects which file events ("create", "write") start or
+// reset the debounce timer. An empty list restores the default of both.
+func (w *Watcher) SetTriggerEvents(events []string) error {
+	ops, err := ParseTriggerEvents(events)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.triggerOps = ops
+	return nil
+}
+
+// ParseTriggerEvents converts event names ("create", "write") into fsnotify
+// operations. An empty list means both.
+func ParseTriggerEvents(events []string) (fsnotify.Op, error) {
+	if len(events) == 0 {
+		return defaultTriggerOps, nil
+	}
+	var ops fsnotify.Op
+	for _, e := range events {
+		switch strings.ToLower(strings.TrimSpace(e)) {
+		case "create":
+			ops |= fsnotify.Create
+		case "write":
+			ops |= fsnotify.Write
+		default:
+			return 0, fmt.Errorf("unknown trigger event %q: must be \"create\" or \"write\"", e)
 		}
 	}
+	return ops, nil
+}
+
+// rootRemoved starts waiting for the deleted watch root to reappear, unless
+// that is already in progress.
+func (w *Watcher) rootRemoved() {
+	w.mu.Lock()
+	if w.recovering {
+		w.mu.Unlock()
+		return
+	}
+	w.recovering = true
+	w.mu.Unlock()
+
+	w.logger.Error("Watch root was removed, no new files are detected until it reappears", "path", w.root)
+	go w.awaitRoot()
+}
+
+// awaitRoot polls for the watch root until it exists again, then re-adds the
+// watches and rescans it.
+func (w *Watcher) awaitRoot() {
+	ticker := time.NewTicker(rootRecheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(w.root)
+		if err != nil || !info.IsDir() {
+			continue
+		}
+
+		w.mu.Lock()
+		onRootRestored := w.onRootRestored
+		w.mu.Unlock()
+
+		// Without a handler, rescan the way new directories are: by calling
+		// back for every file found.
+		if err := w.addRecursive(w.root, onRootRestored == nil); err != nil {
+			w.logger.Error("Failed to re-watch restored watch root, retrying", "path", w.root, "error", err)
+			continue
+		}
+
+		w.mu.Lock()
+		w.recovering = false
+		w.mu.Unlock()
+
+		w.logger.Warn("Watch root reappeared, detection resumed", "path", w.root)
+		if onRootRestored != nil {
+			onRootRestored()
+		}
+		return
+	}
+}
+
+// SetOnRootRestored registers a function called after a deleted watch root
+// reappeared and is watched again, so the caller can rescan it. Without one,
+// the callback is invoked for every file in the restored tree.
+func (w *Watcher) SetOnRootRestored(fn func()) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.onRootRestored = fn
+}
+
+// Recovering reports whether the watch root was removed and the watcher is
+// waiting for it to reappear.
+func (w *Watcher) Recovering() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.recovering
+}
+
+// SetOnNewDirectory registers a function called for every directory created
+// below the watch root while watching, including the directories created with
+// it (e.g. by mkdir -p). Directories present at startup are not reported, nor
+// are excluded ones. It runs on the event loop, so it should return quickly.
+func (w *Watcher) SetOnNewDirectory(fn func(path string)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.onNewDirectory = fn
+}
+
+// watchedIfReporting returns the directories watched so far if new ones are
+// reported, so directories already reported with their parent (the walk of a
+// new directory can add subdirectories before their own Create event arrives)
+// are not reported twice. It returns nil otherwise.
+func (w *Watcher) watchedIfReporting() map[string]bool {
+	w.mu.Lock()
+	reporting := w.onNewDirectory != nil
+	w.mu.Unlock()
+	if !reporting {
+		return nil
+	}
+	watched := make(map[string]bool)
+	for _, path := range w.fsWatcher.WatchList() {
+		watched[path] = true
+	}
+	return watched
+}
+
+// reportNewDirectories calls the OnNewDirectory handler for dir and the
+// directories below it that were not in watched before.
+func (w *Watcher) reportNewDirectories(dir string, watched map[string]bool) {
+	w.mu.Lock()
+	onNewDirectory, exclude := w.onNewDirectory, w.exclude
+	w.mu.Unlock()
+	if onNewDirectory == nil {
+		return
+	}
+	util.Walk(dir, w.followSymlinks, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+		if exclude != nil && exclude(path) {
+			return filepath.SkipDir
+		}
+		if !watched[path] {
+			onNewDirectory(path)
+		}
+		return nil
+	})
+}
+
+// SetOnEventsLost registers a function called when events may have been dropped
+// (fsnotify queue overflow or another watcher error), so the caller can rescan
+// for missed files. It is called once the watches were re-added.
+func (w *Watcher) SetOnEventsLost(fn func()) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.onEventsLost = fn
+}
+
+// SetStabilityCheck makes a fired debounce timer check that the file is no
+// longer being written before the callback: its size and mod time are read
+// twice, interval apart, and the timer is started again if they changed. This
+// catches writers that pause longer than the debounce window, e.g. slow
+// network copies. 0 disables the check.
+func (w *Watcher) SetStabilityCheck(interval time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stability = interval
 }
 
 // resetTimer starts or resets the debounce timer for a given file path.
 func (w *Watcher) resetTimer(path string) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
+	w.resetTimerLocked(path)
+}
 
+// resetTimerLocked is resetTimer for callers already holding w.mu.
+func (w *Watcher) resetTimerLocked(path string) {
 	// Stop existing timer if it exists
 	if t, ok := w.timers[path]; ok {
-		t.Stop()
+		t.timer.Stop()
 	}
 
 	// Create a new timer
-	w.timers[path] = time.AfterFunc(w.debounce, func() {
-		w.mu.Lock()
-		delete(w.timers, path)
+	w.generation++
+	generation := w.generation
+	w.timers[path] = &debounceTimer{
+		generation: generation,
+		timer:      time.AfterFunc(w.debounce, func() { w.fire(path, generation) }),
+	}
+}
+
+// fire runs when the debounce timer of path with the given generation expired.
+func (w *Watcher) fire(path string, generation uint64) {
+	w.mu.Lock()
+	current, ok := w.timers[path]
+	if !ok || current.generation != generation {
+		// Cancelled or reset after this timer had already fired.
+		w.mu.Unlock()
+		return
+	}
+	stability := w.stability
+	if stability > 0 {
+		// The timer stays registered during the check, so events and
+		// cancellations in the meantime still supersede it.
 		w.mu.Unlock()
+		stable := w.stable(path, stability)
+		w.mu.Lock()
+		if current, ok = w.timers[path]; !ok || current.generation != generation {
+			w.mu.Unlock()
+			return
+		}
+		if !stable {
+			w.logger.Debug("File is still being written, waiting", "path", path)
+			w.resetTimerLocked(path)
+			w.mu.Unlock()
+			return
+		}
+	}
+	delete(w.timers, path)
+	w.mu.Unlock()
 
-		// Trigger the callback
-		w.callback(path)
-	})
+	// Trigger the callback
+	w.callback(path)
+}
+
+// stable reports whether the size and mod time of path stay the same for
+// interval. Files that cannot be read count as stable, so the callback deals
+// with them as before; a Close during the wait counts as unstable.
+func (w *Watcher) stable(path string, interval time.Duration) bool {
+	before, err := os.Stat(path)
+	if err != nil {
+		return true
+	}
+	select {
+	case <-time.After(interval):
+	case <-w.done:
+		return false
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		return true
+	}
+	return before.Size() == after.Size() && before.ModTime().Equal(after.ModTime())
 }
 
 // cancelTimer stops and removes the debounce timer for a given file path.
 func (w *Watcher) cancelTimer(path string) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
+	w.cancelTimerLocked(path)
+}
 
+// cancelTimerLocked is cancelTimer for callers already holding w.mu.
+func (w *Watcher) cancelTimerLocked(path string) {
 	if t, ok := w.timers[path]; ok {
-		t.Stop()
+		t.timer.Stop()
 		delete(w.timers, path)
 	}
 }
 
 // AddRecursive adds the given path and all its sub-directories to the watcher.
 func (w *Watcher) AddRecursive(path string) error {
-	return filepath.Walk(path, func(newPath string, info os.FileInfo, err error) error {
+	return w.addRecursive(path, true)
+}
+
+// addRecursive is AddRecursive, optionally without calling back for the files found.
+func (w *Watcher) addRecursive(path string, notify bool) error {
+	return util.Walk(path, w.followSymlinks, func(newPath string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 		if info.IsDir() {
+			if w.fsWatcher == nil {
+				// Polling, see NewPollingWatcher
+				return nil
+			}
 			w.logger.Info("Watching directory", "path", newPath)
 			return w.fsWatcher.Add(newPath)
 		}
+		if !notify {
+			return nil
+		}
+		w.mu.Lock()
+		exclude := w.exclude
+		w.mu.Unlock()
+		if exclude != nil && exclude(newPath) {
+			return nil
+		}
 
 		// Fix: Process existing files immediately.
 		// If a directory is created with files already inside (or created very quickly),
@@ -149,13 +569,16 @@ func (w *Watcher) AddRecursive(path string) error {
 
 // Close shuts down the file system watcher and cleans up any pending timers.
 func (w *Watcher) Close() {
-	w.fsWatcher.Close()
+	w.closeOnce.Do(func() { close(w.done) })
+	if w.fsWatcher != nil {
+		w.fsWatcher.Close()
+	}
 
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
 	for _, t := range w.timers {
-		t.Stop()
+		t.timer.Stop()
 	}
-	w.timers = make(map[string]*time.Timer)
+	w.timers = make(map[string]*debounceTimer)
 }
@@ -4,9 +4,12 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/fsnotify/fsnotify"
 )
 
 func TestWatcherDebounce(t *testing.T) {
@@ -90,3 +93,334 @@ func TestWatcherDebounce(t *testing.T) {
 		t.Errorf("Expected callback count 1, got %d. Debounce might not be working.", count)
 	}
 }
+
+func TestCancelTimerWhileFiring(t *testing.T) {
+	var callbackCount int32
+	w := &Watcher{
+		logger:   slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		debounce: 10 * time.Millisecond,
+		callback: func(string) { atomic.AddInt32(&callbackCount, 1) },
+		timers:   make(map[string]*debounceTimer),
+	}
+
+	path := "/watch/img.png"
+	w.resetTimer(path)
+
+	// Hold the lock past the debounce so the timer fires and its callback blocks
+	// on the mutex; Stop() can no longer prevent it. Then cancel, as a Remove
+	// event arriving at that exact moment would.
+	w.mu.Lock()
+	time.Sleep(50 * time.Millisecond)
+	w.cancelTimerLocked(path)
+	w.mu.Unlock()
+
+	time.Sleep(50 * time.Millisecond)
+	if got := atomic.LoadInt32(&callbackCount); got != 0 {
+		t.Errorf("Expected callback to be suppressed for a cancelled timer, got %d calls", got)
+	}
+}
+
+func TestTriggerEventsCreateOnly(t *testing.T) {
+	var callbackCount int32
+	w := &Watcher{
+		logger:     slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		debounce:   10 * time.Millisecond,
+		callback:   func(string) { atomic.AddInt32(&callbackCount, 1) },
+		timers:     make(map[string]*debounceTimer),
+		triggerOps: defaultTriggerOps,
+	}
+	if err := w.SetTriggerEvents([]string{"create"}); err != nil {
+		t.Fatal(err)
+	}
+
+	w.handleEvent(fsnotify.Event{Name: "/watch/img.png", Op: fsnotify.Write})
+	time.Sleep(50 * time.Millisecond)
+	if got := atomic.LoadInt32(&callbackCount); got != 0 {
+		t.Errorf("Expected a pure write not to trigger with trigger_events [create], got %d calls", got)
+	}
+
+	w.handleEvent(fsnotify.Event{Name: "/watch/img.png", Op: fsnotify.Create})
+	time.Sleep(50 * time.Millisecond)
+	if got := atomic.LoadInt32(&callbackCount); got != 1 {
+		t.Errorf("Expected create to trigger once, got %d calls", got)
+	}
+
+	if err := w.SetTriggerEvents([]string{"chmod"}); err == nil {
+		t.Error("Expected an unknown trigger event to be rejected")
+	}
+}
+
+func TestWatchRootRemovedAndRecreated(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "watcher_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	defer func(d time.Duration) { rootRecheckInterval = d }(rootRecheckInterval)
+	rootRecheckInterval = 20 * time.Millisecond
+
+	root := filepath.Join(tmpDir, "watch")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	callbackCh := make(chan string, 10)
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	w, err := NewWatcher(root, 20*time.Millisecond, func(path string) { callbackCh <- path }, logger)
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	defer w.Close()
+
+	restored := make(chan struct{}, 1)
+	w.SetOnRootRestored(func() { restored <- struct{}{} })
+
+	if err := os.RemoveAll(root); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-restored:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for the watch root to be restored")
+	}
+
+	testFile := filepath.Join(root, "img.png")
+	if err := os.WriteFile(testFile, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case path := <-callbackCh:
+		if path != testFile {
+			t.Errorf("Expected path %s, got %s", testFile, path)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected detection to resume after the watch root was recreated")
+	}
+}
+
+func TestExcludedFilesDoNotStartTimers(t *testing.T) {
+	var callbackCount int32
+	w := &Watcher{
+		logger:     slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		debounce:   10 * time.Millisecond,
+		callback:   func(string) { atomic.AddInt32(&callbackCount, 1) },
+		timers:     make(map[string]*debounceTimer),
+		triggerOps: defaultTriggerOps,
+	}
+	w.SetExclude(func(path string) bool { return strings.HasSuffix(path, ".tmp") })
+
+	w.handleEvent(fsnotify.Event{Name: "/watch/img.png.tmp", Op: fsnotify.Create})
+	w.mu.Lock()
+	timers := len(w.timers)
+	w.mu.Unlock()
+	if timers != 0 {
+		t.Errorf("Expected no debounce timer for an excluded file, got %d", timers)
+	}
+
+	w.handleEvent(fsnotify.Event{Name: "/watch/img.png", Op: fsnotify.Create})
+	time.Sleep(50 * time.Millisecond)
+	if got := atomic.LoadInt32(&callbackCount); got != 1 {
+		t.Errorf("Expected only the included file to trigger, got %d calls", got)
+	}
+}
+
+func TestStabilityCheckWaitsForGrowingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "copy.bin")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	sizes := make(chan int64, 10)
+	w := &Watcher{
+		logger:   slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		debounce: 20 * time.Millisecond,
+		callback: func(path string) {
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Errorf("Failed to stat %s: %v", path, err)
+				return
+			}
+			sizes <- info.Size()
+		},
+		done:       make(chan struct{}),
+		timers:     make(map[string]*debounceTimer),
+		triggerOps: defaultTriggerOps,
+	}
+	w.SetStabilityCheck(40 * time.Millisecond)
+
+	// A slow copy: the only event is the create, the writes keep going well
+	// past the debounce window.
+	w.handleEvent(fsnotify.Event{Name: path, Op: fsnotify.Create})
+	for i := 0; i < 10; i++ {
+		if _, err := f.Write(make([]byte, 1024)); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(15 * time.Millisecond)
+	}
+
+	select {
+	case size := <-sizes:
+		if size != 10*1024 {
+			t.Errorf("Expected the callback to see the complete file (10240 bytes), got %d", size)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Callback did not fire after the file stopped growing")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if len(sizes) != 0 {
+		t.Errorf("Expected the callback to fire once, got %d more calls", len(sizes))
+	}
+}
+
+func TestOverflowReaddsLostWatches(t *testing.T) {
+	defer func(d time.Duration) { rewatchBackoff = d }(rewatchBackoff)
+	rewatchBackoff = 10 * time.Millisecond
+
+	root := t.TempDir()
+	sub := filepath.Join(root, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	callbackCh := make(chan string, 10)
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	w, err := NewWatcher(root, 20*time.Millisecond, func(path string) { callbackCh <- path }, logger)
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	defer w.Close()
+
+	eventsLost := make(chan struct{}, 1)
+	w.SetOnEventsLost(func() { eventsLost <- struct{}{} })
+
+	// Simulate a subdirectory whose watch was lost along with dropped events.
+	if err := w.fsWatcher.Remove(sub); err != nil {
+		t.Fatal(err)
+	}
+	w.fsWatcher.Errors <- fsnotify.ErrEventOverflow
+
+	select {
+	case <-eventsLost:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for the rescan after the overflow")
+	}
+
+	testFile := filepath.Join(sub, "img.png")
+	if err := os.WriteFile(testFile, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case path := <-callbackCh:
+		if path != testFile {
+			t.Errorf("Expected path %s, got %s", testFile, path)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the lost watch to be re-added after the overflow")
+	}
+}
+
+func TestPollingWatcherDetectsNewFiles(t *testing.T) {
+	root := t.TempDir()
+	existing := filepath.Join(root, "existing.png")
+	if err := os.WriteFile(existing, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	callbackCh := make(chan string, 10)
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	w, err := NewPollingWatcher(root, 20*time.Millisecond, 20*time.Millisecond, func(path string) { callbackCh <- path }, logger)
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	defer w.Close()
+
+	dirCh := make(chan string, 10)
+	w.SetOnNewDirectory(func(path string) { dirCh <- path })
+
+	select {
+	case path := <-callbackCh:
+		if path != existing {
+			t.Errorf("Expected path %s, got %s", existing, path)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected a callback for the existing file")
+	}
+
+	sub := filepath.Join(root, "session1")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	testFile := filepath.Join(sub, "img.png")
+	if err := os.WriteFile(testFile, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case path := <-dirCh:
+		if path != sub {
+			t.Errorf("Expected new directory %s, got %s", sub, path)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the new directory to be reported")
+	}
+	select {
+	case path := <-callbackCh:
+		if path != testFile {
+			t.Errorf("Expected path %s, got %s", testFile, path)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the new file to be detected by polling")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if len(callbackCh) != 0 {
+		t.Errorf("Expected unchanged files not to be reported again, got %d more callbacks", len(callbackCh))
+	}
+}
+
+func TestFollowSymlinksWatchesLinkedDirectory(t *testing.T) {
+	tmp := t.TempDir()
+	root := filepath.Join(tmp, "watch")
+	images := filepath.Join(tmp, "images")
+	for _, dir := range []string{root, images} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(images, filepath.Join(root, "cam1")); err != nil {
+		t.Skipf("Symlinks not supported: %v", err)
+	}
+	// A loop must not keep the watcher from starting.
+	if err := os.Symlink(".", filepath.Join(images, "self")); err != nil {
+		t.Fatal(err)
+	}
+
+	callbackCh := make(chan string, 10)
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	w, err := NewWatcher(root, 20*time.Millisecond, func(path string) { callbackCh <- path }, logger, FollowSymlinks())
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	defer w.Close()
+
+	if err := os.WriteFile(filepath.Join(images, "img.png"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join(root, "cam1", "img.png")
+	select {
+	case path := <-callbackCh:
+		if path != want {
+			t.Errorf("Expected path %s, got %s", want, path)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected a file in the symlinked directory to be detected")
+	}
+}